@@ -0,0 +1,15 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// gorilla-selfservice runs in each person's session and shows a tray icon
+// It lists the optional installs from the gorilla service, and asks the service to install or remove them
+func main() {
+	if err := runTray(); err != nil {
+		fmt.Println("Self-service error:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,301 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"github.com/1dustindavis/gorilla/pkg/ipc"
+	"golang.org/x/sys/windows"
+)
+
+const (
+	// Messages the tray window handles
+	wmTrayIcon  = wmApp + 1
+	wmRefreshed = wmApp + 2
+
+	// Menu item ids, with each optional install numbered from menuFirstItem
+	menuRefresh   = 1
+	menuQuit      = 2
+	menuFirstItem = 100
+
+	// The list is refreshed in the background every five minutes
+	refreshTimer    = 1
+	refreshInterval = 5 * 60 * 1000
+)
+
+// tray is the tray icon, and what it last heard from the service
+type tray struct {
+	hwnd uintptr
+	icon uintptr
+
+	mu      sync.Mutex
+	items   []ipc.Item
+	busy    string
+	working string
+	err     error
+
+	// notice is shown in a balloon the next time the icon is updated, like the result of an install
+	notice      string
+	noticeError bool
+}
+
+// app is the only tray icon, since the window procedure cant be given any context
+var app tray
+
+// runTray shows the tray icon and handles its messages until the app quits
+func runTray() error {
+	// Every window call has to come from the thread that created the window
+	runtime.LockOSThread()
+
+	instance, _, _ := procGetModuleHandle.Call(0)
+	className := utf16("GorillaSelfService")
+	wc := wndClassEx{
+		WndProc:   windows.NewCallback(wndProc),
+		Instance:  instance,
+		ClassName: className,
+	}
+	wc.Size = uint32(unsafe.Sizeof(wc))
+	if r, _, err := procRegisterClassEx.Call(uintptr(unsafe.Pointer(&wc))); r == 0 {
+		return fmt.Errorf("unable to register the window class: %v", err)
+	}
+
+	// The window is never shown, it only owns the tray icon and its menu
+	hwnd, _, err := procCreateWindowEx.Call(0, uintptr(unsafe.Pointer(className)), uintptr(unsafe.Pointer(utf16("Gorilla"))),
+		0, 0, 0, 0, 0, 0, 0, instance, 0)
+	if hwnd == 0 {
+		return fmt.Errorf("unable to create the window: %v", err)
+	}
+	app.hwnd = hwnd
+	app.icon, _, _ = procLoadIcon.Call(0, idiApplication)
+
+	nid := app.iconData()
+	nid.Flags = nifMessage | nifIcon | nifTip
+	nid.CallbackMessage = wmTrayIcon
+	nid.Icon = app.icon
+	copyUTF16(nid.Tip[:], "Gorilla")
+	if r, _, err := procShellNotifyIcon.Call(nimAdd, uintptr(unsafe.Pointer(&nid))); r == 0 {
+		return fmt.Errorf("unable to add the tray icon: %v", err)
+	}
+	defer func() {
+		nid := app.iconData()
+		procShellNotifyIcon.Call(nimDelete, uintptr(unsafe.Pointer(&nid)))
+	}()
+
+	procSetTimer.Call(hwnd, refreshTimer, refreshInterval, 0)
+	go app.refresh()
+
+	var m msg
+	for {
+		r, _, _ := procGetMessage.Call(uintptr(unsafe.Pointer(&m)), 0, 0, 0)
+		if int32(r) <= 0 {
+			return nil
+		}
+		procTranslateMessage.Call(uintptr(unsafe.Pointer(&m)))
+		procDispatchMessage.Call(uintptr(unsafe.Pointer(&m)))
+	}
+}
+
+// wndProc handles the messages sent to the tray window
+func wndProc(hwnd, message, wParam, lParam uintptr) uintptr {
+	switch message {
+	case wmTrayIcon:
+		switch lParam & 0xffff {
+		case wmLButtonUp, wmRButtonUp:
+			app.showMenu()
+		}
+		return 0
+	case wmRefreshed:
+		app.update()
+		return 0
+	case wmTimer:
+		go app.refresh()
+		return 0
+	case wmDestroy:
+		procPostQuitMessage.Call(0)
+		return 0
+	}
+	r, _, _ := procDefWindowProc.Call(hwnd, message, wParam, lParam)
+	return r
+}
+
+// iconData returns the data that identifies our tray icon
+func (t *tray) iconData() notifyIconData {
+	nid := notifyIconData{HWnd: t.hwnd, ID: 1}
+	nid.Size = uint32(unsafe.Sizeof(nid))
+	return nid
+}
+
+// refresh asks the service for the optional installs and pending actions, then has the window show them
+func (t *tray) refresh() {
+	resp, err := ipc.Send(ipc.Request{Command: "list"})
+	t.mu.Lock()
+	t.items, t.busy, t.err = resp.Items, resp.Busy, err
+	t.mu.Unlock()
+	procPostMessage.Call(t.hwnd, wmRefreshed, 0, 0)
+}
+
+// act installs or removes an optional install, and lets the person know how it went
+func (t *tray) act(command string, item ipc.Item) {
+	verb := "Installing"
+	if command == "remove" {
+		verb = "Removing"
+	}
+	t.mu.Lock()
+	t.working = fmt.Sprintf("%s %s...", verb, item.DisplayName)
+	t.mu.Unlock()
+	procPostMessage.Call(t.hwnd, wmRefreshed, 0, 0)
+
+	_, err := ipc.Send(ipc.Request{Command: command, Items: []string{item.Name}})
+
+	t.mu.Lock()
+	t.working = ""
+	switch {
+	case err != nil:
+		t.notice, t.noticeError = fmt.Sprintf("Unable to %s %s: %v", command, item.DisplayName, err), true
+	case command == "remove":
+		t.notice, t.noticeError = fmt.Sprintf("%s was removed", item.DisplayName), false
+	default:
+		t.notice, t.noticeError = fmt.Sprintf("%s is installed", item.DisplayName), false
+	}
+	t.mu.Unlock()
+	t.refresh()
+}
+
+// update shows what the service is doing in the icon's tooltip, along with any notice
+func (t *tray) update() {
+	t.mu.Lock()
+	tip := "Gorilla"
+	switch {
+	case t.working != "":
+		tip = t.working
+	case t.busy != "":
+		tip = t.busy
+	case t.err != nil:
+		tip = "Gorilla is not available"
+	default:
+		if pending := len(pendingActions(t.items)); pending > 0 {
+			tip = fmt.Sprintf("Gorilla - %d changes pending", pending)
+		}
+	}
+	notice, noticeError := t.notice, t.noticeError
+	t.notice = ""
+	t.mu.Unlock()
+
+	nid := t.iconData()
+	nid.Flags = nifTip
+	copyUTF16(nid.Tip[:], tip)
+	if notice != "" {
+		nid.Flags |= nifInfo
+		nid.InfoFlags = niifInfo
+		if noticeError {
+			nid.InfoFlags = niifError
+		}
+		copyUTF16(nid.InfoTitle[:], "Gorilla")
+		copyUTF16(nid.Info[:], notice)
+	}
+	procShellNotifyIcon.Call(nimModify, uintptr(unsafe.Pointer(&nid)))
+}
+
+// showMenu shows the optional installs and pending actions at the mouse, and acts on the choice
+func (t *tray) showMenu() {
+	t.mu.Lock()
+	items := append([]ipc.Item{}, t.items...)
+	busy := t.working != "" || t.busy != ""
+	listErr := t.err
+	t.mu.Unlock()
+
+	menu, _, _ := procCreatePopupMenu.Call()
+	if menu == 0 {
+		return
+	}
+	defer procDestroyMenu.Call(menu)
+	appendMenu := func(flags, id uintptr, text string) {
+		procAppendMenu.Call(menu, flags, id, uintptr(unsafe.Pointer(utf16(text))))
+	}
+
+	if listErr != nil {
+		appendMenu(mfString|mfGrayed, 0, fmt.Sprint("Gorilla is not available: ", listErr))
+	} else {
+		// Installed items are checked, and clicking one offers to remove it
+		offered := 0
+		for i, item := range items {
+			if !item.Optional {
+				continue
+			}
+			offered++
+			flags := uintptr(mfString)
+			if item.Installed {
+				flags |= mfChecked
+			}
+			if busy {
+				flags |= mfGrayed
+			}
+			text := item.DisplayName
+			if item.UpdatePending {
+				text += " (update available)"
+			}
+			appendMenu(flags, menuFirstItem+uintptr(i), text)
+		}
+		if offered == 0 {
+			appendMenu(mfString|mfGrayed, 0, "No optional software is available")
+		}
+
+		// Changes to managed items are made by the next run
+		if pending := pendingActions(items); len(pending) > 0 {
+			appendMenu(mfSeparator, 0, "")
+			appendMenu(mfString|mfGrayed, 0, "Waiting for the next run:")
+			for _, item := range pending {
+				appendMenu(mfString|mfGrayed, 0, fmt.Sprintf("    %s %s %s", strings.Title(item.Pending), item.DisplayName, item.Version))
+			}
+		}
+	}
+	appendMenu(mfSeparator, 0, "")
+	appendMenu(mfString, menuRefresh, "Refresh")
+	appendMenu(mfString, menuQuit, "Quit")
+
+	// The menu only closes when clicking elsewhere if our window is in the foreground
+	var pt point
+	procGetCursorPos.Call(uintptr(unsafe.Pointer(&pt)))
+	procSetForegroundWindow.Call(t.hwnd)
+	id, _, _ := procTrackPopupMenu.Call(menu, tpmRightButton|tpmReturnCmd, uintptr(pt.X), uintptr(pt.Y), 0, t.hwnd, 0)
+
+	switch {
+	case id == menuRefresh:
+		go t.refresh()
+	case id == menuQuit:
+		procPostQuitMessage.Call(0)
+	case id >= menuFirstItem && int(id-menuFirstItem) < len(items):
+		t.confirm(items[id-menuFirstItem])
+	}
+}
+
+// pendingActions returns the managed items that the next run will install, update, or remove
+func pendingActions(items []ipc.Item) (pending []ipc.Item) {
+	for _, item := range items {
+		if item.Pending != "" && !item.Optional {
+			pending = append(pending, item)
+		}
+	}
+	return pending
+}
+
+// confirm asks before installing, updating, or removing an optional install
+func (t *tray) confirm(item ipc.Item) {
+	command, question := "install", fmt.Sprintf("Install %s?", item.DisplayName)
+	switch {
+	case item.UpdatePending:
+		question = fmt.Sprintf("Update %s to %s?", item.DisplayName, item.Version)
+	case item.Installed:
+		command, question = "remove", fmt.Sprintf("Remove %s?", item.DisplayName)
+	}
+	r, _, _ := procMessageBox.Call(t.hwnd, uintptr(unsafe.Pointer(utf16(question))), uintptr(unsafe.Pointer(utf16("Gorilla"))), mbYesNo|mbIconQuestion)
+	if r == idYes {
+		go t.act(command, item)
+	}
+}
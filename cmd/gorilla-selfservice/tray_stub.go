@@ -0,0 +1,13 @@
+// Without a darwin specific build, go tools will try to include Windows libraries and fail
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import "fmt"
+
+// runTray is just a placeholder on darwin
+func runTray() error {
+	return fmt.Errorf("the self-service app is only supported on Windows")
+}
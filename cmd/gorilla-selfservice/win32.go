@@ -0,0 +1,135 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "golang.org/x/sys/windows"
+
+var (
+	user32   = windows.NewLazySystemDLL("user32.dll")
+	shell32  = windows.NewLazySystemDLL("shell32.dll")
+	kernel32 = windows.NewLazySystemDLL("kernel32.dll")
+
+	procGetModuleHandle     = kernel32.NewProc("GetModuleHandleW")
+	procRegisterClassEx     = user32.NewProc("RegisterClassExW")
+	procCreateWindowEx      = user32.NewProc("CreateWindowExW")
+	procDefWindowProc       = user32.NewProc("DefWindowProcW")
+	procGetMessage          = user32.NewProc("GetMessageW")
+	procTranslateMessage    = user32.NewProc("TranslateMessage")
+	procDispatchMessage     = user32.NewProc("DispatchMessageW")
+	procPostMessage         = user32.NewProc("PostMessageW")
+	procPostQuitMessage     = user32.NewProc("PostQuitMessage")
+	procLoadIcon            = user32.NewProc("LoadIconW")
+	procCreatePopupMenu     = user32.NewProc("CreatePopupMenu")
+	procAppendMenu          = user32.NewProc("AppendMenuW")
+	procTrackPopupMenu      = user32.NewProc("TrackPopupMenu")
+	procDestroyMenu         = user32.NewProc("DestroyMenu")
+	procGetCursorPos        = user32.NewProc("GetCursorPos")
+	procSetForegroundWindow = user32.NewProc("SetForegroundWindow")
+	procMessageBox          = user32.NewProc("MessageBoxW")
+	procSetTimer            = user32.NewProc("SetTimer")
+	procShellNotifyIcon     = shell32.NewProc("Shell_NotifyIconW")
+)
+
+// Window messages and flags, from WinUser.h and shellapi.h
+const (
+	wmDestroy      = 0x0002
+	wmTimer        = 0x0113
+	wmLButtonUp    = 0x0202
+	wmRButtonUp    = 0x0205
+	wmApp          = 0x8000
+	idiApplication = 32512
+
+	mfString    = 0x0000
+	mfGrayed    = 0x0001
+	mfChecked   = 0x0008
+	mfSeparator = 0x0800
+
+	tpmRightButton = 0x0002
+	tpmReturnCmd   = 0x0100
+
+	mbYesNo        = 0x0004
+	mbIconQuestion = 0x0020
+	idYes          = 6
+
+	nimAdd    = 0x0
+	nimModify = 0x1
+	nimDelete = 0x2
+
+	nifMessage = 0x1
+	nifIcon    = 0x2
+	nifTip     = 0x4
+	nifInfo    = 0x10
+
+	niifInfo  = 0x1
+	niifError = 0x3
+)
+
+type point struct {
+	X, Y int32
+}
+
+type msg struct {
+	HWnd    uintptr
+	Message uint32
+	WParam  uintptr
+	LParam  uintptr
+	Time    uint32
+	Pt      point
+	Private uint32
+}
+
+type wndClassEx struct {
+	Size       uint32
+	Style      uint32
+	WndProc    uintptr
+	ClsExtra   int32
+	WndExtra   int32
+	Instance   uintptr
+	Icon       uintptr
+	Cursor     uintptr
+	Background uintptr
+	MenuName   *uint16
+	ClassName  *uint16
+	IconSm     uintptr
+}
+
+type notifyIconData struct {
+	Size            uint32
+	HWnd            uintptr
+	ID              uint32
+	Flags           uint32
+	CallbackMessage uint32
+	Icon            uintptr
+	Tip             [128]uint16
+	State           uint32
+	StateMask       uint32
+	Info            [256]uint16
+	Version         uint32
+	InfoTitle       [64]uint16
+	InfoFlags       uint32
+	GUIDItem        windows.GUID
+	BalloonIcon     uintptr
+}
+
+// copyUTF16 copies a string into a fixed size buffer, cutting it short if it doesnt fit
+func copyUTF16(dst []uint16, s string) {
+	src, err := windows.UTF16FromString(s)
+	if err != nil {
+		return
+	}
+	if len(src) > len(dst) {
+		src = src[:len(dst)]
+		src[len(src)-1] = 0
+	}
+	copy(dst, src)
+}
+
+// utf16 returns a string for a win32 call, which is empty if the string cant be converted
+func utf16(s string) *uint16 {
+	p, err := windows.UTF16PtrFromString(s)
+	if err != nil {
+		p, _ = windows.UTF16PtrFromString("")
+	}
+	return p
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/1dustindavis/gorilla/pkg/catalog"
+	"github.com/1dustindavis/gorilla/pkg/config"
+	"github.com/1dustindavis/gorilla/pkg/download"
+	"github.com/1dustindavis/gorilla/pkg/gitrepo"
+	"github.com/1dustindavis/gorilla/pkg/gorillalog"
+	"github.com/1dustindavis/gorilla/pkg/installer"
+	"github.com/1dustindavis/gorilla/pkg/manifest"
+	"github.com/1dustindavis/gorilla/pkg/process"
+	"github.com/1dustindavis/gorilla/pkg/report"
+	"github.com/1dustindavis/gorilla/pkg/state"
+)
+
+// adhocCommands act on items right away, without editing a manifest
+var adhocCommands = map[string]bool{
+	"install": true,
+	"remove":  true,
+	"status":  true,
+}
+
+// splitItems returns the item names at the start of the arguments, and the options after them
+func splitItems(args []string) (items []string, options []string) {
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		items, args = append(items, args[0]), args[1:]
+	}
+	return items, args
+}
+
+// adhocCommand handles `gorilla <install|remove|status> <item>... [options]`
+// Items are found in the same catalogs a normal run would use, and installs bring in their dependencies
+func adhocCommand(command string, args []string) {
+	items, options := splitItems(args)
+	if len(items) == 0 {
+		fail(report.ExitConfigError, "Expected at least one item to", command)
+	}
+
+	// Read the configuration using only the options
+	os.Args = append([]string{os.Args[0]}, options...)
+	cfg := config.Get()
+	report.SummaryPath = cfg.JSONSummary
+
+	// Checking status never changes anything, so it doesnt need an administrator
+	if command == "status" {
+		cfg.CheckOnly = true
+	}
+	if !cfg.CheckOnly {
+		admin, err := adminCheck()
+		if err != nil {
+			fail(report.ExitConfigError, "Unable to check if running as admin, got:", err)
+		}
+		if !admin {
+			fail(report.ExitConfigError, "Gorilla requires admnisistrative access. Please run as an administrator.")
+		}
+	}
+	if err := os.MkdirAll(filepath.Clean(cfg.CachePath), 0755); err != nil {
+		fail(report.ExitConfigError, "Unable to create cache directory: ", err)
+	}
+	gorillalog.NewLog(cfg)
+	if !cfg.CheckOnly {
+		takeLock(cfg)
+	}
+
+	if cfg.GitPath != "" {
+		if err := gitrepo.Sync(cfg.GitURL, cfg.GitBranch, cfg.GitPath); err != nil {
+			fail(report.ExitNetworkError, "Unable to sync git repo:", err)
+		}
+	}
+
+	// Keep the history of attempts, so an item that keeps failing here is known to the next run
+	if !cfg.CheckOnly {
+		if err := state.Load(cfg.AppDataPath); err != nil {
+			gorillalog.Warn("Unable to read state, starting over:", err)
+		}
+	}
+	download.SetConfig(cfg)
+	installer.SetConfig(cfg)
+	ctx := interruptContext(cfg)
+	download.SetContext(ctx)
+	installer.SetContext(ctx)
+	setProgress(cfg)
+
+	// The manifests may add catalogs, and tell us how each item is already managed
+	pickManifest(&cfg)
+	gorillalog.Info("Retrieving manifest:", cfg.Manifest)
+	manifests, newCatalogs := manifest.Get(cfg)
+	cfg.Catalogs = append(cfg.Catalogs, newCatalogs...)
+	catalogs := catalog.Get(cfg)
+	installs, uninstalls, updates := process.Manifests(manifests, catalogs)
+	optional := process.Optional(manifests, catalogs)
+
+	switch command {
+	case "install":
+		gorillalog.Info("Installing requested items:", items)
+		process.Installs(items, catalogs, cfg.URLPackages, cfg.CachePath, cfg.CheckOnly)
+		process.SetRequested(items, optional, catalogs, true)
+	case "remove":
+		gorillalog.Info("Removing requested items:", items)
+		process.SetRequested(items, optional, catalogs, false)
+		process.Uninstalls(items, catalogs, cfg.URLPackages, cfg.CachePath, cfg.CheckOnly, cfg.Force)
+		if cfg.RemoveDependencies {
+			process.UninstallUnused(items, installs, updates, catalogs, cfg.URLPackages, cfg.CachePath, cfg.CheckOnly)
+		}
+	case "status":
+		process.ItemStatus(items, installs, uninstalls, updates, optional, catalogs, cfg.CachePath)
+	}
+
+	if !cfg.CheckOnly {
+		handleRestart(cfg)
+		if err := state.Save(); err != nil {
+			gorillalog.Warn("Unable to save state:", err)
+		}
+	}
+	report.Exit()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// maxBackoff is the longest we will wait between attempts, no matter how many have failed
+const maxBackoff = 24 * time.Hour
+
+// backoffState is saved between runs to track how long the repo has been unreachable
+type backoffState struct {
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+}
+
+// backoffPath returns the path of the backoff state file
+func backoffPath(appDataPath string) string {
+	return filepath.Join(appDataPath, "backoff.json")
+}
+
+// readBackoff returns the saved backoff state, or an empty state if there is none
+func readBackoff(appDataPath string) backoffState {
+	var state backoffState
+	stateJSON, err := ioutil.ReadFile(backoffPath(appDataPath))
+	if err == nil {
+		json.Unmarshal(stateJSON, &state)
+	}
+	return state
+}
+
+// interval returns how long to wait after the last failure, doubling with each consecutive failure
+func (state backoffState) interval(baseMinutes int) time.Duration {
+	interval := time.Duration(baseMinutes) * time.Minute
+	for i := 1; i < state.Failures && interval < maxBackoff; i++ {
+		interval *= 2
+	}
+	if interval > maxBackoff {
+		interval = maxBackoff
+	}
+	return interval
+}
+
+// remaining returns how much longer we should wait before contacting the repo again
+func (state backoffState) remaining(baseMinutes int) time.Duration {
+	if state.Failures == 0 {
+		return 0
+	}
+	return time.Until(state.LastFailure.Add(state.interval(baseMinutes)))
+}
+
+// recordFailure saves another consecutive failure to the backoff state file
+func recordFailure(appDataPath string, state backoffState) (backoffState, error) {
+	state.Failures++
+	state.LastFailure = time.Now().UTC()
+	stateJSON, err := json.Marshal(state)
+	if err != nil {
+		return state, err
+	}
+	return state, ioutil.WriteFile(backoffPath(appDataPath), stateJSON, 0644)
+}
+
+// resetBackoff removes the backoff state once the repo is reachable again
+func resetBackoff(appDataPath string) error {
+	err := os.Remove(backoffPath(appDataPath))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestInterval verifies the wait doubles with each failure in a row, up to maxBackoff
+func TestInterval(t *testing.T) {
+	for _, test := range []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 0, want: 60 * time.Minute},
+		{failures: 1, want: 60 * time.Minute},
+		{failures: 2, want: 120 * time.Minute},
+		{failures: 4, want: 480 * time.Minute},
+		{failures: 6, want: maxBackoff},
+		{failures: 100, want: maxBackoff},
+	} {
+		if have := (backoffState{Failures: test.failures}).interval(60); have != test.want {
+			t.Errorf("%d failures: have %s, want %s", test.failures, have, test.want)
+		}
+	}
+}
+
+// TestRemaining verifies how long is left to wait is counted from the last failure
+func TestRemaining(t *testing.T) {
+	if have := (backoffState{}).remaining(60); have != 0 {
+		t.Errorf("expected no wait without failures, got %s", have)
+	}
+
+	// Two failures wait two hours, and one has passed
+	state := backoffState{Failures: 2, LastFailure: time.Now().Add(-time.Hour)}
+	if have := state.remaining(60); have <= 59*time.Minute || have > time.Hour {
+		t.Errorf("have %s, want about an hour", have)
+	}
+
+	// Once the wait is over there is nothing left
+	state.LastFailure = time.Now().Add(-3 * time.Hour)
+	if have := state.remaining(60); have > 0 {
+		t.Errorf("expected the wait to be over, got %s", have)
+	}
+}
+
+// TestRecordFailure verifies each failure is saved, and the saved state is removed once the repo is reachable
+func TestRecordFailure(t *testing.T) {
+	appData, err := ioutil.TempDir("", "gorilla_backoff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(appData)
+
+	if _, err := recordFailure(appData, readBackoff(appData)); err != nil {
+		t.Fatal(err)
+	}
+	state, err := recordFailure(appData, readBackoff(appData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := readBackoff(appData)
+	if saved.Failures != 2 || !saved.LastFailure.Equal(state.LastFailure) {
+		t.Errorf("have %#v, want %#v", saved, state)
+	}
+
+	if err := resetBackoff(appData); err != nil {
+		t.Fatal(err)
+	}
+	if saved := readBackoff(appData); saved.Failures != 0 {
+		t.Errorf("expected the backoff to be reset, got %#v", saved)
+	}
+
+	// Resetting when there is nothing to reset is fine
+	if err := resetBackoff(appData); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
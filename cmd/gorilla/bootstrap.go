@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/1dustindavis/gorilla/pkg/config"
+	"github.com/1dustindavis/gorilla/pkg/gorillalog"
+	"github.com/1dustindavis/gorilla/pkg/installer"
+)
+
+const (
+	// maxBootstrapPasses stops a bootstrap run that never settles, like an item that installs but is never detected
+	maxBootstrapPasses = 10
+
+	// How long the service waits to try again while the computer is still being set up
+	bootstrapInterval = time.Minute
+
+	// How long a computer being set up waits to restart, since nobody should be using it yet
+	bootstrapRestartDelay = time.Minute
+)
+
+// This abstraction allows us to override when testing
+var restartNeeded = installer.RestartNeeded
+
+// bootstrapPass is how one pass through the manifests went
+type bootstrapPass struct {
+	number   int
+	changes  int
+	failures int
+	// pending is how many items were put off, like ones skipped for a blocking app or after too many failures
+	pending int
+	// interrupted is true if the run was stopped before the pass finished, so anything may have been skipped
+	interrupted bool
+}
+
+// done returns true once a pass has nothing left to change, and nothing failed, was put off, or was skipped
+func (p bootstrapPass) done() bool {
+	return p.changes == 0 && p.failures == 0 && p.pending == 0 && !p.interrupted
+}
+
+// again returns true if a bootstrap run should go through everything again
+// Another pass is only useful when the last one changed something, and nothing is waiting on a restart
+func (p bootstrapPass) again(ctx context.Context) bool {
+	if ctx.Err() != nil || p.interrupted || p.changes == 0 {
+		return false
+	}
+	if restartRequired() {
+		return false
+	}
+	if p.number >= maxBootstrapPasses {
+		gorillalog.Warn("Bootstrap is still making changes after", p.number, "passes, stopping")
+		return false
+	}
+	gorillalog.Info("Bootstrap pass", p.number, "made", p.changes, "changes, checking again")
+	return true
+}
+
+// restartRequired returns true if anything installed or removed this run needs a restart to finish
+func restartRequired() bool {
+	action, _ := restartNeeded()
+	return action == installer.RestartRequired
+}
+
+// bootstrapping returns true while the bootstrap flag is there
+func bootstrapping(appDataPath string) bool {
+	_, err := os.Stat(config.BootstrapFlagPath(appDataPath))
+	return err == nil
+}
+
+// finishBootstrap shows how the bootstrap run went, and removes the bootstrap flag once the computer has everything it needs
+// Until then the flag stays, and the service keeps running gorilla, including after the restarts it asks for
+func finishBootstrap(cfg config.Configuration, p bootstrapPass) {
+	switch {
+	case p.interrupted:
+		bootstrapStatus("Setting up this computer was interrupted, and will continue on the next run")
+	case p.done():
+		bootstrapStatus("This computer is set up, after", p.number, "passes")
+		if !bootstrapping(cfg.AppDataPath) {
+			return
+		}
+		if err := os.Remove(config.BootstrapFlagPath(cfg.AppDataPath)); err != nil {
+			gorillalog.Warn("Unable to remove the bootstrap flag:", err)
+			return
+		}
+		gorillalog.Info("Removed the bootstrap flag")
+	case restartRequired():
+		bootstrapStatus("Setting up this computer will continue after a restart")
+	case p.failures > 0:
+		bootstrapStatus("Setting up this computer is not finished,", p.failures, "items failed")
+	case p.pending > 0:
+		bootstrapStatus("Setting up this computer is not finished,", p.pending, "items are waiting")
+	default:
+		bootstrapStatus("Setting up this computer is not finished")
+	}
+}
+
+// bootstrapStatus shows how setting up the computer is going on the console, for anyone watching a task sequence,
+// as well as in the log
+func bootstrapStatus(msg ...interface{}) {
+	status := strings.TrimSpace(fmt.Sprintln(msg...))
+	gorillalog.Info(status)
+	if isTerminal(os.Stderr) {
+		line := strings.Repeat("=", len(status)+4)
+		fmt.Fprintf(os.Stderr, "\n%s\n  %s\n%s\n\n", line, status, line)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/1dustindavis/gorilla/pkg/config"
+	"github.com/1dustindavis/gorilla/pkg/installer"
+)
+
+// TestBootstrapPass verifies a pass is only done when nothing changed, failed, was put off, or was interrupted,
+// and another pass only follows one that changed something
+func TestBootstrapPass(t *testing.T) {
+	restart := ""
+	restartNeeded = func() (string, []string) { return restart, nil }
+	defer func() { restartNeeded = installer.RestartNeeded }()
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, test := range []struct {
+		name      string
+		pass      bootstrapPass
+		ctx       context.Context
+		restart   string
+		wantDone  bool
+		wantAgain bool
+	}{
+		{name: "settled", pass: bootstrapPass{number: 2}, wantDone: true},
+		{name: "changed", pass: bootstrapPass{number: 1, changes: 3}, wantAgain: true},
+		{name: "failed", pass: bootstrapPass{number: 1, failures: 1}},
+		{name: "failed while changing", pass: bootstrapPass{number: 1, changes: 2, failures: 1}, wantAgain: true},
+		{name: "put off", pass: bootstrapPass{number: 1, pending: 1}},
+		{name: "interrupted", pass: bootstrapPass{number: 1, interrupted: true}},
+		{name: "interrupted while changing", pass: bootstrapPass{number: 1, changes: 2, interrupted: true}},
+		{name: "cancelled", pass: bootstrapPass{number: 1, changes: 2}, ctx: cancelled},
+		{name: "waiting on a restart", pass: bootstrapPass{number: 1, changes: 2}, restart: installer.RestartRequired},
+		{name: "too many passes", pass: bootstrapPass{number: maxBootstrapPasses, changes: 2}},
+	} {
+		ctx := test.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		restart = test.restart
+		if have := test.pass.done(); have != test.wantDone {
+			t.Errorf("%s: done is %v, want %v", test.name, have, test.wantDone)
+		}
+		if have := test.pass.again(ctx); have != test.wantAgain {
+			t.Errorf("%s: again is %v, want %v", test.name, have, test.wantAgain)
+		}
+	}
+}
+
+// TestFinishBootstrap verifies the bootstrap flag is only removed once the computer is set up
+func TestFinishBootstrap(t *testing.T) {
+	restartNeeded = func() (string, []string) { return "", nil }
+	defer func() { restartNeeded = installer.RestartNeeded }()
+
+	for _, test := range []struct {
+		name     string
+		pass     bootstrapPass
+		wantFlag bool
+	}{
+		{name: "settled", pass: bootstrapPass{number: 2}},
+		{name: "failed", pass: bootstrapPass{number: 1, failures: 1}, wantFlag: true},
+		{name: "put off", pass: bootstrapPass{number: 1, pending: 2}, wantFlag: true},
+		{name: "interrupted", pass: bootstrapPass{number: 1, interrupted: true}, wantFlag: true},
+	} {
+		appData, err := ioutil.TempDir("", "gorilla_bootstrap")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(appData)
+		if err := ioutil.WriteFile(config.BootstrapFlagPath(appData), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		finishBootstrap(config.Configuration{AppDataPath: appData}, test.pass)
+		if have := bootstrapping(appData); have != test.wantFlag {
+			t.Errorf("%s: flag exists is %v, want %v", test.name, have, test.wantFlag)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	user32                   = windows.NewLazySystemDLL("user32.dll")
+	kernel32                 = windows.NewLazySystemDLL("kernel32.dll")
+	procGetLastInputInfo     = user32.NewProc("GetLastInputInfo")
+	procGetTickCount         = kernel32.NewProc("GetTickCount")
+	procGetSystemPowerStatus = kernel32.NewProc("GetSystemPowerStatus")
+)
+
+const (
+	// noUserIdle is how long we report as idle when nobody is logged in
+	noUserIdle = 365 * 24 * time.Hour
+	// idleTimeout is how long we wait on the helper running as the user
+	idleTimeout = 30 * time.Second
+	// maxIdleSeconds keeps idle times apart from error codes like 0xC0000005
+	maxIdleSeconds = 0x7FFFFFFF
+)
+
+// lastInputInfo matches the LASTINPUTINFO struct
+// See https://docs.microsoft.com/en-us/windows/win32/api/winuser/ns-winuser-lastinputinfo
+type lastInputInfo struct {
+	cbSize uint32
+	dwTime uint32
+}
+
+// systemPowerStatus matches the SYSTEM_POWER_STATUS struct
+// See https://docs.microsoft.com/en-us/windows/win32/api/winbase/ns-winbase-system_power_status
+type systemPowerStatus struct {
+	ACLineStatus        byte
+	BatteryFlag         byte
+	BatteryLifePercent  byte
+	SystemStatusFlag    byte
+	BatteryLifeTime     uint32
+	BatteryFullLifeTime uint32
+}
+
+// idleTime returns how long it has been since the user at the console last used the keyboard or mouse
+// Input can only be seen from the user's own session, so when we run as SYSTEM in session 0
+// we start `gorilla.exe idle` as the user, which exits with the number of seconds they have been idle
+func idleTime() (time.Duration, error) {
+	// 0xFFFFFFFF means nobody is attached to the console
+	sessionID := windows.WTSGetActiveConsoleSessionId()
+	if sessionID == 0xFFFFFFFF {
+		return noUserIdle, nil
+	}
+	var ourSession uint32
+	if err := windows.ProcessIdToSessionId(windows.GetCurrentProcessId(), &ourSession); err == nil && ourSession == sessionID {
+		return lastInput()
+	}
+
+	var token windows.Token
+	if err := windows.WTSQueryUserToken(sessionID, &token); err != nil {
+		if err == windows.ERROR_NO_TOKEN {
+			return noUserIdle, nil
+		}
+		return 0, fmt.Errorf("unable to get the logged in user's token: %v", err)
+	}
+	defer token.Close()
+
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	commandLine, err := windows.UTF16PtrFromString(windows.EscapeArg(exe) + " " + idleCommand)
+	if err != nil {
+		return 0, err
+	}
+	// The helper has to be on the user's desktop to see their input
+	desktop, err := windows.UTF16PtrFromString(`winsta0\default`)
+	if err != nil {
+		return 0, err
+	}
+	startup := windows.StartupInfo{Cb: uint32(unsafe.Sizeof(windows.StartupInfo{})), Desktop: desktop}
+	var process windows.ProcessInformation
+	err = windows.CreateProcessAsUser(token, nil, commandLine, nil, nil, false, windows.CREATE_NO_WINDOW, nil, nil, &startup, &process)
+	if err != nil {
+		return 0, fmt.Errorf("unable to check idle time as the logged in user: %v", err)
+	}
+	defer windows.CloseHandle(process.Process)
+	defer windows.CloseHandle(process.Thread)
+
+	if event, err := windows.WaitForSingleObject(process.Process, uint32(idleTimeout/time.Millisecond)); event != windows.WAIT_OBJECT_0 {
+		windows.TerminateProcess(process.Process, 1)
+		return 0, fmt.Errorf("timed out checking idle time as the logged in user: %v", err)
+	}
+	var code uint32
+	if err := windows.GetExitCodeProcess(process.Process, &code); err != nil {
+		return 0, err
+	}
+	// Crashes and failures exit with codes far larger than any idle time
+	if code > maxIdleSeconds {
+		return 0, fmt.Errorf("checking idle time as the logged in user failed with %#x", code)
+	}
+	return time.Duration(code) * time.Second, nil
+}
+
+// idleExit exits with the number of seconds since the last input in our session, for idleTime to read
+func idleExit() {
+	idle, err := lastInput()
+	if err != nil {
+		os.Exit(-1)
+	}
+	seconds := int(idle / time.Second)
+	if seconds > maxIdleSeconds {
+		seconds = maxIdleSeconds
+	}
+	os.Exit(seconds)
+}
+
+// lastInput returns how long it has been since the last keyboard or mouse input in our own session
+func lastInput() (time.Duration, error) {
+	info := lastInputInfo{cbSize: uint32(unsafe.Sizeof(lastInputInfo{}))}
+	ret, _, err := procGetLastInputInfo.Call(uintptr(unsafe.Pointer(&info)))
+	if ret == 0 {
+		return 0, err
+	}
+
+	// Both values are milliseconds since boot, and wrap around together
+	tickCount, _, _ := procGetTickCount.Call()
+	return time.Duration(uint32(tickCount)-info.dwTime) * time.Millisecond, nil
+}
+
+// onBattery returns true if the computer is not connected to AC power
+func onBattery() (bool, error) {
+	var status systemPowerStatus
+	ret, _, err := procGetSystemPowerStatus.Call(uintptr(unsafe.Pointer(&status)))
+	if ret == 0 {
+		return false, err
+	}
+
+	// 0 is offline, 1 is online, and 255 is unknown
+	return status.ACLineStatus == 0, nil
+}
+
+// onMetered returns true if the current internet connection is metered
+// The connection cost is only available from WinRT, so we ask powershell for it
+func onMetered() (bool, error) {
+	psCmd := filepath.Join(os.Getenv("WINDIR"), "system32/", "WindowsPowershell", "v1.0", "powershell.exe")
+	psScript := `[void][Windows.Networking.Connectivity.NetworkInformation, Windows.Networking.Connectivity, ContentType=WindowsRuntime]; ` +
+		`[Windows.Networking.Connectivity.NetworkInformation]::GetInternetConnectionProfile().GetConnectionCost().NetworkCostType`
+	out, err := exec.Command(psCmd, "-NoProfile", "-NoLogo", "-NonInteractive", "-Command", psScript).Output()
+	if err != nil {
+		return false, err
+	}
+
+	// Anything other than "Unrestricted" (or "Unknown") is billed by usage
+	costType := strings.TrimSpace(string(out))
+	return costType == "Fixed" || costType == "Variable", nil
+}
@@ -0,0 +1,30 @@
+// Without an OS specific build, go tools will try to include Windows libraries and fail
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+var errUnsupported = errors.New("not supported on this platform")
+
+func idleTime() (time.Duration, error) {
+	return 0, errUnsupported
+}
+
+func idleExit() {
+	os.Exit(-1)
+}
+
+func onBattery() (bool, error) {
+	return false, errUnsupported
+}
+
+func onMetered() (bool, error) {
+	return false, errUnsupported
+}
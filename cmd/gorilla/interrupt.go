@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/1dustindavis/gorilla/pkg/config"
+	"github.com/1dustindavis/gorilla/pkg/gorillalog"
+	"github.com/1dustindavis/gorilla/pkg/report"
+)
+
+// interruptContext returns a context that is cancelled when the run is interrupted,
+// whether by Ctrl+C, Windows shutting down, the service stopping, or the run taking longer than run_timeout.
+// The interruption is recorded in the report, and a second Ctrl+C exits right away.
+func interruptContext(cfg config.Configuration) context.Context {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	var cancel context.CancelFunc
+	if cfg.RunTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(cfg.RunTimeout)*time.Minute)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	watchServiceStop(cancel)
+	go func() {
+		<-ctx.Done()
+		stop()
+		report.Interrupt()
+		if ctx.Err() == context.DeadlineExceeded {
+			gorillalog.Warn("The run took longer than", cfg.RunTimeout, "minutes, stopping once the current step finishes")
+			return
+		}
+		gorillalog.Warn("The run was interrupted, stopping once the current step finishes")
+	}()
+	return ctx
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/1dustindavis/gorilla/pkg/catalog"
+	"github.com/1dustindavis/gorilla/pkg/config"
+	"github.com/1dustindavis/gorilla/pkg/download"
+	"github.com/1dustindavis/gorilla/pkg/gitrepo"
+	"github.com/1dustindavis/gorilla/pkg/gorillalog"
+	"github.com/1dustindavis/gorilla/pkg/manifest"
+	"github.com/1dustindavis/gorilla/pkg/process"
+	"github.com/1dustindavis/gorilla/pkg/report"
+	"github.com/1dustindavis/gorilla/pkg/state"
+)
+
+// listCommand handles `gorilla list [options]`
+// It prints the optional installs and pending actions as JSON, which is what the self-service app shows
+func listCommand(args []string) {
+	os.Args = append([]string{os.Args[0]}, args...)
+	cfg := config.Get()
+
+	// Listing never changes anything, and stdout is reserved for the list
+	cfg.CheckOnly = true
+	logCfg := cfg
+	logCfg.JSONSummary = "-"
+	gorillalog.NewLog(logCfg)
+
+	if cfg.GitPath != "" {
+		if err := gitrepo.Sync(cfg.GitURL, cfg.GitBranch, cfg.GitPath); err != nil {
+			fail(report.ExitNetworkError, "Unable to sync git repo:", err)
+		}
+	}
+
+	// The state knows which optional installs someone already asked for
+	if err := state.Load(cfg.AppDataPath); err != nil {
+		gorillalog.Warn("Unable to read state:", err)
+	}
+	download.SetConfig(cfg)
+
+	pickManifest(&cfg)
+	manifests, newCatalogs := manifest.Get(cfg)
+	cfg.Catalogs = append(cfg.Catalogs, newCatalogs...)
+	catalogs := catalog.Get(cfg)
+	installs, uninstalls, updates := process.Manifests(manifests, catalogs)
+	optional := process.Optional(manifests, catalogs)
+	installs = append(installs, process.Requested(optional, installs, catalogs)...)
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "    ")
+	if err := encoder.Encode(process.List(installs, uninstalls, updates, optional, catalogs, cfg.CachePath)); err != nil {
+		fail(report.ExitConfigError, "Unable to print the list:", err)
+	}
+	report.Exit()
+}
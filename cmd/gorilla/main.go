@@ -1,54 +1,173 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/1dustindavis/gorilla/pkg/catalog"
 	"github.com/1dustindavis/gorilla/pkg/config"
 	"github.com/1dustindavis/gorilla/pkg/download"
+	"github.com/1dustindavis/gorilla/pkg/gitrepo"
 	"github.com/1dustindavis/gorilla/pkg/gorillalog"
+	"github.com/1dustindavis/gorilla/pkg/installer"
+	"github.com/1dustindavis/gorilla/pkg/lock"
 	"github.com/1dustindavis/gorilla/pkg/manifest"
 	"github.com/1dustindavis/gorilla/pkg/process"
 	"github.com/1dustindavis/gorilla/pkg/report"
+	"github.com/1dustindavis/gorilla/pkg/state"
+)
+
+// idleCommand is the hidden subcommand idleTime runs as the logged in user
+const idleCommand = "idle"
+
+// These check the state of the computer before a scheduled run, and are variables so tests can replace them
+var (
+	lookupIdle    = idleTime
+	lookupBattery = onBattery
+	lookupMetered = onMetered
 )
 
 func main() {
 
+	// Report how long the logged in user has been idle, for a run in another session
+	if len(os.Args) > 1 && os.Args[1] == idleCommand {
+		idleExit()
+	}
+
+	// Manage the Windows service, or run as one
+	if len(os.Args) > 1 && os.Args[1] == "service" {
+		err := serviceCommand(os.Args[2:])
+		if err != nil {
+			fail(report.ExitConfigError, "Service error:", err)
+		}
+		return
+	}
+
+	// Encrypt secrets for the config file
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		err := configCommand(os.Args[2:])
+		if err != nil {
+			fail(report.ExitConfigError, "Config error:", err)
+		}
+		return
+	}
+
+	// List what the self-service app can offer
+	if len(os.Args) > 1 && os.Args[1] == "list" {
+		listCommand(os.Args[2:])
+	}
+
+	// Install, remove, or check specific items without a manifest
+	if len(os.Args) > 1 && adhocCommands[os.Args[1]] {
+		adhocCommand(os.Args[1], os.Args[2:])
+	}
+
 	// Get our configuration
 	cfg := config.Get()
+	report.SummaryPath = cfg.JSONSummary
 	var err error
 	// if --checkonly is NOT passed, we need to run adminCheck()
 	if !cfg.CheckOnly {
 		admin, err := adminCheck()
 		if err != nil {
-			fmt.Println("Unable to check if running as admin, got: %w", err)
-			os.Exit(1)
+			fail(report.ExitConfigError, "Unable to check if running as admin, got:", err)
 		}
 		if !admin {
-			fmt.Println("Gorilla requires admnisistrative access. Please run as an administrator.")
-			os.Exit(1)
+			fail(report.ExitConfigError, "Gorilla requires admnisistrative access. Please run as an administrator.")
 		}
 	}
 
 	// If needed, create the cache directory
 	err = os.MkdirAll(filepath.Clean(cfg.CachePath), 0755)
 	if err != nil {
-		fmt.Println("Unable to create cache directory: ", err)
-		os.Exit(1)
+		fail(report.ExitConfigError, "Unable to create cache directory: ", err)
 	}
 
 	// Create a new logger object
 	gorillalog.NewLog(cfg)
 
+	// Clear the cache and stop, instead of running
+	if cfg.ClearCache {
+		// The cache is the bootstrap media, which we should never delete from
+		if cfg.BootstrapPath != "" {
+			fail(report.ExitConfigError, "The cache can not be cleared in bootstrap mode")
+		}
+		gorillalog.Info("Clearing the cache...")
+		err = process.ClearCache(cfg.CachePath)
+		if err != nil {
+			fail(report.ExitConfigError, "Unable to clear the cache:", err)
+		}
+		gorillalog.Info("Done!")
+		return
+	}
+
+	// Wait for a better time if the computer is busy, unless we are forced to run now
+	// Only scheduled runs wait, since someone is waiting on any other run
+	if !cfg.CheckOnly && !cfg.Force && cfg.Mode == config.ModeAuto {
+		if reason := deferRun(cfg); reason != "" {
+			gorillalog.Info("Deferring run because", reason)
+			report.Exit()
+		}
+	}
+
+	// Only one run may make changes at a time
+	if !cfg.CheckOnly {
+		takeLock(cfg)
+	}
+
+	// Bring the local copy of a git repo up to date, since catalogs and manifests are read from it
+	if cfg.GitPath != "" {
+		err = gitrepo.Sync(cfg.GitURL, cfg.GitBranch, cfg.GitPath)
+		if err != nil {
+			fail(report.ExitNetworkError, "Unable to sync git repo:", err)
+		}
+	}
+
 	// Start creating GorillaReport
 	if !cfg.CheckOnly {
+		report.MetricsFile = cfg.MetricsFile
+		// Bootstrap media is used offline, so there is nowhere to send the report
+		if cfg.BootstrapPath == "" {
+			report.URL = cfg.ReportURL
+			report.PostFunc = download.Post
+		}
 		report.Start()
+
+		// Load the history of earlier attempts, so we know which items keep failing
+		if err := state.Load(cfg.AppDataPath); err != nil {
+			gorillalog.Warn("Unable to read state, starting over:", err)
+		}
 	}
 
-	// Set the configuration that `download` will use
+	// Set the configuration that `download` and `installer` will use
 	download.SetConfig(cfg)
+	installer.SetConfig(cfg)
+
+	// Stop downloads and installers cleanly if the run is interrupted
+	ctx := interruptContext(cfg)
+	download.SetContext(ctx)
+	installer.SetContext(ctx)
+
+	// Show how downloads and installers are coming along
+	setProgress(cfg)
+
+	// The manifest may be picked for this computer, like `serials/%serial%`
+	pickManifest(&cfg)
+
+	// Give the repo a break if it was recently unreachable
+	backoff := cfg.BackoffMinutes > 0 && !cfg.CheckOnly && !cfg.Force && cfg.Mode == config.ModeAuto
+	if backoff {
+		if backingOff(cfg) {
+			report.FailWith(report.ExitNetworkError)
+			report.End()
+			report.Exit()
+		}
+		// A manifest or catalog that cant be reached ends the run, so the failure is recorded on the way out
+		report.AtExit(func() { recordRepoFailure(cfg) })
+	}
 
 	// Get the manifests
 	gorillalog.Info("Retrieving manifest:", cfg.Manifest)
@@ -63,33 +182,221 @@ func main() {
 	gorillalog.Info("Retrieving catalog:", cfg.Catalogs)
 	catalogs := catalog.Get(cfg)
 
+	// Every manifest and catalog was downloaded, so the repo is reachable again
+	if backoff {
+		repoReached(cfg)
+	}
+
 	// Process the manifests into install type groups
 	gorillalog.Info("Processing manifest...")
 	installs, uninstalls, updates := process.Manifests(manifests, catalogs)
 
-	// Prepare and install
-	gorillalog.Info("Processing managed installs...")
-	process.Installs(installs, catalogs, cfg.URLPackages, cfg.CachePath, cfg.CheckOnly)
+	// Optional installs someone asked for are kept installed and up to date
+	optional := process.Optional(manifests, catalogs)
+	installs = append(installs, process.Requested(optional, installs, catalogs)...)
+
+	// Limit the run to items in the requested categories
+	if len(cfg.Categories) > 0 {
+		gorillalog.Info("Only processing items in categories:", cfg.Categories)
+		installs = process.FilterCategories(installs, cfg.Categories, catalogs)
+		uninstalls = process.FilterCategories(uninstalls, cfg.Categories, catalogs)
+		updates = process.FilterCategories(updates, cfg.Categories, catalogs)
+		optional = process.FilterCategories(optional, cfg.Categories, catalogs)
+	}
+
+	// In status only mode, print the state of each item and stop before taking any action
+	if cfg.StatusOnly {
+		process.Status(installs, uninstalls, updates, optional, catalogs, cfg.CachePath)
+		report.Exit()
+	}
+
+	// Bootstrap runs go through everything again while each pass changes something, since an install can
+	// make another item ready, like an update for an item that was just installed
+	for pass := 1; ; pass++ {
+		changes, failures, pending := installer.Changes(), len(report.FailedItems), len(report.PendingItems)
+		if cfg.Mode == config.ModeBootstrap && !cfg.CheckOnly {
+			bootstrapStatus("Setting up this computer, pass", pass)
+		}
+
+		// Prepare and install
+		gorillalog.Info("Processing managed installs...")
+		process.Installs(installs, catalogs, cfg.URLPackages, cfg.CachePath, cfg.CheckOnly)
+
+		// Prepare and uninstall
+		gorillalog.Info("Processing managed uninstalls...")
+		process.Uninstalls(uninstalls, catalogs, cfg.URLPackages, cfg.CachePath, cfg.CheckOnly, cfg.Force)
+
+		// Remove the dependencies the uninstalled items leave behind
+		if cfg.RemoveDependencies {
+			process.UninstallUnused(uninstalls, installs, updates, catalogs, cfg.URLPackages, cfg.CachePath, cfg.CheckOnly)
+		}
+
+		// Prepare and update, only items that are already installed are updated
+		gorillalog.Info("Processing managed updates...")
+		process.Updates(updates, catalogs, cfg.URLPackages, cfg.CachePath, cfg.CheckOnly)
 
-	// Prepare and uninstall
-	gorillalog.Info("Processing managed uninstalls...")
-	process.Uninstalls(uninstalls, catalogs, cfg.URLPackages, cfg.CachePath, cfg.CheckOnly)
+		if cfg.Mode != config.ModeBootstrap || cfg.CheckOnly {
+			break
+		}
+		result := bootstrapPass{
+			number:      pass,
+			changes:     installer.Changes() - changes,
+			failures:    len(report.FailedItems) - failures,
+			pending:     len(report.PendingItems) - pending,
+			interrupted: ctx.Err() != nil || report.Interrupted(),
+		}
+		if !result.again(ctx) {
+			finishBootstrap(cfg, result)
+			break
+		}
+	}
+
+	// Let the user know if anything we installed or removed needs a restart, and schedule it if required
+	if !cfg.CheckOnly {
+		handleRestart(cfg)
+	}
 
-	// Prepare and update
-	gorillalog.Info("Processing managed updates...")
-	process.Updates(updates, catalogs, cfg.URLPackages, cfg.CachePath, cfg.CheckOnly)
+	// Save the history of this run's attempts
+	if err := state.Save(); err != nil {
+		gorillalog.Warn("Unable to save state:", err)
+	}
 
 	// Save GorillaReport to disk
 	gorillalog.Info("Saving GorillaReport.json...")
+	// Stdout is reserved for the summary if it was requested there
 	if !cfg.CheckOnly {
 		report.End()
-	} else {
+	} else if cfg.JSONSummary != "-" {
 		report.Print()
 	}
 
+	// The cache is the bootstrap media, which we should never delete from
+	if cfg.BootstrapPath != "" {
+		gorillalog.Info("Done!")
+		report.Exit()
+	}
+
+	// If enabled, delete cached items that are no longer in any catalog
+	if cfg.CleanOrphans && !cfg.CheckOnly {
+		gorillalog.Info("Cleaning up orphaned cache files...")
+		process.CleanOrphans(cfg.CachePath, catalogs)
+	}
+
 	// Run CleanUp to delete old cached items and empty directories
-	gorillalog.Info("Cleaning up the cache...")
-	process.CleanUp(cfg.CachePath)
+	// Check only mode never changes anything, including the cache
+	if !cfg.CheckOnly {
+		gorillalog.Info("Cleaning up the cache...")
+		process.CleanUp(cfg.CachePath)
+
+		// Keep the cache under its size limit, if it has one
+		if cfg.CacheMaxMB > 0 {
+			process.LimitCache(cfg.CachePath, int64(cfg.CacheMaxMB)*1024*1024)
+		}
+	}
 
 	gorillalog.Info("Done!")
+
+	// Exit with a code that tells whoever started us how the run went
+	report.Exit()
+}
+
+// fail prints why the run cant continue, and exits with the code for it
+func fail(code int, msg ...interface{}) {
+	fmt.Fprintln(os.Stderr, msg...)
+	report.FailWith(code)
+	report.Exit()
+}
+
+// pickManifest fills in the placeholders in the manifest name for this computer
+func pickManifest(cfg *config.Configuration) {
+	name, err := manifest.Name(*cfg)
+	if err != nil {
+		fail(report.ExitConfigError, "Unable to pick a manifest for this computer:", err)
+	}
+	cfg.Manifest = name
+	report.Items["Manifest"] = name
+}
+
+// takeLock keeps another run from making changes until this one exits
+// Force takes the lock even if another run is still going
+func takeLock(cfg config.Configuration) {
+	lockPath := filepath.Join(cfg.AppDataPath, "gorilla.lock")
+	err := lock.Acquire(lockPath, cfg.Force)
+	var busy *lock.BusyError
+	if errors.As(err, &busy) {
+		fail(report.ExitBusy, "Unable to run:", err)
+	} else if err != nil {
+		fail(report.ExitConfigError, "Unable to take the run lock:", err)
+	}
+	report.AtExit(func() { lock.Release(lockPath) })
+}
+
+// deferRun returns the reason this run should be skipped, or an empty string if it should continue
+// Any check that cant be completed is logged and does not defer the run
+func deferRun(cfg config.Configuration) string {
+	// Only run once the user has stepped away
+	if cfg.MinIdleMinutes > 0 {
+		idle, err := lookupIdle()
+		if err != nil {
+			gorillalog.Warn("Unable to determine idle time:", err)
+		} else if minIdle := time.Duration(cfg.MinIdleMinutes) * time.Minute; idle < minIdle {
+			return fmt.Sprintf("the computer has only been idle for %v", idle.Round(time.Second))
+		}
+	}
+
+	// Heavy installs can drain a battery
+	if cfg.DeferOnBattery {
+		battery, err := lookupBattery()
+		if err != nil {
+			gorillalog.Warn("Unable to determine power status:", err)
+		} else if battery {
+			return "the computer is running on battery"
+		}
+	}
+
+	// Downloads on a metered connection can be expensive
+	if cfg.DeferOnMetered {
+		metered, err := lookupMetered()
+		if err != nil {
+			gorillalog.Warn("Unable to determine connection cost:", err)
+		} else if metered {
+			return "the network connection is metered"
+		}
+	}
+
+	return ""
+}
+
+// backingOff returns true if the repo was recently unreachable, and we are still waiting to contact it again
+func backingOff(cfg config.Configuration) bool {
+	state := readBackoff(cfg.AppDataPath)
+	if wait := state.remaining(cfg.BackoffMinutes); wait > 0 {
+		gorillalog.Info("The repo was unreachable", state.Failures, "times in a row, backing off for another", wait.Round(time.Second))
+		return true
+	}
+	return false
+}
+
+// recordRepoFailure adds to the backoff when the run is ending because a manifest or catalog couldnt be downloaded
+// Only a failure to reach the server counts, not a missing manifest or bad credentials
+func recordRepoFailure(cfg config.Configuration) {
+	if report.ExitCode() != report.ExitNetworkError {
+		return
+	}
+	state, err := recordFailure(cfg.AppDataPath, readBackoff(cfg.AppDataPath))
+	if err != nil {
+		gorillalog.Warn("Unable to save backoff state:", err)
+	}
+	gorillalog.Warn("Unable to reach the repo, backing off for", state.interval(cfg.BackoffMinutes))
+}
+
+// repoReached resets the backoff once the manifests and catalogs have been downloaded
+func repoReached(cfg config.Configuration) {
+	if readBackoff(cfg.AppDataPath).Failures == 0 {
+		return
+	}
+	gorillalog.Info("The repo is reachable again, resetting the backoff")
+	if err := resetBackoff(cfg.AppDataPath); err != nil {
+		gorillalog.Warn("Unable to reset backoff state:", err)
+	}
 }
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/1dustindavis/gorilla/pkg/config"
+)
+
+// TestDeferRun verifies a run is only put off when a check says the computer is busy,
+// and a check that fails never holds the run back
+func TestDeferRun(t *testing.T) {
+	defer func() {
+		lookupIdle = idleTime
+		lookupBattery = onBattery
+		lookupMetered = onMetered
+	}()
+	errProbe := errors.New("probe failed")
+
+	for _, test := range []struct {
+		name      string
+		cfg       config.Configuration
+		idle      time.Duration
+		idleErr   error
+		battery   bool
+		metered   bool
+		probeErr  error
+		wantDefer bool
+	}{
+		{name: "no checks", cfg: config.Configuration{}, battery: true, metered: true},
+		{name: "not idle long enough", cfg: config.Configuration{MinIdleMinutes: 10}, idle: 5 * time.Minute, wantDefer: true},
+		{name: "idle long enough", cfg: config.Configuration{MinIdleMinutes: 10}, idle: 15 * time.Minute},
+		{name: "idle unknown", cfg: config.Configuration{MinIdleMinutes: 10}, idleErr: errProbe},
+		{name: "on battery", cfg: config.Configuration{DeferOnBattery: true}, battery: true, wantDefer: true},
+		{name: "on ac power", cfg: config.Configuration{DeferOnBattery: true}},
+		{name: "metered", cfg: config.Configuration{DeferOnMetered: true}, metered: true, wantDefer: true},
+		{name: "not metered", cfg: config.Configuration{DeferOnMetered: true}},
+		{name: "power and cost unknown", cfg: config.Configuration{DeferOnBattery: true, DeferOnMetered: true}, battery: true, metered: true, probeErr: errProbe},
+		{name: "idle but metered", cfg: config.Configuration{MinIdleMinutes: 10, DeferOnMetered: true}, idle: time.Hour, metered: true, wantDefer: true},
+	} {
+		lookupIdle = func() (time.Duration, error) { return test.idle, test.idleErr }
+		lookupBattery = func() (bool, error) { return test.battery, test.probeErr }
+		lookupMetered = func() (bool, error) { return test.metered, test.probeErr }
+
+		if reason := deferRun(test.cfg); (reason != "") != test.wantDefer {
+			t.Errorf("%s: deferred is %v (%q), want %v", test.name, reason != "", reason, test.wantDefer)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/1dustindavis/gorilla/pkg/config"
+	"github.com/1dustindavis/gorilla/pkg/gorillalog"
+	"github.com/1dustindavis/gorilla/pkg/progress"
+)
+
+// progressLogInterval is how often a download or installer that is taking a while is logged
+const progressLogInterval = 30 * time.Second
+
+// setProgress picks where the progress of downloads and installers goes
+// It is always logged, and shown on the console when there is one or the run is manual, unless `-progress` says otherwise
+func setProgress(cfg config.Configuration) {
+	reporters := []progress.Reporter{progress.Log(progressLogInterval)}
+	switch cfg.Progress {
+	case "":
+		// A manual run is being watched, even if its output is going somewhere else
+		if isTerminal(os.Stderr) || cfg.Mode == config.ModeManual {
+			reporters = append(reporters, progress.Console(os.Stderr))
+		}
+	case "console":
+		reporters = append(reporters, progress.Console(os.Stderr))
+	case "json":
+		reporters = append(reporters, progress.JSON(os.Stdout))
+	case "off":
+	default:
+		gorillalog.Warn("Unknown -progress option, expected console, json, or off:", cfg.Progress)
+	}
+	progress.SetReporters(reporters...)
+}
+
+// isTerminal returns true if the file is a console, instead of a pipe or a file
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
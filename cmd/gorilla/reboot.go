@@ -0,0 +1,38 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+var procGetTickCount64 = kernel32.NewProc("GetTickCount64")
+
+// bootTime returns when the computer last started
+func bootTime() (time.Time, error) {
+	if err := procGetTickCount64.Find(); err != nil {
+		return time.Time{}, err
+	}
+	uptime, _, _ := procGetTickCount64.Call()
+	return time.Now().Add(-time.Duration(uptime) * time.Millisecond), nil
+}
+
+// scheduleRestart has Windows restart the computer once the delay is up, showing the user a countdown
+// Windows only allows a message of up to 512 characters
+func scheduleRestart(delay time.Duration, message string) error {
+	if len(message) > 512 {
+		message = message[:509] + "..."
+	}
+	shutdown := filepath.Join(os.Getenv("WINDIR"), "system32", "shutdown.exe")
+	// The reason is planned, application installation
+	out, err := exec.Command(shutdown, "/r", "/t", fmt.Sprint(int(delay.Seconds())), "/c", message, "/d", "p:4:1").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, out)
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+// Without an OS specific build, go tools will try to include Windows libraries and fail
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import "time"
+
+func bootTime() (time.Time, error) {
+	return time.Time{}, errUnsupported
+}
+
+func scheduleRestart(delay time.Duration, message string) error {
+	return errUnsupported
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/1dustindavis/gorilla/pkg/config"
+	"github.com/1dustindavis/gorilla/pkg/gorillalog"
+	"github.com/1dustindavis/gorilla/pkg/installer"
+	"github.com/1dustindavis/gorilla/pkg/report"
+)
+
+const (
+	// defaultRestartDelay is how long the user has to save their work before a scheduled restart
+	defaultRestartDelay = 30 * time.Minute
+
+	// finalRestartDelay is all the warning they get once they have put the restart off too many times
+	finalRestartDelay = time.Minute
+)
+
+// restartState is saved between runs, until the computer restarts
+type restartState struct {
+	Action    string    `json:"action"`
+	Items     []string  `json:"items"`
+	Since     time.Time `json:"since"`
+	Scheduled time.Time `json:"scheduled,omitempty"`
+	Deferrals int       `json:"deferrals"`
+}
+
+// restartPath returns the path of the restart state file
+func restartPath(appDataPath string) string {
+	return filepath.Join(appDataPath, "restart.json")
+}
+
+// readRestart returns the saved restart state, or an empty state if there is none
+func readRestart(appDataPath string) restartState {
+	var state restartState
+	stateJSON, err := ioutil.ReadFile(restartPath(appDataPath))
+	if err == nil {
+		json.Unmarshal(stateJSON, &state)
+	}
+	return state
+}
+
+// saveRestart writes the restart state, or removes it once no restart is pending
+func saveRestart(appDataPath string, state restartState) error {
+	if state.Action == "" {
+		err := os.Remove(restartPath(appDataPath))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	stateJSON, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(restartPath(appDataPath), stateJSON, 0644)
+}
+
+// pendingRestart adds the restart this run's items asked for to what earlier runs were waiting on
+// Anything earlier runs were waiting on is done once the computer has restarted since
+func pendingRestart(state restartState, booted time.Time, action string, items []string, now time.Time) restartState {
+	if !state.Since.IsZero() && booted.After(state.Since) {
+		state = restartState{}
+	}
+	if action == "" {
+		return state
+	}
+
+	state.Action = installer.StrongerRestart(state.Action, action)
+	known := make(map[string]bool)
+	for _, item := range state.Items {
+		known[item] = true
+	}
+	for _, item := range items {
+		if !known[item] {
+			state.Items = append(state.Items, item)
+			known[item] = true
+		}
+	}
+	if state.Since.IsZero() {
+		state.Since = now
+	}
+	return state
+}
+
+// nextRestart returns how long to count down before restarting, or false if an earlier countdown is still going
+// A countdown that ended without a restart means the user put it off
+func (state *restartState) nextRestart(cfg config.Configuration, now time.Time) (time.Duration, bool) {
+	if !state.Scheduled.IsZero() {
+		if now.Before(state.Scheduled) {
+			return 0, false
+		}
+		state.Deferrals++
+	}
+	if state.Deferrals > cfg.RestartDeferrals {
+		return finalRestartDelay, true
+	}
+	if cfg.RestartDelay > 0 {
+		return time.Duration(cfg.RestartDelay) * time.Minute, true
+	}
+	return defaultRestartDelay, true
+}
+
+// handleRestart keeps track of the restart the installed and removed items asked for,
+// lets the user know about it, and schedules it if it is required and scheduling is enabled
+func handleRestart(cfg config.Configuration) {
+	action, items := installer.RestartNeeded()
+	booted, err := bootTime()
+	if err != nil {
+		gorillalog.Debug("Unable to check when the computer started:", err)
+	}
+	state := pendingRestart(readRestart(cfg.AppDataPath), booted, action, items, time.Now())
+	report.RestartAction = state.Action
+
+	// Windows may want a restart after an install, even if the item didnt say it would
+	if state.Action == "" {
+		if len(report.InstalledItems) > 0 && report.RebootPending() {
+			installer.NotifyReboot()
+		}
+		if err := saveRestart(cfg.AppDataPath, state); err != nil {
+			gorillalog.Warn("Unable to save the pending restart:", err)
+		}
+		return
+	}
+
+	gorillalog.Info("Restart pending:", state.Action, "for", strings.Join(state.Items, ", "))
+	if state.Action == installer.RestartRequired && cfg.Mode == config.ModeBootstrap && bootstrapping(cfg.AppDataPath) {
+		// A computer being set up restarts right away, and the service carries on once it is back
+		message := fmt.Sprintf("This computer will restart in a minute to finish installing %s.", strings.Join(state.Items, ", "))
+		if err := scheduleRestart(bootstrapRestartDelay, message); err != nil {
+			gorillalog.Warn("Unable to schedule a restart:", err)
+		} else {
+			gorillalog.Info("Scheduled a restart in", bootstrapRestartDelay, "to continue setting up this computer")
+			state.Scheduled = time.Now().Add(bootstrapRestartDelay)
+		}
+	} else if state.Action == installer.RestartRequired && cfg.ScheduleRestart {
+		if delay, ok := state.nextRestart(cfg, time.Now()); ok {
+			minutes := "minutes"
+			if delay == time.Minute {
+				minutes = "minute"
+			}
+			message := fmt.Sprintf("This computer will restart in %d %s to finish installing %s. Please save your work.",
+				int(delay.Minutes()), minutes, strings.Join(state.Items, ", "))
+			if err := scheduleRestart(delay, message); err != nil {
+				gorillalog.Warn("Unable to schedule a restart:", err)
+			} else {
+				gorillalog.Info("Scheduled a restart in", delay, "after", state.Deferrals, "deferrals")
+				state.Scheduled = time.Now().Add(delay)
+			}
+		}
+	} else if action != "" {
+		// Without a scheduled restart, the user is only reminded when something new asks for one
+		installer.NotifyReboot()
+	}
+
+	if err := saveRestart(cfg.AppDataPath, state); err != nil {
+		gorillalog.Warn("Unable to save the pending restart:", err)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/1dustindavis/gorilla/pkg/config"
+	"github.com/1dustindavis/gorilla/pkg/secret"
+)
+
+// configCommand handles `gorilla config set-secret <setting>`
+// The secret is read from stdin, so it never ends up in the shell's history
+func configCommand(args []string) error {
+	if len(args) != 2 || args[0] != "set-secret" {
+		return fmt.Errorf("expected: config set-secret <setting>")
+	}
+	setting := args[1]
+	if !config.IsSecret(setting) {
+		return fmt.Errorf("%s is not a password, key, or token", setting)
+	}
+
+	fmt.Fprintf(os.Stderr, "Enter the value for %s: ", setting)
+	value, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	value = strings.TrimRight(value, "\r\n")
+	if value == "" {
+		return fmt.Errorf("no value was entered")
+	}
+
+	// Only this computer can decrypt the value, so it must be encrypted on each computer that uses it
+	protected, err := secret.Protect(value)
+	if err != nil {
+		return fmt.Errorf("unable to encrypt: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "Use this as the value of %s in the config file:\n", setting)
+	fmt.Println(protected)
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/1dustindavis/gorilla/pkg/ipc"
+	"github.com/1dustindavis/gorilla/pkg/report"
+)
+
+// selfService answers requests from the self-service app and other local tools by running gorilla, the same way a scheduled run does
+// Each request is a separate process, so the service itself never loads a catalog or runs an installer
+type selfService struct {
+	exePath string
+	args    []string
+	events  *ipc.Events
+
+	// runNow starts a scheduled run right away, and runStatus reports on the scheduled runs
+	runNow    func() error
+	runStatus func() ipc.Status
+
+	mu   sync.Mutex
+	busy string
+}
+
+// handle answers a single request from the self-service app
+func (s *selfService) handle(req ipc.Request) ipc.Response {
+	switch req.Command {
+	case "list":
+		items, err := s.list()
+		if err != nil {
+			return ipc.Response{Error: err.Error(), Busy: s.status()}
+		}
+		return ipc.Response{Items: items, Busy: s.status()}
+
+	case "status":
+		var status ipc.Status
+		if s.runStatus != nil {
+			status = s.runStatus()
+		}
+		status.Busy = s.status()
+		items, err := s.list()
+		resp := ipc.Response{Items: items, Busy: status.Busy, Status: &status}
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		return resp
+
+	case "run":
+		if s.runNow == nil {
+			return ipc.Response{Error: "runs can only be started by the service"}
+		}
+		if err := s.runNow(); err != nil {
+			return ipc.Response{Error: err.Error()}
+		}
+		return ipc.Response{}
+
+	case "install", "remove":
+		if len(req.Items) == 0 {
+			return ipc.Response{Error: fmt.Sprint("expected at least one item to ", req.Command)}
+		}
+		// People can only install and remove what the manifests offer them
+		items, err := s.list()
+		if err != nil {
+			return ipc.Response{Error: err.Error()}
+		}
+		for _, name := range req.Items {
+			if !offered(items, name) {
+				return ipc.Response{Error: fmt.Sprint("not an optional install: ", name)}
+			}
+		}
+		return s.run(req.Command, req.Items)
+	}
+	return ipc.Response{Error: fmt.Sprint("unknown command: ", req.Command)}
+}
+
+// offered returns true if the item is one of the optional installs
+func offered(items []ipc.Item, name string) bool {
+	for _, item := range items {
+		if item.Optional && item.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// status returns what the service is doing for the self-service app, if anything
+func (s *selfService) status() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.busy
+}
+
+// list runs `gorilla list` and returns the optional installs and pending actions it prints
+func (s *selfService) list() ([]ipc.Item, error) {
+	out, err := exec.Command(s.exePath, append([]string{"list"}, s.args...)...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list items: %v", err)
+	}
+	var items []ipc.Item
+	if err := json.Unmarshal(out, &items); err != nil {
+		return nil, fmt.Errorf("unable to read the list of items: %v", err)
+	}
+	return items, nil
+}
+
+// run installs or removes items, one request at a time
+func (s *selfService) run(command string, items []string) ipc.Response {
+	verb := "Installing"
+	if command == "remove" {
+		verb = "Removing"
+	}
+
+	s.mu.Lock()
+	if s.busy != "" {
+		busy := s.busy
+		s.mu.Unlock()
+		return ipc.Response{Error: fmt.Sprint("gorilla is busy: ", busy), Busy: busy}
+	}
+	s.busy = fmt.Sprint(verb, " ", strings.Join(items, ", "))
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.busy = ""
+		s.mu.Unlock()
+	}()
+
+	// Anyone watching the events sees the run's progress, and someone asked for it so it is a manual run
+	args := append(append([]string{command}, items...), append([]string{"-verbose", "-progress", "json", "-manual"}, s.args...)...)
+	cmd := exec.Command(s.exePath, args...)
+	if s.events != nil {
+		cmd.Stdout = s.events.Writer()
+		cmd.Stderr = cmd.Stdout
+		s.events.Publish("started", s.status())
+	}
+	err := cmd.Run()
+
+	var resp ipc.Response
+	if exitCode(err) == report.ExitBusy {
+		resp.Error = "another gorilla run is in progress, try again once it finishes"
+	} else if err != nil {
+		resp.Error = fmt.Sprintf("unable to %s %s: %v", command, strings.Join(items, ", "), err)
+	}
+	if s.events != nil {
+		finished := fmt.Sprint("Finished ", strings.ToLower(verb), " ", strings.Join(items, ", "))
+		if resp.Error != "" {
+			finished = resp.Error
+		}
+		s.events.Publish("finished", finished)
+	}
+	return resp
+}
+
+// exitCode returns the exit code of a finished run, which is -1 if it couldnt be started
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	if err != nil {
+		return -1
+	}
+	return 0
+}
@@ -0,0 +1,465 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/1dustindavis/gorilla/pkg/config"
+	"github.com/1dustindavis/gorilla/pkg/gorillalog"
+	"github.com/1dustindavis/gorilla/pkg/ipc"
+	"github.com/1dustindavis/gorilla/pkg/peer"
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const (
+	serviceName        = "gorilla"
+	serviceDisplayName = "Gorilla"
+	serviceDescription = "Installs and updates managed software on a schedule"
+
+	// Defaults used when the configuration doesnt set an interval or jitter
+	defaultServiceInterval = 60 * time.Minute
+	defaultServiceJitter   = 10 * time.Minute
+	defaultPeerPort        = 8089
+
+	// How long a run has to stop on its own once the service is stopping, before it is killed
+	stopGrace = 30 * time.Second
+
+	// Event IDs written to the event log
+	eventServiceStarted = 1
+	eventServiceStopped = 2
+	eventRunCompleted   = 3
+	eventRunFailed      = 4
+)
+
+// serviceCommand handles `gorilla service <command>`
+// Any arguments after `install` are passed to every scheduled run, such as `-config`
+func serviceCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected one of: install, uninstall, start, stop, run-now")
+	}
+
+	switch args[0] {
+	case "install":
+		return installService(args[1:])
+	case "uninstall", "remove":
+		return removeService()
+	case "start":
+		return controlService(func(s *mgr.Service) error { return s.Start() })
+	case "stop":
+		return controlService(func(s *mgr.Service) error {
+			_, err := s.Control(svc.Stop)
+			return err
+		})
+	case "run-now":
+		if _, err := ipc.Send(ipc.Request{Command: "run"}); err != nil {
+			return err
+		}
+		fmt.Println("Started a gorilla run")
+		return nil
+	case "run":
+		return runService(args[1:])
+	default:
+		return fmt.Errorf("unknown service command: %s", args[0])
+	}
+}
+
+// installService registers gorilla as an automatically started service and as an event log source
+func installService(args []string) error {
+	exePath, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	// Dont replace an existing service
+	s, err := m.OpenService(serviceName)
+	if err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", serviceName)
+	}
+
+	// The service manager starts us with `service run` plus any arguments we were given
+	s, err = m.CreateService(serviceName, exePath, mgr.Config{
+		DisplayName: serviceDisplayName,
+		Description: serviceDescription,
+		StartType:   mgr.StartAutomatic,
+	}, append([]string{"service", "run"}, args...)...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	err = eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info)
+	if err != nil {
+		s.Delete()
+		return fmt.Errorf("unable to register event log source: %v", err)
+	}
+
+	fmt.Println("Installed service:", serviceName)
+	return nil
+}
+
+// removeService deletes the service and its event log source
+func removeService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", serviceName)
+	}
+	defer s.Close()
+
+	err = s.Delete()
+	if err != nil {
+		return err
+	}
+
+	// The service is already gone, so a leftover event source isnt worth failing over
+	if err := eventlog.Remove(serviceName); err != nil {
+		fmt.Println("Unable to remove event log source:", err)
+	}
+
+	fmt.Println("Removed service:", serviceName)
+	return nil
+}
+
+// controlService opens the installed service and runs an action against it
+func controlService(action func(s *mgr.Service) error) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", serviceName)
+	}
+	defer s.Close()
+
+	return action(s)
+}
+
+// runService is called by the service manager, and runs gorilla on a schedule until stopped
+func runService(args []string) error {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return err
+	}
+	if !isService {
+		return fmt.Errorf("service run can only be started by the service manager")
+	}
+
+	// Read the same configuration each scheduled run will use
+	os.Args = append([]string{os.Args[0]}, args...)
+	cfg := config.Get()
+
+	elog, err := eventlog.Open(serviceName)
+	if err != nil {
+		return err
+	}
+	defer elog.Close()
+
+	exePath, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	// Each run is a separate process, so the service shares the cache with peers between runs
+	if cfg.PeerCache {
+		gorillalog.NewLog(cfg)
+		server, err := peer.Start(cfg.CachePath, peerPort(cfg))
+		if err != nil {
+			elog.Warning(eventServiceStarted, fmt.Sprint("Unable to share the cache with peers: ", err))
+		} else {
+			defer server.Close()
+		}
+	}
+
+	service := &gorillaService{
+		exePath:     exePath,
+		args:        args,
+		appDataPath: cfg.AppDataPath,
+		interval:    serviceInterval(cfg),
+		jitter:      serviceJitter(cfg),
+		elog:        elog,
+		events:      &ipc.Events{},
+		runNow:      make(chan chan error),
+	}
+	api := &ipc.Server{
+		Events: service.events,
+		Handler: (&selfService{
+			exePath:   exePath,
+			args:      args,
+			events:    service.events,
+			runNow:    service.requestRun,
+			runStatus: service.currentStatus,
+		}).handle,
+	}
+
+	// The self-service app and local tools start runs, check on them, and install optional installs over a named pipe
+	listener, err := ipc.Listen()
+	if err != nil {
+		elog.Warning(eventServiceStarted, fmt.Sprint("Unable to listen for the self-service app: ", err))
+	} else {
+		defer listener.Close()
+		go api.Serve(listener)
+	}
+
+	// Tools that would rather use HTTP get the same requests on a localhost port
+	if cfg.APIPort > 0 {
+		httpListener, err := ipc.ListenHTTP(cfg.APIPort)
+		if err != nil {
+			elog.Warning(eventServiceStarted, fmt.Sprint("Unable to listen on the api port: ", err))
+		} else {
+			defer httpListener.Close()
+			go http.Serve(httpListener, api)
+		}
+	}
+
+	return svc.Run(serviceName, service)
+}
+
+// serviceInterval returns the configured time between runs
+func serviceInterval(cfg config.Configuration) time.Duration {
+	if cfg.ServiceInterval > 0 {
+		return time.Duration(cfg.ServiceInterval) * time.Minute
+	}
+	return defaultServiceInterval
+}
+
+// serviceJitter returns the configured maximum random delay added to each run
+func serviceJitter(cfg config.Configuration) time.Duration {
+	if cfg.ServiceJitter > 0 {
+		return time.Duration(cfg.ServiceJitter) * time.Minute
+	}
+	return defaultServiceJitter
+}
+
+// peerPort returns the configured port the cache is shared on
+func peerPort(cfg config.Configuration) int {
+	if cfg.PeerPort > 0 {
+		return cfg.PeerPort
+	}
+	return defaultPeerPort
+}
+
+// stopEventName returns the name of the event the service sets when it is stopping
+// It includes the service's process id, so a run only watches the event of the service that started it
+func stopEventName(servicePID int) string {
+	return fmt.Sprintf(`Global\GorillaStop-%d`, servicePID)
+}
+
+// watchServiceStop cancels the run once the service that started it is stopping
+// A run that wasnt started by the service has no event to watch
+func watchServiceStop(cancel func()) {
+	name, err := windows.UTF16PtrFromString(stopEventName(os.Getppid()))
+	if err != nil {
+		return
+	}
+	event, err := windows.OpenEvent(windows.SYNCHRONIZE, false, name)
+	if err != nil {
+		return
+	}
+	go func() {
+		defer windows.CloseHandle(event)
+		if _, err := windows.WaitForSingleObject(event, windows.INFINITE); err == nil {
+			cancel()
+		}
+	}()
+}
+
+// gorillaService runs gorilla in a separate process on an interval
+// A separate process keeps each run isolated, so a failed run never stops the service
+type gorillaService struct {
+	exePath     string
+	args        []string
+	appDataPath string
+	interval    time.Duration
+	jitter      time.Duration
+	elog        *eventlog.Log
+
+	// events gets each run's output, and runNow asks for a run to start right away
+	events *ipc.Events
+	runNow chan chan error
+
+	mu     sync.Mutex
+	status ipc.Status
+}
+
+// requestRun starts a run right away, unless one is already in progress
+func (g *gorillaService) requestRun() error {
+	reply := make(chan error, 1)
+	select {
+	case g.runNow <- reply:
+		return <-reply
+	case <-time.After(5 * time.Second):
+		return fmt.Errorf("the service is not accepting runs right now")
+	}
+}
+
+// currentStatus returns whether a run is in progress, and when the last and next runs are
+func (g *gorillaService) currentStatus() ipc.Status {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.status
+}
+
+// startRun starts gorilla in a separate process, with its output sent to anyone watching the events
+func (g *gorillaService) startRun(done chan<- error) (*exec.Cmd, error) {
+	cmd := exec.Command(g.exePath, append([]string{"-verbose", "-progress", "json", "-auto"}, g.args...)...)
+	cmd.Stdout = g.events.Writer()
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	go func() { done <- cmd.Wait() }()
+
+	// The next run is scheduled once this one finishes
+	g.mu.Lock()
+	g.status.Running = true
+	g.status.NextRun = time.Time{}
+	g.status.Bootstrapping = bootstrapping(g.appDataPath)
+	g.mu.Unlock()
+	g.events.Publish("started", "Gorilla run started")
+	return cmd, nil
+}
+
+// finishRun records how a run went, and lets anyone watching know
+func (g *gorillaService) finishRun(err error) {
+	g.mu.Lock()
+	g.status.Running = false
+	g.status.LastRun = time.Now()
+	g.status.LastExitCode = exitCode(err)
+	g.status.Bootstrapping = bootstrapping(g.appDataPath)
+	g.mu.Unlock()
+
+	if err != nil {
+		g.elog.Warning(eventRunFailed, fmt.Sprint("Gorilla run failed: ", err))
+		g.events.Publish("finished", fmt.Sprint("Gorilla run failed: ", err))
+	} else {
+		g.elog.Info(eventRunCompleted, "Gorilla run completed")
+		g.events.Publish("finished", "Gorilla run completed")
+	}
+}
+
+// nextRun returns how long to wait before the next run, and remembers when that is for the status
+// The random jitter keeps a fleet of machines from hitting the repo at the same moment,
+// but a computer that is still being set up shouldnt wait for it
+func (g *gorillaService) nextRun(wait time.Duration) time.Duration {
+	if g.jitter > 0 && !bootstrapping(g.appDataPath) {
+		wait += time.Duration(rand.Int63n(int64(g.jitter)))
+	}
+	g.mu.Lock()
+	g.status.NextRun = time.Now().Add(wait)
+	g.mu.Unlock()
+	return wait
+}
+
+// Execute is called by the service manager once the service starts
+func (g *gorillaService) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	rand.Seed(time.Now().UnixNano())
+
+	// The first run only waits for the jitter, so a new machine doesnt wait a full interval
+	timer := time.NewTimer(g.nextRun(0))
+	defer timer.Stop()
+
+	var running *exec.Cmd
+	done := make(chan error, 1)
+
+	// Runs watch this event, so they can stop cleanly when the service does
+	stopEvent, err := windows.CreateEvent(nil, 1, 0, windows.StringToUTF16Ptr(stopEventName(os.Getpid())))
+	if err != nil {
+		g.elog.Warning(eventServiceStarted, fmt.Sprint("Unable to create the stop event, runs will be killed when the service stops: ", err))
+	} else {
+		defer windows.CloseHandle(stopEvent)
+	}
+
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	g.elog.Info(eventServiceStarted, fmt.Sprintf("Gorilla service started, running every %v with up to %v of jitter", g.interval, g.jitter))
+
+	for {
+		select {
+		case <-timer.C:
+			running, err = g.startRun(done)
+			if err != nil {
+				g.elog.Error(eventRunFailed, fmt.Sprint("Unable to start gorilla: ", err))
+				timer.Reset(g.nextRun(g.interval))
+			}
+
+		case reply := <-g.runNow:
+			if running != nil {
+				reply <- fmt.Errorf("a gorilla run is already in progress")
+				continue
+			}
+			// The scheduled run is replaced by this one, and the next is scheduled once it finishes
+			if !timer.Stop() {
+				<-timer.C
+			}
+			running, err = g.startRun(done)
+			if err != nil {
+				g.elog.Error(eventRunFailed, fmt.Sprint("Unable to start gorilla: ", err))
+				timer.Reset(g.nextRun(g.interval))
+			}
+			reply <- err
+
+		case err := <-done:
+			running = nil
+			g.finishRun(err)
+			// A computer that is still being set up tries again soon, instead of waiting a full interval
+			if bootstrapping(g.appDataPath) {
+				timer.Reset(g.nextRun(bootstrapInterval))
+			} else {
+				timer.Reset(g.nextRun(g.interval))
+			}
+
+		case c := <-requests:
+			switch c.Cmd {
+			case svc.Interrogate:
+				status <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending, WaitHint: uint32(stopGrace / time.Millisecond)}
+				if running != nil {
+					g.elog.Warning(eventServiceStopped, "Stopping a gorilla run that was in progress")
+					g.stopRun(running, stopEvent, done)
+				}
+				g.elog.Info(eventServiceStopped, "Gorilla service stopped")
+				return false, 0
+			}
+		}
+	}
+}
+
+// stopRun asks a run to stop, and kills it if it hasnt stopped within the grace period
+func (g *gorillaService) stopRun(running *exec.Cmd, stopEvent windows.Handle, done <-chan error) {
+	if stopEvent != 0 && windows.SetEvent(stopEvent) == nil {
+		select {
+		case <-done:
+			return
+		case <-time.After(stopGrace):
+			g.elog.Warning(eventServiceStopped, fmt.Sprint("The gorilla run did not stop within ", stopGrace, ", killing it"))
+		}
+	}
+	running.Process.Kill()
+}
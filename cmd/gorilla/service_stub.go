@@ -0,0 +1,12 @@
+// Without an OS specific build, go tools will try to include Windows libraries and fail
+
+//go:build !windows
+// +build !windows
+
+package main
+
+func serviceCommand(args []string) error {
+	return errUnsupported
+}
+
+func watchServiceStop(cancel func()) {}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// importItem is an installer that is being added to a catalog
+type importItem struct {
+	Name        string
+	DisplayName string
+	Version     string
+	Type        string
+	Location    string
+	Hash        string
+	ProductCode string
+	PackageName string
+}
+
+// updateCatalog adds an item to a catalog, or updates the item if it already exists
+// The catalog is edited in place, so any other items, settings, and comments are kept
+func updateCatalog(catalogPath string, item importItem) error {
+	document := &yaml.Node{Kind: yaml.DocumentNode}
+	catalogYaml, err := ioutil.ReadFile(catalogPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(bytes.TrimSpace(catalogYaml)) > 0 {
+		err = yaml.Unmarshal(catalogYaml, document)
+		if err != nil {
+			return fmt.Errorf("unable to parse catalog %s: %v", catalogPath, err)
+		}
+	}
+	if len(document.Content) == 0 {
+		document.Content = []*yaml.Node{{Kind: yaml.MappingNode}}
+	}
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("catalog %s is not a map of items", catalogPath)
+	}
+
+	entry := mappingValue(root, item.Name)
+	if entry == nil {
+		entry = &yaml.Node{Kind: yaml.MappingNode}
+		root.Content = append(root.Content, scalarNode(item.Name), entry)
+	}
+	if entry.Kind != yaml.MappingNode {
+		return fmt.Errorf("catalog item %s is not a map", item.Name)
+	}
+
+	// An existing display name is kept, since it may have been edited by hand
+	if mappingValue(entry, "display_name") == nil {
+		setScalar(entry, "display_name", item.DisplayName)
+	}
+	if item.Version != "" {
+		setScalar(entry, "version", item.Version)
+	}
+
+	installer := childMapping(entry, "installer")
+	// A new installer needs a new signature, and its hash is always sha256
+	if hash := mappingValue(installer, "hash"); hash != nil && hash.Value != item.Hash {
+		if mappingValue(installer, "hash_type") != nil {
+			setScalar(installer, "hash_type", "sha256")
+		}
+		if deleteKey(installer, "signature") {
+			fmt.Println("Removed the signature of the old installer, sign the new one and add it to", item.Name)
+		}
+	}
+	setScalar(installer, "type", item.Type)
+	setScalar(installer, "location", item.Location)
+	setScalar(installer, "hash", item.Hash)
+	if item.ProductCode != "" {
+		setScalar(installer, "product_code", item.ProductCode)
+	}
+	if item.PackageName != "" {
+		setScalar(installer, "package_name", item.PackageName)
+	}
+
+	// Msi, appx, and msix items can be uninstalled without an uninstaller, so add one if there isnt one already
+	if mappingValue(entry, "uninstaller") == nil && (item.ProductCode != "" || item.PackageName != "") {
+		setScalar(childMapping(entry, "uninstaller"), "type", item.Type)
+	}
+
+	var buffer bytes.Buffer
+	encoder := yaml.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+	err = encoder.Encode(document)
+	if err != nil {
+		return err
+	}
+	encoder.Close()
+
+	err = os.MkdirAll(filepath.Dir(catalogPath), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(catalogPath, buffer.Bytes(), 0644)
+}
+
+// scalarNode returns a node holding a single string
+func scalarNode(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
+
+// mappingValue returns the value for a key in a mapping node, or nil if the key doesnt exist
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setScalar sets a key in a mapping node to a string, adding the key if it doesnt exist
+func setScalar(mapping *yaml.Node, key, value string) {
+	// Replace an existing value in place, so any comment on it is kept
+	if existing := mappingValue(mapping, key); existing != nil {
+		existing.Kind = yaml.ScalarNode
+		existing.Tag = "!!str"
+		existing.Value = value
+		existing.Style = 0
+		existing.Content = nil
+		return
+	}
+	mapping.Content = append(mapping.Content, scalarNode(key), scalarNode(value))
+}
+
+// deleteKey removes a key and its value from a mapping node, and returns true if it was there
+func deleteKey(mapping *yaml.Node, key string) bool {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// childMapping returns the mapping node for a key, replacing anything that isnt a mapping
+func childMapping(mapping *yaml.Node, key string) *yaml.Node {
+	existing := mappingValue(mapping, key)
+	if existing != nil && existing.Kind == yaml.MappingNode {
+		return existing
+	}
+	child := &yaml.Node{Kind: yaml.MappingNode}
+	if existing != nil {
+		*existing = *child
+		return existing
+	}
+	mapping.Content = append(mapping.Content, scalarNode(key), child)
+	return child
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/1dustindavis/gorilla/pkg/catalog"
+	"gopkg.in/yaml.v3"
+)
+
+// TestUpdateCatalog verifies that items are added and updated without losing anything else in the catalog
+func TestUpdateCatalog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gorilla_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	catalogPath := filepath.Join(dir, "catalogs", "testing.yaml")
+
+	// A missing catalog is created
+	sevenZip := importItem{
+		Name:        "7-Zip",
+		DisplayName: "7-Zip 22.01 (x64 edition)",
+		Version:     "22.01.00.0",
+		Type:        "msi",
+		Location:    "packages/7-Zip/7z2201-x64.msi",
+		Hash:        "f4afba646166999d6090b5beddde546450262dc595dddeb62132da70f70d14ca",
+		ProductCode: "{23170F69-40C1-2702-2201-000001000000}",
+	}
+	err = updateCatalog(catalogPath, sevenZip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An existing item keeps its other settings and comments
+	existing := `# Browsers
+Firefox:
+  display_name: Mozilla Firefox
+  # Checked by version
+  check:
+    registry:
+      name: Mozilla Firefox
+      version: "115.0"
+  installer:
+    type: exe
+    location: packages/Firefox/Firefox Setup 115.0.exe
+    hash: abc
+    arguments:
+      - /S
+  version: "115.0"
+`
+	err = ioutil.WriteFile(catalogPath, []byte(existing), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	firefox := importItem{
+		Name:        "Firefox",
+		DisplayName: "Firefox",
+		Version:     "116.0",
+		Type:        "exe",
+		Location:    "packages/Firefox/Firefox Setup 116.0.exe",
+		Hash:        "def",
+	}
+	for _, item := range []importItem{firefox, sevenZip} {
+		err = updateCatalog(catalogPath, item)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	catalogYaml, err := ioutil.ReadFile(catalogPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, comment := range []string{"# Browsers", "# Checked by version"} {
+		if !strings.Contains(string(catalogYaml), comment) {
+			t.Errorf("Comment was not kept: %s", comment)
+		}
+	}
+
+	var items map[string]catalog.Item
+	err = yaml.Unmarshal(catalogYaml, &items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]catalog.Item{
+		"Firefox": {
+			DisplayName: "Mozilla Firefox",
+			Check:       catalog.InstallCheck{Registry: catalog.RegCheck{Name: "Mozilla Firefox", Version: "115.0"}},
+			Installer: catalog.InstallerItem{
+				Type:      "exe",
+				Location:  "packages/Firefox/Firefox Setup 116.0.exe",
+				Hash:      "def",
+				Arguments: []string{"/S"},
+			},
+			Version: "116.0",
+		},
+		"7-Zip": {
+			DisplayName: "7-Zip 22.01 (x64 edition)",
+			Installer: catalog.InstallerItem{
+				Type:        "msi",
+				Location:    "packages/7-Zip/7z2201-x64.msi",
+				Hash:        "f4afba646166999d6090b5beddde546450262dc595dddeb62132da70f70d14ca",
+				ProductCode: "{23170F69-40C1-2702-2201-000001000000}",
+			},
+			Uninstaller: catalog.InstallerItem{Type: "msi"},
+			Version:     "22.01.00.0",
+		},
+	}
+	if !reflect.DeepEqual(items, expected) {
+		t.Errorf("\nExpected: %#v\nActual: %#v", expected, items)
+	}
+}
+
+// TestUpdateCatalogHash verifies that importing a new installer drops the old one's signature and hash type,
+// while importing the same installer again keeps them
+func TestUpdateCatalogHash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gorilla_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	catalogPath := filepath.Join(dir, "catalogs", "testing.yaml")
+
+	existing := `Firefox:
+  display_name: Mozilla Firefox
+  installer:
+    type: exe
+    location: packages/Firefox/Firefox Setup 115.0.exe
+    hash: 20a3b34c697a33478efe691badac9199552724526a19123e080f26ec1bf160e721c3d74283b4a1a32b0f4253159be5380e3c7d267c99e2b6c2e5f7e68be028b1
+    hash_type: sha512
+    signature: packages/Firefox/Firefox Setup 115.0.exe.minisig
+  version: "115.0"
+`
+	err = os.MkdirAll(filepath.Dir(catalogPath), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(catalogPath, []byte(existing), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	firefox := importItem{
+		Name:     "Firefox",
+		Version:  "116.0",
+		Type:     "exe",
+		Location: "packages/Firefox/Firefox Setup 116.0.exe",
+		Hash:     "dca48f4e34541c52d12351479454b3af6d87d8dc23ec48f68962f062d8703de3",
+	}
+
+	read := func() catalog.InstallerItem {
+		catalogYaml, err := ioutil.ReadFile(catalogPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var items map[string]catalog.Item
+		if err := yaml.Unmarshal(catalogYaml, &items); err != nil {
+			t.Fatal(err)
+		}
+		return items["Firefox"].Installer
+	}
+
+	// The same installer keeps its signature
+	same := firefox
+	same.Location = "packages/Firefox/Firefox Setup 115.0.exe"
+	same.Hash = read().Hash
+	if err := updateCatalog(catalogPath, same); err != nil {
+		t.Fatal(err)
+	}
+	if installer := read(); installer.HashType != "sha512" || installer.Signature == "" {
+		t.Errorf("Expected the signature and hash type to be kept, got %#v", installer)
+	}
+
+	// A new installer doesnt
+	if err := updateCatalog(catalogPath, firefox); err != nil {
+		t.Fatal(err)
+	}
+	expected := catalog.InstallerItem{
+		Type:     "exe",
+		Location: "packages/Firefox/Firefox Setup 116.0.exe",
+		Hash:     "dca48f4e34541c52d12351479454b3af6d87d8dc23ec48f68962f062d8703de3",
+		HashType: "sha256",
+	}
+	if installer := read(); !reflect.DeepEqual(installer, expected) {
+		t.Errorf("\nExpected: %#v\nActual: %#v", expected, installer)
+	}
+}
+
+// TestCopyInstaller verifies that an installer is copied into the repo and hashed
+func TestCopyInstaller(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gorilla_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "setup.exe")
+	err = ioutil.WriteFile(source, []byte("gorilla"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The sha256 of "gorilla"
+	expectedHash := "541f42d7542b70062fa430bfccac434186c0c1bb433b45b6f1b76e6f46d4cb60"
+	destination := filepath.Join(dir, "repo", "packages", "Setup", "setup.exe")
+	for i := 0; i < 2; i++ {
+		hash, err := copyInstaller(source, destination)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hash != expectedHash {
+			t.Errorf("have %s, want %s", hash, expectedHash)
+		}
+	}
+
+	// Importing a file that is already in the repo leaves it in place
+	hash, err := copyInstaller(destination, destination)
+	if err != nil || hash != expectedHash {
+		t.Errorf("have %s %v, want %s", hash, err, expectedHash)
+	}
+}
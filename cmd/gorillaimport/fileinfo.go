@@ -0,0 +1,120 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/1dustindavis/gorilla/pkg/status"
+	"golang.org/x/sys/windows"
+)
+
+var (
+	msiDLL                  = windows.NewLazySystemDLL("msi.dll")
+	procMsiOpenDatabase     = msiDLL.NewProc("MsiOpenDatabaseW")
+	procMsiDatabaseOpenView = msiDLL.NewProc("MsiDatabaseOpenViewW")
+	procMsiViewExecute      = msiDLL.NewProc("MsiViewExecute")
+	procMsiViewFetch        = msiDLL.NewProc("MsiViewFetch")
+	procMsiRecordGetString  = msiDLL.NewProc("MsiRecordGetStringW")
+	procMsiCloseHandle      = msiDLL.NewProc("MsiCloseHandle")
+)
+
+// msiMetadata reads the product name, version, and product code from an msi's property table
+func msiMetadata(path string) (metadata, error) {
+	properties, err := msiProperties(path)
+	if err != nil {
+		return metadata{}, err
+	}
+	return metadata{
+		Name:        properties["ProductName"],
+		Version:     properties["ProductVersion"],
+		ProductCode: properties["ProductCode"],
+	}, nil
+}
+
+// msiProperties returns every row in an msi's property table
+func msiProperties(path string) (map[string]string, error) {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// A persist mode of zero opens the database read only
+	var database uintptr
+	r, _, _ := procMsiOpenDatabase.Call(uintptr(unsafe.Pointer(pathPtr)), 0, uintptr(unsafe.Pointer(&database)))
+	if r != 0 {
+		return nil, fmt.Errorf("unable to open msi database: %v", windows.Errno(r))
+	}
+	defer procMsiCloseHandle.Call(database)
+
+	query, err := windows.UTF16PtrFromString("SELECT `Property`, `Value` FROM `Property`")
+	if err != nil {
+		return nil, err
+	}
+	var view uintptr
+	r, _, _ = procMsiDatabaseOpenView.Call(database, uintptr(unsafe.Pointer(query)), uintptr(unsafe.Pointer(&view)))
+	if r != 0 {
+		return nil, fmt.Errorf("unable to query msi properties: %v", windows.Errno(r))
+	}
+	defer procMsiCloseHandle.Call(view)
+
+	r, _, _ = procMsiViewExecute.Call(view, 0)
+	if r != 0 {
+		return nil, fmt.Errorf("unable to query msi properties: %v", windows.Errno(r))
+	}
+
+	properties := make(map[string]string)
+	for {
+		var record uintptr
+		r, _, _ = procMsiViewFetch.Call(view, uintptr(unsafe.Pointer(&record)))
+		if windows.Errno(r) == windows.ERROR_NO_MORE_ITEMS {
+			break
+		}
+		if r != 0 {
+			return nil, fmt.Errorf("unable to read msi properties: %v", windows.Errno(r))
+		}
+
+		name, nameErr := msiRecordString(record, 1)
+		value, valueErr := msiRecordString(record, 2)
+		procMsiCloseHandle.Call(record)
+		if nameErr != nil {
+			return nil, nameErr
+		}
+		if valueErr != nil {
+			return nil, valueErr
+		}
+		properties[name] = value
+	}
+	return properties, nil
+}
+
+// msiRecordString returns a field from an msi record, growing the buffer if it is too small
+func msiRecordString(record uintptr, field int) (string, error) {
+	buffer := make([]uint16, 256)
+	for {
+		size := uint32(len(buffer))
+		r, _, _ := procMsiRecordGetString.Call(record, uintptr(field), uintptr(unsafe.Pointer(&buffer[0])), uintptr(unsafe.Pointer(&size)))
+		if windows.Errno(r) == windows.ERROR_MORE_DATA {
+			buffer = make([]uint16, size+1)
+			continue
+		}
+		if r != 0 {
+			return "", fmt.Errorf("unable to read msi record: %v", windows.Errno(r))
+		}
+		return windows.UTF16ToString(buffer[:size]), nil
+	}
+}
+
+// exeMetadata reads the product name and version from an exe's version information
+func exeMetadata(path string) (metadata, error) {
+	fileMetadata := status.GetFileMetadata(path)
+	if fileMetadata.Version() == "" {
+		return metadata{}, fmt.Errorf("no version information found in %s", path)
+	}
+	return metadata{
+		Name:    fileMetadata.ProductName(),
+		Version: fileMetadata.Version(),
+	}, nil
+}
@@ -0,0 +1,16 @@
+// Without an OS specific build, go tools will try to include Windows libraries and fail
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import "fmt"
+
+func msiMetadata(path string) (metadata, error) {
+	return metadata{}, fmt.Errorf("reading msi metadata is only supported on Windows")
+}
+
+func exeMetadata(path string) (metadata, error) {
+	return metadata{}, fmt.Errorf("reading exe metadata is only supported on Windows")
+}
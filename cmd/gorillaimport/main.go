@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/1dustindavis/gorilla/pkg/signature"
+	"github.com/1dustindavis/gorilla/pkg/version"
+)
+
+const usage = `
+Imports an installer into a gorilla repo, and adds it to a catalog
+
+Usage: gorillaimport.exe -repo <path> [options] <installer>
+
+Options:
+-r, -repo          path to the root of the gorilla repo
+-c, -catalog       catalog to add the item to (default: production)
+-n, -name          name of the catalog item, detected from the installer if possible
+-d, -displayname   display name of the catalog item
+-v, -version       version of the catalog item, detected from the installer if possible
+-s, -subdirectory  directory under packages to copy the installer to (default: the item name)
+-k, -key           PEM private key to sign the catalog with, for clients with a metadata_key
+-h, -help          display this help message
+-a, -about         displays the version number and other build info
+
+Example:
+gorillaimport.exe -repo C:\gorilla\repo -catalog testing 7z2201-x64.msi
+`
+
+// options holds everything needed to import an installer
+type options struct {
+	Repo         string
+	Catalog      string
+	Name         string
+	DisplayName  string
+	Version      string
+	Subdirectory string
+	Key          string
+}
+
+func main() {
+	var opts options
+	var help, about bool
+
+	// Repo
+	flag.StringVar(&opts.Repo, "repo", "", "")
+	flag.StringVar(&opts.Repo, "r", "", "")
+	// Catalog
+	flag.StringVar(&opts.Catalog, "catalog", "production", "")
+	flag.StringVar(&opts.Catalog, "c", "production", "")
+	// Name
+	flag.StringVar(&opts.Name, "name", "", "")
+	flag.StringVar(&opts.Name, "n", "", "")
+	// Display name
+	flag.StringVar(&opts.DisplayName, "displayname", "", "")
+	flag.StringVar(&opts.DisplayName, "d", "", "")
+	// Version
+	flag.StringVar(&opts.Version, "version", "", "")
+	flag.StringVar(&opts.Version, "v", "", "")
+	// Subdirectory
+	flag.StringVar(&opts.Subdirectory, "subdirectory", "", "")
+	flag.StringVar(&opts.Subdirectory, "s", "", "")
+	// Key
+	flag.StringVar(&opts.Key, "key", "", "")
+	flag.StringVar(&opts.Key, "k", "", "")
+	// Help
+	flag.BoolVar(&help, "help", false, "")
+	flag.BoolVar(&help, "h", false, "")
+	// About
+	flag.BoolVar(&about, "about", false, "")
+	flag.BoolVar(&about, "a", false, "")
+
+	flag.Usage = func() { fmt.Print(usage) }
+	flag.Parse()
+
+	if help {
+		fmt.Print(usage)
+		os.Exit(0)
+	}
+	if about {
+		version.PrintFull()
+		os.Exit(0)
+	}
+	if opts.Repo == "" || flag.NArg() != 1 {
+		fmt.Print(usage)
+		os.Exit(1)
+	}
+
+	item, err := importInstaller(flag.Arg(0), opts)
+	if err != nil {
+		fmt.Println("Unable to import:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Imported %s %s into the %s catalog\n", item.Name, item.Version, opts.Catalog)
+	fmt.Println("Location:", item.Location)
+	fmt.Println("Hash:", item.Hash)
+}
+
+// importInstaller copies an installer into the repo and adds it to the catalog
+func importInstaller(installerPath string, opts options) (importItem, error) {
+	installerType := typeFromPath(installerPath)
+	if installerType == "" {
+		return importItem{}, fmt.Errorf("unsupported installer type: %s", filepath.Ext(installerPath))
+	}
+
+	// Metadata is a convenience, anything missing can be provided as an option
+	info, err := fileMetadata(installerPath, installerType)
+	if err != nil {
+		fmt.Println("Unable to read installer metadata:", err)
+	}
+
+	item := importItem{
+		Name:        firstNonEmpty(opts.Name, strings.ReplaceAll(info.Name, " ", "")),
+		DisplayName: firstNonEmpty(opts.DisplayName, info.Name, opts.Name),
+		Version:     firstNonEmpty(opts.Version, info.Version),
+		Type:        installerType,
+		ProductCode: info.ProductCode,
+		PackageName: info.PackageName,
+	}
+	if item.Name == "" {
+		return item, fmt.Errorf("unable to determine the item name, use -name")
+	}
+	if item.Version == "" {
+		fmt.Println("Unable to determine the version, use -version to set one")
+	}
+
+	// Copy the installer to packages/<subdirectory>/ in the repo
+	subdirectory := firstNonEmpty(opts.Subdirectory, item.Name)
+	item.Location = path.Join("packages", filepath.ToSlash(subdirectory), filepath.Base(installerPath))
+	item.Hash, err = copyInstaller(installerPath, filepath.Join(opts.Repo, filepath.FromSlash(item.Location)))
+	if err != nil {
+		return item, err
+	}
+
+	catalogPath := filepath.Join(opts.Repo, "catalogs", opts.Catalog+".yaml")
+	err = updateCatalog(catalogPath, item)
+	if err != nil || opts.Key == "" {
+		return item, err
+	}
+
+	// Clients with a metadata key wont use the catalog until it is signed again
+	privateKey, err := ioutil.ReadFile(opts.Key)
+	if err != nil {
+		return item, err
+	}
+	return item, signature.SignFile(privateKey, catalogPath)
+}
+
+// typeFromPath returns the installer type based on the file extension
+func typeFromPath(installerPath string) string {
+	switch strings.ToLower(filepath.Ext(installerPath)) {
+	case ".msi":
+		return "msi"
+	case ".exe":
+		return "exe"
+	case ".ps1":
+		return "ps1"
+	case ".nupkg":
+		return "nupkg"
+	case ".zip":
+		return "zip"
+	case ".msix", ".msixbundle":
+		return "msix"
+	case ".appx", ".appxbundle":
+		return "appx"
+	}
+	return ""
+}
+
+// copyInstaller copies the installer to the destination and returns its sha256 hash
+func copyInstaller(source, destination string) (string, error) {
+	in, err := os.Open(source)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	err = os.MkdirAll(filepath.Dir(destination), 0755)
+	if err != nil {
+		return "", err
+	}
+
+	// Importing a file that is already in place only needs the hash
+	hash := sha256.New()
+	if sameFile(source, destination) {
+		_, err = io.Copy(hash, in)
+		return hex.EncodeToString(hash.Sum(nil)), err
+	}
+
+	out, err := os.Create(destination)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(io.MultiWriter(out, hash), in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(destination)
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// sameFile returns true if both paths refer to the same existing file
+func sameFile(a, b string) bool {
+	aInfo, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bInfo, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(aInfo, bInfo)
+}
+
+// firstNonEmpty returns the first string that isnt empty
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
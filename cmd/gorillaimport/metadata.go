@@ -0,0 +1,77 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// metadata is what we can learn about an installer from the file itself
+type metadata struct {
+	Name        string
+	Version     string
+	ProductCode string
+	PackageName string
+}
+
+// fileMetadata returns the metadata for an installer of the provided type
+func fileMetadata(path, installerType string) (metadata, error) {
+	switch installerType {
+	case "msi":
+		return msiMetadata(path)
+	case "exe":
+		return exeMetadata(path)
+	case "appx", "msix":
+		return appxMetadata(path)
+	}
+	return metadata{}, fmt.Errorf("no metadata available for %s installers", installerType)
+}
+
+// appxManifest holds the parts of an appx or msix manifest that identify the package
+// Packages have an `AppxManifest.xml`, and bundles have an `AppxMetadata/AppxBundleManifest.xml`
+type appxManifest struct {
+	Identity struct {
+		Name    string `xml:"Name,attr"`
+		Version string `xml:"Version,attr"`
+	} `xml:"Identity"`
+	DisplayName string `xml:"Properties>DisplayName"`
+}
+
+// appxMetadata reads the identity from an appx or msix package, which are zip files
+func appxMetadata(path string) (metadata, error) {
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		return metadata{}, err
+	}
+	defer reader.Close()
+
+	for _, file := range reader.File {
+		if !strings.EqualFold(file.Name, "AppxManifest.xml") && !strings.EqualFold(file.Name, "AppxMetadata/AppxBundleManifest.xml") {
+			continue
+		}
+		manifestFile, err := file.Open()
+		if err != nil {
+			return metadata{}, err
+		}
+		defer manifestFile.Close()
+
+		var manifest appxManifest
+		err = xml.NewDecoder(manifestFile).Decode(&manifest)
+		if err != nil {
+			return metadata{}, err
+		}
+
+		// Store apps often use a resource reference instead of a display name
+		name := manifest.DisplayName
+		if name == "" || strings.HasPrefix(name, "ms-resource:") {
+			name = manifest.Identity.Name
+		}
+		return metadata{
+			Name:        name,
+			Version:     manifest.Identity.Version,
+			PackageName: manifest.Identity.Name,
+		}, nil
+	}
+	return metadata{}, fmt.Errorf("no package manifest found in %s", path)
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/1dustindavis/gorilla/pkg/catalog"
+	"github.com/1dustindavis/gorilla/pkg/download"
+	"github.com/1dustindavis/gorilla/pkg/signature"
+	"gopkg.in/yaml.v3"
+)
+
+// pkginfo is a single catalog item, plus the name and catalogs it is added to
+type pkginfo struct {
+	Name         string   `yaml:"name"`
+	Catalogs     []string `yaml:"catalogs"`
+	catalog.Item `yaml:",inline"`
+}
+
+// catalogItem is an item that has been added to a catalog
+type catalogItem struct {
+	// source is the pkginfo file the item came from
+	source string
+	// node is the item as it was written, so the catalog keeps its formatting
+	node *yaml.Node
+}
+
+// installerTypes are the installer types gorilla knows how to run
+var installerTypes = map[string]bool{
+	"msi":   true,
+	"exe":   true,
+	"ps1":   true,
+	"nupkg": true,
+	"zip":   true,
+	"appx":  true,
+	"msix":  true,
+}
+
+// uninstallMethods are the uninstall methods gorilla knows how to use
+var uninstallMethods = map[string]bool{
+	"":            true,
+	"uninstaller": true,
+	"installer":   true,
+	"msi":         true,
+	"script":      true,
+}
+
+// restartActions are the restart actions an item can declare
+var restartActions = map[string]bool{
+	"":                  true,
+	"none":              true,
+	"recommend_restart": true,
+	"require_restart":   true,
+}
+
+// buildCatalogs reads every pkginfo file in the repo and returns the items for each catalog
+// Anything that would keep an item from installing is returned as a problem
+func buildCatalogs(repo string) (catalogs map[string]map[string]catalogItem, problems []string, err error) {
+	catalogs = make(map[string]map[string]catalogItem)
+	pkgsinfo := filepath.Join(repo, "pkgsinfo")
+
+	err = filepath.Walk(pkgsinfo, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		ext := strings.ToLower(filepath.Ext(path))
+		if info.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			return nil
+		}
+		relPath, _ := filepath.Rel(pkgsinfo, path)
+
+		item, node, err := readPkginfo(path)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", relPath, err))
+			return nil
+		}
+		for _, problem := range checkItem(repo, item) {
+			problems = append(problems, fmt.Sprintf("%s: %s", relPath, problem))
+		}
+
+		for _, catalogName := range item.Catalogs {
+			if catalogs[catalogName] == nil {
+				catalogs[catalogName] = make(map[string]catalogItem)
+			}
+			if existing, exists := catalogs[catalogName][item.Name]; exists {
+				problems = append(problems, fmt.Sprintf("%s: %s is already in the %s catalog from %s", relPath, item.Name, catalogName, existing.source))
+				continue
+			}
+			catalogs[catalogName][item.Name] = catalogItem{source: relPath, node: node}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return catalogs, problems, nil
+}
+
+// readPkginfo parses a pkginfo file, rejecting any settings gorilla doesnt know about
+// It also returns the item's yaml without the name and catalogs, ready to add to a catalog
+func readPkginfo(path string) (pkginfo, *yaml.Node, error) {
+	var item pkginfo
+	pkginfoYaml, err := ioutil.ReadFile(path)
+	if err != nil {
+		return item, nil, err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(pkginfoYaml))
+	decoder.KnownFields(true)
+	err = decoder.Decode(&item)
+	if err != nil {
+		return item, nil, err
+	}
+
+	// Items are named after their file, unless they have a name
+	if item.Name == "" {
+		item.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if len(item.Catalogs) == 0 {
+		return item, nil, fmt.Errorf("%s is not in any catalogs", item.Name)
+	}
+
+	var document yaml.Node
+	err = yaml.Unmarshal(pkginfoYaml, &document)
+	if err != nil {
+		return item, nil, err
+	}
+	node := document.Content[0]
+	for i := 0; i+1 < len(node.Content); {
+		if key := node.Content[i].Value; key == "name" || key == "catalogs" {
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			continue
+		}
+		i += 2
+	}
+	return item, node, nil
+}
+
+// checkItem returns any problems that would keep an item from installing or uninstalling
+func checkItem(repo string, item pkginfo) (problems []string) {
+	if item.Installer.Type == "" && item.Uninstaller.Type == "" && item.UninstallScript == "" {
+		problems = append(problems, "has no installer or uninstaller")
+	}
+	if !uninstallMethods[item.UninstallMethod] {
+		problems = append(problems, fmt.Sprintf("uninstall method is not supported: %s", item.UninstallMethod))
+	}
+	if !restartActions[item.RestartAction] {
+		problems = append(problems, fmt.Sprintf("restart action is not supported: %s", item.RestartAction))
+	}
+	if item.ForceInstallAfterDate != "" {
+		if _, err := catalog.ParseDeadline(item.ForceInstallAfterDate); err != nil {
+			problems = append(problems, fmt.Sprint("force_install_after_date: ", err))
+		}
+	}
+	if item.UninstallMethod == "script" && item.UninstallScript == "" {
+		problems = append(problems, "uninstall method is script, but there is no uninstall_script")
+	}
+	if item.Uninstaller.Type == "command" && item.Uninstaller.Command == "" {
+		problems = append(problems, "uninstaller type is command, but there is no command")
+	}
+	for _, installer := range []struct {
+		name string
+		item catalog.InstallerItem
+	}{
+		{"installer", item.Installer},
+		{"uninstaller", item.Uninstaller},
+	} {
+		// Only an uninstaller can run a command that is already on the computer
+		if installer.item.Type != "" && !installerTypes[installer.item.Type] && !(installer.name == "uninstaller" && installer.item.Type == "command") {
+			problems = append(problems, fmt.Sprintf("%s type is not supported: %s", installer.name, installer.item.Type))
+		}
+
+		// Items on another server cant be checked, and some uninstallers dont need a file
+		location := installer.item.Location
+		if location == "" || strings.Contains(location, "://") {
+			continue
+		}
+		if installer.item.Hash == "" {
+			problems = append(problems, fmt.Sprintf("%s has no hash", installer.name))
+		}
+		hash, err := fileHash(filepath.Join(repo, filepath.FromSlash(location)), installer.item.HashType, installer.item.Hash)
+		if os.IsNotExist(err) {
+			problems = append(problems, fmt.Sprintf("%s file is missing: %s", installer.name, location))
+			continue
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("unable to read %s file: %v", installer.name, err))
+			continue
+		}
+		if installer.item.Hash != "" && !strings.EqualFold(hash, installer.item.Hash) {
+			problems = append(problems, fmt.Sprintf("%s hash does not match %s", installer.name, location))
+		}
+	}
+	return problems
+}
+
+// fileHash returns the hash of a file, using the same hash type a client will when it downloads the file
+// Clients only trust sha256 and sha512 for installers, so a weaker hash is an error
+func fileHash(path, hashType, expected string) (string, error) {
+	h, err := download.NewHash(hashType, expected)
+	if err != nil {
+		return "", err
+	}
+	if h.Size() < sha256.Size {
+		return "", fmt.Errorf("%d bit hashes are not trusted for installers, use sha256 or sha512", h.Size()*8)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeCatalogs replaces each catalog in the repo with the items that belong to it
+// If a private key is provided, each catalog is signed with it
+func writeCatalogs(repo string, catalogs map[string]map[string]catalogItem, privateKey []byte) error {
+	catalogsDir := filepath.Join(repo, "catalogs")
+	err := os.MkdirAll(catalogsDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	for catalogName, items := range catalogs {
+		// Sort the items so the catalog only changes when an item does
+		names := make([]string, 0, len(items))
+		for name := range items {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		root := &yaml.Node{Kind: yaml.MappingNode}
+		for _, name := range names {
+			key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: name}
+			root.Content = append(root.Content, key, items[name].node)
+		}
+		document := &yaml.Node{
+			Kind:        yaml.DocumentNode,
+			HeadComment: "Created by makecatalogs, edit the files in pkgsinfo instead",
+			Content:     []*yaml.Node{root},
+		}
+
+		var buffer bytes.Buffer
+		encoder := yaml.NewEncoder(&buffer)
+		encoder.SetIndent(2)
+		err = encoder.Encode(document)
+		if err != nil {
+			return err
+		}
+		encoder.Close()
+
+		catalogPath := filepath.Join(catalogsDir, catalogName+".yaml")
+		err = ioutil.WriteFile(catalogPath, buffer.Bytes(), 0644)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %d items to %s\n", len(items), catalogPath)
+
+		if privateKey != nil {
+			err = signature.SignFile(privateKey, catalogPath)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// signManifests signs every manifest in the repo, since they are edited by hand
+func signManifests(repo string, privateKey []byte) error {
+	manifests, err := filepath.Glob(filepath.Join(repo, "manifests", "*.yaml"))
+	if err != nil {
+		return err
+	}
+	for _, manifestPath := range manifests {
+		err = signature.SignFile(privateKey, manifestPath)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Signed %d manifests\n", len(manifests))
+	return nil
+}
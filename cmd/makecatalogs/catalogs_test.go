@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/1dustindavis/gorilla/pkg/catalog"
+	"github.com/1dustindavis/gorilla/pkg/signature"
+	"gopkg.in/yaml.v3"
+)
+
+// writeTestFile writes a file to the test repo, creating any directories it needs
+func writeTestFile(t *testing.T, repo, name, contents string) {
+	path := filepath.Join(repo, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestBuildCatalogs verifies that pkginfo files are assembled into catalogs, and that problems are found
+func TestBuildCatalogs(t *testing.T) {
+	repo, err := ioutil.TempDir("", "gorilla_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(repo)
+
+	// The sha256 of "gorilla"
+	writeTestFile(t, repo, "packages/Tool/tool.msi", "gorilla")
+	writeTestFile(t, repo, "pkgsinfo/Tool.yaml", `
+catalogs: [testing, production]
+display_name: Tool
+installer:
+  type: msi
+  location: packages/Tool/tool.msi
+  hash: 541f42d7542b70062fa430bfccac434186c0c1bb433b45b6f1b76e6f46d4cb60
+  product_code: "{6F1D1E2C-6F5A-4E55-9C3C-2F1B4F1E8A11}"
+uninstaller:
+  type: msi
+version: "1.10"
+`)
+	writeTestFile(t, repo, "pkgsinfo/apps/Browser-2.0.yml", `
+name: Browser
+catalogs: [testing]
+installer:
+  type: exe
+  location: https://example.com/browser.exe
+version: "2.0"
+`)
+	writeTestFile(t, repo, "pkgsinfo/apps/Browser-1.0.yaml", `
+name: Browser
+catalogs: [testing]
+installer:
+  type: exe
+  location: https://example.com/browser-1.0.exe
+`)
+	writeTestFile(t, repo, "pkgsinfo/Missing.yaml", `
+catalogs: [testing]
+installer:
+  type: msi
+  location: packages/Missing/missing.msi
+  hash: abc
+`)
+	writeTestFile(t, repo, "pkgsinfo/BadHash.yaml", `
+catalogs: [testing]
+installer:
+  type: dmg
+  location: packages/Tool/tool.msi
+  hash: abc
+restart_action: reboot_now
+force_install_after_date: next tuesday
+`)
+	writeTestFile(t, repo, "pkgsinfo/Typo.yaml", `
+catalogs: [testing]
+instaler:
+  type: msi
+`)
+	writeTestFile(t, repo, "pkgsinfo/Uncataloged.yaml", `
+installer:
+  type: exe
+  location: https://example.com/setup.exe
+`)
+	writeTestFile(t, repo, "pkgsinfo/notes.txt", "not a pkginfo")
+
+	catalogs, problems, err := buildCatalogs(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedProblems := []string{
+		"BadHash.yaml: installer type is not supported: dmg",
+		"BadHash.yaml: restart action is not supported: reboot_now",
+		"BadHash.yaml: force_install_after_date: unable to read \"next tuesday\" as a date, like 2024-06-01T17:00",
+		"BadHash.yaml: installer hash does not match packages/Tool/tool.msi",
+		"Missing.yaml: installer file is missing: packages/Missing/missing.msi",
+		filepath.Join("apps", "Browser-2.0.yml") + ": Browser is already in the testing catalog from " + filepath.Join("apps", "Browser-1.0.yaml"),
+	}
+	if len(problems) != len(expectedProblems)+2 {
+		t.Errorf("Expected %d problems, got %d: %v", len(expectedProblems)+2, len(problems), problems)
+	}
+	for _, expected := range expectedProblems {
+		found := false
+		for _, problem := range problems {
+			if problem == expected {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expected problem not found: %s\n%v", expected, problems)
+		}
+	}
+
+	// Write the catalogs, and confirm gorilla can read them
+	err = writeCatalogs(repo, catalogs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readCatalog := func(name string) map[string]catalog.Item {
+		catalogYaml, err := ioutil.ReadFile(filepath.Join(repo, "catalogs", name+".yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var items map[string]catalog.Item
+		if err := yaml.Unmarshal(catalogYaml, &items); err != nil {
+			t.Fatal(err)
+		}
+		return items
+	}
+
+	tool := catalog.Item{
+		DisplayName: "Tool",
+		Installer: catalog.InstallerItem{
+			Type:        "msi",
+			Location:    "packages/Tool/tool.msi",
+			Hash:        "541f42d7542b70062fa430bfccac434186c0c1bb433b45b6f1b76e6f46d4cb60",
+			ProductCode: "{6F1D1E2C-6F5A-4E55-9C3C-2F1B4F1E8A11}",
+		},
+		Uninstaller: catalog.InstallerItem{Type: "msi"},
+		Version:     "1.10",
+	}
+	if have, want := readCatalog("production"), map[string]catalog.Item{"Tool": tool}; !reflect.DeepEqual(have, want) {
+		t.Errorf("\nExpected: %#v\nActual: %#v", want, have)
+	}
+
+	testingItems := readCatalog("testing")
+	var names []string
+	for name := range testingItems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if have, want := names, []string{"BadHash", "Browser", "Missing", "Tool"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	if have, want := testingItems["Browser"].Installer.Location, "https://example.com/browser-1.0.exe"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+}
+
+// TestSignManifests verifies that each manifest gets a signature that matches the key
+func TestSignManifests(t *testing.T) {
+	repo, err := ioutil.TempDir("", "gorilla_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(repo)
+	writeTestFile(t, repo, "manifests/site_default.yaml", "managed_installs: [Tool]\n")
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = signManifests(repo, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: privateKey}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifestPath := filepath.Join(repo, "manifests", "site_default.yaml")
+	sig, err := ioutil.ReadFile(manifestPath + signature.Extension)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = signature.VerifyFile(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey}), manifestPath, sig)
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/1dustindavis/gorilla/pkg/version"
+)
+
+const usage = `
+Builds the catalogs in a gorilla repo from the pkginfo files in its pkgsinfo directory
+
+Usage: makecatalogs.exe -repo <path> [options]
+
+Options:
+-r, -repo          path to the root of the gorilla repo
+-f, -force         write the catalogs even if problems are found
+-k, -key           PEM private key to sign the catalogs and manifests with, for clients with a metadata_key
+-h, -help          display this help message
+-a, -about         displays the version number and other build info
+
+Each pkginfo file holds a single catalog item, plus the catalogs it belongs to:
+
+name: Firefox
+catalogs:
+  - testing
+display_name: Mozilla Firefox
+installer:
+  type: msi
+  location: packages/Firefox/Firefox Setup 116.0.msi
+  hash: 1d4a...
+version: "116.0"
+`
+
+func main() {
+	var repo, key string
+	var force, help, about bool
+
+	// Repo
+	flag.StringVar(&repo, "repo", "", "")
+	flag.StringVar(&repo, "r", "", "")
+	// Force
+	flag.BoolVar(&force, "force", false, "")
+	flag.BoolVar(&force, "f", false, "")
+	// Key
+	flag.StringVar(&key, "key", "", "")
+	flag.StringVar(&key, "k", "", "")
+	// Help
+	flag.BoolVar(&help, "help", false, "")
+	flag.BoolVar(&help, "h", false, "")
+	// About
+	flag.BoolVar(&about, "about", false, "")
+	flag.BoolVar(&about, "a", false, "")
+
+	flag.Usage = func() { fmt.Print(usage) }
+	flag.Parse()
+
+	if help {
+		fmt.Print(usage)
+		os.Exit(0)
+	}
+	if about {
+		version.PrintFull()
+		os.Exit(0)
+	}
+	if repo == "" {
+		fmt.Print(usage)
+		os.Exit(1)
+	}
+
+	var privateKey []byte
+	if key != "" {
+		var err error
+		privateKey, err = ioutil.ReadFile(key)
+		if err != nil {
+			fmt.Println("Unable to read signing key:", err)
+			os.Exit(1)
+		}
+	}
+
+	catalogs, problems, err := buildCatalogs(repo)
+	if err != nil {
+		fmt.Println("Unable to build catalogs:", err)
+		os.Exit(1)
+	}
+	for _, problem := range problems {
+		fmt.Println("WARNING:", problem)
+	}
+	if len(problems) > 0 && !force {
+		fmt.Println("Not writing catalogs, fix the problems above or use -force")
+		os.Exit(1)
+	}
+
+	err = writeCatalogs(repo, catalogs, privateKey)
+	if err != nil {
+		fmt.Println("Unable to write catalogs:", err)
+		os.Exit(1)
+	}
+
+	if privateKey != nil {
+		err = signManifests(repo, privateKey)
+		if err != nil {
+			fmt.Println("Unable to sign manifests:", err)
+			os.Exit(1)
+		}
+	}
+}
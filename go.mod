@@ -6,6 +6,7 @@ require (
 	github.com/gonutz/w32 v1.0.0
 	github.com/hashicorp/go-version v1.3.0
 	github.com/kr/pretty v0.3.0 // indirect
+	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519
 	golang.org/x/sys v0.0.0-20210925032602-92d5a993a665
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
 	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
@@ -1,42 +1,98 @@
 package catalog
 
 import (
+	"errors"
 	"fmt"
-	"os"
+	"time"
 
 	"github.com/1dustindavis/gorilla/pkg/config"
 	"github.com/1dustindavis/gorilla/pkg/download"
 	"github.com/1dustindavis/gorilla/pkg/gorillalog"
 	"github.com/1dustindavis/gorilla/pkg/report"
+	"github.com/1dustindavis/gorilla/pkg/signature"
 	"gopkg.in/yaml.v3"
 )
 
 // Item contains an individual entry from the catalog
 type Item struct {
-	Dependencies []string      `yaml:"dependencies"`
-	DisplayName  string        `yaml:"display_name"`
-	Check        InstallCheck  `yaml:"check"`
-	Installer    InstallerItem `yaml:"installer"`
-	Uninstaller  InstallerItem `yaml:"uninstaller"`
-	Version      string        `yaml:"version"`
-	BlockingApps []string      `yaml:"blocking_apps"`
-	PreScript    string        `yaml:"preinstall_script"`
-	PostScript   string        `yaml:"postinstall_script"`
+	Dependencies           []string      `yaml:"dependencies"`
+	DisplayName            string        `yaml:"display_name"`
+	Check                  InstallCheck  `yaml:"check"`
+	CheckScript            string        `yaml:"check_script,omitempty"`
+	InstallCheckScript     string        `yaml:"installcheck_script,omitempty"`
+	UninstallCheckScript   string        `yaml:"uninstallcheck_script,omitempty"`
+	Installs               []FileCheck   `yaml:"installs,omitempty"`
+	Installer              InstallerItem `yaml:"installer"`
+	Uninstaller            InstallerItem `yaml:"uninstaller"`
+	UninstallMethod        string        `yaml:"uninstall_method,omitempty"`
+	UninstallScript        string        `yaml:"uninstall_script,omitempty"`
+	UninstallScriptType    string        `yaml:"uninstall_script_type,omitempty"`
+	Version                string        `yaml:"version"`
+	BlockingApps           []string      `yaml:"blocking_apps"`
+	PreScript              string        `yaml:"preinstall_script"`
+	PreScriptType          string        `yaml:"preinstall_script_type,omitempty"`
+	PostScript             string        `yaml:"postinstall_script"`
+	PostScriptType         string        `yaml:"postinstall_script_type,omitempty"`
+	UpdateFor              []string      `yaml:"update_for,omitempty"`
+	Category               string        `yaml:"category,omitempty"`
+	Priority               int           `yaml:"priority,omitempty"`
+	DownloadTimeout        int           `yaml:"download_timeout,omitempty"`
+	InstallerTimeout       int           `yaml:"installer_timeout,omitempty"`
+	MinimumOSVersion       string        `yaml:"minimum_os_version,omitempty"`
+	MaximumOSVersion       string        `yaml:"maximum_os_version,omitempty"`
+	SupportedArchitectures []string      `yaml:"supported_architectures,omitempty"`
+	RestartAction          string        `yaml:"restart_action,omitempty"`
+	ForceInstallAfterDate  string        `yaml:"force_install_after_date,omitempty"`
+}
+
+// deadlineFormats are the ways a `force_install_after_date` can be written
+// Without a time zone, the deadline is in the computer's local time
+var deadlineFormats = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"}
+
+// ParseDeadline reads an item's `force_install_after_date`
+func ParseDeadline(value string) (time.Time, error) {
+	for _, format := range deadlineFormats {
+		if deadline, err := time.ParseInLocation(format, value, time.Local); err == nil {
+			return deadline, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unable to read %q as a date, like 2024-06-01T17:00", value)
 }
 
 // InstallerItem holds information about how to install a catalog item
 type InstallerItem struct {
-	Type      string   `yaml:"type"`
-	Location  string   `yaml:"location"`
-	Hash      string   `yaml:"hash"`
-	Arguments []string `yaml:"arguments"`
+	Type             string   `yaml:"type"`
+	Location         string   `yaml:"location"`
+	Hash             string   `yaml:"hash"`
+	HashType         string   `yaml:"hash_type,omitempty"`
+	Signature        string   `yaml:"signature,omitempty"`
+	Arguments        []string `yaml:"arguments"`
+	Destination      string   `yaml:"destination,omitempty"`
+	WorkingDirectory string   `yaml:"working_directory,omitempty"`
+	RunAs            string   `yaml:"run_as,omitempty"`
+	ProductCode      string   `yaml:"product_code,omitempty"`
+	PackageName      string   `yaml:"package_name,omitempty"`
+	Command          string   `yaml:"command,omitempty"`
+	SuccessCodes     []int    `yaml:"success_codes,omitempty"`
+	RebootExitCodes  []int    `yaml:"reboot_exit_codes,omitempty"`
+	Deltas           []Delta  `yaml:"deltas,omitempty"`
+}
+
+// Delta is a bsdiff patch that turns a previous version of an installer into this one
+// Both locations are relative to the packages url, like the installer location
+type Delta struct {
+	From     string `yaml:"from"`
+	FromHash string `yaml:"from_hash"`
+	Location string `yaml:"location"`
+	Hash     string `yaml:"hash,omitempty"`
 }
 
 // InstallCheck holds information about how to check the status of a catalog item
 type InstallCheck struct {
-	File     []FileCheck `yaml:"file"`
-	Script   string      `yaml:"script"`
-	Registry RegCheck    `yaml:"registry"`
+	File           []FileCheck     `yaml:"file"`
+	Script         string          `yaml:"script"`
+	Registry       RegCheck        `yaml:"registry"`
+	RegistryValues []RegValueCheck `yaml:"registry_values,omitempty"`
 }
 
 // FileCheck holds information about checking via a file
@@ -45,6 +101,7 @@ type FileCheck struct {
 	Version     string `yaml:"version"`
 	ProductName string `yaml:"product_name"`
 	Hash        string `yaml:"hash"`
+	HashType    string `yaml:"hash_type,omitempty"`
 }
 
 // RegCheck holds information about checking via registry
@@ -53,10 +110,120 @@ type RegCheck struct {
 	Version string `yaml:"version"`
 }
 
+// RegValueCheck holds information about checking a registry key or value, like `HKLM\SOFTWARE\Vendor\App`
+// Without a name only the key must exist, otherwise the value must exist and match the data or be at least the version
+type RegValueCheck struct {
+	Path    string `yaml:"path"`
+	Name    string `yaml:"name,omitempty"`
+	Data    string `yaml:"data,omitempty"`
+	Version string `yaml:"version,omitempty"`
+}
+
 // This abstraction allows us to override the function while testing
-var downloadGet = download.Get
+// Catalogs rarely change between runs, so only changed catalogs are downloaded again
+var downloadGet = download.GetCached
+
+// defaultsKey is the name of the catalog entry that holds values shared by every item
+const defaultsKey = "defaults"
+
+// applyDefaults decodes each item in a catalog, after filling in any setting it does not set
+// with the value from the catalog's `defaults` entry, which is not treated as an item
+func applyDefaults(catalogNodes map[string]yaml.Node) (map[string]Item, error) {
+	defaults, hasDefaults := catalogNodes[defaultsKey]
+	delete(catalogNodes, defaultsKey)
+
+	catalogItems := make(map[string]Item, len(catalogNodes))
+	for name, node := range catalogNodes {
+		if hasDefaults {
+			node = *mergeDefaults(&node, &defaults)
+		}
+		var item Item
+		if err := node.Decode(&item); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		catalogItems[name] = item
+	}
+	return catalogItems, nil
+}
+
+// mergeDefaults returns the item's yaml with every key it is missing added from defaults
+// Merging keys instead of values means an item can still set `false`, `0`, or an empty value over a default,
+// and nested settings are merged key by key, so an item can override a single installer setting
+func mergeDefaults(item *yaml.Node, defaults *yaml.Node) *yaml.Node {
+	if item.Kind != yaml.MappingNode || defaults.Kind != yaml.MappingNode {
+		return item
+	}
+	// Build a new mapping, so the parsed yaml is never shared or changed
+	merged := *item
+	merged.Content = append([]*yaml.Node(nil), item.Content...)
+	for i := 0; i+1 < len(defaults.Content); i += 2 {
+		key, value := defaults.Content[i], defaults.Content[i+1]
+		found := false
+		for j := 0; j+1 < len(merged.Content); j += 2 {
+			if merged.Content[j].Value == key.Value {
+				merged.Content[j+1] = mergeDefaults(merged.Content[j+1], value)
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged.Content = append(merged.Content, key, value)
+		}
+	}
+	return &merged
+}
+
+// applyCheckScripts copies an item's top level `check_script` into its checks,
+// which is where the `status` package looks for it. A `check.script` takes precedence.
+func applyCheckScripts(catalogItems map[string]Item) map[string]Item {
+	for name, item := range catalogItems {
+		if item.CheckScript != "" && item.Check.Script == "" {
+			item.Check.Script = item.CheckScript
+			catalogItems[name] = item
+		}
+	}
+	return catalogItems
+}
+
+// applyInstalls adds an item's `installs` to its file checks, which is where the `status` package looks for them
+// Like Munki's installs array, every file must exist and match its version or hash for the item to be installed.
+func applyInstalls(catalogItems map[string]Item) map[string]Item {
+	for name, item := range catalogItems {
+		if len(item.Installs) > 0 {
+			item.Check.File = append(append([]FileCheck(nil), item.Check.File...), item.Installs...)
+			catalogItems[name] = item
+		}
+	}
+	return catalogItems
+}
 
-// Get returns a map of `Item` from the catalog
+// applyUninstallMethod sets up an item's uninstaller for its `uninstall_method`, which is where the `installer` package looks for it
+//
+//	uninstaller  the `uninstaller` is used as it is, which is the default
+//	installer    the installer is run again with the uninstaller's arguments, like `/uninstall`
+//	msi          the installer's msi is removed by its product code
+//	script       the `uninstall_script` is run, which is also the default for an item without an uninstaller
+func applyUninstallMethod(catalogItems map[string]Item) map[string]Item {
+	for name, item := range catalogItems {
+		switch item.UninstallMethod {
+		case "":
+			if item.UninstallScript != "" && item.Uninstaller.Type == "" {
+				item.UninstallMethod = "script"
+			}
+		case "installer":
+			arguments := item.Uninstaller.Arguments
+			item.Uninstaller = item.Installer
+			item.Uninstaller.Arguments = arguments
+		case "msi":
+			item.Uninstaller.Type = "msi"
+		}
+		catalogItems[name] = item
+	}
+	return catalogItems
+}
+
+// Get returns a map of `Item` from each catalog, keyed by the order the catalogs are configured in
+// When more than one catalog has an item with the same name, the earliest catalog takes precedence
 func Get(cfg config.Configuration) map[int]map[string]Item {
 
 	// catalogMap is an map of parsed catalogs
@@ -69,8 +236,9 @@ func Get(cfg config.Configuration) map[int]map[string]Item {
 	defer func() {
 		if r := recover(); r != nil {
 			fmt.Println(r)
+			report.Fail()
 			report.End()
-			os.Exit(1)
+			report.Exit()
 
 		}
 	}()
@@ -90,18 +258,32 @@ func Get(cfg config.Configuration) map[int]map[string]Item {
 		gorillalog.Info("Catalog Url:", catalogURL)
 		yamlFile, err := downloadGet(catalogURL)
 		if err != nil {
+			// A repo that cant be reached is a different problem than a missing or broken catalog
+			if errors.Is(err, download.ErrNetwork) || errors.Is(err, download.ErrServerError) {
+				report.FailWith(report.ExitNetworkError)
+			}
 			gorillalog.Error("Unable to retrieve catalog: ", err)
 		}
 
+		// Make sure the catalog was signed by us before trusting anything in it
+		err = signature.VerifyURL(cfg.MetadataKey, catalogURL, yamlFile, downloadGet)
+		if err != nil {
+			gorillalog.Error("Unable to verify catalog signature: ", catalogURL, err)
+		}
+
 		// Parse the catalog
-		var catalogItems map[string]Item
-		err = yaml.Unmarshal(yamlFile, &catalogItems)
+		var catalogNodes map[string]yaml.Node
+		err = yaml.Unmarshal(yamlFile, &catalogNodes)
+		if err != nil {
+			gorillalog.Error("Unable to parse yaml catalog: ", err)
+		}
+		catalogItems, err := applyDefaults(catalogNodes)
 		if err != nil {
 			gorillalog.Error("Unable to parse yaml catalog: ", err)
 		}
 
 		// Add the new parsed catalog items to the catalogMap
-		catalogMap[catalogCount] = catalogItems
+		catalogMap[catalogCount] = applyUninstallMethod(applyInstalls(applyCheckScripts(catalogItems)))
 	}
 
 	return catalogMap
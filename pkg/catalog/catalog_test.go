@@ -71,3 +71,139 @@ If ($upToDate) {
 		t.Errorf("\n\nExpected:\n\n%#v\n\nReceived:\n\n %#v", expected, testCatalog[1])
 	}
 }
+
+// TestApplyDefaults verifies that items inherit any field they dont set from the catalog defaults,
+// and can override a default with an empty value
+func TestApplyDefaults(t *testing.T) {
+	var catalogNodes map[string]yaml.Node
+	err := yaml.Unmarshal([]byte(`
+defaults:
+  installer:
+    type: exe
+    arguments:
+      - /S
+  installer_timeout: 600
+  category: utilities
+  blocking_apps:
+    - Outlook
+Silent:
+  display_name: Silent
+  installer:
+    location: packages/silent.exe
+Custom:
+  display_name: Custom
+  installer:
+    location: packages/custom.msi
+    type: msi
+    arguments:
+      - /quiet
+  installer_timeout: 2700
+Quiet:
+  display_name: Quiet
+  installer:
+    location: packages/quiet.exe
+    arguments: []
+  installer_timeout: 0
+  category: ""
+  blocking_apps:
+`), &catalogNodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]Item{
+		"Silent": {
+			DisplayName:      "Silent",
+			Installer:        InstallerItem{Type: "exe", Location: "packages/silent.exe", Arguments: []string{"/S"}},
+			InstallerTimeout: 600,
+			Category:         "utilities",
+			BlockingApps:     []string{"Outlook"},
+		},
+		"Custom": {
+			DisplayName:      "Custom",
+			Installer:        InstallerItem{Type: "msi", Location: "packages/custom.msi", Arguments: []string{"/quiet"}},
+			InstallerTimeout: 2700,
+			Category:         "utilities",
+			BlockingApps:     []string{"Outlook"},
+		},
+		"Quiet": {
+			DisplayName: "Quiet",
+			Installer:   InstallerItem{Type: "exe", Location: "packages/quiet.exe", Arguments: []string{}},
+		},
+	}
+	actual, err := applyDefaults(catalogNodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("\n\nExpected:\n\n%#v\n\nReceived:\n\n %#v", expected, actual)
+	}
+}
+
+// TestApplyCheckScripts verifies that a top level check_script is used as the item's check script
+func TestApplyCheckScripts(t *testing.T) {
+	catalogItems := map[string]Item{
+		"TopLevel": {CheckScript: "exit 0"},
+		"Nested":   {Check: InstallCheck{Script: "exit 1"}},
+		"Both":     {CheckScript: "exit 0", Check: InstallCheck{Script: "exit 1"}},
+	}
+
+	expected := map[string]Item{
+		"TopLevel": {CheckScript: "exit 0", Check: InstallCheck{Script: "exit 0"}},
+		"Nested":   {Check: InstallCheck{Script: "exit 1"}},
+		"Both":     {CheckScript: "exit 0", Check: InstallCheck{Script: "exit 1"}},
+	}
+	actual := applyCheckScripts(catalogItems)
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("\n\nExpected:\n\n%#v\n\nReceived:\n\n %#v", expected, actual)
+	}
+}
+
+// TestApplyInstalls verifies an item's installs are added to its file checks
+func TestApplyInstalls(t *testing.T) {
+	tool := FileCheck{Path: `C:\Tools\tool.exe`, Hash: "29baadaae342146c9c172fc2662d7cea"}
+	license := FileCheck{Path: `C:\Tools\license.txt`}
+	catalogItems := map[string]Item{
+		"Installs": {Installs: []FileCheck{tool}},
+		"Both":     {Installs: []FileCheck{tool}, Check: InstallCheck{File: []FileCheck{license}}},
+		"Neither":  {},
+	}
+
+	expected := map[string]Item{
+		"Installs": {Installs: []FileCheck{tool}, Check: InstallCheck{File: []FileCheck{tool}}},
+		"Both":     {Installs: []FileCheck{tool}, Check: InstallCheck{File: []FileCheck{license, tool}}},
+		"Neither":  {},
+	}
+	actual := applyInstalls(catalogItems)
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("\n\nExpected:\n\n%#v\n\nReceived:\n\n %#v", expected, actual)
+	}
+}
+
+// TestApplyUninstallMethod verifies an item's uninstaller is set up for its uninstall method
+func TestApplyUninstallMethod(t *testing.T) {
+	setup := InstallerItem{Type: "exe", Location: "packages/app/setup.exe", Hash: "abc", Arguments: []string{"/S"}}
+	catalogItems := map[string]Item{
+		"Installer": {UninstallMethod: "installer", Installer: setup, Uninstaller: InstallerItem{Arguments: []string{"/uninstall", "/S"}}},
+		"Msi":       {UninstallMethod: "msi", Installer: InstallerItem{Type: "msi", ProductCode: "{1234}"}},
+		"Script":    {UninstallScript: "exit 0"},
+		"Both":      {UninstallScript: "exit 0", Uninstaller: InstallerItem{Type: "exe", Location: "uninstall.exe"}},
+		"Neither":   {},
+	}
+
+	expected := map[string]Item{
+		"Installer": {
+			UninstallMethod: "installer",
+			Installer:       setup,
+			Uninstaller:     InstallerItem{Type: "exe", Location: "packages/app/setup.exe", Hash: "abc", Arguments: []string{"/uninstall", "/S"}},
+		},
+		"Msi":     {UninstallMethod: "msi", Installer: InstallerItem{Type: "msi", ProductCode: "{1234}"}, Uninstaller: InstallerItem{Type: "msi"}},
+		"Script":  {UninstallMethod: "script", UninstallScript: "exit 0"},
+		"Both":    {UninstallScript: "exit 0", Uninstaller: InstallerItem{Type: "exe", Location: "uninstall.exe"}},
+		"Neither": {},
+	}
+	actual := applyUninstallMethod(catalogItems)
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("\n\nExpected:\n\n%#v\n\nReceived:\n\n %#v", expected, actual)
+	}
+}
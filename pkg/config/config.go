@@ -3,13 +3,15 @@ package config
 import (
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 
 	"github.com/1dustindavis/gorilla/pkg/report"
+	"github.com/1dustindavis/gorilla/pkg/secret"
 	"github.com/1dustindavis/gorilla/pkg/version"
 )
 
@@ -18,20 +20,41 @@ var (
 	cachePath string
 
 	// Define flag defaults
-	aboutArg         bool
-	aboutDefault     = false
-	configArg        string
-	configDefault    = filepath.Join(os.Getenv("ProgramData"), "gorilla/config.yaml")
-	debugArg         bool
-	debugDefault     = false
-	helpArg          bool
-	helpDefault      = false
-	verboseArg       bool
-	verboseDefault   = false
-	checkOnlyArg     bool
-	checkOnlyDefault = false
-	versionArg       bool
-	versionDefault   = false
+	aboutArg          bool
+	aboutDefault      = false
+	configArg         string
+	configDefault     = filepath.Join(os.Getenv("ProgramData"), "gorilla/config.yaml")
+	debugArg          bool
+	debugDefault      = false
+	helpArg           bool
+	helpDefault       = false
+	verboseArg        bool
+	verboseDefault    = false
+	checkOnlyArg      bool
+	checkOnlyDefault  = false
+	statusArg         bool
+	statusDefault     = false
+	forceArg          bool
+	forceDefault      = false
+	categoryArg       stringList
+	bootstrapArg      string
+	bootstrapDefault  = ""
+	clearCacheArg     bool
+	clearCacheDefault = false
+	versionArg        bool
+	versionDefault    = false
+	jsonArg           summaryFlag
+	progressArg       string
+	progressDefault   = ""
+	showConfigArg     bool
+	setArg            settingList
+	profileArg        string
+	profileDefault    = ""
+	showConfigDefault = false
+	modeArg           string
+	modeDefault       = ""
+	autoArg           bool
+	manualArg         bool
 
 	// Use a fake function so we can override when testing
 	osExit = os.Exit
@@ -42,10 +65,27 @@ Gorilla - Munki-like Application Management for Windows
 https://github.com/1dustindavis/gorilla
 
 Usage: gorilla.exe [options]
+       gorilla.exe service <install|uninstall|start|stop|run-now> [options]
+       gorilla.exe <install|remove|status> <item>... [options]
+       gorilla.exe list [options]
+       gorilla.exe config set-secret <setting>
 
 Options:
 -c, -config         path to configuration file in yaml format
+    -profile        apply a named profile from the configuration file
 -C, -checkonly	    enable check only mode
+-s, -status         display the status of each managed item without making changes
+-f, -force          run even when busy, and uninstall items other items depend on
+-g, -category       only process items in a category, may be repeated or comma separated
+-b, -bootstrap      install from a pre-staged media folder without using the network
+    -mode           how the run was started: auto, manual, or bootstrap
+    -auto           a scheduled run, the same as -mode auto
+    -manual         a run started by someone watching it, the same as -mode manual
+    -clear-cache    delete every downloaded installer from the cache and exit
+    -json           print a JSON summary of the run, or write it to a file with -json=<path>
+    -progress       show the progress of downloads and installers: console, json, or off
+    -set            override a setting for this run, like -set url=https://example.com/gorilla/
+    -show-config    print the resolved configuration, with secrets redacted, and exit
 -v, -verbose        enable verbose output
 -d, -debug          enable debug output
 -a, -about          displays the version number and other build info
@@ -56,23 +96,172 @@ Options:
 
 // Configuration stores all of the possible parameters a config file could contain
 type Configuration struct {
-	URL            string   `yaml:"url"`
-	URLPackages    string   `yaml:"url_packages"`
-	Manifest       string   `yaml:"manifest"`
-	LocalManifests []string `yaml:"local_manifests,omitempty"`
-	Catalogs       []string `yaml:"catalogs"`
-	AppDataPath    string   `yaml:"app_data_path"`
-	Verbose        bool     `yaml:"verbose,omitempty"`
-	Debug          bool     `yaml:"debug,omitempty"`
-	CheckOnly      bool     `yaml:"checkonly,omitempty"`
-	SASToken       string   `yaml:"sas_token,omitempty"`
-	AuthUser       string   `yaml:"auth_user,omitempty"`
-	AuthPass       string   `yaml:"auth_pass,omitempty"`
-	TLSAuth        bool     `yaml:"tls_auth,omitempty"`
-	TLSClientCert  string   `yaml:"tls_client_cert,omitempty"`
-	TLSClientKey   string   `yaml:"tls_client_key,omitempty"`
-	TLSServerCert  string   `yaml:"tls_server_cert,omitempty"`
-	CachePath      string
+	URL                   string            `yaml:"url"`
+	URLPackages           string            `yaml:"url_packages"`
+	Mirrors               []string          `yaml:"mirrors,omitempty"`
+	PackageMirrors        []string          `yaml:"package_mirrors,omitempty"`
+	GitURL                string            `yaml:"git_url,omitempty"`
+	GitBranch             string            `yaml:"git_branch,omitempty"`
+	Manifest              string            `yaml:"manifest"`
+	DefaultManifest       string            `yaml:"default_manifest,omitempty"`
+	GroupManifests        map[string]string `yaml:"group_manifests,omitempty"`
+	GroupSource           string            `yaml:"group_source,omitempty"`
+	GraphTenantID         string            `yaml:"graph_tenant_id,omitempty"`
+	GraphClientID         string            `yaml:"graph_client_id,omitempty"`
+	GraphClientSecret     string            `yaml:"graph_client_secret,omitempty"`
+	LocalManifests        []string          `yaml:"local_manifests,omitempty"`
+	Catalogs              []string          `yaml:"catalogs"`
+	AppDataPath           string            `yaml:"app_data_path"`
+	Verbose               bool              `yaml:"verbose,omitempty"`
+	Debug                 bool              `yaml:"debug,omitempty"`
+	LogLevel              string            `yaml:"log_level,omitempty"`
+	LogFormat             string            `yaml:"log_format,omitempty"`
+	LogMaxSizeMB          int               `yaml:"log_max_size_mb,omitempty"`
+	LogMaxBackups         int               `yaml:"log_max_backups,omitempty"`
+	LogMaxAgeDays         int               `yaml:"log_max_age_days,omitempty"`
+	EventLog              bool              `yaml:"event_log,omitempty"`
+	CheckOnly             bool              `yaml:"checkonly,omitempty"`
+	StatusOnly            bool              `yaml:"-"`
+	Force                 bool              `yaml:"-"`
+	Categories            []string          `yaml:"-"`
+	BootstrapPath         string            `yaml:"-"`
+	Mode                  string            `yaml:"-"`
+	JSONSummary           string            `yaml:"-"`
+	Progress              string            `yaml:"-"`
+	ClearCache            bool              `yaml:"-"`
+	SASToken              string            `yaml:"sas_token,omitempty"`
+	SASTokenFile          string            `yaml:"sas_token_file,omitempty"`
+	SASTokenURL           string            `yaml:"sas_token_url,omitempty"`
+	AzureStorage          bool              `yaml:"azure_storage,omitempty"`
+	AzureSASTokens        map[string]string `yaml:"azure_sas_tokens,omitempty"`
+	AzureManagedIdentity  bool              `yaml:"azure_managed_identity,omitempty"`
+	AzureClientID         string            `yaml:"azure_client_id,omitempty"`
+	AuthUser              string            `yaml:"auth_user,omitempty"`
+	AuthPass              string            `yaml:"auth_pass,omitempty"`
+	AuthNegotiate         bool              `yaml:"auth_negotiate,omitempty"`
+	BearerToken           string            `yaml:"bearer_token,omitempty"`
+	OAuthTokenURL         string            `yaml:"oauth_token_url,omitempty"`
+	OAuthClientID         string            `yaml:"oauth_client_id,omitempty"`
+	OAuthClientSecret     string            `yaml:"oauth_client_secret,omitempty"`
+	OAuthScopes           []string          `yaml:"oauth_scopes,omitempty"`
+	Headers               map[string]string `yaml:"headers,omitempty"`
+	S3Region              string            `yaml:"s3_region,omitempty"`
+	S3Endpoint            string            `yaml:"s3_endpoint,omitempty"`
+	S3AccessKey           string            `yaml:"s3_access_key,omitempty"`
+	S3SecretKey           string            `yaml:"s3_secret_key,omitempty"`
+	S3UseRole             bool              `yaml:"s3_use_role,omitempty"`
+	TLSAuth               bool              `yaml:"tls_auth,omitempty"`
+	TLSClientCert         string            `yaml:"tls_client_cert,omitempty"`
+	TLSClientKey          string            `yaml:"tls_client_key,omitempty"`
+	TLSClientKeyPass      string            `yaml:"tls_client_key_pass,omitempty"`
+	TLSServerCert         string            `yaml:"tls_server_cert,omitempty"`
+	TLSCABundle           string            `yaml:"tls_ca_bundle,omitempty"`
+	TLSPinnedKeys         []string          `yaml:"tls_pinned_keys,omitempty"`
+	TLSMinVersion         string            `yaml:"tls_min_version,omitempty"`
+	TLSCipherSuites       []string          `yaml:"tls_cipher_suites,omitempty"`
+	ProxyURL              string            `yaml:"proxy_url,omitempty"`
+	ProxyUser             string            `yaml:"proxy_user,omitempty"`
+	ProxyPass             string            `yaml:"proxy_pass,omitempty"`
+	ProxyWinHTTP          bool              `yaml:"proxy_winhttp,omitempty"`
+	SignatureKey          string            `yaml:"signature_key,omitempty"`
+	RequireSignatures     bool              `yaml:"require_signatures,omitempty"`
+	MetadataKey           string            `yaml:"metadata_key,omitempty"`
+	MetricsFile           string            `yaml:"metrics_file,omitempty"`
+	ReportURL             string            `yaml:"report_url,omitempty"`
+	CleanOrphans          bool              `yaml:"clean_orphans,omitempty"`
+	RemoveDependencies    bool              `yaml:"remove_unused_dependencies,omitempty"`
+	CacheMaxMB            int               `yaml:"cache_max_mb,omitempty"`
+	RunMSIInPlace         bool              `yaml:"run_msi_in_place,omitempty"`
+	PeerCache             bool              `yaml:"peer_cache,omitempty"`
+	PeerPort              int               `yaml:"peer_port,omitempty"`
+	DownloadTimeout       int               `yaml:"download_timeout,omitempty"`
+	ConnectTimeout        int               `yaml:"connect_timeout,omitempty"`
+	ReadTimeout           int               `yaml:"read_timeout,omitempty"`
+	RunTimeout            int               `yaml:"run_timeout,omitempty"`
+	MaxParallelDownloads  int               `yaml:"max_parallel_downloads,omitempty"`
+	InstallerTimeout      int               `yaml:"installer_timeout,omitempty"`
+	MinIdleMinutes        int               `yaml:"min_idle_minutes,omitempty"`
+	DeferOnBattery        bool              `yaml:"defer_on_battery,omitempty"`
+	DeferOnMetered        bool              `yaml:"defer_on_metered,omitempty"`
+	BackoffMinutes        int               `yaml:"backoff_minutes,omitempty"`
+	MaxInstallFailures    int               `yaml:"max_install_failures,omitempty"`
+	RetryBackoffMinutes   int               `yaml:"retry_backoff_minutes,omitempty"`
+	NotifyCommand         []string          `yaml:"notify_command,omitempty"`
+	NotifyMessage         string            `yaml:"notify_message,omitempty"`
+	NotifyRebootMessage   string            `yaml:"notify_reboot_message,omitempty"`
+	NotifyDeadlineMessage string            `yaml:"notify_deadline_message,omitempty"`
+	NotifyForceMessage    string            `yaml:"notify_force_message,omitempty"`
+	NotifyToast           bool              `yaml:"notify_toast,omitempty"`
+	NotifyQuietHours      string            `yaml:"notify_quiet_hours,omitempty"`
+	ForceInstallWarning   int               `yaml:"force_install_warning,omitempty"`
+	ScheduleRestart       bool              `yaml:"schedule_restart,omitempty"`
+	RestartDelay          int               `yaml:"restart_delay,omitempty"`
+	RestartDeferrals      int               `yaml:"restart_deferrals,omitempty"`
+	ServiceInterval       int               `yaml:"service_interval,omitempty"`
+	ServiceJitter         int               `yaml:"service_jitter,omitempty"`
+	APIPort               int               `yaml:"api_port,omitempty"`
+	CachePath             string
+	GitPath               string
+	MetadataPath          string
+}
+
+// Run modes, which change how a run treats deferrals and failures
+const (
+	// ModeAuto is a scheduled run, which waits for a good time and respects quiet hours and retry backoff
+	ModeAuto = "auto"
+	// ModeManual is a run someone started and is watching, so it runs now and shows its progress
+	ModeManual = "manual"
+	// ModeBootstrap provisions a new computer, and keeps going until everything is installed
+	ModeBootstrap = "bootstrap"
+)
+
+// Where the groups for group_manifests come from
+const (
+	// GroupSourceAD is the computer's Active Directory groups, which is the default
+	GroupSourceAD = "ad"
+	// GroupSourceAzure is the device's Azure AD groups, looked up with Microsoft Graph
+	GroupSourceAzure = "azure"
+)
+
+// stringList is a flag that may be passed more than once, with each value optionally comma separated
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*s = append(*s, v)
+		}
+	}
+	return nil
+}
+
+// summaryFlag is where to write a JSON summary of the run
+// On its own it means stdout, or it may be given a path like `-json=C:\summary.json`
+type summaryFlag string
+
+func (s *summaryFlag) String() string {
+	return string(*s)
+}
+
+func (s *summaryFlag) Set(value string) error {
+	switch value {
+	case "true":
+		*s = "-"
+	case "false":
+		*s = ""
+	default:
+		*s = summaryFlag(value)
+	}
+	return nil
+}
+
+// IsBoolFlag lets the flag be passed without a value
+func (s *summaryFlag) IsBoolFlag() bool {
+	return true
 }
 
 func init() {
@@ -84,12 +273,40 @@ func init() {
 	// Config
 	flag.StringVar(&configArg, "config", configDefault, "")
 	flag.StringVar(&configArg, "c", configDefault, "")
+	// Profile
+	flag.StringVar(&profileArg, "profile", profileDefault, "")
 	// Debug
 	flag.BoolVar(&debugArg, "debug", debugDefault, "")
 	flag.BoolVar(&debugArg, "d", debugDefault, "")
 	// Checkonly
 	flag.BoolVar(&checkOnlyArg, "checkonly", checkOnlyDefault, "")
 	flag.BoolVar(&checkOnlyArg, "C", checkOnlyDefault, "")
+	// Status
+	flag.BoolVar(&statusArg, "status", statusDefault, "")
+	flag.BoolVar(&statusArg, "s", statusDefault, "")
+	// Force
+	flag.BoolVar(&forceArg, "force", forceDefault, "")
+	flag.BoolVar(&forceArg, "f", forceDefault, "")
+	// Category
+	flag.Var(&categoryArg, "category", "")
+	flag.Var(&categoryArg, "g", "")
+	// Bootstrap
+	flag.StringVar(&bootstrapArg, "bootstrap", bootstrapDefault, "")
+	flag.StringVar(&bootstrapArg, "b", bootstrapDefault, "")
+	// Mode
+	flag.StringVar(&modeArg, "mode", modeDefault, "")
+	flag.BoolVar(&autoArg, "auto", false, "")
+	flag.BoolVar(&manualArg, "manual", false, "")
+	// Clear cache
+	flag.BoolVar(&clearCacheArg, "clear-cache", clearCacheDefault, "")
+	// JSON summary
+	flag.Var(&jsonArg, "json", "")
+	// Progress
+	flag.StringVar(&progressArg, "progress", progressDefault, "")
+	// Set
+	flag.Var(&setArg, "set", "")
+	// Show config
+	flag.BoolVar(&showConfigArg, "show-config", showConfigDefault, "")
 	// Help
 	flag.BoolVar(&helpArg, "help", helpDefault, "")
 	flag.BoolVar(&helpArg, "h", helpDefault, "")
@@ -128,34 +345,48 @@ func Get() Configuration {
 	// Parse any arguments that may have been passed
 	configPath, verbose, debug, checkonly := parseArguments()
 
-	// Read the config file
-	configFile, err := ioutil.ReadFile(configPath)
+	// Read the config file, along with any files it includes
+	profiles := make(map[string]yaml.Node)
+	err := loadFile(configPath, &cfg, profiles, make(map[string]bool))
 	if err != nil {
-		fmt.Println("Unable to read configuration file: ", err)
-		os.Exit(1)
+		fmt.Println("Unable to load configuration: ", err)
+		os.Exit(report.ExitConfigError)
 	}
 
-	// Parse the config into a struct
-	err = yaml.Unmarshal(configFile, &cfg)
+	// A profile sets its settings on top of the config files
+	if profileArg != "" {
+		err = applyProfile(&cfg, profiles, profileArg)
+		if err != nil {
+			fmt.Println("Unable to use profile: ", err)
+			os.Exit(report.ExitConfigError)
+		}
+	}
+
+	// The environment and the command line can override anything in the file
+	err = applyOverrides(&cfg, setArg)
 	if err != nil {
-		fmt.Println("Unable to parse yaml configuration: ", err)
-		os.Exit(1)
+		fmt.Println("Invalid configuration override: ", err)
+		os.Exit(report.ExitConfigError)
 	}
 
-	// If Manifest wasnt provided, exit
-	if cfg.Manifest == "" {
-		fmt.Println("Invalid configuration - Manifest: ", err)
-		os.Exit(1)
+	// Secrets may be encrypted, so they are never on disk in plain text
+	err = revealSecrets(&cfg)
+	if err != nil {
+		fmt.Println("Unable to decrypt configuration: ", err)
+		os.Exit(report.ExitConfigError)
 	}
 
-	// If URL wasnt provided, exit
-	if cfg.URL == "" {
-		fmt.Println("Invalid configuration - URL: ", err)
-		os.Exit(1)
+	// If Manifest wasnt provided, exit
+	if cfg.Manifest == "" {
+		fmt.Println("Invalid configuration - Manifest can not be empty")
+		os.Exit(report.ExitConfigError)
 	}
 
-	// If URLPackages wasn't provided, use the repo URL
-	if cfg.URLPackages == "" {
+	// In bootstrap mode, everything comes from the media folder
+	// The repo layout is the same, so catalogs, manifests, and packages are all relative to it
+	if bootstrapArg != "" {
+		cfg.BootstrapPath = filepath.Clean(bootstrapArg)
+		cfg.URL = filepath.ToSlash(cfg.BootstrapPath) + "/"
 		cfg.URLPackages = cfg.URL
 	}
 
@@ -166,11 +397,41 @@ func Get() Configuration {
 		cfg.AppDataPath = filepath.Clean(cfg.AppDataPath)
 	}
 
+	// The mode is only ever set on the command line, or by the bootstrap flag
+	_, flagErr := os.Stat(BootstrapFlagPath(cfg.AppDataPath))
+	cfg.Mode, err = runMode(modeArg, autoArg, manualArg, cfg.BootstrapPath != "", flagErr == nil)
+	if err != nil {
+		fmt.Println("Invalid mode: ", err)
+		os.Exit(report.ExitConfigError)
+	}
+
+	// Catalogs and manifests from a git repo are read from a local copy, which is kept in sync before each run
+	if cfg.GitURL != "" && cfg.BootstrapPath == "" {
+		cfg.GitPath = filepath.Join(cfg.AppDataPath, "git")
+		cfg.URL = filepath.ToSlash(cfg.GitPath) + "/"
+	}
+
+	// If URL wasnt provided, exit
+	if cfg.URL == "" {
+		fmt.Println("Invalid configuration - URL: ", err)
+		os.Exit(report.ExitConfigError)
+	}
+
+	// If URLPackages wasn't provided, use the repo URL
+	if cfg.URLPackages == "" {
+		cfg.URLPackages = cfg.URL
+	}
+
 	// Set the verbosity
 	if verbose && !cfg.Verbose {
 		cfg.Verbose = true
 	}
 
+	// Asking for verbose output on the command line also logs at least INFO, even if the config file asks for less
+	if verbose && !strings.EqualFold(cfg.LogLevel, "debug") {
+		cfg.LogLevel = ""
+	}
+
 	// Set the debug and verbose
 	if debug && !cfg.Debug {
 		cfg.Debug = true
@@ -181,8 +442,51 @@ func Get() Configuration {
 		cfg.CheckOnly = true
 	}
 
+	// Status only mode never makes changes, so it implies check only mode
+	if statusArg {
+		cfg.StatusOnly = true
+		cfg.CheckOnly = true
+	}
+
+	// The summary is only ever requested on the command line
+	cfg.JSONSummary = string(jsonArg)
+
+	// Progress is only ever requested on the command line, the console is used if there is one
+	cfg.Progress = progressArg
+
+	// Force is only ever set on the command line
+	if forceArg {
+		cfg.Force = true
+	}
+
+	// Categories are only ever set on the command line
+	cfg.Categories = categoryArg
+
+	// Clearing the cache is only ever requested on the command line
+	if clearCacheArg {
+		cfg.ClearCache = true
+	}
+
 	// Set the cache path
+	// Packages on bootstrap media are already where the cache would put them
 	cfg.CachePath = filepath.Join(cfg.AppDataPath, "cache")
+	if cfg.BootstrapPath != "" {
+		cfg.CachePath = cfg.BootstrapPath
+	}
+
+	// Catalogs and manifests are kept separately, so cleaning the cache never removes them
+	cfg.MetadataPath = filepath.Join(cfg.AppDataPath, "metadata")
+
+	// Print what we ended up with, so a bad setting can be found without reading the code
+	if showConfigArg {
+		out, err := yaml.Marshal(redact(cfg))
+		if err != nil {
+			fmt.Println("Unable to show configuration: ", err)
+			osExit(report.ExitConfigError)
+		}
+		fmt.Print(string(out))
+		osExit(0)
+	}
 
 	// Add to GorillaReport
 	report.Items["Manifest"] = cfg.Manifest
@@ -190,3 +494,157 @@ func Get() Configuration {
 
 	return cfg
 }
+
+// BootstrapFlagPath returns the file that marks a computer as still being provisioned
+// While it is there every scheduled run is a bootstrap run, and it is removed once the computer has everything it needs
+func BootstrapFlagPath(appDataPath string) string {
+	return filepath.Join(appDataPath, "bootstrap.flag")
+}
+
+// runMode returns the mode selected on the command line
+// Installing from bootstrap media is provisioning, so it is a bootstrap run unless another mode was asked for,
+// and so is a scheduled run while the bootstrap flag is there
+func runMode(mode string, auto, manual, media, flagged bool) (string, error) {
+	mode = strings.ToLower(mode)
+	for _, flagMode := range []struct {
+		set  bool
+		mode string
+	}{{auto, ModeAuto}, {manual, ModeManual}} {
+		if !flagMode.set {
+			continue
+		}
+		if mode != "" && mode != flagMode.mode {
+			return "", fmt.Errorf("-%s conflicts with -mode %s", flagMode.mode, mode)
+		}
+		mode = flagMode.mode
+	}
+
+	switch mode {
+	case "":
+		if media || flagged {
+			return ModeBootstrap, nil
+		}
+		return ModeAuto, nil
+	case ModeAuto:
+		if flagged {
+			return ModeBootstrap, nil
+		}
+		return mode, nil
+	case ModeManual, ModeBootstrap:
+		return mode, nil
+	}
+	return "", fmt.Errorf("expected auto, manual, or bootstrap, not %q", mode)
+}
+
+// redactedValue replaces secrets when the configuration is shown
+const redactedValue = "REDACTED"
+
+// secrets returns every password, key, and token in the configuration, by setting name
+func secrets(cfg *Configuration) map[string]*string {
+	return map[string]*string{
+		"sas_token":           &cfg.SASToken,
+		"auth_pass":           &cfg.AuthPass,
+		"s3_access_key":       &cfg.S3AccessKey,
+		"s3_secret_key":       &cfg.S3SecretKey,
+		"tls_client_key_pass": &cfg.TLSClientKeyPass,
+		"proxy_pass":          &cfg.ProxyPass,
+		"bearer_token":        &cfg.BearerToken,
+		"oauth_client_secret": &cfg.OAuthClientSecret,
+		"graph_client_secret": &cfg.GraphClientSecret,
+	}
+}
+
+// secretMaps returns the settings where every value is a secret, like a token for each Azure container or a CDN access key header
+func secretMaps(cfg *Configuration) map[string]*map[string]string {
+	return map[string]*map[string]string{
+		"azure_sas_tokens": &cfg.AzureSASTokens,
+		"headers":          &cfg.Headers,
+	}
+}
+
+// urls returns the settings that hold a url, which can carry a password or a token like a SAS query string
+func urls(cfg *Configuration) []*string {
+	return []*string{&cfg.URL, &cfg.URLPackages, &cfg.GitURL, &cfg.SASTokenURL, &cfg.OAuthTokenURL, &cfg.ProxyURL, &cfg.ReportURL}
+}
+
+// IsSecret returns true if a setting holds a password, key, or token that may be encrypted
+func IsSecret(name string) bool {
+	_, ok := secrets(&Configuration{})[name]
+	_, isMap := secretMaps(&Configuration{})[name]
+	return ok || isMap
+}
+
+// revealSecrets decrypts every secret that was encrypted with `gorilla config set-secret`
+func revealSecrets(cfg *Configuration) error {
+	for name, value := range secrets(cfg) {
+		revealed, err := secret.Reveal(*value)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		*value = revealed
+	}
+	for name, values := range secretMaps(cfg) {
+		for key, value := range *values {
+			revealed, err := secret.Reveal(value)
+			if err != nil {
+				return fmt.Errorf("%s %s: %v", name, key, err)
+			}
+			(*values)[key] = revealed
+		}
+	}
+	return nil
+}
+
+// redact returns a copy of the configuration with every password, key, and token replaced
+// Empty values are left alone, so it is still clear which secrets were set
+func redact(cfg Configuration) Configuration {
+	for _, value := range secrets(&cfg) {
+		if *value != "" {
+			*value = redactedValue
+		}
+	}
+	for _, values := range secretMaps(&cfg) {
+		if *values == nil {
+			continue
+		}
+		redacted := make(map[string]string, len(*values))
+		for key := range *values {
+			redacted[key] = redactedValue
+		}
+		*values = redacted
+	}
+	for _, value := range urls(&cfg) {
+		*value = redactURL(*value)
+	}
+	// Copy the mirrors, so the original is untouched
+	for _, mirrors := range []*[]string{&cfg.Mirrors, &cfg.PackageMirrors} {
+		redacted := make([]string, 0, len(*mirrors))
+		for _, mirror := range *mirrors {
+			redacted = append(redacted, redactURL(mirror))
+		}
+		if *mirrors != nil {
+			*mirrors = redacted
+		}
+	}
+	return cfg
+}
+
+// redactURL replaces the user and password, and the value of every query parameter, in a url
+// Anything that isnt a url with those is returned as it is
+func redactURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.User == nil && parsed.RawQuery == "") {
+		return rawURL
+	}
+	if parsed.User != nil {
+		parsed.User = url.User(redactedValue)
+	}
+	if parsed.RawQuery != "" {
+		query := parsed.Query()
+		for key := range query {
+			query[key] = []string{redactedValue}
+		}
+		parsed.RawQuery = query.Encode()
+	}
+	return parsed.String()
+}
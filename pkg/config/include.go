@@ -0,0 +1,72 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fragment holds the parts of a config file that arent settings
+type fragment struct {
+	Include  []string             `yaml:"include"`
+	Profiles map[string]yaml.Node `yaml:"profiles"`
+}
+
+// loadFile reads a config file into the configuration, after the files it includes
+// Settings in a file override the same settings from its includes, and a profile replaces any earlier profile with the same name.
+// Included paths are relative to the file that includes them.
+func loadFile(path string, cfg *Configuration, profiles map[string]yaml.Node, including map[string]bool) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	// A file that is still being loaded further up would include itself forever
+	if including[absPath] {
+		return fmt.Errorf("%s includes itself", path)
+	}
+	including[absPath] = true
+	defer delete(including, absPath)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	// Check the file before using it, so a typo isnt silently ignored
+	if problems := validate(data); len(problems) > 0 {
+		return fmt.Errorf("%s\n   %s", path, strings.Join(problems, "\n   "))
+	}
+
+	var frag fragment
+	if err := yaml.Unmarshal(data, &frag); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	for _, include := range frag.Include {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(path), include)
+		}
+		if err := loadFile(include, cfg, profiles, including); err != nil {
+			return err
+		}
+	}
+
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	for name, profile := range frag.Profiles {
+		profiles[name] = profile
+	}
+	return nil
+}
+
+// applyProfile sets the settings from a profile, on top of the config files
+func applyProfile(cfg *Configuration, profiles map[string]yaml.Node, name string) error {
+	profile, ok := profiles[name]
+	if !ok {
+		return fmt.Errorf("profile %q is not defined", name)
+	}
+	return profile.Decode(cfg)
+}
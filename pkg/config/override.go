@@ -0,0 +1,118 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// envPrefix starts the name of every environment variable that overrides a setting, like GORILLA_URL
+const envPrefix = "GORILLA_"
+
+// settingList is a flag that sets a setting to a value, and may be passed more than once
+// Values are never split on commas, since a list setting is given as one comma separated value
+type settingList []string
+
+func (s *settingList) String() string {
+	return strings.Join(*s, " ")
+}
+
+func (s *settingList) Set(value string) error {
+	if !strings.Contains(value, "=") {
+		return fmt.Errorf("expected <setting>=<value>, got %q", value)
+	}
+	*s = append(*s, value)
+	return nil
+}
+
+// settingFields returns the field for each setting a config file may contain, by name
+func settingFields(cfg *Configuration) map[string]reflect.Value {
+	fields := make(map[string]reflect.Value)
+	v := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		name := strings.Split(v.Type().Field(i).Tag.Get("yaml"), ",")[0]
+		if name != "" && name != "-" {
+			fields[name] = v.Field(i)
+		}
+	}
+	return fields
+}
+
+// applyOverrides replaces settings from the config file with environment variables, and then with `-set` flags
+// That makes the command line win over the environment, and the environment win over the file
+func applyOverrides(cfg *Configuration, sets []string) error {
+	fields := settingFields(cfg)
+	for name, field := range fields {
+		value, ok := os.LookupEnv(envPrefix + strings.ToUpper(name))
+		if !ok {
+			continue
+		}
+		if err := setField(field, value); err != nil {
+			return fmt.Errorf("%s%s: %v", envPrefix, strings.ToUpper(name), err)
+		}
+	}
+
+	known := knownKeys()
+	for _, set := range sets {
+		parts := strings.SplitN(set, "=", 2)
+		field, ok := fields[parts[0]]
+		if !ok {
+			err := fmt.Errorf("unknown setting %q", parts[0])
+			if suggestion := closestKey(parts[0], known); suggestion != "" {
+				err = fmt.Errorf("%v, did you mean %q?", err, suggestion)
+			}
+			return err
+		}
+		if err := setField(field, parts[1]); err != nil {
+			return fmt.Errorf("%s: %v", parts[0], err)
+		}
+	}
+	return nil
+}
+
+// setField sets a setting from its text
+// Lists are comma separated, and maps are comma separated key=value pairs
+func setField(field reflect.Value, text string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(text)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return fmt.Errorf("expected true or false, got %q", text)
+		}
+		field.SetBool(b)
+	case reflect.Int:
+		i, err := strconv.Atoi(text)
+		if err != nil {
+			return fmt.Errorf("expected a number, got %q", text)
+		}
+		field.SetInt(int64(i))
+	case reflect.Slice:
+		var list []string
+		for _, item := range strings.Split(text, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+	case reflect.Map:
+		m := make(map[string]string)
+		for _, pair := range strings.Split(text, ",") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			parts := strings.SplitN(pair, "=", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("expected key=value pairs, got %q", pair)
+			}
+			m[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+		field.Set(reflect.ValueOf(m))
+	default:
+		return fmt.Errorf("can not be overridden")
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// urlKeys are the settings that hold a url, and whether a plain or UNC path is also allowed
+var urlKeys = map[string]bool{
+	"url":             true,
+	"url_packages":    true,
+	"mirrors":         true,
+	"package_mirrors": true,
+	"sas_token_url":   false,
+	"report_url":      false,
+	"proxy_url":       false,
+	"oauth_token_url": false,
+}
+
+// knownKeys returns the name of every setting a config file may contain
+func knownKeys() map[string]bool {
+	keys := make(map[string]bool)
+	t := reflect.TypeOf(Configuration{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if name != "" && name != "-" {
+			keys[name] = true
+		}
+	}
+	return keys
+}
+
+// validate checks a config file for settings we dont know, and urls that cant be used
+// Each problem includes its line, so a typo doesnt quietly become a zero value
+func validate(data []byte) (problems []string) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return []string{err.Error()}
+	}
+
+	// An empty file is fine, since every setting may come from the environment or the command line
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return []string{fmt.Sprintf("line %d: expected a mapping of settings", root.Line)}
+	}
+
+	// Files can also include other files and hold profiles, but profiles only hold settings
+	known := knownKeys()
+	fileKnown := knownKeys()
+	fileKnown["include"], fileKnown["profiles"] = true, true
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		switch key.Value {
+		case "include":
+			if value.Kind != yaml.SequenceNode {
+				problems = append(problems, fmt.Sprintf("line %d: include must be a list of files", value.Line))
+			}
+		case "profiles":
+			if value.Kind != yaml.MappingNode {
+				problems = append(problems, fmt.Sprintf("line %d: profiles must be a mapping of names to settings", value.Line))
+				continue
+			}
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				profile := value.Content[j+1]
+				if profile.Kind != yaml.MappingNode {
+					problems = append(problems, fmt.Sprintf("line %d: profile %q must be a mapping of settings", profile.Line, value.Content[j].Value))
+					continue
+				}
+				for k := 0; k+1 < len(profile.Content); k += 2 {
+					problems = append(problems, checkSetting(profile.Content[k], profile.Content[k+1], known)...)
+				}
+			}
+		default:
+			problems = append(problems, checkSetting(key, value, fileKnown)...)
+		}
+	}
+	return problems
+}
+
+// checkSetting returns a problem if we dont know a setting, or for each url it holds that cant be used
+func checkSetting(key *yaml.Node, value *yaml.Node, known map[string]bool) []string {
+	if !known[key.Value] {
+		problem := fmt.Sprintf("line %d: unknown setting %q", key.Line, key.Value)
+		if suggestion := closestKey(key.Value, known); suggestion != "" {
+			problem += fmt.Sprintf(", did you mean %q?", suggestion)
+		}
+		return []string{problem}
+	}
+	if allowPath, ok := urlKeys[key.Value]; ok {
+		return checkURLs(key.Value, value, allowPath)
+	}
+	return nil
+}
+
+// checkURLs returns a problem for each url in a setting that cant be used
+// A setting may hold one url or a list of them
+func checkURLs(key string, value *yaml.Node, allowPath bool) (problems []string) {
+	values := []*yaml.Node{value}
+	if value.Kind == yaml.SequenceNode {
+		values = value.Content
+	}
+	for _, v := range values {
+		if v.Kind != yaml.ScalarNode || v.Value == "" {
+			continue
+		}
+		if !strings.Contains(v.Value, "://") {
+			if !allowPath {
+				problems = append(problems, fmt.Sprintf("line %d: %s must be a url like https://example.com, not %q", v.Line, key, v.Value))
+			}
+			continue
+		}
+		u, err := url.Parse(v.Value)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("line %d: %s is not a valid url: %v", v.Line, key, err))
+			continue
+		}
+		if u.Host == "" && !strings.EqualFold(u.Scheme, "file") {
+			problems = append(problems, fmt.Sprintf("line %d: %s is missing a host: %q", v.Line, key, v.Value))
+		}
+	}
+	return problems
+}
+
+// closestKey returns the known setting that is only a small typo away from a key, or an empty string if there isnt one
+func closestKey(key string, known map[string]bool) string {
+	best, bestDistance := "", 3
+	for candidate := range known {
+		if d := editDistance(strings.ToLower(key), candidate); d < bestDistance || (d == bestDistance && best != "" && candidate < best) {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// editDistance returns how many single character insertions, deletions, or substitutions turn a into b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// minInt returns the smallest value
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
@@ -0,0 +1,154 @@
+package download
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/1dustindavis/gorilla/pkg/gorillalog"
+)
+
+// authenticator adds credentials to each request for a web repo
+// An authenticator may also implement refresher, tlsAuthenticator, or transportAuthenticator,
+// so a new kind of authentication only needs a type here and a line in authenticators.
+type authenticator interface {
+	authenticate(req *http.Request) error
+}
+
+// refresher replaces credentials the repo denied
+// Returns true if the request should be sent again with the new credentials
+type refresher interface {
+	refresh(client *http.Client, url string, resp *http.Response) (bool, error)
+}
+
+// tlsAuthenticator authenticates during the tls handshake, instead of in each request
+type tlsAuthenticator interface {
+	configureTLS(tlsConfig *tls.Config) error
+}
+
+// transportAuthenticator answers the repo's challenges, which may take more than one round trip
+type transportAuthenticator interface {
+	wrap(base http.RoundTripper) http.RoundTripper
+}
+
+// authenticators returns every configured kind of authentication, in the order they are applied
+// Static headers go first, so they cant replace the credentials that follow
+func authenticators() []authenticator {
+	var auths []authenticator
+	if len(downloadCfg.Headers) > 0 {
+		auths = append(auths, headerAuth{})
+	}
+	if downloadCfg.TLSAuth {
+		auths = append(auths, clientCertAuth{})
+	}
+	if downloadCfg.AuthNegotiate {
+		auths = append(auths, negotiateAuth{})
+	}
+	if downloadCfg.AuthUser != "" && downloadCfg.AuthPass != "" {
+		auths = append(auths, basicAuth{})
+	}
+	if downloadCfg.BearerToken != "" || oauthRefreshable() {
+		auths = append(auths, bearerAuth{})
+	}
+	if currentSASToken() != "" || sasRefreshable() {
+		auths = append(auths, sasAuth{})
+	}
+	return auths
+}
+
+// reauthenticate gives each authenticator a chance to replace credentials the repo denied
+// Returns true if the request should be sent again
+func reauthenticate(client *http.Client, url string, resp *http.Response) (bool, error) {
+	for _, auth := range authenticators() {
+		if r, ok := auth.(refresher); ok {
+			if retry, err := r.refresh(client, url, resp); retry || err != nil {
+				return retry, err
+			}
+		}
+	}
+	return false, nil
+}
+
+// headerAuth adds the configured headers, like the access key a CDN expects
+type headerAuth struct{}
+
+func (headerAuth) authenticate(req *http.Request) error {
+	for name, value := range downloadCfg.Headers {
+		req.Header.Set(name, value)
+	}
+	return nil
+}
+
+// basicAuth adds the configured user and password
+type basicAuth struct{}
+
+func (basicAuth) authenticate(req *http.Request) error {
+	req.SetBasicAuth(downloadCfg.AuthUser, downloadCfg.AuthPass)
+	return nil
+}
+
+// sasAuth appends the SAS token to each request, and replaces it once it expires
+type sasAuth struct{}
+
+func (sasAuth) authenticate(req *http.Request) error {
+	if token := currentSASToken(); token != "" {
+		if req.URL.RawQuery == "" {
+			req.URL.RawQuery = token
+		} else {
+			req.URL.RawQuery += "&" + token
+		}
+	}
+	return nil
+}
+
+// refresh retries once with a fresh token when the request was denied, if we know where to get one
+// A token that was already replaced by another download only needs the retry
+func (sasAuth) refresh(client *http.Client, url string, resp *http.Response) (bool, error) {
+	if resp.StatusCode != http.StatusForbidden || !sasRefreshable() {
+		return false, nil
+	}
+	gorillalog.Info("Request denied, refreshing SAS token:", url)
+	token := currentSASToken()
+	if resp.Request != nil && !strings.Contains(resp.Request.URL.RawQuery, token) {
+		return true, nil
+	}
+	if err := refreshSASToken(client, token); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// clientCertAuth presents a client certificate, and only trusts the configured server certificate
+type clientCertAuth struct{}
+
+func (clientCertAuth) authenticate(req *http.Request) error {
+	return nil
+}
+
+func (clientCertAuth) configureTLS(tlsConfig *tls.Config) error {
+	// Load	the client certificate and private key
+	clientCert, err := loadClientCert(downloadCfg.TLSClientCert, downloadCfg.TLSClientKey, downloadCfg.TLSClientKeyPass)
+	if err != nil {
+		return err
+	}
+
+	// Load server certificates
+	serverCert, err := ioutil.ReadFile(downloadCfg.TLSServerCert)
+	if err != nil {
+		return err
+	}
+	caCertPool := tlsConfig.RootCAs
+	if caCertPool == nil {
+		caCertPool = x509.NewCertPool()
+	}
+	caCertPool.AppendCertsFromPEM(serverCert)
+
+	// Add our certificates to the tls configuration
+	tlsConfig.Certificates = []tls.Certificate{clientCert}
+	tlsConfig.RootCAs = caCertPool
+	// Insecure, but might need to be an option for odd configurations in the future
+	// tlsConfig.Renegotiation = tls.RenegotiateFreelyAsClient
+	return nil
+}
@@ -0,0 +1,61 @@
+package download
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/1dustindavis/gorilla/pkg/config"
+)
+
+// TestAuthenticators verifies only the configured kinds of authentication are used, with static headers first
+func TestAuthenticators(t *testing.T) {
+	defer SetConfig(config.Configuration{})
+
+	SetConfig(config.Configuration{})
+	if have := authenticators(); len(have) != 0 {
+		t.Errorf("have %v, want no authenticators", have)
+	}
+
+	SetConfig(config.Configuration{
+		Headers:      map[string]string{"X-Access-Key": "key"},
+		AuthUser:     "user",
+		AuthPass:     "pass",
+		BearerToken:  "token",
+		SASTokenFile: "sas.txt",
+	})
+	var names []string
+	for _, auth := range authenticators() {
+		names = append(names, fmt.Sprintf("%T", auth))
+	}
+	if have, want := fmt.Sprint(names), "[download.headerAuth download.basicAuth download.bearerAuth download.sasAuth]"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+}
+
+// TestHeaders verifies configured headers are sent to the repo, but cant replace our credentials
+func TestHeaders(t *testing.T) {
+	defer SetConfig(config.Configuration{})
+
+	var header http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	SetConfig(config.Configuration{
+		Headers:     map[string]string{"X-Access-Key": "key", "Authorization": "Basic bm90OnVz"},
+		BearerToken: "token",
+	})
+	if _, err := Get(ts.URL + "/catalogs/production.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := header.Get("X-Access-Key"), "key"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+	if have, want := header.Get("Authorization"), "Bearer token"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+}
@@ -0,0 +1,133 @@
+package download
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/1dustindavis/gorilla/pkg/gorillalog"
+)
+
+// azureVersion is the Blob storage api version we send, which must be at least 2017-11-09 for managed identities
+const azureVersion = "2020-04-08"
+
+var (
+	// The instance metadata service provides tokens for an Azure VM's managed identity
+	// This is a variable so we can override it when testing
+	azureMetadataURL = "http://169.254.169.254"
+
+	// The managed identity token is cached until it is about to expire
+	// Downloads may run in parallel, so it is only read or replaced while holding azureMutex
+	azureToken        string
+	azureTokenExpires time.Time
+	azureMutex        sync.Mutex
+)
+
+// isAzureBlob returns true if a url is served by Azure Blob storage
+// Custom domains and the storage emulator are only recognized when azure_storage is set
+func isAzureBlob(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	return downloadCfg.AzureStorage || strings.HasSuffix(strings.ToLower(u.Hostname()), ".blob.core.windows.net")
+}
+
+// azureContainer returns the container a blob url is in, which is the first part of its path
+func azureContainer(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.SplitN(strings.TrimLeft(u.Path, "/"), "/", 2)[0]
+}
+
+// azureBackend serves a repo from Azure Blob storage
+// Each container may have its own SAS token, falling back to the shared SAS token, and then the VM's managed identity
+type azureBackend struct{}
+
+func (azureBackend) newRequest(method string, rawURL string, body io.Reader) (*http.Request, error) {
+	token := strings.TrimPrefix(downloadCfg.AzureSASTokens[azureContainer(rawURL)], "?")
+	if token == "" {
+		token = currentSASToken()
+	}
+
+	req, err := http.NewRequestWithContext(runContext, method, appendQuery(rawURL, token), body)
+	if err != nil {
+		gorillalog.Warn("Unable to request url:", rawURL, err)
+		return nil, err
+	}
+	req.Header.Set("x-ms-version", azureVersion)
+
+	if token == "" && downloadCfg.AzureManagedIdentity {
+		accessToken, err := azureAccessToken()
+		if err != nil {
+			return nil, fmt.Errorf("unable to get a token for the managed identity: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	return req, nil
+}
+
+// azureAccessToken returns a storage token for the VM's managed identity, requesting a new one when it is about to expire
+// https://docs.microsoft.com/en-us/azure/active-directory/managed-identities-azure-resources/how-to-use-vm-token
+func azureAccessToken() (string, error) {
+	azureMutex.Lock()
+	defer azureMutex.Unlock()
+	if azureToken != "" && time.Now().Add(5*time.Minute).Before(azureTokenExpires) {
+		return azureToken, nil
+	}
+
+	query := url.Values{}
+	query.Set("api-version", "2018-02-01")
+	query.Set("resource", "https://storage.azure.com/")
+	if downloadCfg.AzureClientID != "" {
+		query.Set("client_id", downloadCfg.AzureClientID)
+	}
+	req, err := http.NewRequestWithContext(runContext, "GET", azureMetadataURL+"/metadata/identity/oauth2/token?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata", "true")
+
+	// The metadata service is always local, so it never goes through a proxy
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata status code: %d", resp.StatusCode)
+	}
+	tokenJSON, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresOn   string `json:"expires_on"`
+	}
+	err = json.Unmarshal(tokenJSON, &token)
+	if err != nil {
+		return "", err
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("no access token in the response")
+	}
+	expires, err := strconv.ParseInt(token.ExpiresOn, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid token expiration: %v", err)
+	}
+
+	azureToken, azureTokenExpires = token.AccessToken, time.Unix(expires, 0)
+	return azureToken, nil
+}
@@ -0,0 +1,93 @@
+package download
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/1dustindavis/gorilla/pkg/config"
+)
+
+// TestAppendQuery verifies a SAS token is added to a url that already has a query
+func TestAppendQuery(t *testing.T) {
+	tests := map[string]string{
+		appendQuery("https://example.com/packages/setup.msi", "sig=abc"):           "https://example.com/packages/setup.msi?sig=abc",
+		appendQuery("https://example.com/packages/setup.msi?version=2", "sig=abc"): "https://example.com/packages/setup.msi?version=2&sig=abc",
+		appendQuery("https://example.com/packages/setup.msi", ""):                  "https://example.com/packages/setup.msi",
+	}
+	for have, want := range tests {
+		if have != want {
+			t.Errorf("have %s, want %s", have, want)
+		}
+	}
+}
+
+// TestAzureBackend verifies each container uses its own SAS token, and the managed identity is used without one
+func TestAzureBackend(t *testing.T) {
+	origMetadataURL := azureMetadataURL
+	defer func() {
+		azureMetadataURL, azureToken = origMetadataURL, ""
+		SetConfig(config.Configuration{})
+	}()
+
+	// A fake instance metadata service
+	metadata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata") != "true" || r.URL.Query().Get("resource") != "https://storage.azure.com/" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, `{"access_token": "identity-token", "expires_on": "%d"}`, time.Now().Add(time.Hour).Unix())
+	}))
+	defer metadata.Close()
+	azureMetadataURL = metadata.URL
+
+	// A fake storage account
+	var query, auth, version string
+	account := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, auth, version = r.URL.RawQuery, r.Header.Get("Authorization"), r.Header.Get("x-ms-version")
+		w.Write([]byte("catalog"))
+	}))
+	defer account.Close()
+
+	SetConfig(config.Configuration{
+		AzureStorage:         true,
+		AzureSASTokens:       map[string]string{"gorilla": "?sv=2020-08-04&sig=gorilla"},
+		AzureManagedIdentity: true,
+	})
+
+	// A container with its own token
+	if _, err := Get(account.URL + "/gorilla/catalogs/production.yaml?snapshot=1"); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := query, "snapshot=1&sv=2020-08-04&sig=gorilla"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+	if have, want := auth, ""; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+	if have, want := version, azureVersion; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+
+	// A container without a token uses the managed identity
+	if _, err := Get(account.URL + "/packages/setup.msi"); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := query, ""; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+	if have, want := auth, "Bearer identity-token"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+
+	// Blob storage is recognized by its host name
+	SetConfig(config.Configuration{})
+	if !isAzureBlob("https://example.blob.core.windows.net/gorilla/catalogs/production.yaml") {
+		t.Error("Expected a blob storage url to use the azure backend")
+	}
+	if isAzureBlob(account.URL + "/gorilla/catalogs/production.yaml") {
+		t.Error("Expected another url to use the web backend")
+	}
+}
@@ -0,0 +1,61 @@
+package download
+
+import (
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/1dustindavis/gorilla/pkg/gorillalog"
+)
+
+// backend is how requests reach a kind of repo
+// Each backend turns a url into a request the repo will accept, including any authentication it needs
+type backend interface {
+	newRequest(method string, url string, body io.Reader) (*http.Request, error)
+}
+
+// backendFor returns the backend that serves a url, based on its scheme
+func backendFor(url string) backend {
+	if strings.HasPrefix(url, "s3://") {
+		return s3Backend{}
+	}
+	if strings.HasPrefix(url, "peer://") {
+		return peerBackend{}
+	}
+	if isAzureBlob(url) {
+		return azureBackend{}
+	}
+	return webBackend{}
+}
+
+// appendQuery adds a query string to a url, keeping any query the url already has
+func appendQuery(url string, query string) string {
+	if query == "" {
+		return url
+	}
+	if strings.Contains(url, "?") {
+		return url + "&" + query
+	}
+	return url + "?" + query
+}
+
+// webBackend serves a repo from any web server, using whichever authenticators are configured
+type webBackend struct{}
+
+func (webBackend) newRequest(method string, url string, body io.Reader) (*http.Request, error) {
+
+	// Build the request
+	req, err := http.NewRequestWithContext(runContext, method, url, body)
+	if err != nil {
+		gorillalog.Warn("Unable to request url:", url, err)
+		return nil, err
+	}
+
+	// Add our credentials
+	for _, auth := range authenticators() {
+		if err := auth.authenticate(req); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
@@ -0,0 +1,218 @@
+//go:build windows
+// +build windows
+
+package download
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	certStoreProvSystem         = 10
+	certSystemStoreLocalMachine = 0x20000
+	certStoreReadOnly           = 0x8000
+	certFindSHA1Hash            = 0x10000
+	certFindSubjectStr          = 0x80007
+	cryptAcquireSilent          = 0x40
+	cryptAcquireOnlyNCrypt      = 0x40000
+	ncryptSilent                = 0x40
+	bcryptPadPKCS1              = 0x2
+	bcryptPadPSS                = 0x8
+)
+
+var (
+	crypt32                               = windows.NewLazySystemDLL("crypt32.dll")
+	ncrypt                                = windows.NewLazySystemDLL("ncrypt.dll")
+	procCryptAcquireCertificatePrivateKey = crypt32.NewProc("CryptAcquireCertificatePrivateKey")
+	procNCryptSignHash                    = ncrypt.NewProc("NCryptSignHash")
+)
+
+// storeCerts keeps the certs we already found, since a new client is built for every request
+// and each key handle stays open for as long as gorilla runs
+var (
+	storeCerts   = make(map[string]tls.Certificate)
+	storeCertsMu sync.Mutex
+)
+
+// storeClientCert finds a client cert in the machine's personal store, by thumbprint or part of its subject
+// The private key stays in windows, and is only asked to sign the tls handshake
+func storeClientCert(spec string) (tls.Certificate, error) {
+	storeCertsMu.Lock()
+	defer storeCertsMu.Unlock()
+	if cert, ok := storeCerts[spec]; ok {
+		return cert, nil
+	}
+
+	storeName, err := windows.UTF16PtrFromString("MY")
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	store, err := windows.CertOpenStore(certStoreProvSystem, 0, 0, certSystemStoreLocalMachine|certStoreReadOnly, uintptr(unsafe.Pointer(storeName)))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("unable to open the machine certificate store: %v", err)
+	}
+	defer windows.CertCloseStore(store, 0)
+
+	// Search by thumbprint if we were given one, otherwise by subject
+	thumbprint, subject := storeCertSearch(spec)
+	var findType uint32
+	var findPara unsafe.Pointer
+	if thumbprint != nil {
+		findType, findPara = certFindSHA1Hash, unsafe.Pointer(&windows.DataBlob{Size: uint32(len(thumbprint)), Data: &thumbprint[0]})
+	} else {
+		subjectPtr, err := windows.UTF16PtrFromString(subject)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		findType, findPara = certFindSubjectStr, unsafe.Pointer(subjectPtr)
+	}
+
+	// Each search frees the previous match, so keep our own copy of every cert we might use
+	var contexts []*windows.CertContext
+	var certs []*x509.Certificate
+	defer func() {
+		for _, context := range contexts {
+			windows.CertFreeCertificateContext(context)
+		}
+	}()
+	var prev *windows.CertContext
+	for {
+		context, err := windows.CertFindCertificateInStore(store, windows.X509_ASN_ENCODING|windows.PKCS_7_ASN_ENCODING, 0, findType, findPara, prev)
+		if err != nil {
+			break
+		}
+		prev = context
+		der := append([]byte(nil), (*[1 << 30]byte)(unsafe.Pointer(context.EncodedCert))[:context.Length:context.Length]...)
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			continue
+		}
+		contexts = append(contexts, windows.CertDuplicateCertificateContext(context))
+		certs = append(certs, cert)
+	}
+
+	best := newestValidCert(certs, time.Now())
+	if best == -1 {
+		return tls.Certificate{}, fmt.Errorf("no valid certificate matching %s was found in the machine certificate store", spec)
+	}
+	key, err := newStoreKey(contexts[best], certs[best].PublicKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	cert := tls.Certificate{Certificate: [][]byte{certs[best].Raw}, PrivateKey: key, Leaf: certs[best]}
+	storeCerts[spec] = cert
+	return cert, nil
+}
+
+// storeKey signs with a private key that windows keeps, so it works even when the key cant be exported
+type storeKey struct {
+	handle uintptr
+	public crypto.PublicKey
+}
+
+// newStoreKey opens the CNG private key that belongs to a cert
+func newStoreKey(context *windows.CertContext, public crypto.PublicKey) (*storeKey, error) {
+	switch public.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+	default:
+		return nil, fmt.Errorf("unsupported client certificate key type: %T", public)
+	}
+	var handle uintptr
+	var keySpec uint32
+	var mustFree int32
+	r, _, err := procCryptAcquireCertificatePrivateKey.Call(
+		uintptr(unsafe.Pointer(context)),
+		cryptAcquireSilent|cryptAcquireOnlyNCrypt,
+		0,
+		uintptr(unsafe.Pointer(&handle)),
+		uintptr(unsafe.Pointer(&keySpec)),
+		uintptr(unsafe.Pointer(&mustFree)),
+	)
+	if r == 0 {
+		return nil, fmt.Errorf("unable to open the client certificate's private key: %v", err)
+	}
+	return &storeKey{handle: handle, public: public}, nil
+}
+
+// Public returns the public key from the cert
+func (k *storeKey) Public() crypto.PublicKey {
+	return k.public
+}
+
+// pkcs1PaddingInfo is a BCRYPT_PKCS1_PADDING_INFO
+type pkcs1PaddingInfo struct {
+	algID *uint16
+}
+
+// pssPaddingInfo is a BCRYPT_PSS_PADDING_INFO
+type pssPaddingInfo struct {
+	algID *uint16
+	salt  uint32
+}
+
+// hashAlgorithms maps go's hashes to their CNG names
+var hashAlgorithms = map[crypto.Hash]string{
+	crypto.SHA1:   "SHA1",
+	crypto.SHA256: "SHA256",
+	crypto.SHA384: "SHA384",
+	crypto.SHA512: "SHA512",
+}
+
+// Sign asks windows to sign a digest with the private key
+// RSA keys need to be told which hash and padding to use, and ECDSA signatures are converted to ASN.1
+func (k *storeKey) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var padding unsafe.Pointer
+	flags := uint32(ncryptSilent)
+	if _, ok := k.public.(*rsa.PublicKey); ok {
+		// TLS 1.0 and 1.1 sign an MD5 and SHA1 digest without naming a hash
+		var algID *uint16
+		if opts.HashFunc() != crypto.MD5SHA1 {
+			name, ok := hashAlgorithms[opts.HashFunc()]
+			if !ok {
+				return nil, fmt.Errorf("unsupported hash for the client certificate: %v", opts.HashFunc())
+			}
+			algID, _ = windows.UTF16PtrFromString(name)
+		}
+		if pss, ok := opts.(*rsa.PSSOptions); ok {
+			salt := pss.SaltLength
+			if salt <= 0 {
+				salt = opts.HashFunc().Size()
+			}
+			padding = unsafe.Pointer(&pssPaddingInfo{algID: algID, salt: uint32(salt)})
+			flags |= bcryptPadPSS
+		} else {
+			padding = unsafe.Pointer(&pkcs1PaddingInfo{algID: algID})
+			flags |= bcryptPadPKCS1
+		}
+	}
+
+	// Ask how large the signature will be, then sign
+	var size uint32
+	r, _, _ := procNCryptSignHash.Call(k.handle, uintptr(padding), uintptr(unsafe.Pointer(&digest[0])), uintptr(len(digest)), 0, 0, uintptr(unsafe.Pointer(&size)), uintptr(flags))
+	if r != 0 {
+		return nil, fmt.Errorf("unable to sign with the client certificate: %v", syscall.Errno(r))
+	}
+	sig := make([]byte, size)
+	r, _, _ = procNCryptSignHash.Call(k.handle, uintptr(padding), uintptr(unsafe.Pointer(&digest[0])), uintptr(len(digest)), uintptr(unsafe.Pointer(&sig[0])), uintptr(size), uintptr(unsafe.Pointer(&size)), uintptr(flags))
+	if r != 0 {
+		return nil, fmt.Errorf("unable to sign with the client certificate: %v", syscall.Errno(r))
+	}
+	sig = sig[:size]
+
+	if _, ok := k.public.(*ecdsa.PublicKey); ok {
+		return ecdsaSignature(sig)
+	}
+	return sig, nil
+}
@@ -0,0 +1,15 @@
+// Without a darwin specific build, go tools will try to include Windows libraries and fail
+
+//go:build !windows
+// +build !windows
+
+package download
+
+import (
+	"crypto/tls"
+	"errors"
+)
+
+func storeClientCert(spec string) (tls.Certificate, error) {
+	return tls.Certificate{}, errors.New("the certificate store is only available on Windows")
+}
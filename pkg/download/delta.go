@@ -0,0 +1,180 @@
+package download
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/1dustindavis/gorilla/pkg/gorillalog"
+)
+
+var errInvalidPatch = errors.New("invalid bsdiff patch")
+
+// Patch rebuilds an installer from a previous version in the cache and a bsdiff patch, instead of downloading all of it
+// The previous version and the patch are checked against their hashes before the patch is applied,
+// and the new installer must match its hash before it replaces anything in the cache.
+// Returns false if any of that fails, so the caller can download the full installer instead.
+func Patch(absFile string, baseFile string, baseHash string, patchURL string, patchHash string, hashType string, hash string, timeout time.Duration) bool {
+	if !Verify(baseFile, baseHash) {
+		gorillalog.Debug("Previous version does not match the delta:", baseFile)
+		return false
+	}
+
+	// Download the patch beside the installer, and only keep it until it is applied
+	absPath, _ := filepath.Split(absFile)
+	gorillalog.Info("Downloading delta", patchURL, "to", absPath)
+	h, err := packageHash("", patchHash)
+	if err != nil {
+		gorillalog.Warn("Unable to verify delta:", patchURL, err)
+		return false
+	}
+	patchPath, sum, err := fetch(absPath, patchURL, timeout, h)
+	if err != nil {
+		gorillalog.Warn("Unable to retrieve delta:", patchURL, err)
+		return false
+	}
+	defer os.Remove(patchPath)
+	if patchHash != "" && sum != strings.ToLower(patchHash) {
+		gorillalog.Warn("Downloaded delta hash does not match the catalog:", patchURL, "expected", strings.ToLower(patchHash), "but got", sum)
+		return false
+	}
+
+	tempPath, sum, err := applyPatch(absFile, baseFile, patchPath, hashType, hash)
+	if err != nil {
+		gorillalog.Warn("Unable to apply delta:", patchURL, err)
+		return false
+	}
+	if sum != strings.ToLower(hash) {
+		gorillalog.Warn("Patched file hash does not match the catalog:", absFile, "expected", strings.ToLower(hash), "but got", sum)
+		os.Remove(tempPath)
+		return false
+	}
+	if err := replace(tempPath, absFile); err != nil {
+		gorillalog.Warn("Unable to save package:", absFile, err)
+		return false
+	}
+	return true
+}
+
+// applyPatch writes the patched file beside the installer, and returns its path along with its hash
+func applyPatch(absFile string, baseFile string, patchPath string, hashType string, hash string) (string, string, error) {
+	h, err := packageHash(hashType, hash)
+	if err != nil {
+		return "", "", err
+	}
+	base, err := os.Open(baseFile)
+	if err != nil {
+		return "", "", err
+	}
+	defer base.Close()
+	patch, err := ioutil.ReadFile(patchPath)
+	if err != nil {
+		return "", "", err
+	}
+
+	tempFile, err := ioutil.TempFile(filepath.Dir(absFile), filepath.Base(absFile)+".*.partial")
+	if err != nil {
+		return "", "", err
+	}
+	err = bspatch(base, patch, io.MultiWriter(tempFile, h))
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return "", "", err
+	}
+	return tempFile.Name(), hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// bspatch applies a patch made by bsdiff to the old file, writing the new file as it is rebuilt
+// The patch has a header followed by three bzip2 streams: the control tuples, the bytes added to the old file, and the extra bytes
+// http://www.daemonology.net/bsdiff/
+func bspatch(old io.ReaderAt, patch []byte, w io.Writer) error {
+	if len(patch) < 32 || string(patch[:8]) != "BSDIFF40" {
+		return errInvalidPatch
+	}
+	ctrlLen, diffLen, newSize := offtin(patch[8:]), offtin(patch[16:]), offtin(patch[24:])
+	if ctrlLen < 0 || diffLen < 0 || newSize < 0 || ctrlLen+diffLen > int64(len(patch))-32 {
+		return errInvalidPatch
+	}
+	ctrl := bzip2.NewReader(bytes.NewReader(patch[32 : 32+ctrlLen]))
+	diff := bzip2.NewReader(bytes.NewReader(patch[32+ctrlLen : 32+ctrlLen+diffLen]))
+	extra := bzip2.NewReader(bytes.NewReader(patch[32+ctrlLen+diffLen:]))
+
+	var oldPos, newPos int64
+	buf := make([]byte, 32*1024)
+	oldBuf := make([]byte, len(buf))
+	tuple := make([]byte, 24)
+	for newPos < newSize {
+		if _, err := io.ReadFull(ctrl, tuple); err != nil {
+			return errInvalidPatch
+		}
+		add, copyLen, seek := offtin(tuple), offtin(tuple[8:]), offtin(tuple[16:])
+		if add < 0 || copyLen < 0 || newPos+add+copyLen > newSize {
+			return errInvalidPatch
+		}
+
+		// Add the diff bytes to the old file, treating anything outside it as zeros
+		for add > 0 {
+			n := int64(len(buf))
+			if add < n {
+				n = add
+			}
+			if _, err := io.ReadFull(diff, buf[:n]); err != nil {
+				return errInvalidPatch
+			}
+			for i := range oldBuf[:n] {
+				oldBuf[i] = 0
+			}
+			if oldPos+n > 0 {
+				start := oldPos
+				if start < 0 {
+					start = 0
+				}
+				if _, err := old.ReadAt(oldBuf[start-oldPos:n], start); err != nil && err != io.EOF {
+					return err
+				}
+			}
+			for i := range buf[:n] {
+				buf[i] += oldBuf[i]
+			}
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+			add -= n
+			oldPos += n
+			newPos += n
+		}
+
+		// Copy the extra bytes as they are
+		if _, err := io.CopyN(w, extra, copyLen); err != nil {
+			if err == io.EOF {
+				return errInvalidPatch
+			}
+			return err
+		}
+		newPos += copyLen
+		oldPos += seek
+	}
+	return nil
+}
+
+// offtin reads an 8 byte sign and magnitude little endian number, which is how bsdiff stores offsets
+func offtin(buf []byte) int64 {
+	n := int64(buf[7] & 0x7f)
+	for i := 6; i >= 0; i-- {
+		n = n<<8 | int64(buf[i])
+	}
+	if buf[7]&0x80 != 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,121 @@
+package download
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/1dustindavis/gorilla/pkg/config"
+)
+
+// deltaVersions returns the two versions of an installer that testdata/setup-1.0-2.0.bsdiff patches between
+func deltaVersions() ([]byte, []byte) {
+	var old bytes.Buffer
+	for i := 0; i < 60; i++ {
+		fmt.Fprintf(&old, "gorilla installer version 1.0 line %03d\n", i)
+	}
+	updated := func(b []byte) []byte { return bytes.ReplaceAll(b, []byte("1.0"), []byte("2.0")) }
+	var new bytes.Buffer
+	new.Write(updated(old.Bytes()[:1200]))
+	new.WriteString("a brand new section\n")
+	new.Write(updated(old.Bytes()[600:1400]))
+	return old.Bytes(), new.Bytes()
+}
+
+// TestBspatch verifies a bsdiff patch rebuilds the new version, and that a damaged patch is rejected
+func TestBspatch(t *testing.T) {
+	old, new := deltaVersions()
+	patch, err := ioutil.ReadFile(filepath.Join("testdata", "setup-1.0-2.0.bsdiff"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var patched bytes.Buffer
+	if err := bspatch(bytes.NewReader(old), patch, &patched); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(patched.Bytes(), new) {
+		t.Errorf("Patched file does not match:\n%s", patched.String())
+	}
+
+	for _, damaged := range [][]byte{patch[:20], patch[:len(patch)/2], append([]byte("BSDIFF41"), patch[8:]...)} {
+		if err := bspatch(bytes.NewReader(old), damaged, ioutil.Discard); err == nil {
+			t.Error("Expected an error for a damaged patch")
+		}
+	}
+}
+
+// TestPatch verifies an installer is rebuilt from the cached previous version, and only when every hash matches
+func TestPatch(t *testing.T) {
+	defer SetConfig(config.Configuration{})
+	SetConfig(config.Configuration{})
+
+	old, new := deltaVersions()
+	patch, err := ioutil.ReadFile(filepath.Join("testdata", "setup-1.0-2.0.bsdiff"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashOf := func(b []byte) string {
+		sum := sha256.Sum256(b)
+		return hex.EncodeToString(sum[:])
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(patch)
+	}))
+	defer server.Close()
+	patchURL := server.URL + "/packages/setup-1.0-2.0.bsdiff"
+
+	cachePath, err := ioutil.TempDir("", "gorilla_delta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cachePath)
+	baseFile := filepath.Join(cachePath, "packages", "setup-1.0.msi")
+	absFile := filepath.Join(cachePath, "packages", "setup-2.0.msi")
+	os.MkdirAll(filepath.Dir(baseFile), 0755)
+	ioutil.WriteFile(baseFile, old, 0644)
+
+	// A mismatched patch or result is never saved
+	if Patch(absFile, baseFile, hashOf(old), patchURL, hashOf([]byte("other")), "", hashOf(new), 0) {
+		t.Error("Expected a patch with the wrong hash to fail")
+	}
+	if Patch(absFile, baseFile, hashOf(old), patchURL, hashOf(patch), "", hashOf([]byte("other")), 0) {
+		t.Error("Expected a result with the wrong hash to fail")
+	}
+	if Patch(absFile, baseFile, hashOf([]byte("other")), patchURL, hashOf(patch), "", hashOf(new), 0) {
+		t.Error("Expected a previous version with the wrong hash to fail")
+	}
+	if _, err := os.Stat(absFile); !os.IsNotExist(err) {
+		t.Errorf("Expected no installer: %v", err)
+	}
+
+	if !Patch(absFile, baseFile, hashOf(old), patchURL, hashOf(patch), "", hashOf(new), 0) {
+		t.Fatal("Expected the installer to be patched")
+	}
+	patched, err := ioutil.ReadFile(absFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(patched, new) {
+		t.Errorf("Patched file does not match:\n%s", patched)
+	}
+
+	// Neither the patch nor a partial file is left behind
+	files, _ := ioutil.ReadDir(filepath.Dir(absFile))
+	for _, file := range files {
+		if name := file.Name(); name != "setup-1.0.msi" && name != "setup-2.0.msi" {
+			t.Errorf("Unexpected file left in the cache: %s", name)
+		}
+		if strings.HasSuffix(file.Name(), ".partial") {
+			t.Errorf("Partial file left in the cache: %s", file.Name())
+		}
+	}
+}
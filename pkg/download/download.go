@@ -1,33 +1,146 @@
 package download
 
 import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha512"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/1dustindavis/gorilla/pkg/config"
 	"github.com/1dustindavis/gorilla/pkg/gorillalog"
+	"github.com/1dustindavis/gorilla/pkg/progress"
+)
+
+// Defaults used when the configuration doesnt set a connect or read timeout
+const (
+	defaultConnectTimeout = 10 * time.Second
+	defaultReadTimeout    = 10 * time.Second
 )
 
 var (
 	// A package level copy of our config for the `download` package to reference
 	downloadCfg config.Configuration
+
+	// The SAS token currently appended to requests, which may be refreshed during a run
+	// Downloads may run in parallel, so it is only read or replaced while holding sasMutex
+	sasToken string
+	sasMutex sync.Mutex
+
+	// A client provided by SetClient, used instead of building our own
+	customClient *http.Client
+
+	// Every request is made with this context, so they all stop when the run is interrupted
+	runContext = context.Background()
 )
 
+// SetClient replaces the http client used for every request, such as one from an `httptest.Server`
+// The configured tls and auth settings are not applied to the client, but basic auth and SAS tokens are.
+// Passing nil restores the default client.
+func SetClient(client *http.Client) {
+	customClient = client
+}
+
+// SetContext sets the context every request is made with
+// Cancelling it stops any download in progress, and what was already downloaded is kept so the next run can resume
+func SetContext(ctx context.Context) {
+	runContext = ctx
+}
+
 // SetConfig accepts a configuration struct that all functions in the `download` package will use
 func SetConfig(cfg config.Configuration) {
 	downloadCfg = cfg
+	sasToken = cfg.SASToken
+	expireOAuthToken(oauthToken)
+
+	// A token file takes precedence over a static token
+	if cfg.SASTokenFile != "" {
+		tokenFile, err := ioutil.ReadFile(cfg.SASTokenFile)
+		if err != nil {
+			gorillalog.Warn("Unable to read SAS token file:", cfg.SASTokenFile, err)
+			return
+		}
+		sasToken = strings.TrimPrefix(strings.TrimSpace(string(tokenFile)), "?")
+	}
+}
+
+// isURL returns true if the location includes a scheme such as `https://` or `file://`
+func isURL(location string) bool {
+	return strings.Contains(location, "://")
+}
+
+// LocalPath returns the file path for a url that is on a local disk or a file share, rather than a server
+// Plain paths, UNC paths like `\\server\share\gorilla`, and `smb://` and `file://` urls are all read directly
+func LocalPath(rawURL string) (string, bool) {
+	if !isURL(rawURL) {
+		return filepath.Clean(rawURL), true
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "smb":
+		return filepath.FromSlash("//" + u.Host + u.Path), true
+	case "file":
+		// `file://C:/repo` puts the drive in the host, and `file:///C:/repo` puts a slash before it
+		filePath := u.Path
+		if len(u.Host) == 2 && u.Host[1] == ':' {
+			filePath = u.Host + filePath
+		} else if u.Host != "" && u.Host != "localhost" {
+			filePath = "//" + u.Host + filePath
+		} else if len(filePath) > 2 && filePath[0] == '/' && filePath[2] == ':' {
+			filePath = filePath[1:]
+		}
+		return filepath.FromSlash(filePath), true
+	}
+	return "", false
+}
+
+// ResolveURL returns the url for a location relative to the base url
+// If the location is already an absolute url, it is returned as-is
+func ResolveURL(base string, location string) string {
+	if isURL(location) {
+		return location
+	}
+
+	// Catalogs written on Windows may use backslashes, which are never valid in a url path
+	location = strings.ReplaceAll(location, `\`, "/")
+
+	// Join with exactly one slash, regardless of how the base and location were written
+	return strings.TrimRight(base, "/") + "/" + strings.TrimLeft(location, "/")
+}
+
+// CacheFile returns the absolute path a location will be stored at within the cache
+// Absolute urls only use their path, so the cache layout matches relative locations
+func CacheFile(cachePath string, location string) string {
+	if isURL(location) {
+		if u, err := url.Parse(location); err == nil {
+			location = u.Path
+		}
+	}
+	relPath, fileName := path.Split(strings.ReplaceAll(location, `\`, "/"))
+	return filepath.Join(cachePath, relPath, fileName)
 }
 
 // File downloads a provided url to the file path specified.
@@ -36,147 +149,673 @@ func File(file string, url string) error {
 	_, fileName := path.Split(url)
 	absPath := filepath.Join(file, fileName)
 
+	// Download to a temporary file first
+	tempPath, _, err := fetch(file, url, 0, sha256.New())
+	if err != nil {
+		return err
+	}
+
+	// Only move the file into place once it is complete
+	return replace(tempPath, absPath)
+}
+
+// fetch downloads a provided url to a `.partial` file in the directory specified
+// and returns the path of the partial file along with its hash, using the provided hash function.
+// Writing beside the final path keeps the eventual rename on the same volume,
+// so it happens in a single step. The content is streamed to disk and hashed as
+// it arrives, so large packages are never held in memory.
+// If an earlier attempt was interrupted, only the remaining bytes are requested.
+// A timeout of zero uses the configured default.
+func fetch(file string, url string, timeout time.Duration, h hash.Hash) (string, string, error) {
+	_, fileName := path.Split(url)
+
 	// Create the directory
 	err := os.MkdirAll(filepath.Clean(file), 0755)
 	if err != nil {
 		gorillalog.Warn("Unable to make filepath:", file, err)
 	}
+	partialPath := filepath.Join(filepath.Clean(file), fileName+".partial")
 
-	// Create the file
-	f, err := os.Create(filepath.Clean(absPath))
+	// Local paths are copied in one go, there is nothing to resume
+	var body io.Reader
+	var start, total int64
+	flags := os.O_CREATE | os.O_RDWR | os.O_TRUNC
+	localPath, isLocal := LocalPath(url)
+	if isLocal {
+		localFile, err := os.Open(localPath)
+		if os.IsNotExist(err) {
+			return "", "", fmt.Errorf("%v: %w", err, ErrNotFound)
+		} else if err != nil {
+			return "", "", err
+		}
+		defer localFile.Close()
+		body = localFile
+		if info, err := localFile.Stat(); err == nil {
+			total = info.Size()
+		}
+	} else {
+		// Pick up where an earlier attempt left off
+		var offset int64
+		if info, err := os.Stat(partialPath); err == nil {
+			offset = info.Size()
+		}
+
+		// Request the content at the provided url
+		resp, err := open(url, timeout, rangeHeader(offset))
+		if err != nil {
+			return "", "", err
+		}
+		defer resp.Body.Close()
+		body = resp.Body
+
+		// Only keep what we have if the server sent the rest of the file, otherwise start over
+		if resp.StatusCode == http.StatusPartialContent {
+			if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+				os.Remove(partialPath)
+				return "", "", fmt.Errorf("%s : unexpected content range: %s", url, resp.Header.Get("Content-Range"))
+			}
+			gorillalog.Info("Resuming download of", url, "at byte", offset)
+			flags = os.O_CREATE | os.O_RDWR
+			start = offset
+		}
+		if resp.ContentLength >= 0 {
+			total = start + resp.ContentLength
+		}
+	}
+	f, err := os.OpenFile(partialPath, flags, 0644)
 	if err != nil {
-		return err
+		return "", "", err
 	}
-	defer f.Close()
 
-	// get the content at the provided url
-	responseBody, err := Get(url)
+	// Hash anything kept from an earlier attempt, which also moves us to the end of the file
+	_, err = io.Copy(h, f)
 	if err != nil {
-		return err
+		f.Close()
+		return "", "", err
 	}
 
-	// Write the responseBody to the file we opened
-	_, err = f.Write(responseBody)
-	if err != nil {
-		return err
+	// Write the content to the file as it arrives, hashing it along the way
+	// If the connection drops, what we have is kept so the next attempt can resume
+	transfer := progress.Download(fileName, total, start)
+	_, err = io.Copy(io.MultiWriter(f, h, transfer), body)
+	transfer.Done()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil && !isLocal {
+		return "", "", &NetworkError{URL: url, Err: err}
+	} else if err != nil {
+		os.Remove(partialPath)
+		return "", "", err
 	}
 
+	return partialPath, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// replace moves a completed temporary file to its final path
+func replace(tempPath string, absPath string) error {
+	// Windows will not rename over an existing file
+	if err := os.Remove(absPath); err != nil && !os.IsNotExist(err) {
+		os.Remove(tempPath)
+		return err
+	}
+	if err := os.Rename(tempPath, absPath); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
 	return nil
 }
 
-// Get downloads a url and returns the body
-// Timeout is 10 seconds
-// Will only write to disk if http status code is 2XX
-func Get(url string) ([]byte, error) {
+// tlsVersions maps the versions that may be configured to their `tls` package constants
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
 
-	// Declare the http client
-	var client *http.Client
+// minTLSVersion returns the configured minimum tls version, which defaults to 1.2
+func minTLSVersion() string {
+	if downloadCfg.TLSMinVersion == "" {
+		return "1.2"
+	}
+	return downloadCfg.TLSMinVersion
+}
+
+// newTLSConfig returns the tls configuration shared by every client
+// Cipher suites only apply to TLS 1.2 and earlier, TLS 1.3 suites are not configurable
+func newTLSConfig() (*tls.Config, error) {
+	minVersion, ok := tlsVersions[minTLSVersion()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported tls_min_version: %s", minTLSVersion())
+	}
+	tlsConfig := &tls.Config{MinVersion: minVersion}
+
+	// Restrict the cipher suites if any were configured
+	if len(downloadCfg.TLSCipherSuites) > 0 {
+		suites := make(map[string]uint16)
+		for _, suite := range tls.CipherSuites() {
+			suites[suite.Name] = suite.ID
+		}
+		for _, name := range downloadCfg.TLSCipherSuites {
+			id, ok := suites[name]
+			if !ok {
+				return nil, fmt.Errorf("unsupported or insecure tls cipher suite: %s", name)
+			}
+			tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, id)
+		}
+	}
 
-	// If TLSAuth is true, configure server and client certs
-	if downloadCfg.TLSAuth {
-		// Load	the client certificate and private key
-		clientCert, err := tls.LoadX509KeyPair(downloadCfg.TLSClientCert, downloadCfg.TLSClientKey)
+	// Trust an internal CA alongside the system roots, without needing client certs
+	if downloadCfg.TLSCABundle != "" {
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			// Older versions of Go cant read the Windows system roots, so only the bundle is trusted
+			gorillalog.Debug("Unable to load the system certificate pool:", err)
+			roots = x509.NewCertPool()
+		}
+		bundle, err := ioutil.ReadFile(downloadCfg.TLSCABundle)
 		if err != nil {
 			return nil, err
 		}
+		if !roots.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("no certificates found in tls_ca_bundle: %s", downloadCfg.TLSCABundle)
+		}
+		tlsConfig.RootCAs = roots
+	}
 
-		// Load server certificates
-		serverCert, err := ioutil.ReadFile(downloadCfg.TLSServerCert)
+	// Only accept servers with a pinned public key somewhere in their chain
+	if len(downloadCfg.TLSPinnedKeys) > 0 {
+		pins, err := parsePins(downloadCfg.TLSPinnedKeys)
 		if err != nil {
 			return nil, err
 		}
-		caCertPool := x509.NewCertPool()
-		caCertPool.AppendCertsFromPEM(serverCert)
-
-		// Setup the tls configuration
-		tlsConfig := &tls.Config{
-			Certificates: []tls.Certificate{clientCert},
-			RootCAs:      caCertPool,
-			// Insecure, but might need to be an option for odd configurations in the future
-			// Renegotiation: tls.RenegotiateFreelyAsClient,
-		}
-
-		// Setup the http client
-		client = &http.Client{
-			Transport: &http.Transport{
-				TLSClientConfig: tlsConfig,
-				Dial: (&net.Dialer{
-					Timeout:   10 * time.Second,
-					KeepAlive: 10 * time.Second,
-				}).Dial,
-				TLSHandshakeTimeout:   10 * time.Second,
-				ResponseHeaderTimeout: 10 * time.Second,
-				ExpectContinueTimeout: 1 * time.Second,
-			},
+		tlsConfig.VerifyPeerCertificate = verifyPins(pins)
+	}
+
+	return tlsConfig, nil
+}
+
+// parsePins decodes pinned keys, which are the base64 sha256 hash of a certificate's public key
+// The same value curl uses for `--pinnedpubkey`, with or without its `sha256//` prefix
+func parsePins(keys []string) (map[string]bool, error) {
+	pins := make(map[string]bool)
+	for _, key := range keys {
+		key = strings.TrimPrefix(strings.TrimSpace(key), "sha256//")
+		sum, err := base64.StdEncoding.DecodeString(key)
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("invalid tls_pinned_keys entry, expected a base64 sha256 hash: %s", key)
 		}
-	} else {
-		// Setup our http client without tls auth
-		// Defining the transport separately so we can add a `file://` protocol
-		transport := &http.Transport{
-			Dial: (&net.Dialer{
-				Timeout:   10 * time.Second,
-				KeepAlive: 10 * time.Second,
-			}).Dial,
-			TLSHandshakeTimeout:   10 * time.Second,
-			ResponseHeaderTimeout: 10 * time.Second,
-			ExpectContinueTimeout: 1 * time.Second,
+		pins[string(sum)] = true
+	}
+	return pins, nil
+}
+
+// verifyPins returns a check that runs after the normal certificate verification,
+// and fails unless a certificate in a verified chain has one of the pinned public keys
+func verifyPins(pins map[string]bool) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		for _, chain := range verifiedChains {
+			for _, cert := range chain {
+				sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				if pins[string(sum[:])] {
+					return nil
+				}
+			}
 		}
+		return errors.New("the server certificate does not match any of the tls_pinned_keys")
+	}
+}
 
-		// Register a file handler so `file://` works
-		transport.RegisterProtocol("file", http.NewFileTransport(http.Dir("/")))
+// loadClientCert loads a client certificate and its private key
+// The key may be encrypted with a passphrase, as long as it is a legacy encrypted PEM block like openssl makes with `-des3`.
+// A cert starting with `store:` is found in the machine's certificate store instead, and its key never leaves windows.
+func loadClientCert(certFile string, keyFile string, pass string) (tls.Certificate, error) {
+	if strings.HasPrefix(certFile, storeCertPrefix) {
+		return storeClientCert(certFile)
+	}
+	if pass == "" {
+		return tls.LoadX509KeyPair(certFile, keyFile)
+	}
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return tls.Certificate{}, fmt.Errorf("no private key found in %s", keyFile)
+	}
+	if x509.IsEncryptedPEMBlock(block) {
+		der, err := x509.DecryptPEMBlock(block, []byte(pass))
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("unable to decrypt %s: %v", keyFile, err)
+		}
+		keyPEM = pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der})
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
 
-		// Create the client using our custom transport
-		client = &http.Client{Transport: transport}
+// connectTimeout returns how long to wait for a connection, including the tls handshake
+func connectTimeout() time.Duration {
+	if downloadCfg.ConnectTimeout > 0 {
+		return time.Duration(downloadCfg.ConnectTimeout) * time.Second
 	}
+	return defaultConnectTimeout
+}
 
-	// Append SAS token if we have one
-	if downloadCfg.SASToken != "" {
-		url = url + "?" + downloadCfg.SASToken
+// readTimeout returns how long to wait for a server to respond, or to send more of a file
+func readTimeout() time.Duration {
+	if downloadCfg.ReadTimeout > 0 {
+		return time.Duration(downloadCfg.ReadTimeout) * time.Second
 	}
+	return defaultReadTimeout
+}
 
-	// Build the request
-	req, err := http.NewRequest("GET", url, nil)
+// newClient builds the http client used for every request
+// A timeout of zero uses the configured default, which may also be zero for no limit
+func newClient(timeout time.Duration) (*http.Client, error) {
+
+	// Use the provided client if there is one, only changing the timeout on our own copy
+	if customClient != nil {
+		if timeout == 0 {
+			return customClient, nil
+		}
+		client := *customClient
+		client.Timeout = timeout
+		return &client, nil
+	}
+
+	// Setup the tls configuration
+	tlsConfig, err := newTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	// Many networks can only reach the repo through a proxy
+	proxy, err := proxyFunc()
+	if err != nil {
+		return nil, err
+	}
+	proxy = directForPeers(proxy)
+
+	// Some authentication happens in the tls handshake, or needs more than one round trip
+	auths := authenticators()
+	for _, auth := range auths {
+		if t, ok := auth.(tlsAuthenticator); ok {
+			if err := t.configureTLS(tlsConfig); err != nil {
+				return nil, err
+			}
+		}
+	}
+	var transport http.RoundTripper = &http.Transport{
+		Proxy:           proxy,
+		TLSClientConfig: tlsConfig,
+		Dial: (&net.Dialer{
+			Timeout:   connectTimeout(),
+			KeepAlive: 10 * time.Second,
+		}).Dial,
+		TLSHandshakeTimeout:   connectTimeout(),
+		ResponseHeaderTimeout: readTimeout(),
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	for _, auth := range auths {
+		if t, ok := auth.(transportAuthenticator); ok {
+			transport = t.wrap(transport)
+		}
+	}
+	client := &http.Client{Transport: transport}
+
+	// Limit the time the entire request may take, including reading the body
+	if timeout == 0 {
+		timeout = time.Duration(downloadCfg.DownloadTimeout) * time.Second
+	}
+	client.Timeout = timeout
+
+	return client, nil
+}
+
+// Client returns an http client for services other than the repo, like Microsoft Graph
+// It goes through the same proxy, but none of the repo's credentials, client certs, or pinned keys are used
+func Client() (*http.Client, error) {
+	if customClient != nil {
+		return customClient, nil
+	}
+	proxy, err := proxyFunc()
 	if err != nil {
-		gorillalog.Warn("Unable to request url:", url, err)
+		return nil, err
+	}
+	transport := &http.Transport{
+		Proxy:           proxy,
+		TLSClientConfig: &tls.Config{MinVersion: tls.VersionTLS12},
+		Dial: (&net.Dialer{
+			Timeout:   connectTimeout(),
+			KeepAlive: 10 * time.Second,
+		}).Dial,
+		TLSHandshakeTimeout:   connectTimeout(),
+		ResponseHeaderTimeout: readTimeout(),
+	}
+	return &http.Client{Transport: transport, Timeout: time.Minute}, nil
+}
+
+// tlsError adds context to errors caused by a failed tls handshake,
+// which is usually a server that cant meet our minimum version or cipher suites
+func tlsError(url string, err error) error {
+	msg := err.Error()
+	if !strings.Contains(msg, "protocol version") && !strings.Contains(msg, "handshake failure") && !strings.Contains(msg, "cipher") {
+		return err
 	}
+	return fmt.Errorf("%s : TLS handshake failed, the server must support TLS %s or later and an allowed cipher suite: %v", url, minTLSVersion(), err)
+}
+
+// newRequest builds a request for the url and adds any authentication,
+// using the backend that serves the url
+func newRequest(method string, url string, body io.Reader) (*http.Request, error) {
+	return backendFor(url).newRequest(method, url, body)
+}
+
+// send builds a request for the url, adds any authentication, and sends it with the provided client
+// Any extra headers are added as well, such as a range or the validators of a cached copy
+func send(client *http.Client, url string, header http.Header) (*http.Response, error) {
 
-	// If we have a user and pass, configure basic auth
-	if downloadCfg.AuthUser != "" && downloadCfg.AuthPass != "" {
-		req.SetBasicAuth(downloadCfg.AuthUser, downloadCfg.AuthPass)
+	// Build the request
+	req, err := newRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
 	}
 
 	// Actually send the request, using the client we setup
-	// Storing the response in resp
 	resp, err := client.Do(req)
+	if err != nil {
+		return nil, &NetworkError{URL: url, Err: tlsError(url, err)}
+	}
+	return resp, nil
+}
+
+// Post sends a body to a url, using the same tls and auth settings as downloads
+// Any 2XX status code is considered successful
+func Post(url string, contentType string, body []byte) error {
 
+	// Setup the http client
+	client, err := newClient(0)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	// The body is read as it is sent, so each attempt needs its own reader
+	post := func() (*http.Response, error) {
+		req, err := newRequest("POST", url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", contentType)
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, &NetworkError{URL: url, Err: tlsError(url, err)}
+		}
+		return resp, nil
+	}
+
+	resp, err := post()
+	if err != nil {
+		return err
+	}
+
+	// Retry once with fresh credentials, just like a download
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		retry, err := reauthenticate(client, url, resp)
+		if err != nil {
+			resp.Body.Close()
+			return &StatusError{URL: url, StatusCode: resp.StatusCode, Err: err}
+		}
+		if retry {
+			resp.Body.Close()
+			resp, err = post()
+			if err != nil {
+				return err
+			}
+		}
 	}
 	defer resp.Body.Close()
 
-	// Check that the request was successful
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("%s : Download status code: %d", url, resp.StatusCode)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &StatusError{URL: url, StatusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+// sasRefreshable returns true if we have somewhere to retrieve a new SAS token from
+func sasRefreshable() bool {
+	return downloadCfg.SASTokenFile != "" || downloadCfg.SASTokenURL != ""
+}
+
+// currentSASToken returns the SAS token to append to requests
+func currentSASToken() string {
+	sasMutex.Lock()
+	defer sasMutex.Unlock()
+	return sasToken
+}
+
+// refreshSASToken replaces the denied SAS token with a new one
+// from either the configured token file or the token endpoint
+// If another download already replaced it, there is nothing left to do
+func refreshSASToken(client *http.Client, denied string) error {
+	sasMutex.Lock()
+	defer sasMutex.Unlock()
+	if sasToken != denied {
+		return nil
 	}
 
+	var newToken string
+
+	if downloadCfg.SASTokenFile != "" {
+		// An external process is responsible for keeping this file current
+		tokenFile, err := ioutil.ReadFile(downloadCfg.SASTokenFile)
+		if err != nil {
+			return fmt.Errorf("unable to read SAS token file: %v", err)
+		}
+		newToken = string(tokenFile)
+	} else {
+		// Request a new token, without appending the token we are replacing
+		req, err := http.NewRequestWithContext(runContext, "GET", downloadCfg.SASTokenURL, nil)
+		if err != nil {
+			return err
+		}
+		if downloadCfg.AuthUser != "" && downloadCfg.AuthPass != "" {
+			req.SetBasicAuth(downloadCfg.AuthUser, downloadCfg.AuthPass)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("unable to request a new SAS token: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != 200 {
+			return fmt.Errorf("%s : SAS token status code: %d", downloadCfg.SASTokenURL, resp.StatusCode)
+		}
+		tokenBody, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		newToken = string(tokenBody)
+	}
+
+	// Tokens are often copied with a leading `?` or a trailing newline
+	newToken = strings.TrimPrefix(strings.TrimSpace(newToken), "?")
+	if newToken == "" || newToken == sasToken {
+		return fmt.Errorf("no new SAS token is available")
+	}
+
+	sasToken = newToken
+	return nil
+}
+
+// Get downloads a url and returns the body
+// The entire body is held in memory, so this is meant for small files like catalogs and manifests,
+// packages should be downloaded with File or IfNeeded instead
+// Timeout is 10 seconds
+// Will only write to disk if http status code is 2XX
+// A local path (without a scheme) is read directly from disk instead
+func Get(url string) ([]byte, error) {
+	return get(url, 0)
+}
+
+// get downloads a url and returns the body, using the provided timeout
+func get(url string, timeout time.Duration) ([]byte, error) {
+
+	// Local paths and file shares dont need an http client at all
+	if localPath, ok := LocalPath(url); ok {
+		localFile, err := ioutil.ReadFile(localPath)
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%v: %w", err, ErrNotFound)
+		}
+		return localFile, err
+	}
+
+	// Request the content at the provided url
+	resp, err := open(url, timeout, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
 	// Copy the download to a a buffer
 	responseBody, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, &NetworkError{URL: url, Err: err}
 	}
 
 	return responseBody, nil
 }
 
-// Verify compares a provided hash to the actual hash of a file
+// rangeHeader returns the header that asks for only the bytes after an offset, or no header for the whole file
+func rangeHeader(offset int64) http.Header {
+	if offset <= 0 {
+		return nil
+	}
+	return http.Header{"Range": {fmt.Sprintf("bytes=%d-", offset)}}
+}
+
+// openURL requests a url and returns the response if it was successful
+// A range header asks for only part of the file, so the caller must check for a
+// `206 Partial Content` status before appending the body. Likewise, validators from
+// a cached copy mean the caller must check for a `304 Not Modified` status.
+func openURL(url string, timeout time.Duration, header http.Header) (*http.Response, error) {
+
+	// Setup the http client
+	client, err := newClient(timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	// Send the request, storing the response in resp
+	resp, err := send(client, url, header)
+	if err != nil {
+		return nil, err
+	}
+
+	// If the request was denied our credentials may have expired,
+	// so retry once with fresh ones if we know where to get them
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		retry, err := reauthenticate(client, url, resp)
+		if err != nil {
+			resp.Body.Close()
+			return nil, &StatusError{URL: url, StatusCode: resp.StatusCode, Err: err}
+		}
+		if retry {
+			resp.Body.Close()
+			resp, err = send(client, url, header)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// The file changed or was already complete, so what we have cant be resumed
+	ranged := header.Get("Range") != ""
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && ranged {
+		resp.Body.Close()
+		gorillalog.Debug("Unable to resume download, starting over:", url)
+		header = header.Clone()
+		header.Del("Range")
+		return openURL(url, timeout, header)
+	}
+
+	// A server that stops sending the file would otherwise leave us waiting forever
+	resp.Body = newStallReader(resp.Body, readTimeout())
+
+	// Check that the request was successful
+	conditional := header.Get("If-None-Match") != "" || header.Get("If-Modified-Since") != ""
+	if resp.StatusCode != http.StatusOK && !(ranged && resp.StatusCode == http.StatusPartialContent) && !(conditional && resp.StatusCode == http.StatusNotModified) {
+		resp.Body.Close()
+		return nil, &StatusError{URL: url, StatusCode: resp.StatusCode}
+	}
+
+	return resp, nil
+}
+
+// NewHash returns the hash function for a hash type, like a catalog's `hash_type`
+// Without a type, the length of the expected hash tells us which one it is, so the md5 and sha1 checksums Munki uses work too
+func NewHash(hashType string, expected string) (hash.Hash, error) {
+	switch strings.ToLower(hashType) {
+	case "md5":
+		return md5.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	case "":
+		switch len(expected) {
+		case md5.Size * 2:
+			return md5.New(), nil
+		case sha1.Size * 2:
+			return sha1.New(), nil
+		case sha512.Size * 2:
+			return sha512.New(), nil
+		}
+		return sha256.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported hash type: %s", hashType)
+}
+
+// packageHash returns the hash function for an installer or patch
+// Only sha256 and sha512 are trusted here, since the hash is all that stands between a download and running it
+func packageHash(hashType string, expected string) (hash.Hash, error) {
+	h, err := NewHash(hashType, expected)
+	if err != nil {
+		return nil, err
+	}
+	if h.Size() < sha256.Size {
+		return nil, fmt.Errorf("unsupported hash type for packages: %d bit", h.Size()*8)
+	}
+	return h, nil
+}
+
+// Verify compares a provided sha256 or sha512 hash to the actual hash of a file
 func Verify(file string, sha string) bool {
+	return verify(file, "", sha)
+}
+
+// verify compares a provided hash to the actual hash of a file, using the provided hash type
+func verify(file string, hashType string, sha string) bool {
+	h, err := packageHash(hashType, sha)
+	if err != nil {
+		gorillalog.Warn("Unable to verify hash:", err)
+		return false
+	}
 	f, err := os.Open(file)
 	if err != nil {
 		gorillalog.Warn("Unable to open file:", err)
 		return false
 	}
 	defer f.Close()
-	h := sha256.New()
 	if _, err := io.Copy(h, f); err != nil {
 		gorillalog.Warn("Unable to verify hash due to IO error:", err)
 		return false
@@ -189,28 +828,65 @@ func Verify(file string, sha string) bool {
 	return true
 }
 
-// IfNeeded takes the same values as Download plus a hash as a string
+// IfNeeded takes the same values as Download plus a hash type and hash as a string
 // It will check if the file already exists, by comparing the hash
 // If the hash does not match, it will attempt to download the file
 // Once downloaded it will attempt to verify the hash again
-func IfNeeded(absFile string, url string, hash string) bool {
+// The hash type may be sha256 or sha512, or empty to tell them apart by length
+// A timeout of zero uses the configured default
+func IfNeeded(absFile string, url string, hashType string, hash string, timeout time.Duration) bool {
+	// A file used in place on a share is only verified, it is never downloaded over or touched
+	if localPath, ok := LocalPath(url); ok && localPath == filepath.Clean(absFile) {
+		return verify(absFile, hashType, hash)
+	}
+
 	// If the file exists, check the hash
 	var verified = false
-	if _, err := os.Stat(absFile); err == nil {
-		verified = Verify(absFile, hash)
+	if info, err := os.Stat(absFile); err == nil {
+		verified = verify(absFile, hashType, hash)
+
+		// Mark a valid cached file as recently used, so it is the last to be removed when the cache is full
+		// Only the access time changes, the modification time is still when it was downloaded
+		if verified {
+			os.Chtimes(absFile, time.Now(), info.ModTime())
+		}
+	}
+
+	// Other clients on the network may already have it, which saves going to the repo
+	if !verified {
+		verified = fromPeers(absFile, hashType, hash)
 	}
 
 	// If hash failed, download the installer
 	if !verified {
 		absPath, _ := filepath.Split(absFile)
 		gorillalog.Info("Downloading", url, "to", absPath)
-		// Download the installer
-		err := File(absPath, url)
+		// Download the installer to a partial file, resuming an earlier attempt if there was one
+		h, err := packageHash(hashType, hash)
+		if err != nil {
+			gorillalog.Warn("Unable to verify package:", url, err)
+			return verified
+		}
+		tempPath, tempHash, err := fetch(absPath, url, timeout, h)
 		if err != nil {
 			gorillalog.Warn("Unable to retrieve package:", url, err)
 			return verified
 		}
-		verified = Verify(absFile, hash)
+
+		// Only replace the cached file if the download is valid
+		// The hash was calculated while downloading, so the file doesnt need to be read again
+		// The partial file is removed right away, so a corrupt download is never left behind or resumed
+		if tempHash != strings.ToLower(hash) {
+			gorillalog.Warn("Downloaded file hash does not match the catalog:", url, "expected", strings.ToLower(hash), "but got", tempHash)
+			os.Remove(tempPath)
+			return verified
+		}
+		err = replace(tempPath, absFile)
+		if err != nil {
+			gorillalog.Warn("Unable to save package:", absFile, err)
+			return verified
+		}
+		verified = true
 	}
 
 	// return the status of verified
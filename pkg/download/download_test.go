@@ -1,8 +1,14 @@
 package download
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -12,9 +18,12 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/1dustindavis/gorilla/pkg/config"
 )
 
 var (
@@ -22,6 +31,7 @@ var (
 	validHash      = "dca48f4e34541c52d12351479454b3af6d87d8dc23ec48f68962f062d8703de3"
 	validHashUpper = "DCA48F4E34541C52D12351479454B3AF6D87D8DC23EC48F68962F062D8703DE3"
 	invalidHash    = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	validSHA512    = "20a3b34c697a33478efe691badac9199552724526a19123e080f26ec1bf160e721c3d74283b4a1a32b0f4253159be5380e3c7d267c99e2b6c2e5f7e68be028b1"
 )
 
 // TestVerify tests hash comparison
@@ -46,6 +56,101 @@ func TestVerify(t *testing.T) {
 	}
 }
 
+// TestVerifyHashType tests sha512 hashes, with and without a hash type
+func TestVerifyHashType(t *testing.T) {
+	tests := []struct {
+		hashType string
+		hash     string
+		expected bool
+	}{
+		{"", validSHA512, true},
+		{"sha512", validSHA512, true},
+		{"SHA512", validSHA512, true},
+		{"sha256", validHash, true},
+		{"sha256", validSHA512, false},
+		{"sha512", validHash, false},
+		{"md5", validHash, false},
+	}
+	for _, test := range tests {
+		if have, want := verify(testFile, test.hashType, test.hash), test.expected; have != want {
+			t.Errorf("%q %s: have %v, want %v", test.hashType, test.hash, have, want)
+		}
+	}
+}
+
+// TestNewHash verifies each hash type, and that the length of the hash picks one without a type,
+// while packages only trust sha256 and sha512
+func TestNewHash(t *testing.T) {
+	tests := []struct {
+		hashType string
+		hash     string
+		size     int
+		strong   bool
+	}{
+		{"md5", "", 16, false},
+		{"SHA1", "", 20, false},
+		{"sha256", "", 32, true},
+		{"sha512", "", 64, true},
+		{"", strings.Repeat("0", 32), 16, false},
+		{"", strings.Repeat("0", 40), 20, false},
+		{"", validHash, 32, true},
+		{"", validSHA512, 64, true},
+	}
+	for _, test := range tests {
+		h, err := NewHash(test.hashType, test.hash)
+		if err != nil {
+			t.Errorf("%q %s: %v", test.hashType, test.hash, err)
+			continue
+		}
+		if h.Size() != test.size {
+			t.Errorf("%q %s: have a %d byte hash, want %d", test.hashType, test.hash, h.Size(), test.size)
+		}
+		if _, err := packageHash(test.hashType, test.hash); (err == nil) != test.strong {
+			t.Errorf("%q %s: package hash error is %v, want trusted %v", test.hashType, test.hash, err, test.strong)
+		}
+	}
+
+	if _, err := NewHash("crc32", ""); err == nil {
+		t.Error("Expected an error for an unsupported hash type")
+	}
+}
+
+// TestResolveURL verifies relative and absolute locations are resolved properly
+func TestResolveURL(t *testing.T) {
+	tests := []struct {
+		base     string
+		location string
+		expected string
+	}{
+		{"https://example.com/", "packages/test.msi", "https://example.com/packages/test.msi"},
+		{"https://example.com", "packages/test.msi", "https://example.com/packages/test.msi"},
+		{"https://example.com/", "/packages/test.msi", "https://example.com/packages/test.msi"},
+		{"https://example.com/repo/", `packages\test.msi`, "https://example.com/repo/packages/test.msi"},
+		{"file:///C:/repo/", "packages/test.msi", "file:///C:/repo/packages/test.msi"},
+		{"https://example.com/", "https://cdn.example.com/test.msi", "https://cdn.example.com/test.msi"},
+		{"https://example.com/", "file:///C:/other/test.msi", "file:///C:/other/test.msi"},
+	}
+
+	for _, test := range tests {
+		if have, want := ResolveURL(test.base, test.location), test.expected; have != want {
+			t.Errorf("have %s, want %s", have, want)
+		}
+	}
+}
+
+// TestCacheFile verifies relative and absolute locations map to the same cache layout
+func TestCacheFile(t *testing.T) {
+	expected := filepath.Join("testdata", "packages", "test.msi")
+
+	if have, want := CacheFile("testdata", "packages/test.msi"), expected; have != want {
+		t.Errorf("Relative location: have %s, want %s", have, want)
+	}
+
+	if have, want := CacheFile("testdata", "https://cdn.example.com/packages/test.msi"), expected; have != want {
+		t.Errorf("Absolute location: have %s, want %s", have, want)
+	}
+}
+
 // serveTestFile writes the contents of `testFile` to the http response
 func serveTestFile(w http.ResponseWriter, r *http.Request) {
 	// Open our test file
@@ -67,11 +172,22 @@ func serveTimeout(w http.ResponseWriter, r *http.Request) {
 	serveTestFile(w, r)
 }
 
+func serveSlow(w http.ResponseWriter, r *http.Request) {
+	// Sleep 1 second, which is longer than a short timeout
+	time.Sleep(1 * time.Second)
+	serveTestFile(w, r)
+}
+
 func serve404(w http.ResponseWriter, r *http.Request) {
 	// Write a 404 response header
 	w.WriteHeader(http.StatusNotFound)
 }
 
+func serve500(w http.ResponseWriter, r *http.Request) {
+	// Write a 500 response header
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
 func serveBasicAuth(w http.ResponseWriter, r *http.Request) {
 	// Parse the username and password
 	user, pass, _ := r.BasicAuth()
@@ -88,6 +204,44 @@ func serveTLSAuth(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+func serveSAS(w http.ResponseWriter, r *http.Request) {
+	// Only the fresh token is accepted, anything else is treated as expired
+	if r.URL.RawQuery == "sig=fresh" {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusForbidden)
+	}
+}
+
+func serveSASToken(w http.ResponseWriter, r *http.Request) {
+	// Write a token formatted the way it is often copied
+	fmt.Fprintln(w, "?sig=fresh")
+}
+
+// lastRange is the Range header of the most recent request to `/ranged/`
+var lastRange string
+
+func serveRanged(w http.ResponseWriter, r *http.Request) {
+	// ServeFile handles Range requests for us
+	lastRange = r.Header.Get("Range")
+	http.ServeFile(w, r, testFile)
+}
+
+// lastPost is the body of the most recent request to `/report`
+var lastPost string
+
+func serveReport(w http.ResponseWriter, r *http.Request) {
+	// Only accept json sent with our credentials
+	user, pass, _ := r.BasicAuth()
+	if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" || user != "frank" || pass != "beans" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	lastPost = string(body)
+	w.WriteHeader(http.StatusCreated)
+}
+
 // route directs http requests to the correct function
 func router() *http.ServeMux {
 	h := http.NewServeMux()
@@ -96,6 +250,12 @@ func router() *http.ServeMux {
 	h.HandleFunc("/404", serve404)
 	h.HandleFunc("/basicauth", serveBasicAuth)
 	h.HandleFunc("/tlsauth", serveTLSAuth)
+	h.HandleFunc("/slow", serveSlow)
+	h.HandleFunc("/500", serve500)
+	h.HandleFunc("/sas", serveSAS)
+	h.HandleFunc("/sastoken", serveSASToken)
+	h.HandleFunc("/ranged/", serveRanged)
+	h.HandleFunc("/report", serveReport)
 	return h
 }
 
@@ -150,6 +310,83 @@ func TestFileHashLocal(t *testing.T) {
 
 }
 
+// TestGetLocalPath verifies that a plain local path is read without a url
+func TestGetLocalPath(t *testing.T) {
+	expected, err := ioutil.ReadFile(testFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A relative path
+	actual, err := Get(testFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(expected, actual) {
+		t.Errorf("Get() did not return the contents of the local file %s", testFile)
+	}
+
+	// An absolute path
+	absPath, err := filepath.Abs(testFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err = Get(absPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(expected, actual) {
+		t.Errorf("Get() did not return the contents of the local file %s", absPath)
+	}
+
+	// A missing file should return an error
+	_, err = Get(filepath.Join("testdata", "missing.yaml"))
+	if err == nil {
+		t.Errorf("Get() did not return an error for a missing local file")
+	}
+}
+
+// TestLocalPath verifies which urls are read directly from disk or a file share
+func TestLocalPath(t *testing.T) {
+	tests := []struct {
+		url      string
+		expected string
+		local    bool
+	}{
+		{"testdata/hashtest.txt", filepath.Clean("testdata/hashtest.txt"), true},
+		{"smb://fileserver/software/gorilla/Example%20App.msi", filepath.FromSlash("//fileserver/software/gorilla/Example App.msi"), true},
+		{"file:///C:/repo/test.msi", filepath.FromSlash("C:/repo/test.msi"), true},
+		{"file://C:/repo/test.msi", filepath.FromSlash("C:/repo/test.msi"), true},
+		{"file://fileserver/software/test.msi", filepath.FromSlash("//fileserver/software/test.msi"), true},
+		{"file:///tmp/test.msi", filepath.FromSlash("/tmp/test.msi"), true},
+		{"https://example.com/test.msi", "", false},
+		{"s3://example-bucket/test.msi", "", false},
+	}
+	for _, test := range tests {
+		localPath, local := LocalPath(test.url)
+		if localPath != test.expected || local != test.local {
+			t.Errorf("%s: have %s %v, want %s %v", test.url, localPath, local, test.expected, test.local)
+		}
+	}
+}
+
+// TestIfNeededInPlace verifies a file used in place is only verified, and never replaced
+func TestIfNeededInPlace(t *testing.T) {
+	absFile, err := filepath.Abs(testFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IfNeeded(absFile, absFile, "", validHash, 0) {
+		t.Error("IfNeeded() did not verify a valid file in place")
+	}
+	if IfNeeded(absFile, absFile, "", invalidHash, 0) {
+		t.Error("IfNeeded() returned true for a file in place with the wrong hash")
+	}
+	if !Verify(absFile, validHash) {
+		t.Error("IfNeeded() changed a file in place")
+	}
+}
+
 // TestFileTimeout verifies a connection will timeout
 func TestFileTimeout(t *testing.T) {
 	// Check it this is short run
@@ -184,6 +421,250 @@ func TestFileTimeout(t *testing.T) {
 
 }
 
+// TestStall verifies a download that stops sending data fails after the read timeout, instead of hanging
+func TestStall(t *testing.T) {
+	SetConfig(config.Configuration{ReadTimeout: 1})
+	defer SetConfig(config.Configuration{})
+
+	stop := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		<-stop
+	}))
+	defer ts.Close()
+	defer close(stop)
+
+	start := time.Now()
+	_, err := Get(ts.URL + "/stalled")
+	if err == nil || !strings.Contains(err.Error(), "no data received") {
+		t.Errorf("Expected a stall error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Download was not stopped after the read timeout, ran for %v", elapsed)
+	}
+}
+
+// TestNewClientTimeout verifies that a provided timeout overrides the configured default
+func TestNewClientTimeout(t *testing.T) {
+	SetConfig(config.Configuration{DownloadTimeout: 30})
+	defer SetConfig(config.Configuration{})
+
+	client, err := newClient(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := client.Timeout, 30*time.Second; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+
+	client, err = newClient(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := client.Timeout, 5*time.Second; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+}
+
+// TestIfNeededTimeout verifies that a download is abandoned once the provided timeout expires
+func TestIfNeededTimeout(t *testing.T) {
+	// Create a temporary directory
+	dir, err := ioutil.TempDir("", "gorilla_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Create a test server
+	ts := httptest.NewServer(router())
+	defer ts.Close()
+
+	// The server takes longer than our timeout
+	valid := IfNeeded(filepath.Join(dir, "slow"), ts.URL+"/slow", "", validHash, 100*time.Millisecond)
+	if valid {
+		t.Error("IfNeeded() returned true for a download that should have timed out")
+	}
+}
+
+// TestSetClient verifies that a provided client is used for requests
+func TestSetClient(t *testing.T) {
+	SetConfig(config.Configuration{})
+
+	// This server uses a certificate only its own client trusts
+	ts := httptest.NewTLSServer(router())
+	defer ts.Close()
+
+	// The default client should reject the certificate
+	_, err := Get(ts.URL + "/hashtest.txt")
+	if err == nil {
+		t.Error("Get() trusted a certificate it shouldn't have")
+	}
+
+	// The server's client should succeed
+	SetClient(ts.Client())
+	defer SetClient(nil)
+	body, err := Get(ts.URL + "/hashtest.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := ioutil.ReadFile(testFile)
+	if !bytes.Equal(expected, body) {
+		t.Error("Get() did not return the expected body using the provided client")
+	}
+
+	// A timeout is applied to a copy, leaving the provided client unchanged
+	client, err := newClient(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client == ts.Client() || client.Timeout != 5*time.Second || ts.Client().Timeout != 0 {
+		t.Error("newClient() did not apply the timeout to a copy of the provided client")
+	}
+}
+
+// TestNewTLSConfig verifies the minimum tls version and cipher suites are configured
+func TestNewTLSConfig(t *testing.T) {
+	defer SetConfig(config.Configuration{})
+
+	// Default to TLS 1.2
+	SetConfig(config.Configuration{})
+	tlsConfig, err := newTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := tlsConfig.MinVersion, uint16(tls.VersionTLS12); have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+
+	// A configured version and cipher suite
+	SetConfig(config.Configuration{
+		TLSMinVersion:   "1.3",
+		TLSCipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"},
+	})
+	tlsConfig, err = newTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := tlsConfig.MinVersion, uint16(tls.VersionTLS13); have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	if have, want := tlsConfig.CipherSuites, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %v, want %v", have, want)
+	}
+
+	// Invalid values should return an error
+	SetConfig(config.Configuration{TLSMinVersion: "1.4"})
+	if _, err := newTLSConfig(); err == nil {
+		t.Error("newTLSConfig did not return an error for an invalid version")
+	}
+	SetConfig(config.Configuration{TLSCipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}})
+	if _, err := newTLSConfig(); err == nil {
+		t.Error("newTLSConfig did not return an error for an insecure cipher suite")
+	}
+}
+
+// TestGetTLSMinVersion verifies a server that cant meet the minimum tls version is rejected
+func TestGetTLSMinVersion(t *testing.T) {
+	SetConfig(config.Configuration{})
+
+	// Create a test server that only supports TLS 1.1 and earlier
+	ts := httptest.NewUnstartedServer(router())
+	ts.TLS = &tls.Config{MinVersion: tls.VersionTLS10, MaxVersion: tls.VersionTLS11}
+	ts.StartTLS()
+	defer ts.Close()
+
+	_, err := Get(ts.URL + "/hashtest.txt")
+	if err == nil {
+		t.Fatal("Get() did not return an error for a server below the minimum tls version")
+	}
+	if !strings.Contains(err.Error(), "TLS 1.2") {
+		t.Errorf("Error received from Get() did not include the minimum version:\n%v", err)
+	}
+}
+
+// TestTLSCABundle verifies a server signed by a configured CA is trusted without tls auth
+func TestTLSCABundle(t *testing.T) {
+	defer SetConfig(config.Configuration{})
+
+	ts := httptest.NewTLSServer(router())
+	defer ts.Close()
+
+	// Without the bundle the test server's certificate is unknown
+	SetConfig(config.Configuration{})
+	if _, err := Get(ts.URL + "/hashtest.txt"); err == nil {
+		t.Fatal("Get() did not return an error for an untrusted server")
+	}
+
+	dir, err := ioutil.TempDir("", "gorilla_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bundle := filepath.Join(dir, "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err := ioutil.WriteFile(bundle, certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	SetConfig(config.Configuration{TLSCABundle: bundle})
+	if _, err := Get(ts.URL + "/hashtest.txt"); err != nil {
+		t.Errorf("Get() returned an error with the CA bundle: %v", err)
+	}
+
+	// A bundle without any certificates is a mistake
+	if err := ioutil.WriteFile(bundle, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newTLSConfig(); err == nil {
+		t.Error("newTLSConfig did not return an error for an empty CA bundle")
+	}
+}
+
+// TestTLSPinnedKeys verifies only servers with a pinned public key are accepted
+func TestTLSPinnedKeys(t *testing.T) {
+	defer SetConfig(config.Configuration{})
+
+	ts := httptest.NewTLSServer(router())
+	defer ts.Close()
+	client := ts.Client()
+	sum := sha256.Sum256(ts.Certificate().RawSubjectPublicKeyInfo)
+	pin := base64.StdEncoding.EncodeToString(sum[:])
+	other := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	tests := []struct {
+		pins  []string
+		valid bool
+	}{
+		{[]string{pin}, true},
+		{[]string{other, "sha256//" + pin}, true},
+		{[]string{other}, false},
+	}
+	for _, test := range tests {
+		SetConfig(config.Configuration{TLSPinnedKeys: test.pins})
+		tlsConfig, err := newTLSConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		tlsConfig.RootCAs = client.Transport.(*http.Transport).TLSClientConfig.RootCAs
+		pinned := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		resp, err := pinned.Get(ts.URL + "/hashtest.txt")
+		if err == nil {
+			resp.Body.Close()
+		}
+		if have, want := err == nil, test.valid; have != want {
+			t.Errorf("%v: have %v, want valid %v", test.pins, err, want)
+		}
+	}
+
+	// Pins must be sha256 hashes
+	SetConfig(config.Configuration{TLSPinnedKeys: []string{"not a pin"}})
+	if _, err := newTLSConfig(); err == nil {
+		t.Error("newTLSConfig did not return an error for an invalid pin")
+	}
+}
+
 // TestFileStatus verifies status codes are respected
 func TestFileStatus(t *testing.T) {
 	// Create a temporary directory
@@ -211,6 +692,50 @@ func TestFileStatus(t *testing.T) {
 
 }
 
+// TestGetErrors verifies that errors can be identified by type
+func TestGetErrors(t *testing.T) {
+	SetConfig(config.Configuration{})
+
+	// Create a test server
+	ts := httptest.NewServer(router())
+
+	tests := []struct {
+		url    string
+		target error
+		status int
+	}{
+		{ts.URL + "/404", ErrNotFound, http.StatusNotFound},
+		{ts.URL + "/basicauth", ErrUnauthorized, http.StatusUnauthorized},
+		{ts.URL + "/500", ErrServerError, http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		_, err := Get(test.url)
+		if !errors.Is(err, test.target) {
+			t.Errorf("%s: have %v, want %v", test.url, err, test.target)
+		}
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != test.status {
+			t.Errorf("%s: have %v, want status code %d", test.url, err, test.status)
+		}
+	}
+
+	// Once the server is gone, we should get a network error
+	ts.Close()
+	_, err := Get(ts.URL + "/hashtest.txt")
+	if !errors.Is(err, ErrNetwork) {
+		t.Errorf("have %v, want %v", err, ErrNetwork)
+	}
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrServerError) {
+		t.Errorf("network error matched an http status error: %v", err)
+	}
+
+	// A missing local file is not found
+	_, err = Get(filepath.Join("testdata", "missing.yaml"))
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("have %v, want %v", err, ErrNotFound)
+	}
+}
+
 // TestFileBasicAuth verifies username and password are included in headers
 func TestFileBasicAuth(t *testing.T) {
 	// Create a temporary directory
@@ -238,6 +763,130 @@ func TestFileBasicAuth(t *testing.T) {
 
 }
 
+// TestPost verifies a body is sent with our credentials, and that a failed status is returned as an error
+func TestPost(t *testing.T) {
+	// Create a test server
+	ts := httptest.NewServer(router())
+	defer ts.Close()
+
+	// Setup basic auth
+	origCfg := downloadCfg
+	defer func() { downloadCfg = origCfg }()
+	downloadCfg.AuthUser = "frank"
+	downloadCfg.AuthPass = "beans"
+
+	err := Post(ts.URL+"/report", "application/json", []byte(`{"HostName":"test"}`))
+	if err != nil {
+		t.Errorf("Post failed: %v", err)
+	}
+	if have, want := lastPost, `{"HostName":"test"}`; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+
+	err = Post(ts.URL+"/404", "application/json", []byte(`{}`))
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got: %v", err)
+	}
+}
+
+// TestGetSASTokenFile verifies an expired SAS token is re-read from the token file
+func TestGetSASTokenFile(t *testing.T) {
+	// Create a temporary directory
+	dir, err := ioutil.TempDir("", "gorilla_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Start with a stale token
+	tokenFile := filepath.Join(dir, "token.txt")
+	err = ioutil.WriteFile(tokenFile, []byte("sig=stale"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	origCfg := downloadCfg
+	defer SetConfig(origCfg)
+	SetConfig(config.Configuration{SASTokenFile: tokenFile})
+
+	// Create a test server
+	ts := httptest.NewServer(router())
+	defer ts.Close()
+
+	// The stale token is rejected without anything new to retry with
+	_, err = Get(ts.URL + "/sas")
+	if err == nil {
+		t.Errorf("Get() did not return an error with an expired SAS token")
+	}
+
+	// An external process refreshes the token
+	err = ioutil.WriteFile(tokenFile, []byte("sig=fresh\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Run the code
+	_, err = Get(ts.URL + "/sas")
+	if err != nil {
+		t.Errorf("Get() did not retry with the refreshed SAS token:\n%v", err)
+	}
+}
+
+// TestGetSASTokenURL verifies an expired SAS token is replaced from the token endpoint
+func TestGetSASTokenURL(t *testing.T) {
+	// Create a test server
+	ts := httptest.NewServer(router())
+	defer ts.Close()
+
+	origCfg := downloadCfg
+	defer SetConfig(origCfg)
+	SetConfig(config.Configuration{SASToken: "sig=stale", SASTokenURL: ts.URL + "/sastoken"})
+
+	// Run the code
+	_, err := Get(ts.URL + "/sas")
+	if err != nil {
+		t.Errorf("Get() did not retry with the refreshed SAS token:\n%v", err)
+	}
+	if have, want := sasToken, "sig=fresh"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+}
+
+// TestLoadClientCert verifies a client key encrypted with a passphrase can be used
+func TestLoadClientCert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gorilla_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Encrypt the test key the same way openssl does
+	keyPEM, err := ioutil.ReadFile("testdata/client.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(keyPEM)
+	encrypted, err := x509.EncryptPEMBlock(rand.Reader, block.Type, block.Bytes, []byte("pizza"), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedKey := filepath.Join(dir, "client.key")
+	if err := ioutil.WriteFile(encryptedKey, pem.EncodeToMemory(encrypted), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := loadClientCert("testdata/client.pem", encryptedKey, "pizza"); err != nil {
+		t.Errorf("Unable to load an encrypted key: %v", err)
+	}
+	if _, err := loadClientCert("testdata/client.pem", encryptedKey, "wrong"); err == nil {
+		t.Error("Expected an error for the wrong passphrase")
+	}
+
+	// A key that isnt encrypted ignores the passphrase
+	if _, err := loadClientCert("testdata/client.pem", "testdata/client.key", "pizza"); err != nil {
+		t.Errorf("Unable to load an unencrypted key: %v", err)
+	}
+}
+
 // TestFileTLS verifies TLS auth is functioning
 func TestFileTLS(t *testing.T) {
 	// Create a temporary directory
@@ -355,7 +1004,7 @@ func TestIfNeededValid(t *testing.T) {
 	defer ts.Close()
 
 	// Run the function with our test data and a validHash
-	valid := IfNeeded(tempFile, ts.URL+"/hashtest.txt", validHash)
+	valid := IfNeeded(tempFile, ts.URL+"/hashtest.txt", "", validHash, 0)
 	if !valid {
 		t.Error("Unable to download valid file: ", ts.URL+"/hashtest.txt")
 	}
@@ -402,7 +1051,7 @@ func TestIfNeededInvalid(t *testing.T) {
 	defer ts.Close()
 
 	// Run the function with our test data and a validHash
-	valid := IfNeeded(tempFile, ts.URL+"/hashtest.txt", validHash)
+	valid := IfNeeded(tempFile, ts.URL+"/hashtest.txt", "", validHash, 0)
 	if !valid {
 		t.Error("Unable to download valid file: ", ts.URL+"/hashtest.txt")
 	}
@@ -419,3 +1068,122 @@ func TestIfNeededInvalid(t *testing.T) {
 	}
 
 }
+
+// TestIfNeededResume verifies that an interrupted download only requests the remaining bytes
+func TestIfNeededResume(t *testing.T) {
+
+	// Create a temporary directory
+	dir, err := ioutil.TempDir("", "gorilla_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Leave the first part of the file behind, as if the connection dropped
+	source, err := ioutil.ReadFile(testFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tempFile := filepath.Join(dir, "hashtest.txt")
+	err = ioutil.WriteFile(tempFile+".partial", source[:10], 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Create a test server
+	ts := httptest.NewServer(router())
+	defer ts.Close()
+
+	// Run the code
+	valid := IfNeeded(tempFile, ts.URL+"/ranged/hashtest.txt", "", validHash, 0)
+	if !valid {
+		t.Error("IfNeeded() was unable to resume a partial download")
+	}
+	if have, want := lastRange, "bytes=10-"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+
+	// The partial file should have been moved into place
+	if _, err := os.Stat(tempFile + ".partial"); !os.IsNotExist(err) {
+		t.Error("IfNeeded() left the partial file behind")
+	}
+}
+
+// TestFetchHash verifies that the hash calculated while streaming matches the content
+func TestFetchHash(t *testing.T) {
+
+	// Create a temporary directory
+	dir, err := ioutil.TempDir("", "gorilla_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Create a test server
+	ts := httptest.NewServer(router())
+	defer ts.Close()
+
+	for _, url := range []string{ts.URL + "/hashtest.txt", testFile} {
+		tempPath, hash, err := fetch(dir, url, 0, sha256.New())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have, want := hash, validHash; have != want {
+			t.Errorf("%s: have %s, want %s", url, have, want)
+		}
+		if !Verify(tempPath, validHash) {
+			t.Errorf("%s: streamed file does not match its hash", url)
+		}
+		os.Remove(tempPath)
+	}
+}
+
+// TestIfNeededMismatch confirms that a download with the wrong hash never replaces the cached file
+func TestIfNeededMismatch(t *testing.T) {
+
+	// Create a temporary directory
+	dir, err := ioutil.TempDir("", "gorilla_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Copy a file with a different hash to our temp directory
+	tempFile := filepath.Join(dir, "/hashtest.txt")
+	err = copy("testdata/client.pem", tempFile)
+	if err != nil {
+		t.Error("copy failed: ", err)
+	}
+
+	// Create a test server
+	ts := httptest.NewServer(router())
+	defer ts.Close()
+
+	// Run the function with a hash that will never match the download
+	valid := IfNeeded(tempFile, ts.URL+"/hashtest.txt", "", invalidHash, 0)
+	if valid {
+		t.Error("IfNeeded() returned true for a download with the wrong hash")
+	}
+
+	// The existing file should be untouched
+	existing, err := ioutil.ReadFile(tempFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, err := ioutil.ReadFile("testdata/client.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(existing, original) {
+		t.Error("IfNeeded() replaced a cached file with an invalid download")
+	}
+
+	// No temporary files should be left behind
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("Expected only the cached file to remain, found %d files", len(files))
+	}
+}
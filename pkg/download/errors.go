@@ -0,0 +1,74 @@
+package download
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// These errors can be compared to any error returned by this package using `errors.Is`
+var (
+	// ErrNotFound means the file does not exist on the server or local disk
+	ErrNotFound = errors.New("not found")
+
+	// ErrUnauthorized means the server rejected our credentials
+	ErrUnauthorized = errors.New("unauthorized")
+
+	// ErrServerError means the server failed to handle the request
+	ErrServerError = errors.New("server error")
+
+	// ErrNetwork means we were unable to get a response from the server at all
+	ErrNetwork = errors.New("network error")
+)
+
+// StatusError is returned when the server responds with anything other than a 200
+type StatusError struct {
+	URL        string
+	StatusCode int
+	// Err is any additional failure, such as being unable to refresh a SAS token
+	Err error
+}
+
+func (e *StatusError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s : Download status code: %d; %v", e.URL, e.StatusCode, e.Err)
+	}
+	return fmt.Sprintf("%s : Download status code: %d", e.URL, e.StatusCode)
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// Is allows a StatusError to match the general error for its status code
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound || e.StatusCode == http.StatusGone
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrServerError:
+		return e.StatusCode >= 500
+	}
+	return false
+}
+
+// NetworkError is returned when a request fails before the server responds,
+// such as a timeout, a refused connection, or a failed tls handshake
+type NetworkError struct {
+	URL string
+	Err error
+}
+
+func (e *NetworkError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *NetworkError) Unwrap() error {
+	return e.Err
+}
+
+// Is allows a NetworkError to match ErrNetwork
+func (e *NetworkError) Is(target error) bool {
+	return target == ErrNetwork
+}
@@ -0,0 +1,104 @@
+package download
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/1dustindavis/gorilla/pkg/gorillalog"
+)
+
+// validators are what a server told us about a file, so we can ask whether it changed instead of downloading it again
+type validators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// GetCached downloads a url like Get, but keeps a copy of the file in the metadata path along with its validators
+// Later requests send the validators, so a file that hasnt changed is read from the copy instead of downloaded again.
+// This is meant for catalogs and manifests, which are requested on every run but rarely change.
+func GetCached(rawURL string) ([]byte, error) {
+	cacheFile := metadataFile(rawURL)
+	if cacheFile == "" {
+		return get(rawURL, 0)
+	}
+	validatorsFile := cacheFile + ".json"
+
+	// Only ask whether the file changed if we still have a copy of it
+	header := http.Header{}
+	cached, err := ioutil.ReadFile(cacheFile)
+	if err == nil {
+		var saved validators
+		if data, err := ioutil.ReadFile(validatorsFile); err == nil && json.Unmarshal(data, &saved) == nil {
+			if saved.ETag != "" {
+				header.Set("If-None-Match", saved.ETag)
+			}
+			if saved.LastModified != "" {
+				header.Set("If-Modified-Since", saved.LastModified)
+			}
+		}
+	}
+
+	resp, err := open(rawURL, 0, header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		gorillalog.Debug("Not modified, using the cached copy:", rawURL)
+		return cached, nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &NetworkError{URL: rawURL, Err: err}
+	}
+
+	// A copy is only useful if the server gave us a way to ask whether it changed
+	current := validators{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+	if current.ETag == "" && current.LastModified == "" {
+		os.Remove(validatorsFile)
+		os.Remove(cacheFile)
+		return body, nil
+	}
+	if err := saveMetadata(cacheFile, body, current); err != nil {
+		gorillalog.Warn("Unable to keep a copy of:", rawURL, err)
+	}
+	return body, nil
+}
+
+// metadataFile returns where a copy of a url is kept, or an empty string if it shouldnt be kept
+// Local paths are already on disk, so only urls are kept
+func metadataFile(rawURL string) string {
+	if downloadCfg.MetadataPath == "" {
+		return ""
+	}
+	if _, ok := LocalPath(rawURL); ok {
+		return ""
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return ""
+	}
+	return CacheFile(filepath.Join(downloadCfg.MetadataPath, u.Hostname()), u.Path)
+}
+
+// saveMetadata writes a copy of a file and its validators
+// The validators are removed first, so a copy that was only partly written is never trusted
+func saveMetadata(cacheFile string, body []byte, current validators) error {
+	validatorsFile := cacheFile + ".json"
+	os.Remove(validatorsFile)
+	if err := os.MkdirAll(filepath.Dir(cacheFile), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(cacheFile, body, 0644); err != nil {
+		return err
+	}
+	data, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(validatorsFile, data, 0644)
+}
@@ -0,0 +1,73 @@
+package download
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/1dustindavis/gorilla/pkg/config"
+)
+
+// TestGetCached verifies a catalog is only downloaded again once it changes
+func TestGetCached(t *testing.T) {
+	defer SetConfig(config.Configuration{})
+
+	metadataPath, err := ioutil.TempDir("", "gorilla_metadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(metadataPath)
+	SetConfig(config.Configuration{MetadataPath: metadataPath})
+
+	catalog, etag := "version 1", `"1"`
+	var downloads int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads++
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(catalog))
+	}))
+	defer ts.Close()
+	catalogURL := ts.URL + "/catalogs/production.yaml"
+
+	for i, want := range []struct {
+		body      string
+		downloads int
+	}{
+		{"version 1", 1},
+		{"version 1", 1},
+		{"version 2", 2},
+	} {
+		if i == 2 {
+			catalog, etag = "version 2", `"2"`
+		}
+		body, err := GetCached(catalogURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have := string(body); have != want.body {
+			t.Errorf("have %s, want %s", have, want.body)
+		}
+		if have := downloads; have != want.downloads {
+			t.Errorf("have %d, want %d", have, want.downloads)
+		}
+	}
+
+	// A copy that is gone is downloaded again, even though its validators are still there
+	os.Remove(metadataFile(catalogURL))
+	body, err := GetCached(catalogURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := string(body), "version 2"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+	if have, want := downloads, 3; have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+}
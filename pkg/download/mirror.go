@@ -0,0 +1,97 @@
+package download
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/1dustindavis/gorilla/pkg/gorillalog"
+)
+
+var (
+	// How many times in a row each mirror has failed during this run
+	// Downloads may run in parallel, so it is only read or changed while holding mirrorMutex
+	mirrorFailures = make(map[string]int)
+	mirrorMutex    sync.Mutex
+)
+
+// mirrorURL is a url on one of the mirrors of a repo
+type mirrorURL struct {
+	base string
+	url  string
+}
+
+// mirrorsFor returns the same file on each mirror of the repo a url is in, starting with the healthiest
+// A url that isnt in a mirrored repo is returned on its own
+func mirrorsFor(url string) []mirrorURL {
+	for _, group := range [][]string{
+		append([]string{downloadCfg.URL}, downloadCfg.Mirrors...),
+		append([]string{downloadCfg.URLPackages}, downloadCfg.PackageMirrors...),
+	} {
+		if len(group) < 2 || group[0] == "" {
+			continue
+		}
+		for _, base := range group {
+			if !strings.HasPrefix(url, base) {
+				continue
+			}
+			relative := strings.TrimPrefix(url, base)
+
+			// The url we were given is tried first, unless it has failed more than the others
+			mirrors := []mirrorURL{{base, url}}
+			for _, mirror := range group {
+				if mirror != base {
+					mirrors = append(mirrors, mirrorURL{mirror, mirror + relative})
+				}
+			}
+			mirrorMutex.Lock()
+			sort.SliceStable(mirrors, func(i, j int) bool {
+				return mirrorFailures[mirrors[i].base] < mirrorFailures[mirrors[j].base]
+			})
+			mirrorMutex.Unlock()
+			return mirrors
+		}
+	}
+	return []mirrorURL{{"", url}}
+}
+
+// recordMirror tracks whether a mirror is working, so the next request tries the healthiest one first
+func recordMirror(base string, success bool) {
+	if base == "" {
+		return
+	}
+	mirrorMutex.Lock()
+	defer mirrorMutex.Unlock()
+	if success {
+		delete(mirrorFailures, base)
+		return
+	}
+	mirrorFailures[base]++
+}
+
+// open requests a url and returns the response if it was successful
+// If the server cant be reached or fails, the same file is requested from each of the repo's mirrors in turn
+func open(url string, timeout time.Duration, header http.Header) (*http.Response, error) {
+	var err error
+	for i, mirror := range mirrorsFor(url) {
+		if i > 0 {
+			gorillalog.Warn("Trying the next mirror:", mirror.url)
+		}
+		var resp *http.Response
+		resp, err = openURL(mirror.url, timeout, header)
+		if err == nil {
+			recordMirror(mirror.base, true)
+			return resp, nil
+		}
+
+		// A missing file or bad credentials will be the same on every mirror
+		if !errors.Is(err, ErrNetwork) && !errors.Is(err, ErrServerError) {
+			return nil, err
+		}
+		recordMirror(mirror.base, false)
+	}
+	return nil, err
+}
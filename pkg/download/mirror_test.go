@@ -0,0 +1,74 @@
+package download
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/1dustindavis/gorilla/pkg/config"
+)
+
+// TestMirrors verifies a request fails over to a mirror, and that the working mirror is tried first afterwards
+func TestMirrors(t *testing.T) {
+	defer func() {
+		SetConfig(config.Configuration{})
+		mirrorFailures = make(map[string]int)
+	}()
+
+	// The primary repo is down
+	var primaryRequests int
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primaryRequests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/gorilla/catalogs/production.yaml" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("catalog"))
+	}))
+	defer mirror.Close()
+
+	SetConfig(config.Configuration{
+		URL:         primary.URL + "/gorilla/",
+		URLPackages: primary.URL + "/gorilla/",
+		Mirrors:     []string{mirror.URL + "/gorilla/"},
+	})
+
+	body, err := Get(primary.URL + "/gorilla/catalogs/production.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := string(body), "catalog"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+	if have, want := primaryRequests, 1; have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+
+	// The failed primary is tried last from now on
+	_, err = Get(primary.URL + "/gorilla/catalogs/production.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := primaryRequests, 1; have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+
+	// A missing file is missing everywhere, so there is no failover
+	_, err = Get(mirror.URL + "/gorilla/catalogs/missing.yaml")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected a not found error: %v", err)
+	}
+	if have, want := primaryRequests, 1; have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+
+	// Urls outside the repo are not mirrored
+	if have, want := len(mirrorsFor("https://example.com/other/setup.msi")), 1; have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+}
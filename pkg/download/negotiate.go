@@ -0,0 +1,121 @@
+package download
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/1dustindavis/gorilla/pkg/gorillalog"
+)
+
+// negotiator produces the tokens for one Negotiate or NTLM handshake
+// Each step is given the server's last challenge, which is empty for the first step
+type negotiator interface {
+	step(challenge []byte) ([]byte, error)
+	close()
+}
+
+// This abstraction allows us to override the windows security provider when testing
+var newNegotiator = sspiNegotiator
+
+// negotiateSchemes are the Windows Integrated Authentication schemes we answer, in the order we prefer them
+var negotiateSchemes = []string{"Negotiate", "NTLM"}
+
+// maxNegotiateSteps is how many tokens we send before giving up, NTLM needs two and Kerberos usually one
+const maxNegotiateSteps = 3
+
+// negotiateAuth authenticates with Windows Integrated Authentication, once the repo asks for it
+type negotiateAuth struct{}
+
+func (negotiateAuth) authenticate(req *http.Request) error {
+	return nil
+}
+
+func (negotiateAuth) wrap(base http.RoundTripper) http.RoundTripper {
+	return &negotiateTransport{base: base}
+}
+
+// negotiateTransport answers a repo's Negotiate or NTLM challenge with the credentials gorilla runs as
+// Running as SYSTEM, that is the computer's domain account, so no password needs to be in the config
+type negotiateTransport struct {
+	base http.RoundTripper
+}
+
+func (t *negotiateTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	// Peers never get our credentials, and a body we cant read again cant be sent again
+	if req.Context().Value(peerRequestKey{}) != nil || (req.Body != nil && req.GetBody == nil) {
+		return resp, nil
+	}
+	scheme := ""
+	for _, s := range negotiateSchemes {
+		if _, ok := challengeToken(resp.Header, s); ok {
+			scheme = s
+			break
+		}
+	}
+	if scheme == "" {
+		return resp, nil
+	}
+	n, err := newNegotiator(scheme, "HTTP/"+req.URL.Hostname())
+	if err != nil {
+		gorillalog.Warn("Unable to use", scheme, "authentication:", err)
+		return resp, nil
+	}
+	defer n.close()
+
+	var challenge []byte
+	for i := 0; i < maxNegotiateSteps; i++ {
+		token, err := n.step(challenge)
+		if err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s authentication failed: %v", scheme, err)
+		}
+		retry := req.Clone(req.Context())
+		if req.GetBody != nil {
+			if retry.Body, err = req.GetBody(); err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+		}
+		retry.Header.Set("Authorization", scheme+" "+base64.StdEncoding.EncodeToString(token))
+
+		// NTLM authenticates the connection, so finish reading the response to keep using it
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		resp, err = t.base.RoundTrip(retry)
+		if err != nil || resp.StatusCode != http.StatusUnauthorized {
+			return resp, err
+		}
+		if challenge, _ = challengeToken(resp.Header, scheme); len(challenge) == 0 {
+			return resp, nil
+		}
+	}
+	return resp, nil
+}
+
+// challengeToken returns the token from a server's challenge for a scheme, and whether the server offered that scheme at all
+func challengeToken(header http.Header, scheme string) ([]byte, bool) {
+	for _, value := range header.Values("WWW-Authenticate") {
+		fields := strings.Fields(value)
+		if len(fields) == 0 || !strings.EqualFold(fields[0], scheme) {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, true
+		}
+		token, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return nil, true
+		}
+		return token, true
+	}
+	return nil, false
+}
@@ -0,0 +1,106 @@
+package download
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/1dustindavis/gorilla/pkg/config"
+)
+
+// fakeNegotiator answers each challenge with a token that names the step it was made for
+type fakeNegotiator struct {
+	steps  []string
+	closed bool
+}
+
+func (n *fakeNegotiator) step(challenge []byte) ([]byte, error) {
+	n.steps = append(n.steps, string(challenge))
+	if len(n.steps) == 1 {
+		return []byte("negotiate"), nil
+	}
+	return []byte("authenticate"), nil
+}
+
+func (n *fakeNegotiator) close() {
+	n.closed = true
+}
+
+// TestNegotiate verifies a repo's NTLM challenge is answered until the repo accepts us
+func TestNegotiate(t *testing.T) {
+	origNegotiator := newNegotiator
+	defer func() {
+		newNegotiator = origNegotiator
+		SetConfig(config.Configuration{})
+	}()
+	fake := &fakeNegotiator{}
+	var target string
+	newNegotiator = func(scheme string, spn string) (negotiator, error) {
+		target = scheme + " " + spn
+		return fake, nil
+	}
+
+	// A two step handshake like NTLM, which needs the request body each time
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		switch r.Header.Get("Authorization") {
+		case "":
+			w.Header().Add("WWW-Authenticate", "Basic realm=repo")
+			w.Header().Add("WWW-Authenticate", "NTLM")
+			w.WriteHeader(http.StatusUnauthorized)
+		case "NTLM bmVnb3RpYXRl":
+			w.Header().Set("WWW-Authenticate", "NTLM Y2hhbGxlbmdl")
+			w.WriteHeader(http.StatusUnauthorized)
+		case "NTLM YXV0aGVudGljYXRl":
+			w.Write([]byte("ok"))
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer ts.Close()
+
+	// Without auth_negotiate the challenge is returned as it is
+	SetConfig(config.Configuration{})
+	if _, err := Get(ts.URL + "/catalogs/production.yaml"); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("have %v, want %v", err, ErrUnauthorized)
+	}
+
+	SetConfig(config.Configuration{AuthNegotiate: true})
+	bodies = nil
+	if err := Post(ts.URL+"/report", "text/plain", []byte("report")); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := target, "NTLM HTTP/127.0.0.1"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+	if have, want := len(fake.steps), 2; have != want {
+		t.Fatalf("have %d, want %d", have, want)
+	}
+	if have, want := fake.steps[1], "challenge"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+	for _, body := range bodies {
+		if have, want := body, "report"; have != want {
+			t.Errorf("have %s, want %s", have, want)
+		}
+	}
+	if !fake.closed {
+		t.Error("The negotiator was not closed")
+	}
+}
+
+// TestChallengeToken verifies the token is read from the scheme we asked for
+func TestChallengeToken(t *testing.T) {
+	header := http.Header{"Www-Authenticate": {"Basic realm=repo", "Negotiate dG9rZW4="}}
+	token, ok := challengeToken(header, "Negotiate")
+	if have, want := string(token), "token"; !ok || have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+	if _, ok := challengeToken(header, "NTLM"); ok {
+		t.Error("challengeToken found a scheme the server did not offer")
+	}
+}
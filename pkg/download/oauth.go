@@ -0,0 +1,148 @@
+package download
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/1dustindavis/gorilla/pkg/gorillalog"
+)
+
+var (
+	// The client credentials token is cached until it is about to expire, or the repo denies it
+	// Downloads may run in parallel, so it is only read or replaced while holding oauthMutex
+	oauthToken        string
+	oauthTokenExpires time.Time
+	oauthMutex        sync.Mutex
+)
+
+// bearerAuth sends a bearer token in place of basic auth, and replaces a requested token the repo denies
+type bearerAuth struct{}
+
+func (bearerAuth) authenticate(req *http.Request) error {
+	token, err := bearerToken()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// refresh retries once with a new token, since a token may be revoked before it expires
+func (bearerAuth) refresh(client *http.Client, url string, resp *http.Response) (bool, error) {
+	if resp.StatusCode != http.StatusUnauthorized || !oauthRefreshable() {
+		return false, nil
+	}
+	gorillalog.Info("Request denied, refreshing bearer token:", url)
+	expireOAuthToken(deniedBearer(resp))
+	return true, nil
+}
+
+// oauthRefreshable returns true if we request our own bearer tokens, so a denied token can be replaced
+func oauthRefreshable() bool {
+	return downloadCfg.OAuthTokenURL != ""
+}
+
+// bearerToken returns the token to send in the Authorization header, or an empty string if we dont use one
+// A static bearer_token is sent as it is, otherwise a token is requested with the OAuth2 client credentials flow
+func bearerToken() (string, error) {
+	if downloadCfg.BearerToken != "" {
+		return downloadCfg.BearerToken, nil
+	}
+	if !oauthRefreshable() {
+		return "", nil
+	}
+
+	oauthMutex.Lock()
+	defer oauthMutex.Unlock()
+	if oauthToken != "" && time.Now().Add(5*time.Minute).Before(oauthTokenExpires) {
+		return oauthToken, nil
+	}
+	token, expires, err := requestOAuthToken()
+	if err != nil {
+		return "", fmt.Errorf("unable to get a token from %s: %v", downloadCfg.OAuthTokenURL, err)
+	}
+	oauthToken, oauthTokenExpires = token, expires
+	return oauthToken, nil
+}
+
+// expireOAuthToken forgets a token the repo denied, so the next request gets a new one
+// If another download already replaced it, there is nothing left to do
+func expireOAuthToken(denied string) {
+	oauthMutex.Lock()
+	defer oauthMutex.Unlock()
+	if oauthToken == denied {
+		oauthToken = ""
+	}
+}
+
+// deniedBearer returns the bearer token a denied request was sent with
+func deniedBearer(resp *http.Response) string {
+	if resp.Request == nil {
+		return ""
+	}
+	return strings.TrimPrefix(resp.Request.Header.Get("Authorization"), "Bearer ")
+}
+
+// requestOAuthToken requests a new token from the identity provider with our client id and secret
+// https://datatracker.ietf.org/doc/html/rfc6749#section-4.4
+func requestOAuthToken() (string, time.Time, error) {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", downloadCfg.OAuthClientID)
+	form.Set("client_secret", downloadCfg.OAuthClientSecret)
+	if len(downloadCfg.OAuthScopes) > 0 {
+		form.Set("scope", strings.Join(downloadCfg.OAuthScopes, " "))
+	}
+	req, err := http.NewRequestWithContext(runContext, "POST", downloadCfg.OAuthTokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	// The identity provider is reached the same way as the repo, through any proxy and with our tls settings
+	client, err := newClient(0)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", time.Time{}, fmt.Errorf("token status code: %d", resp.StatusCode)
+	}
+	tokenJSON, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(tokenJSON, &token); err != nil {
+		return "", time.Time{}, err
+	}
+	if token.AccessToken == "" {
+		return "", time.Time{}, fmt.Errorf("no access token in the response")
+	}
+	if token.TokenType != "" && !strings.EqualFold(token.TokenType, "bearer") {
+		return "", time.Time{}, fmt.Errorf("unsupported token type: %s", token.TokenType)
+	}
+
+	// Without an expiration, keep the token until the repo denies it
+	expires := time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	if token.ExpiresIn <= 0 {
+		expires = time.Now().Add(24 * time.Hour)
+	}
+	return token.AccessToken, expires, nil
+}
@@ -0,0 +1,80 @@
+package download
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/1dustindavis/gorilla/pkg/config"
+)
+
+// TestBearerToken verifies tokens are requested with the client credentials, reused, and replaced when the repo denies them
+func TestBearerToken(t *testing.T) {
+	defer SetConfig(config.Configuration{})
+
+	var issued int
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("grant_type") != "client_credentials" || r.Form.Get("client_id") != "gorilla" || r.Form.Get("client_secret") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if have, want := r.Form.Get("scope"), "repo.read api://gorilla/.default"; have != want {
+			t.Errorf("have %s, want %s", have, want)
+		}
+		issued++
+		fmt.Fprintf(w, `{"access_token": "token%d", "token_type": "Bearer", "expires_in": 3600}`, issued)
+	}))
+	defer idp.Close()
+
+	// The repo only accepts the second token, as if the first were revoked
+	var seen []string
+	repo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r.Header.Get("Authorization"))
+		if r.Header.Get("Authorization") != "Bearer token2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer repo.Close()
+
+	SetConfig(config.Configuration{
+		OAuthTokenURL:     idp.URL,
+		OAuthClientID:     "gorilla",
+		OAuthClientSecret: "secret",
+		OAuthScopes:       []string{"repo.read", "api://gorilla/.default"},
+	})
+	for i := 0; i < 2; i++ {
+		if _, err := Get(repo.URL + "/catalogs/production.yaml"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if have, want := issued, 2; have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+	if have, want := fmt.Sprint(seen), "[Bearer token1 Bearer token2 Bearer token2]"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+
+	// A static token is sent as it is
+	seen = nil
+	SetConfig(config.Configuration{BearerToken: "token2"})
+	if _, err := Get(repo.URL + "/catalogs/production.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := fmt.Sprint(seen), "[Bearer token2]"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+
+	// Bad client credentials are an error before the repo is ever asked
+	seen = nil
+	SetConfig(config.Configuration{OAuthTokenURL: idp.URL, OAuthClientID: "gorilla", OAuthClientSecret: "wrong"})
+	if _, err := Get(repo.URL + "/catalogs/production.yaml"); err == nil {
+		t.Error("Get() did not return an error for bad client credentials")
+	}
+	if len(seen) != 0 {
+		t.Errorf("have %v, want no requests to the repo", seen)
+	}
+}
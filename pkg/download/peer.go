@@ -0,0 +1,78 @@
+package download
+
+import (
+	"context"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/1dustindavis/gorilla/pkg/gorillalog"
+	"github.com/1dustindavis/gorilla/pkg/peer"
+)
+
+// This abstraction allows us to override finding peers when testing
+var peerFind = peer.Find
+
+// peerTimeout is how long we wait for a peer to answer before going to the repo
+const peerTimeout = 2 * time.Second
+
+// peerRequestKey marks a request to a peer, so it can skip the proxy
+type peerRequestKey struct{}
+
+// peerBackend serves a package from another client's cache, using a `peer://host:port/sha256/<hash>` url
+// Peers are on the local network and only serve files by hash, so they never get our credentials or go through a proxy
+type peerBackend struct{}
+
+func (peerBackend) newRequest(method string, rawURL string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(runContext, method, "http://"+strings.TrimPrefix(rawURL, "peer://"), body)
+	if err != nil {
+		gorillalog.Warn("Unable to request url:", rawURL, err)
+		return nil, err
+	}
+	return req.WithContext(context.WithValue(req.Context(), peerRequestKey{}, true)), nil
+}
+
+// directForPeers wraps a proxy function so requests to peers connect directly
+func directForPeers(proxy func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		if req.Context().Value(peerRequestKey{}) != nil {
+			return nil, nil
+		}
+		return proxy(req)
+	}
+}
+
+// fromPeers downloads a package from the first peer that has it, and returns true if it was saved
+// Peers are found by sha256 hash, so packages with any other hash type always come from the repo
+func fromPeers(absFile string, hashType string, hash string) bool {
+	if !downloadCfg.PeerCache || len(hash) != sha256.Size*2 || (hashType != "" && !strings.EqualFold(hashType, "sha256")) {
+		return false
+	}
+	absPath, _ := filepath.Split(absFile)
+	for _, peerURL := range peerFind(hash, peerTimeout) {
+		gorillalog.Info("Downloading", filepath.Base(absFile), "from peer", peerURL)
+		tempPath, tempHash, err := fetch(absPath, peerURL, 0, sha256.New())
+		if err != nil {
+			gorillalog.Warn("Unable to retrieve package from peer:", peerURL, err)
+			continue
+		}
+
+		// A peer is never trusted, only the catalog hash is
+		if tempHash != strings.ToLower(hash) {
+			gorillalog.Warn("Package from peer does not match the catalog:", peerURL, "expected", strings.ToLower(hash), "but got", tempHash)
+			os.Remove(tempPath)
+			continue
+		}
+		if err := replace(tempPath, absFile); err != nil {
+			gorillalog.Warn("Unable to save package:", absFile, err)
+			return false
+		}
+		return true
+	}
+	return false
+}
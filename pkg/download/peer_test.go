@@ -0,0 +1,98 @@
+package download
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1dustindavis/gorilla/pkg/config"
+)
+
+// TestPeers verifies a package comes from the first peer with a valid copy, before the repo
+func TestPeers(t *testing.T) {
+	origFind := peerFind
+	defer func() {
+		peerFind = origFind
+		SetConfig(config.Configuration{})
+	}()
+
+	content := []byte("installer content")
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+
+	var repoRequests int
+	repo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		repoRequests++
+		w.Write(content)
+	}))
+	defer repo.Close()
+
+	// The first peer has a corrupt copy, so the second one is used
+	// Neither should ever see the repo's credentials
+	badPeer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("corrupt content"))
+	}))
+	defer badPeer.Close()
+	var peerAuth bool
+	goodPeer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _, peerAuth = r.BasicAuth()
+		if r.URL.Path != "/sha256/"+hash {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(content)
+	}))
+	defer goodPeer.Close()
+	peerFind = func(hash string, timeout time.Duration) []string {
+		return []string{
+			"peer://" + strings.TrimPrefix(badPeer.URL, "http://") + "/sha256/" + hash,
+			"peer://" + strings.TrimPrefix(goodPeer.URL, "http://") + "/sha256/" + hash,
+		}
+	}
+
+	cachePath, err := ioutil.TempDir("", "gorilla_peers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cachePath)
+	absFile := filepath.Join(cachePath, "packages", "setup.msi")
+
+	SetConfig(config.Configuration{PeerCache: true, AuthUser: "user", AuthPass: "pass"})
+	if !IfNeeded(absFile, repo.URL+"/packages/setup.msi", "", hash, 0) {
+		t.Fatal("Expected the package to be downloaded")
+	}
+	if have, want := repoRequests, 0; have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+	if peerAuth {
+		t.Error("Credentials were sent to a peer")
+	}
+
+	// Without a valid copy on any peer, the repo is used
+	os.Remove(absFile)
+	goodPeer.Close()
+	if !IfNeeded(absFile, repo.URL+"/packages/setup.msi", "", hash, 0) {
+		t.Fatal("Expected the package to be downloaded")
+	}
+	if have, want := repoRequests, 1; have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+
+	// Peers are only asked when peer_cache is set
+	os.Remove(absFile)
+	peerFind = func(hash string, timeout time.Duration) []string {
+		t.Error("Peers were asked without peer_cache")
+		return nil
+	}
+	SetConfig(config.Configuration{})
+	if !IfNeeded(absFile, repo.URL+"/packages/setup.msi", "", hash, 0) {
+		t.Fatal("Expected the package to be downloaded")
+	}
+}
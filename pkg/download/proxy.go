@@ -0,0 +1,116 @@
+package download
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// proxyFunc returns how requests find their proxy
+// A configured proxy_url takes precedence, then the machine's WinHTTP proxy if enabled,
+// and then the HTTP_PROXY, HTTPS_PROXY, and NO_PROXY environment variables.
+func proxyFunc() (func(*http.Request) (*url.URL, error), error) {
+	if downloadCfg.ProxyURL != "" {
+		proxyURL, err := parseProxy(downloadCfg.ProxyURL)
+		if err != nil {
+			return nil, err
+		}
+		if downloadCfg.ProxyUser != "" {
+			proxyURL.User = url.UserPassword(downloadCfg.ProxyUser, downloadCfg.ProxyPass)
+		}
+		return http.ProxyURL(proxyURL), nil
+	}
+
+	if downloadCfg.ProxyWinHTTP {
+		settings, err := winHTTPSettings()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the WinHTTP proxy: %v", err)
+		}
+		proxies, bypass := parseWinHTTPSettings(settings)
+		if len(proxies) > 0 {
+			return winHTTPProxy(proxies, bypass), nil
+		}
+	}
+
+	return http.ProxyFromEnvironment, nil
+}
+
+// parseProxy parses a proxy address, which defaults to http if it has no scheme
+func parseProxy(proxy string) (*url.URL, error) {
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+	proxyURL, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy_url: %v", err)
+	}
+	return proxyURL, nil
+}
+
+// parseWinHTTPSettings reads the proxies and bypass list from the `WinHttpSettings` registry value,
+// which is what `netsh winhttp set proxy` writes. Proxies are keyed by scheme, with "" used for every scheme.
+func parseWinHTTPSettings(settings []byte) (proxies map[string]string, bypass []string) {
+	// The value starts with a version, a counter, and flags, followed by the proxy and bypass list,
+	// each as a length and a string. The flags are 1 for a direct connection and 3 for a proxy.
+	readString := func(offset int) (string, int) {
+		if len(settings) < offset+4 {
+			return "", len(settings)
+		}
+		length := int(binary.LittleEndian.Uint32(settings[offset:]))
+		offset += 4
+		if len(settings) < offset+length {
+			return "", len(settings)
+		}
+		return string(settings[offset : offset+length]), offset + length
+	}
+	if len(settings) < 12 || binary.LittleEndian.Uint32(settings[8:])&2 == 0 {
+		return nil, nil
+	}
+	proxy, offset := readString(12)
+	bypassList, _ := readString(offset)
+
+	// The proxy is either a single `host:port`, or a list like `http=host:port;https=host:port`
+	proxies = make(map[string]string)
+	for _, entry := range strings.FieldsFunc(proxy, func(r rune) bool { return r == ';' || r == ' ' }) {
+		if i := strings.Index(entry, "="); i > 0 {
+			proxies[strings.ToLower(entry[:i])] = entry[i+1:]
+			continue
+		}
+		proxies[""] = entry
+	}
+	for _, entry := range strings.FieldsFunc(bypassList, func(r rune) bool { return r == ';' || r == ' ' }) {
+		bypass = append(bypass, strings.ToLower(entry))
+	}
+	return proxies, bypass
+}
+
+// winHTTPProxy returns a proxy function that uses the WinHTTP proxies, except for hosts on the bypass list
+// `<local>` bypasses hosts without a dot, and other entries may use `*` as a wildcard
+func winHTTPProxy(proxies map[string]string, bypass []string) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		host := strings.ToLower(req.URL.Hostname())
+		for _, pattern := range bypass {
+			if pattern == "<local>" && !strings.Contains(host, ".") {
+				return nil, nil
+			}
+			if matched, _ := path.Match(pattern, host); matched {
+				return nil, nil
+			}
+			if matched, _ := path.Match(pattern, net.JoinHostPort(host, req.URL.Port())); matched {
+				return nil, nil
+			}
+		}
+		proxy, ok := proxies[req.URL.Scheme]
+		if !ok {
+			proxy, ok = proxies[""]
+		}
+		if !ok {
+			return nil, nil
+		}
+		return parseProxy(proxy)
+	}
+}
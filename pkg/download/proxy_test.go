@@ -0,0 +1,142 @@
+package download
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/1dustindavis/gorilla/pkg/config"
+)
+
+// TestProxy verifies requests are sent through a configured proxy with its credentials
+func TestProxy(t *testing.T) {
+	defer SetConfig(config.Configuration{})
+
+	// The proxy answers every request itself, and remembers what it was asked for
+	var requested, auth string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.String()
+		auth = r.Header.Get("Proxy-Authorization")
+		w.Write([]byte("proxied"))
+	}))
+	defer proxy.Close()
+
+	SetConfig(config.Configuration{ProxyURL: proxy.URL, ProxyUser: "johnny", ProxyPass: "pizza"})
+	body, err := Get("http://gorilla.example.com/catalogs/production.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := string(body), "proxied"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+	if have, want := requested, "http://gorilla.example.com/catalogs/production.yaml"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+	if have, want := auth, "Basic "+base64.StdEncoding.EncodeToString([]byte("johnny:pizza")); have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+
+	// A proxy without a scheme is http
+	proxyURL, err := parseProxy("proxy.example.com:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := proxyURL.String(), "http://proxy.example.com:8080"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+}
+
+// TestClientProxy verifies the client for other services uses the proxy, without sending the repo's credentials
+func TestClientProxy(t *testing.T) {
+	defer SetConfig(config.Configuration{})
+
+	var requested, auth, accessKey string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.String()
+		auth = r.Header.Get("Authorization")
+		accessKey = r.Header.Get("X-Access-Key")
+		w.Write([]byte("proxied"))
+	}))
+	defer proxy.Close()
+
+	SetConfig(config.Configuration{
+		ProxyURL:    proxy.URL,
+		AuthUser:    "johnny",
+		AuthPass:    "pizza",
+		BearerToken: "repo-token",
+		Headers:     map[string]string{"X-Access-Key": "repo-key"},
+	})
+	client, err := Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get("http://graph.example.com/v1.0/devices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if have, want := requested, "http://graph.example.com/v1.0/devices"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+	if auth != "" || accessKey != "" {
+		t.Errorf("expected no repo credentials, got %q and %q", auth, accessKey)
+	}
+}
+
+// winHTTPValue builds a `WinHttpSettings` registry value
+func winHTTPValue(flags uint32, proxy, bypass string) []byte {
+	value := make([]byte, 12)
+	binary.LittleEndian.PutUint32(value, 0x28)
+	binary.LittleEndian.PutUint32(value[8:], flags)
+	for _, s := range []string{proxy, bypass} {
+		length := make([]byte, 4)
+		binary.LittleEndian.PutUint32(length, uint32(len(s)))
+		value = append(append(value, length...), s...)
+	}
+	return value
+}
+
+// TestWinHTTPProxy verifies the WinHTTP proxy settings are parsed, and the bypass list is honored
+func TestWinHTTPProxy(t *testing.T) {
+	// A direct connection has no proxies
+	if proxies, _ := parseWinHTTPSettings(winHTTPValue(1, "", "")); len(proxies) != 0 {
+		t.Errorf("Expected no proxies for a direct connection: %v", proxies)
+	}
+	if proxies, _ := parseWinHTTPSettings(nil); len(proxies) != 0 {
+		t.Errorf("Expected no proxies without any settings: %v", proxies)
+	}
+
+	proxies, bypass := parseWinHTTPSettings(winHTTPValue(3, "http=proxy:8080;https=secure-proxy:8443", "<local>;*.corp.example.com"))
+	if have, want := proxies, map[string]string{"http": "proxy:8080", "https": "secure-proxy:8443"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	if have, want := bypass, []string{"<local>", "*.corp.example.com"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %v, want %v", have, want)
+	}
+
+	proxyFunc := winHTTPProxy(proxies, bypass)
+	for rawURL, expected := range map[string]string{
+		"http://gorilla.example.com/":        "http://proxy:8080",
+		"https://gorilla.example.com/":       "http://secure-proxy:8443",
+		"https://gorilla/":                   "",
+		"https://repo.corp.example.com/":     "",
+		"https://repo.corp.example.com:8443": "",
+	} {
+		reqURL, _ := url.Parse(rawURL)
+		proxyURL, err := proxyFunc(&http.Request{URL: reqURL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		have := ""
+		if proxyURL != nil {
+			have = proxyURL.String()
+		}
+		if have != expected {
+			t.Errorf("%s: have %q, want %q", rawURL, have, expected)
+		}
+	}
+}
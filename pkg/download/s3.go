@@ -0,0 +1,261 @@
+package download
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/1dustindavis/gorilla/pkg/gorillalog"
+)
+
+var (
+	// The instance metadata service provides credentials for the IAM role of an EC2 instance
+	// This is a variable so we can override it when testing
+	s3MetadataURL = "http://169.254.169.254"
+
+	// Credentials from the instance metadata service are cached until they are about to expire
+	// Downloads may run in parallel, so they are only read or replaced while holding s3Mutex
+	s3RoleCredentials s3Credentials
+	s3Mutex           sync.Mutex
+
+	// This abstraction allows us to override the time when testing
+	s3Now = time.Now
+)
+
+// s3Credentials are the keys used to sign requests to S3
+type s3Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	Token           string
+	Expiration      time.Time
+}
+
+// s3Backend serves a repo directly from an S3 or S3-compatible bucket, using an `s3://bucket/prefix/` url
+// Requests are signed with AWS Signature Version 4, unless there are no credentials for a public bucket
+type s3Backend struct{}
+
+func (s3Backend) newRequest(method string, rawURL string, body io.Reader) (*http.Request, error) {
+	requestURL, err := s3URL(rawURL)
+	if err != nil {
+		gorillalog.Warn("Unable to request url:", rawURL, err)
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(runContext, method, requestURL, body)
+	if err != nil {
+		gorillalog.Warn("Unable to request url:", rawURL, err)
+		return nil, err
+	}
+
+	creds, err := s3CurrentCredentials()
+	if err != nil {
+		return nil, err
+	}
+	if creds.AccessKeyID != "" {
+		signS3(req, creds, s3Region(), s3Now())
+	}
+	return req, nil
+}
+
+// s3Region returns the configured region, which defaults to us-east-1
+func s3Region() string {
+	if downloadCfg.S3Region == "" {
+		return "us-east-1"
+	}
+	return downloadCfg.S3Region
+}
+
+// s3URL returns the https url for an `s3://bucket/key` url
+// AWS buckets use their virtual hosted url, and a configured endpoint like MinIO uses a path style url
+func s3URL(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	bucket := u.Host
+	if bucket == "" {
+		return "", fmt.Errorf("no bucket in url: %s", rawURL)
+	}
+	key := strings.TrimLeft(u.Path, "/")
+
+	if downloadCfg.S3Endpoint != "" {
+		return strings.TrimRight(downloadCfg.S3Endpoint, "/") + s3EscapePath("/"+bucket+"/"+key), nil
+	}
+	return "https://" + bucket + ".s3." + s3Region() + ".amazonaws.com" + s3EscapePath("/"+key), nil
+}
+
+// s3EscapePath encodes everything in a path except unreserved characters and slashes, which is what S3 expects
+func s3EscapePath(p string) string {
+	var escaped strings.Builder
+	for _, b := range []byte(p) {
+		if ('A' <= b && b <= 'Z') || ('a' <= b && b <= 'z') || ('0' <= b && b <= '9') || strings.IndexByte("-._~/", b) >= 0 {
+			escaped.WriteByte(b)
+			continue
+		}
+		fmt.Fprintf(&escaped, "%%%02X", b)
+	}
+	return escaped.String()
+}
+
+// s3CurrentCredentials returns the configured keys, then the standard AWS environment variables,
+// and finally the credentials for the instance's IAM role
+func s3CurrentCredentials() (s3Credentials, error) {
+	if downloadCfg.S3AccessKey != "" {
+		return s3Credentials{AccessKeyID: downloadCfg.S3AccessKey, SecretAccessKey: downloadCfg.S3SecretKey}, nil
+	}
+	if os.Getenv("AWS_ACCESS_KEY_ID") != "" {
+		return s3Credentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			Token:           os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+	if !downloadCfg.S3UseRole {
+		return s3Credentials{}, nil
+	}
+
+	s3Mutex.Lock()
+	defer s3Mutex.Unlock()
+	if s3RoleCredentials.AccessKeyID != "" && s3Now().Add(5*time.Minute).Before(s3RoleCredentials.Expiration) {
+		return s3RoleCredentials, nil
+	}
+	creds, err := s3InstanceCredentials()
+	if err != nil {
+		return s3Credentials{}, fmt.Errorf("unable to get credentials for the instance's IAM role: %v", err)
+	}
+	s3RoleCredentials = creds
+	return creds, nil
+}
+
+// s3InstanceCredentials requests credentials for the instance's IAM role from the instance metadata service
+func s3InstanceCredentials() (s3Credentials, error) {
+	var creds s3Credentials
+
+	// The metadata service is always local, so it never goes through a proxy
+	client := &http.Client{Timeout: 5 * time.Second}
+	metadata := func(method, path string, header http.Header) ([]byte, error) {
+		req, err := http.NewRequestWithContext(runContext, method, s3MetadataURL+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s : metadata status code: %d", path, resp.StatusCode)
+		}
+		return ioutil.ReadAll(resp.Body)
+	}
+
+	// IMDSv2 requires a session token for every request
+	token, err := metadata("PUT", "/latest/api/token", http.Header{"X-Aws-Ec2-Metadata-Token-Ttl-Seconds": {"300"}})
+	if err != nil {
+		return creds, err
+	}
+	header := http.Header{"X-Aws-Ec2-Metadata-Token": {string(token)}}
+	role, err := metadata("GET", "/latest/meta-data/iam/security-credentials/", header)
+	if err != nil {
+		return creds, err
+	}
+	roleName := strings.TrimSpace(strings.SplitN(string(role), "\n", 2)[0])
+	if roleName == "" {
+		return creds, fmt.Errorf("the instance has no IAM role")
+	}
+	credsJSON, err := metadata("GET", "/latest/meta-data/iam/security-credentials/"+roleName, header)
+	if err != nil {
+		return creds, err
+	}
+	err = json.Unmarshal(credsJSON, &struct {
+		AccessKeyID     *string    `json:"AccessKeyId"`
+		SecretAccessKey *string    `json:"SecretAccessKey"`
+		Token           *string    `json:"Token"`
+		Expiration      *time.Time `json:"Expiration"`
+	}{&creds.AccessKeyID, &creds.SecretAccessKey, &creds.Token, &creds.Expiration})
+	return creds, err
+}
+
+// signS3 adds an AWS Signature Version 4 authorization header to a request
+// The payload is not signed, so the body can be streamed
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func signS3(req *http.Request, creds s3Credentials, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	scope := amzDate[:8] + "/" + region + "/s3/aws4_request"
+
+	payloadHash := "UNSIGNED-PAYLOAD"
+	if req.Body == nil {
+		payloadHash = hex.EncodeToString(sha256.New().Sum(nil))
+	}
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if creds.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.Token)
+	}
+
+	// Sign the host, range, and every amz header
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "range" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	// Query parameters are sorted by name, and then by value
+	query := req.URL.Query()
+	var params []string
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, s3EscapePath(name)+"="+strings.ReplaceAll(s3EscapePath(value), "/", "%2F"))
+		}
+	}
+	sort.Strings(params)
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		strings.Join(params, "&"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	hmacSHA256 := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{amzDate[:8], region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", creds.AccessKeyID, scope, signedHeaders, signature))
+}
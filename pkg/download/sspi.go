@@ -0,0 +1,150 @@
+//go:build windows
+// +build windows
+
+package download
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	secpkgCredOutbound      = 2
+	securityNativeDrep      = 0x10
+	secbufferVersion        = 0
+	secbufferToken          = 2
+	iscReqAllocateMemory    = 0x100
+	iscReqConnection        = 0x800
+	secEOK                  = 0
+	secIContinueNeeded      = 0x90312
+	secICompleteNeeded      = 0x90313
+	secICompleteAndContinue = 0x90314
+)
+
+var (
+	secur32                        = windows.NewLazySystemDLL("secur32.dll")
+	procAcquireCredentialsHandleW  = secur32.NewProc("AcquireCredentialsHandleW")
+	procInitializeSecurityContextW = secur32.NewProc("InitializeSecurityContextW")
+	procCompleteAuthToken          = secur32.NewProc("CompleteAuthToken")
+	procFreeCredentialsHandle      = secur32.NewProc("FreeCredentialsHandle")
+	procDeleteSecurityContext      = secur32.NewProc("DeleteSecurityContext")
+	procFreeContextBuffer          = secur32.NewProc("FreeContextBuffer")
+)
+
+// secHandle is a CredHandle or CtxtHandle
+type secHandle struct {
+	lower uintptr
+	upper uintptr
+}
+
+// secBuffer is a SecBuffer
+type secBuffer struct {
+	size       uint32
+	bufferType uint32
+	buffer     *byte
+}
+
+// secBufferDesc is a SecBufferDesc
+type secBufferDesc struct {
+	version uint32
+	count   uint32
+	buffers *secBuffer
+}
+
+// sspi authenticates with the windows security provider, using the credentials of the account gorilla runs as
+type sspi struct {
+	target     *uint16
+	cred       secHandle
+	ctx        secHandle
+	hasContext bool
+}
+
+// sspiNegotiator acquires the credentials for a Negotiate or NTLM handshake with a target, like `HTTP/repo.example.com`
+func sspiNegotiator(scheme string, target string) (negotiator, error) {
+	pkg, err := windows.UTF16PtrFromString(scheme)
+	if err != nil {
+		return nil, err
+	}
+	targetName, err := windows.UTF16PtrFromString(target)
+	if err != nil {
+		return nil, err
+	}
+	s := &sspi{target: targetName}
+	var expiry int64
+	r, _, _ := procAcquireCredentialsHandleW.Call(
+		0,
+		uintptr(unsafe.Pointer(pkg)),
+		secpkgCredOutbound,
+		0,
+		0,
+		0,
+		0,
+		uintptr(unsafe.Pointer(&s.cred)),
+		uintptr(unsafe.Pointer(&expiry)),
+	)
+	if r != secEOK {
+		return nil, fmt.Errorf("unable to acquire credentials: %v", syscall.Errno(r))
+	}
+	return s, nil
+}
+
+// step returns the next token to send, given the server's last challenge
+func (s *sspi) step(challenge []byte) ([]byte, error) {
+	out := secBuffer{bufferType: secbufferToken}
+	outDesc := secBufferDesc{version: secbufferVersion, count: 1, buffers: &out}
+
+	// The first step has no challenge or context yet
+	var inDesc *secBufferDesc
+	var ctx *secHandle
+	if s.hasContext {
+		ctx = &s.ctx
+	}
+	if len(challenge) > 0 {
+		in := secBuffer{size: uint32(len(challenge)), bufferType: secbufferToken, buffer: &challenge[0]}
+		inDesc = &secBufferDesc{version: secbufferVersion, count: 1, buffers: &in}
+	}
+
+	var attrs uint32
+	var expiry int64
+	r, _, _ := procInitializeSecurityContextW.Call(
+		uintptr(unsafe.Pointer(&s.cred)),
+		uintptr(unsafe.Pointer(ctx)),
+		uintptr(unsafe.Pointer(s.target)),
+		iscReqAllocateMemory|iscReqConnection,
+		0,
+		securityNativeDrep,
+		uintptr(unsafe.Pointer(inDesc)),
+		0,
+		uintptr(unsafe.Pointer(&s.ctx)),
+		uintptr(unsafe.Pointer(&outDesc)),
+		uintptr(unsafe.Pointer(&attrs)),
+		uintptr(unsafe.Pointer(&expiry)),
+	)
+	switch r {
+	case secEOK, secIContinueNeeded:
+	case secICompleteNeeded, secICompleteAndContinue:
+		if c, _, _ := procCompleteAuthToken.Call(uintptr(unsafe.Pointer(&s.ctx)), uintptr(unsafe.Pointer(&outDesc))); c != secEOK {
+			return nil, fmt.Errorf("unable to complete the token: %v", syscall.Errno(c))
+		}
+	default:
+		return nil, fmt.Errorf("unable to create a token: %v", syscall.Errno(r))
+	}
+	s.hasContext = true
+
+	if out.buffer == nil {
+		return nil, nil
+	}
+	defer procFreeContextBuffer.Call(uintptr(unsafe.Pointer(out.buffer)))
+	return append([]byte(nil), (*[1 << 30]byte)(unsafe.Pointer(out.buffer))[:out.size:out.size]...), nil
+}
+
+// close frees the context and credentials
+func (s *sspi) close() {
+	if s.hasContext {
+		procDeleteSecurityContext.Call(uintptr(unsafe.Pointer(&s.ctx)))
+	}
+	procFreeCredentialsHandle.Call(uintptr(unsafe.Pointer(&s.cred)))
+}
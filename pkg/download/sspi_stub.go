@@ -0,0 +1,12 @@
+// Without a darwin specific build, go tools will try to include Windows libraries and fail
+
+//go:build !windows
+// +build !windows
+
+package download
+
+import "errors"
+
+func sspiNegotiator(scheme string, target string) (negotiator, error) {
+	return nil, errors.New("windows authentication is only available on Windows")
+}
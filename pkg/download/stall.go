@@ -0,0 +1,47 @@
+package download
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// stallReader fails a response body that stops sending data for longer than its timeout
+// The timer only runs while a read is waiting, so a caller that is slow to read is never mistaken for a stalled server.
+// Closing the body is what unblocks a read that is waiting on the server.
+type stallReader struct {
+	body    io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	stalled int32
+}
+
+// newStallReader watches a response body for stalls
+func newStallReader(body io.ReadCloser, timeout time.Duration) *stallReader {
+	return &stallReader{body: body, timeout: timeout}
+}
+
+func (r *stallReader) Read(p []byte) (int, error) {
+	if r.timer == nil {
+		r.timer = time.AfterFunc(r.timeout, func() {
+			atomic.StoreInt32(&r.stalled, 1)
+			r.body.Close()
+		})
+	} else {
+		r.timer.Reset(r.timeout)
+	}
+	n, err := r.body.Read(p)
+	r.timer.Stop()
+	if atomic.LoadInt32(&r.stalled) == 1 {
+		return n, fmt.Errorf("no data received for %v", r.timeout)
+	}
+	return n, err
+}
+
+func (r *stallReader) Close() error {
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	return r.body.Close()
+}
@@ -0,0 +1,53 @@
+package download
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// storeCertPrefix marks a tls_client_cert that is in the machine's certificate store instead of a file
+// Many organizations enroll client certs through AD autoenrollment, and dont allow the private key to be exported
+const storeCertPrefix = "store:"
+
+// storeCertSearch returns what to look for in the certificate store: a thumbprint, or else part of the subject
+// Thumbprints are often copied from the certificate manager with spaces, or an invisible mark at the start, so those are ignored
+func storeCertSearch(spec string) (thumbprint []byte, subject string) {
+	spec = strings.TrimSpace(strings.TrimPrefix(spec, storeCertPrefix))
+	cleaned := strings.Map(func(r rune) rune {
+		if r == ' ' || r == ':' || r == '\u200e' {
+			return -1
+		}
+		return r
+	}, spec)
+	if sum, err := hex.DecodeString(cleaned); err == nil && len(sum) == 20 {
+		return sum, ""
+	}
+	return nil, spec
+}
+
+// newestValidCert returns the index of the cert that is valid now and expires last, or -1 if none are valid
+// Renewed certs are enrolled beside the ones they replace, so a search often finds more than one
+func newestValidCert(certs []*x509.Certificate, now time.Time) int {
+	best := -1
+	for i, cert := range certs {
+		if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+			continue
+		}
+		if best == -1 || cert.NotAfter.After(certs[best].NotAfter) {
+			best = i
+		}
+	}
+	return best
+}
+
+// ecdsaSignature converts the r and s values windows returns into the ASN.1 signature go expects
+func ecdsaSignature(raw []byte) ([]byte, error) {
+	half := len(raw) / 2
+	return asn1.Marshal(struct {
+		R, S *big.Int
+	}{new(big.Int).SetBytes(raw[:half]), new(big.Int).SetBytes(raw[half:])})
+}
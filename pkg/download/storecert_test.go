@@ -0,0 +1,77 @@
+package download
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+// TestStoreCertSearch verifies a thumbprint is recognized however it was copied, and anything else is a subject
+func TestStoreCertSearch(t *testing.T) {
+	want, _ := hex.DecodeString("0123456789abcdef0123456789abcdef01234567")
+	tests := []struct {
+		spec       string
+		thumbprint []byte
+		subject    string
+	}{
+		{"store:0123456789abcdef0123456789abcdef01234567", want, ""},
+		{"store:01 23 45 67 89 AB CD EF 01 23 45 67 89 AB CD EF 01 23 45 67", want, ""},
+		{"store:\u200e0123456789abcdef0123456789abcdef01234567", want, ""},
+		{"store:CN=gorilla.example.com", nil, "CN=gorilla.example.com"},
+		{"store: gorilla client ", nil, "gorilla client"},
+		{"store:abcd", nil, "abcd"},
+	}
+	for _, test := range tests {
+		thumbprint, subject := storeCertSearch(test.spec)
+		if !bytes.Equal(thumbprint, test.thumbprint) || subject != test.subject {
+			t.Errorf("%q: have %x %q, want %x %q", test.spec, thumbprint, subject, test.thumbprint, test.subject)
+		}
+	}
+}
+
+// TestNewestValidCert verifies an expired or future cert is skipped, and the one that expires last is used
+func TestNewestValidCert(t *testing.T) {
+	now := time.Now()
+	certs := []*x509.Certificate{
+		{NotBefore: now.Add(-48 * time.Hour), NotAfter: now.Add(-24 * time.Hour)},
+		{NotBefore: now.Add(-24 * time.Hour), NotAfter: now.Add(24 * time.Hour)},
+		{NotBefore: now.Add(-24 * time.Hour), NotAfter: now.Add(48 * time.Hour)},
+		{NotBefore: now.Add(24 * time.Hour), NotAfter: now.Add(96 * time.Hour)},
+	}
+	if have, want := newestValidCert(certs, now), 2; have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+	if have, want := newestValidCert(certs[:1], now), -1; have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+}
+
+// TestECDSASignature verifies the r and s values windows returns become a signature go can verify
+func TestECDSASignature(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte("handshake"))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := make([]byte, 64)
+	r.FillBytes(raw[:32])
+	s.FillBytes(raw[32:])
+
+	sig, err := ecdsaSignature(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], sig) {
+		t.Error("Signature did not verify")
+	}
+}
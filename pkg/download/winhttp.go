@@ -0,0 +1,26 @@
+//go:build windows
+// +build windows
+
+package download
+
+import (
+	registry "golang.org/x/sys/windows/registry"
+)
+
+// winHTTPSettings returns the machine's WinHTTP proxy settings, as set by `netsh winhttp set proxy`
+// A machine that has never had a proxy set has no settings, which is the same as a direct connection
+func winHTTPSettings() ([]byte, error) {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, `SOFTWARE\Microsoft\Windows\CurrentVersion\Internet Settings\Connections`, registry.QUERY_VALUE|registry.WOW64_64KEY)
+	if err == registry.ErrNotExist {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer key.Close()
+	settings, _, err := key.GetBinaryValue("WinHttpSettings")
+	if err == registry.ErrNotExist {
+		return nil, nil
+	}
+	return settings, err
+}
@@ -0,0 +1,73 @@
+package gitrepo
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/1dustindavis/gorilla/pkg/gorillalog"
+)
+
+// These abstractions allows us to override when testing
+var (
+	gitCommand  = "git"
+	execCommand = exec.Command
+)
+
+// git runs a git command, and includes its output in any error
+// Git is never allowed to prompt for credentials, since nobody is there to answer
+func git(args ...string) error {
+	cmd := execCommand(gitCommand, args...)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(output.String()))
+	}
+	return nil
+}
+
+// Sync makes dir a copy of the latest commit on a branch of a git repo, cloning it the first time
+// Without a branch, the repo's default branch is used
+// If the repo cant be reached, the copy from the last successful sync is kept and used
+func Sync(repoURL, branch, dir string) error {
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+		gorillalog.Info("Cloning git repo:", repoURL)
+		args := []string{"clone", "--depth", "1"}
+		if branch != "" {
+			args = append(args, "--branch", branch)
+		}
+		err = git(append(args, repoURL, dir)...)
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+		return err
+	}
+
+	gorillalog.Info("Updating git repo:", repoURL)
+	ref := branch
+	if ref == "" {
+		ref = "HEAD"
+	}
+	// The repo url may have changed since the last sync
+	err := git("-C", dir, "remote", "set-url", "origin", repoURL)
+	if err == nil {
+		err = git("-C", dir, "fetch", "--depth", "1", "origin", ref)
+	}
+	if err != nil {
+		gorillalog.Warn("Unable to update git repo, using the last copy:", err)
+		return nil
+	}
+
+	// Match the fetched commit exactly, discarding anything that changed locally
+	err = git("-C", dir, "reset", "--hard", "FETCH_HEAD")
+	if err != nil {
+		return err
+	}
+	return git("-C", dir, "clean", "-ffdx")
+}
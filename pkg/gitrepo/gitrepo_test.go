@@ -0,0 +1,86 @@
+package gitrepo
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// commit writes a file to a repo and commits it
+func commit(t *testing.T, repo, name, contents string) {
+	if err := ioutil.WriteFile(filepath.Join(repo, name), []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"-C", repo, "add", name},
+		{"-C", repo, "-c", "user.name=gorilla", "-c", "user.email=gorilla@example.com", "commit", "-m", name},
+	} {
+		if err := git(args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestSync verifies a repo is cloned, updated, and kept when it cant be reached
+func TestSync(t *testing.T) {
+	if _, err := exec.LookPath(gitCommand); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "gorilla_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A repo to sync from
+	origin := filepath.Join(dir, "origin")
+	if err := git("init", "--initial-branch", "main", origin); err != nil {
+		t.Fatal(err)
+	}
+	commit(t, origin, "production.yaml", "first")
+
+	readCatalog := func(checkout string) string {
+		catalog, err := ioutil.ReadFile(filepath.Join(checkout, "production.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(catalog)
+	}
+
+	// The first sync clones the repo
+	checkout := filepath.Join(dir, "git")
+	if err := Sync(origin, "main", checkout); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := readCatalog(checkout), "first"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+
+	// Later syncs pick up new commits, and discard local changes
+	commit(t, origin, "production.yaml", "second")
+	ioutil.WriteFile(filepath.Join(checkout, "extra.yaml"), []byte("extra"), 0644)
+	if err := Sync(origin, "", checkout); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := readCatalog(checkout), "second"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+	if _, err := os.Stat(filepath.Join(checkout, "extra.yaml")); !os.IsNotExist(err) {
+		t.Errorf("Expected a local file to be removed: %v", err)
+	}
+
+	// An unreachable repo keeps the last copy
+	if err := Sync(filepath.Join(dir, "missing"), "main", checkout); err != nil {
+		t.Errorf("Expected the last copy to be used: %v", err)
+	}
+	if have, want := readCatalog(checkout), "second"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+
+	// Without a copy, an unreachable repo is an error
+	if err := Sync(filepath.Join(dir, "missing"), "main", filepath.Join(dir, "other")); err == nil {
+		t.Error("Expected an error cloning a missing repo")
+	}
+}
@@ -0,0 +1,16 @@
+//go:build windows
+// +build windows
+
+package gorillalog
+
+import "golang.org/x/sys/windows/svc/eventlog"
+
+// eventSource is the Application log source events are written under, which is also used by the service
+const eventSource = "Gorilla"
+
+// openEventLog opens the Application event log, registering our source if it isnt already
+func openEventLog() (eventWriter, error) {
+	// Registering a source that already exists fails, which is fine
+	eventlog.InstallAsEventCreate(eventSource, eventlog.Error|eventlog.Warning|eventlog.Info)
+	return eventlog.Open(eventSource)
+}
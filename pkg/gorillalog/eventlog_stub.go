@@ -0,0 +1,12 @@
+// Without a darwin specific build, go tools will try to include Windows libraries and fail
+
+//go:build !windows
+// +build !windows
+
+package gorillalog
+
+import "fmt"
+
+func openEventLog() (eventWriter, error) {
+	return nil, fmt.Errorf("the event log is not supported on this platform")
+}
@@ -1,25 +1,74 @@
 package gorillalog
 
 import (
+	"encoding/json"
 	"fmt"
-	"log"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/1dustindavis/gorilla/pkg/config"
+	"github.com/1dustindavis/gorilla/pkg/report"
 )
 
+// Levels a message may be logged at, from the most to the least detailed
+const (
+	levelDebug = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+// Event IDs written to the event log
+const (
+	eventSuccess = 100
+	eventWarning = 200
+	eventError   = 300
+)
+
+// eventWriter writes messages to the Windows event log
+type eventWriter interface {
+	Info(eid uint32, msg string) error
+	Warning(eid uint32, msg string) error
+	Error(eid uint32, msg string) error
+}
+
+// levelNames maps each level to how it is written and configured
+var levelNames = map[int]string{
+	levelDebug: "DEBUG",
+	levelInfo:  "INFO",
+	levelWarn:  "WARN",
+	levelError: "ERROR",
+}
+
 var (
 	// Define these config variables at the package scope
 	debug     bool
 	verbose   bool
 	checkonly bool
-)
 
-// TODO rewrite with io.multiwriter
-// Something like this?
-// logOutput := io.MultiWriter(os.Stdout, logFile)
-// log.SetOutput(logOutput)
+	// fileLevel is the least severe level written to the log file
+	fileLevel = levelInfo
+
+	// jsonFormat writes each message as a JSON object, for log shippers like Splunk or ELK
+	jsonFormat bool
+
+	// logOutput is where messages are written, which is stderr until the log file is opened
+	logOutput io.Writer = os.Stderr
+
+	// consoleStderr prints messages to stderr, when stdout is reserved for the JSON summary
+	consoleStderr bool
+
+	// events is the Windows event log, if warnings, errors, and install results are also written there
+	events eventWriter
+
+	// Items may be downloaded in parallel, so each message is logged while holding logMutex
+	// This keeps a message from being written with another message's prefix
+	logMutex sync.Mutex
+)
 
 // NewLog creates a file and points a new logging instance at it
 func NewLog(cfg config.Configuration) {
@@ -28,7 +77,7 @@ func NewLog(cfg config.Configuration) {
 	defer func() {
 		if r := recover(); r != nil {
 			fmt.Println(r)
-			os.Exit(1)
+			os.Exit(report.ExitConfigError)
 		}
 	}()
 
@@ -36,6 +85,12 @@ func NewLog(cfg config.Configuration) {
 	debug = cfg.Debug
 	verbose = cfg.Verbose
 	checkonly = cfg.CheckOnly
+	fileLevel = parseLevel(cfg.LogLevel)
+	if debug {
+		fileLevel = levelDebug
+	}
+	jsonFormat = strings.EqualFold(cfg.LogFormat, "json")
+	consoleStderr = cfg.JSONSummary == "-"
 
 	// Skip log if checkonly is active
 	if checkonly {
@@ -50,63 +105,141 @@ func NewLog(cfg config.Configuration) {
 		panic(msg)
 	}
 
-	// Create the log file
-	logFile, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	// Create the log file, which is rotated once it reaches the configured size
+	logFile, err := openRotating(logPath, int64(cfg.LogMaxSizeMB)*1024*1024, cfg.LogMaxBackups, time.Duration(cfg.LogMaxAgeDays)*24*time.Hour)
 	if err != nil {
-		msg := fmt.Sprint("Unable to open file:", logFile, err)
+		msg := fmt.Sprint("Unable to open file:", logPath, err)
 		panic(msg)
 	}
 
-	// Configure the `log` package to use our file
-	log.SetOutput(logFile)
+	// Write every message to our file
+	logOutput = logFile
+
+	// Monitoring that already forwards the event log can pick up failures without reading our file
+	if cfg.EventLog {
+		events, err = openEventLog()
+		if err != nil {
+			fmt.Println("Unable to open the event log:", err)
+		}
+	}
+}
 
-	//  Configure the `log` package to use microsecond resolution
-	log.SetFlags(log.Ldate | log.Lmicroseconds)
+// parseLevel returns the level for a configured name, which defaults to INFO
+func parseLevel(name string) int {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) || (level == levelWarn && strings.EqualFold(name, "warning")) {
+			return level
+		}
+	}
+	return levelInfo
+}
+
+// write adds a message to the log file if its level is enabled, as text or JSON
+// The caller must hold logMutex
+func write(level int, msg string) {
+	if checkonly || level < fileLevel {
+		return
+	}
+	now := time.Now()
+	var line []byte
+	if jsonFormat {
+		line, _ = json.Marshal(struct {
+			Time    string `json:"time"`
+			Level   string `json:"level"`
+			Message string `json:"message"`
+		}{now.Format(time.RFC3339Nano), strings.ToLower(levelNames[level]), strings.TrimSuffix(msg, "\n")})
+		line = append(line, '\n')
+	} else {
+		line = []byte(levelNames[level] + ": " + now.Format("2006/01/02 15:04:05.000000") + " " + msg)
+		if !strings.HasSuffix(msg, "\n") {
+			line = append(line, '\n')
+		}
+	}
+	logOutput.Write(line)
+}
+
+// printConsole prints a message for whoever is watching the run
+func printConsole(logStrings ...interface{}) {
+	if consoleStderr {
+		fmt.Fprintln(os.Stderr, logStrings...)
+		return
+	}
+	fmt.Println(logStrings...)
+}
+
+// writeEvent adds a message to the event log if it is enabled
+// The caller must hold logMutex
+func writeEvent(level int, msg string) {
+	if events == nil || checkonly {
+		return
+	}
+	msg = strings.TrimSpace(msg)
+	switch level {
+	case levelError:
+		events.Error(eventError, msg)
+	case levelWarn:
+		events.Warning(eventWarning, msg)
+	default:
+		events.Info(eventSuccess, msg)
+	}
 }
 
 // Debug logs a string as DEBUG
-// We write to disk if debug is true
+// We write to disk if debug is true or the log level is DEBUG, and only print to stdout if debug is true
 func Debug(logStrings ...interface{}) {
-	log.SetPrefix("DEBUG: ")
+	logMutex.Lock()
+	defer logMutex.Unlock()
 	if debug {
-		fmt.Println(logStrings...)
-		if checkonly {
-			return
-		}
-		log.Println(logStrings...)
+		printConsole(logStrings...)
 	}
+	write(levelDebug, fmt.Sprintln(logStrings...))
 }
 
 // Info logs a string as INFO
 // We only print to stdout if verbose is true
 func Info(logStrings ...interface{}) {
-	log.SetPrefix("INFO: ")
+	logMutex.Lock()
+	defer logMutex.Unlock()
 	if verbose {
-		fmt.Println(logStrings...)
-	}
-	if checkonly {
-		return
+		printConsole(logStrings...)
 	}
-	log.Println(logStrings...)
+	write(levelInfo, fmt.Sprintln(logStrings...))
 }
 
 // Warn logs a string as WARN
-// We print to stdout and write to disk
+// We print to stdout, write to disk, and add it to GorillaReport
 func Warn(logStrings ...interface{}) {
-	log.SetPrefix("WARN: ")
-	fmt.Println(logStrings...)
-	if checkonly {
-		return
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	report.Errors = append(report.Errors, strings.TrimSpace(fmt.Sprintln(logStrings...)))
+	printConsole(logStrings...)
+	write(levelWarn, fmt.Sprintln(logStrings...))
+	writeEvent(levelWarn, fmt.Sprintln(logStrings...))
+}
+
+// Success logs the result of an install or uninstall as INFO
+// We only print to stdout if verbose is true, and it is also written to the event log
+func Success(logStrings ...interface{}) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	if verbose {
+		printConsole(logStrings...)
 	}
-	log.Println(logStrings...)
+	write(levelInfo, fmt.Sprintln(logStrings...))
+	writeEvent(levelInfo, fmt.Sprintln(logStrings...))
 }
 
 // Error logs a string a ERROR
-// We print to stdout, write to disk, and then panic
+// We print to stdout, write to disk, add it to GorillaReport, and then panic
 func Error(logStrings ...interface{}) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	report.Errors = append(report.Errors, strings.TrimSpace(fmt.Sprintln(logStrings...)))
 	if checkonly {
 		return
 	}
-	log.SetPrefix("ERROR: ")
-	log.Panic(logStrings...)
+	msg := fmt.Sprint(logStrings...)
+	write(levelError, msg)
+	writeEvent(levelError, msg)
+	panic(msg)
 }
@@ -1,10 +1,15 @@
 package gorillalog
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/1dustindavis/gorilla/pkg/config"
 )
@@ -40,6 +45,120 @@ func TestNewLog(t *testing.T) {
 	}
 }
 
+// TestLevels verifies only messages at or above the configured level are written, as text or JSON
+func TestLevels(t *testing.T) {
+	origOutput, origLevel, origJSON := logOutput, fileLevel, jsonFormat
+	defer func() { logOutput, fileLevel, jsonFormat, verbose = origOutput, origLevel, origJSON, false }()
+	var buf bytes.Buffer
+	logOutput, verbose = &buf, false
+
+	fileLevel = parseLevel("warning")
+	Info("Info String!")
+	Warn("Warn String!")
+	if have := buf.String(); strings.Contains(have, "Info String!") || !strings.HasPrefix(have, "WARN: ") || !strings.HasSuffix(have, " Warn String!\n") {
+		t.Errorf("Unexpected log: %q", have)
+	}
+
+	buf.Reset()
+	fileLevel, jsonFormat = parseLevel("debug"), true
+	Debug("Debug", "String!")
+	var line struct {
+		Time    string `json:"time"`
+		Level   string `json:"level"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := line.Level+" "+line.Message, "debug Debug String!"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+	if line.Time == "" {
+		t.Error("Expected a time")
+	}
+
+	if have, want := parseLevel("unknown"), levelInfo; have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+}
+
+// TestRotate verifies the log is rotated once it is too large, keeping only the newest backups
+func TestRotate(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "gorillalog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	logPath := filepath.Join(tmpDir, "gorilla.log")
+
+	logFile, err := openRotating(logPath, 100, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logFile.file.Close()
+	for i := 0; i < 5; i++ {
+		logFile.Write([]byte(strings.Repeat("x", 59) + "\n"))
+		// Rotated files are named by time, so give each one a unique name
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	if have, want := len(logFile.backups()), 2; have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+	info, err := os.Stat(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := info.Size(), int64(60); have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+}
+
+// fakeEvents records each event written to the event log
+type fakeEvents []string
+
+func (f *fakeEvents) Info(eid uint32, msg string) error {
+	*f = append(*f, fmt.Sprint("INFO ", eid, " ", msg))
+	return nil
+}
+
+func (f *fakeEvents) Warning(eid uint32, msg string) error {
+	*f = append(*f, fmt.Sprint("WARN ", eid, " ", msg))
+	return nil
+}
+
+func (f *fakeEvents) Error(eid uint32, msg string) error {
+	*f = append(*f, fmt.Sprint("ERROR ", eid, " ", msg))
+	return nil
+}
+
+// TestEventLog verifies warnings, errors, and install results are written to the event log, and nothing else is
+func TestEventLog(t *testing.T) {
+	origOutput := logOutput
+	defer func() { logOutput, events = origOutput, nil }()
+	logOutput = ioutil.Discard
+	var recorded fakeEvents
+	events = &recorded
+
+	Debug("Debug String!")
+	Info("Info String!")
+	Success("Example 1.0 Installation SUCCESSFUL")
+	Warn("Example 1.0 Installation FAILED")
+	func() {
+		defer func() { recover() }()
+		Error("Error String!")
+	}()
+
+	want := []string{
+		"INFO 100 Example 1.0 Installation SUCCESSFUL",
+		"WARN 200 Example 1.0 Installation FAILED",
+		"ERROR 300 Error String!",
+	}
+	if have := fmt.Sprint(recorded); have != fmt.Sprint(want) {
+		t.Errorf("have %s, want %s", have, want)
+	}
+}
+
 // ExampleDebug_off tests the output of a log sent to DEBUG while config.Debug is false
 func ExampleDebug_off() {
 	// Set up what we expect
@@ -0,0 +1,96 @@
+package gorillalog
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// rotatingFile is a log file that is moved aside once it reaches a maximum size
+// Rotated files are named after the time they were rotated, and removed once there are too many or they are too old
+type rotatingFile struct {
+	path       string
+	file       *os.File
+	size       int64
+	maxSize    int64
+	maxBackups int
+	maxAge     time.Duration
+}
+
+// openRotating opens a log file for appending
+// A max size of zero never rotates, and a max backups or max age of zero keeps every rotated file
+func openRotating(path string, maxSize int64, maxBackups int, maxAge time.Duration) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups, maxAge: maxAge}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	r.prune()
+	return r, nil
+}
+
+// open opens the log file, and keeps track of how large it already is
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.file, r.size = f, info.Size()
+	return nil
+}
+
+// Write appends to the log file, rotating it first if the message would make it too large
+// A failed rotation keeps writing to the current file, since losing messages is worse than a large log
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		r.rotate()
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate moves the log file aside and starts a new one
+func (r *rotatingFile) rotate() {
+	ext := filepath.Ext(r.path)
+	backup := strings.TrimSuffix(r.path, ext) + "." + time.Now().Format("20060102T150405.000") + ext
+	r.file.Close()
+	err := os.Rename(r.path, backup)
+	if openErr := r.open(); openErr != nil {
+		// Without a file there is nowhere left to write, so fall back to stderr until the next run
+		r.file, r.size, r.maxSize = os.Stderr, 0, 0
+		return
+	}
+	if err == nil {
+		r.prune()
+	}
+}
+
+// backups returns the rotated log files, oldest first
+func (r *rotatingFile) backups() []string {
+	ext := filepath.Ext(r.path)
+	matches, _ := filepath.Glob(strings.TrimSuffix(r.path, ext) + ".*" + ext)
+	sort.Strings(matches)
+	return matches
+}
+
+// prune removes rotated log files beyond the maximum count or age
+func (r *rotatingFile) prune() {
+	backups := r.backups()
+	for i, backup := range backups {
+		tooMany := r.maxBackups > 0 && len(backups)-i > r.maxBackups
+		tooOld := false
+		if info, err := os.Stat(backup); err == nil && r.maxAge > 0 {
+			tooOld = time.Since(info.ModTime()) > r.maxAge
+		}
+		if tooMany || tooOld {
+			os.Remove(backup)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package identity
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// These are variables so we can override them when testing
+var (
+	loginURL = "https://login.microsoftonline.com"
+	graphURL = "https://graph.microsoft.com"
+)
+
+// Graph looks up the Azure AD groups a device is in with Microsoft Graph
+// The app registration needs the Device.Read.All and GroupMember.Read.All application permissions
+type Graph struct {
+	TenantID     string
+	ClientID     string
+	ClientSecret string
+	Client       *http.Client
+}
+
+// Groups returns the names of the Azure AD groups a device is in, including groups inside groups
+func (g Graph) Groups(deviceID string) ([]string, error) {
+	if g.TenantID == "" || g.ClientID == "" || g.ClientSecret == "" {
+		return nil, fmt.Errorf("graph_tenant_id, graph_client_id, and graph_client_secret are required")
+	}
+	token, err := g.token()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get a token for Microsoft Graph: %v", err)
+	}
+
+	// The device id dsregcmd reports isnt the id of the device object, so that is looked up first
+	var devices struct {
+		Value []struct {
+			ID string `json:"id"`
+		} `json:"value"`
+	}
+	filter := "deviceId eq '" + strings.ReplaceAll(deviceID, "'", "''") + "'"
+	devicesURL := graphURL + "/v1.0/devices?$filter=" + strings.ReplaceAll(url.QueryEscape(filter), "+", "%20") + "&$select=id"
+	if err := g.get(token, devicesURL, &devices); err != nil {
+		return nil, err
+	}
+	if len(devices.Value) == 0 {
+		return nil, fmt.Errorf("device %s was not found in Azure AD", deviceID)
+	}
+
+	// Graph returns a page at a time, with a link to the next one
+	var groups []string
+	next := graphURL + "/v1.0/devices/" + url.PathEscape(devices.Value[0].ID) + "/transitiveMemberOf/microsoft.graph.group?$select=displayName"
+	for next != "" {
+		var page struct {
+			Value []struct {
+				DisplayName string `json:"displayName"`
+			} `json:"value"`
+			NextLink string `json:"@odata.nextLink"`
+		}
+		if err := g.get(token, next, &page); err != nil {
+			return nil, err
+		}
+		for _, group := range page.Value {
+			groups = append(groups, group.DisplayName)
+		}
+		next = page.NextLink
+	}
+	return groups, nil
+}
+
+// token requests a token for Graph with the OAuth2 client credentials flow
+func (g Graph) token() (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", g.ClientID)
+	form.Set("client_secret", g.ClientSecret)
+	form.Set("scope", graphURL+"/.default")
+	resp, err := g.client().PostForm(loginURL+"/"+url.PathEscape(g.TenantID)+"/oauth2/v2.0/token", form)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token status code: %d", resp.StatusCode)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("no access token in the response")
+	}
+	return token.AccessToken, nil
+}
+
+// get requests a url from Graph and decodes the json it returns
+func (g Graph) get(token string, rawURL string, v interface{}) error {
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	resp, err := g.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: status code %d: %s", rawURL, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, v)
+}
+
+func (g Graph) client() *http.Client {
+	if g.Client == nil {
+		return http.DefaultClient
+	}
+	return g.Client
+}
@@ -0,0 +1,67 @@
+package identity
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// TestGraphGroups verifies the device is looked up by its device id, and every page of its groups is read
+func TestGraphGroups(t *testing.T) {
+	origLogin, origGraph := loginURL, graphURL
+	defer func() { loginURL, graphURL = origLogin, origGraph }()
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/tenant/oauth2/v2.0/token":
+			r.ParseForm()
+			if r.PostForm.Get("client_secret") != "pizza" || r.PostForm.Get("scope") != server.URL+"/.default" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"access_token": "graph-token", "token_type": "Bearer", "expires_in": 3600}`)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer graph-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.URL.Path == "/v1.0/devices" && r.URL.Query().Get("$filter") == "deviceId eq 'device-id'":
+			fmt.Fprint(w, `{"value": [{"id": "object-id"}]}`)
+		case r.URL.Path == "/v1.0/devices/object-id/transitiveMemberOf/microsoft.graph.group" && r.URL.Query().Get("page") == "":
+			fmt.Fprintf(w, `{"value": [{"displayName": "SW-AutoCAD"}], "@odata.nextLink": "%s/v1.0/devices/object-id/transitiveMemberOf/microsoft.graph.group?page=2"}`, server.URL)
+		case r.URL.Path == "/v1.0/devices/object-id/transitiveMemberOf/microsoft.graph.group":
+			fmt.Fprint(w, `{"value": [{"displayName": "All Laptops"}]}`)
+		case r.URL.Path == "/v1.0/devices":
+			fmt.Fprint(w, `{"value": []}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	loginURL, graphURL = server.URL, server.URL
+
+	graph := Graph{TenantID: "tenant", ClientID: "gorilla", ClientSecret: "pizza", Client: server.Client()}
+	groups, err := graph.Groups("device-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := groups, []string{"SW-AutoCAD", "All Laptops"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %v, want %v", have, want)
+	}
+
+	// A device that isnt in Azure AD is an error
+	if _, err := graph.Groups("unknown-device"); err == nil {
+		t.Error("expected an error for an unknown device")
+	}
+
+	// So is a secret Azure AD doesnt accept
+	graph.ClientSecret = "pasta"
+	if _, err := graph.Groups("device-id"); err == nil {
+		t.Error("expected an error for a denied token")
+	}
+}
@@ -0,0 +1,225 @@
+// Package identity works out which computer gorilla is running on, so a manifest can be picked for it
+// without writing a configuration for each one, like `manifest: serials/%serial%`
+package identity
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Identity is everything that identifies a computer, and anything that cant be found is left empty
+type Identity struct {
+	Hostname string
+	// Serial is the serial number in the firmware, which is empty if the manufacturer didnt set a real one
+	Serial string
+	// OU is the name of the Active Directory OU the computer is in, like "Laptops"
+	OU string
+	// AzureDeviceID is the id of the computer in Azure AD, if it is joined or registered
+	AzureDeviceID string
+}
+
+// These abstractions allow us to override when testing
+var (
+	hostname      = os.Hostname
+	lookupSerial  = serialNumber
+	lookupDN      = distinguishedName
+	lookupAzureID = azureDeviceID
+	lookupGroups  = groupMembership
+)
+
+// Get looks up everything that identifies this computer
+func Get() Identity {
+	var id Identity
+	if name, err := hostname(); err == nil {
+		id.Hostname = strings.ToLower(name)
+	}
+	if serial, err := lookupSerial(); err == nil {
+		id.Serial = serial
+	}
+	if dn, err := lookupDN(); err == nil {
+		id.OU = firstOU(dn)
+	}
+	if deviceID, err := lookupAzureID(); err == nil {
+		id.AzureDeviceID = deviceID
+	}
+	return id
+}
+
+// ADGroups returns the names of the Active Directory groups this computer is in, including groups inside groups
+// Windows saves them each time Group Policy applies, and the computer only sees a group it was just added to after it restarts
+func ADGroups() ([]string, error) {
+	return lookupGroups()
+}
+
+// placeholder matches a placeholder like %serial%
+var placeholder = regexp.MustCompile(`%([A-Za-z_]+)%`)
+
+// HasPlaceholders returns true if a value has any placeholders to fill in
+func HasPlaceholders(value string) bool {
+	return placeholder.MatchString(value)
+}
+
+// values returns the value for each placeholder
+func (id Identity) values() map[string]string {
+	return map[string]string{
+		"hostname":        id.Hostname,
+		"serial":          id.Serial,
+		"ou":              id.OU,
+		"azure_device_id": id.AzureDeviceID,
+	}
+}
+
+// Expand fills in the placeholders in a value, like `serials/%serial%`
+// Each value is made safe for a file name, and a placeholder this computer has no value for is an error
+func (id Identity) Expand(value string) (string, error) {
+	values := id.values()
+	var expandErr error
+	expanded := placeholder.ReplaceAllStringFunc(value, func(match string) string {
+		name := strings.ToLower(strings.Trim(match, "%"))
+		v, ok := values[name]
+		if !ok && expandErr == nil {
+			var names []string
+			for name := range values {
+				names = append(names, "%"+name+"%")
+			}
+			sort.Strings(names)
+			expandErr = fmt.Errorf("unknown placeholder %s, expected one of %s", match, strings.Join(names, ", "))
+		} else if v == "" && expandErr == nil {
+			expandErr = fmt.Errorf("unable to determine %s for this computer", match)
+		}
+		return safeName(v)
+	})
+	if expandErr != nil {
+		return "", expandErr
+	}
+	return expanded, nil
+}
+
+// safeName replaces characters that cant be in a file name, so a value cant point somewhere else in the repo
+func safeName(value string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|#%`, r) || r < ' ' {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(value))
+}
+
+// placeholderSerials are what manufacturers leave in the firmware when they dont set a serial number
+var placeholderSerials = map[string]bool{
+	"0":                      true,
+	"none":                   true,
+	"default string":         true,
+	"not specified":          true,
+	"not applicable":         true,
+	"system serial number":   true,
+	"chassis serial number":  true,
+	"to be filled by o.e.m.": true,
+	"0123456789":             true,
+	"123456789":              true,
+}
+
+// smbiosSerial returns the system serial number from raw SMBIOS data, as returned by GetSystemFirmwareTable
+// See the System Information (Type 1) structure in the SMBIOS specification
+func smbiosSerial(data []byte) string {
+	// The table follows an 8 byte header, which ends with its length
+	if len(data) < 8 {
+		return ""
+	}
+	table := data[8:]
+	if length := int(binary.LittleEndian.Uint32(data[4:8])); length < len(table) {
+		table = table[:length]
+	}
+
+	for len(table) >= 4 {
+		structType, size := table[0], int(table[1])
+		if size < 4 || size > len(table) {
+			return ""
+		}
+		// The strings come after the formatted part of the structure, and end with two nulls
+		end := size
+		for end+1 < len(table) && (table[end] != 0 || table[end+1] != 0) {
+			end++
+		}
+		if end+1 >= len(table) {
+			return ""
+		}
+		switch structType {
+		case 1:
+			if size <= 7 {
+				return ""
+			}
+			serial := strings.TrimSpace(smbiosString(table[size:end], table[7]))
+			if placeholderSerials[strings.ToLower(serial)] {
+				return ""
+			}
+			return serial
+		case 127:
+			// End of table
+			return ""
+		}
+		table = table[end+2:]
+	}
+	return ""
+}
+
+// smbiosString returns a string from the strings of a structure, which are numbered from one
+func smbiosString(strs []byte, index byte) string {
+	if index == 0 {
+		return ""
+	}
+	parts := strings.Split(string(strs), "\x00")
+	if int(index) > len(parts) {
+		return ""
+	}
+	return parts[index-1]
+}
+
+// firstOU returns the name of the OU a computer is directly in, from its distinguished name
+// like `CN=PC01,OU=Laptops,OU=Computers,DC=example,DC=com`
+func firstOU(dn string) string {
+	for _, rdn := range splitDN(dn) {
+		parts := strings.SplitN(rdn, "=", 2)
+		if len(parts) == 2 && strings.EqualFold(strings.TrimSpace(parts[0]), "OU") {
+			return strings.TrimSpace(parts[1])
+		}
+	}
+	return ""
+}
+
+// splitDN splits a distinguished name on the commas that arent escaped, and unescapes each part
+func splitDN(dn string) []string {
+	var parts []string
+	var part strings.Builder
+	escaped := false
+	for _, r := range dn {
+		switch {
+		case escaped:
+			part.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == ',':
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteRune(r)
+		}
+	}
+	return append(parts, part.String())
+}
+
+// parseDeviceID returns the DeviceId from the output of `dsregcmd /status`
+func parseDeviceID(output string) string {
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) == 2 && strings.TrimSpace(parts[0]) == "DeviceId" {
+			return strings.TrimSpace(parts[1])
+		}
+	}
+	return ""
+}
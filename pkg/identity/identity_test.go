@@ -0,0 +1,116 @@
+package identity
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestGet verifies that each lookup is used, and anything that cant be found is left empty
+func TestGet(t *testing.T) {
+	origHostname, origSerial, origDN, origAzureID := hostname, lookupSerial, lookupDN, lookupAzureID
+	defer func() {
+		hostname, lookupSerial, lookupDN, lookupAzureID = origHostname, origSerial, origDN, origAzureID
+	}()
+
+	hostname = func() (string, error) { return "LAB-PC01", nil }
+	lookupSerial = func() (string, error) { return "5CG1234XYZ", nil }
+	lookupDN = func() (string, error) { return `CN=LAB-PC01,OU=Labs\, West,OU=Computers,DC=example,DC=com`, nil }
+	lookupAzureID = func() (string, error) { return "", fmt.Errorf("not joined to Azure AD") }
+
+	expected := Identity{Hostname: "lab-pc01", Serial: "5CG1234XYZ", OU: "Labs, West"}
+	if have, want := Get(), expected; have != want {
+		t.Errorf("have %#v, want %#v", have, want)
+	}
+}
+
+// TestExpand verifies placeholders are filled in safely, and missing or unknown ones are errors
+func TestExpand(t *testing.T) {
+	id := Identity{Hostname: "lab-pc01", Serial: "5CG/1234", OU: "Labs"}
+
+	for _, test := range []struct {
+		value   string
+		want    string
+		wantErr string
+	}{
+		{value: "site_default", want: "site_default"},
+		{value: "serials/%serial%", want: "serials/5CG_1234"},
+		{value: "%OU%/%hostname%", want: "Labs/lab-pc01"},
+		{value: "devices/%azure_device_id%", wantErr: "unable to determine %azure_device_id%"},
+		{value: "%asset_tag%", wantErr: "unknown placeholder %asset_tag%"},
+	} {
+		have, err := id.Expand(test.value)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("expected an error containing %q for %s, got %v", test.wantErr, test.value, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for %s: %v", test.value, err)
+		}
+		if have != test.want {
+			t.Errorf("have %s, want %s", have, test.want)
+		}
+	}
+
+	if !HasPlaceholders("serials/%serial%") || HasPlaceholders("site_default") {
+		t.Error("HasPlaceholders did not find the placeholders")
+	}
+}
+
+// smbiosTable builds raw SMBIOS data like GetSystemFirmwareTable returns
+func smbiosTable(structures ...[]byte) []byte {
+	var table []byte
+	for _, s := range structures {
+		table = append(table, s...)
+	}
+	header := make([]byte, 8)
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(table)))
+	return append(header, table...)
+}
+
+// TestSMBIOSSerial verifies the serial number is read from the System Information structure
+func TestSMBIOSSerial(t *testing.T) {
+	// A BIOS Information structure without strings, then System Information with the serial as its third string
+	bios := []byte{0, 4, 0, 0, 0, 0}
+	system := append([]byte{1, 8, 1, 0, 1, 2, 0, 3}, "HP\x00EliteBook\x00 5CG1234XYZ \x00\x00"...)
+	end := []byte{127, 4, 2, 0, 0, 0}
+
+	if have, want := smbiosSerial(smbiosTable(bios, system, end)), "5CG1234XYZ"; have != want {
+		t.Errorf("have %q, want %q", have, want)
+	}
+
+	// Manufacturers that dont set a serial number leave a placeholder
+	placeholder := append([]byte{1, 8, 1, 0, 0, 0, 0, 1}, "To Be Filled By O.E.M.\x00\x00"...)
+	if have := smbiosSerial(smbiosTable(bios, placeholder, end)); have != "" {
+		t.Errorf("expected no serial, got %q", have)
+	}
+
+	// A table without System Information, or one that is cut short
+	if have := smbiosSerial(smbiosTable(bios, end)); have != "" {
+		t.Errorf("expected no serial, got %q", have)
+	}
+	if have := smbiosSerial(smbiosTable(system[:10])); have != "" {
+		t.Errorf("expected no serial, got %q", have)
+	}
+}
+
+// TestParseDeviceID verifies the device id is found in the output of dsregcmd
+func TestParseDeviceID(t *testing.T) {
+	output := "+----------------------------------------------------------------------+\r\n" +
+		"| Device State                                                         |\r\n" +
+		"+----------------------------------------------------------------------+\r\n" +
+		"\r\n" +
+		"             AzureAdJoined : YES\r\n" +
+		"          EnterpriseJoined : NO\r\n" +
+		"                  DeviceId : 3f1b2c4d-5e6f-7a8b-9c0d-e1f2a3b4c5d6\r\n" +
+		"                Thumbprint : 0123456789ABCDEF0123456789ABCDEF01234567\r\n"
+	if have, want := parseDeviceID(output), "3f1b2c4d-5e6f-7a8b-9c0d-e1f2a3b4c5d6"; have != want {
+		t.Errorf("have %q, want %q", have, want)
+	}
+	if have := parseDeviceID("             AzureAdJoined : NO\r\n"); have != "" {
+		t.Errorf("expected no device id, got %q", have)
+	}
+}
@@ -0,0 +1,90 @@
+//go:build windows
+// +build windows
+
+package identity
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	registry "golang.org/x/sys/windows/registry"
+)
+
+var procGetSystemFirmwareTable = windows.NewLazySystemDLL("kernel32.dll").NewProc("GetSystemFirmwareTable")
+
+// firmwareRSMB is the provider signature for the raw SMBIOS table
+const firmwareRSMB = 'R'<<24 | 'S'<<16 | 'M'<<8 | 'B'
+
+// serialNumber reads the serial number from the SMBIOS table, the same one Win32_BIOS reports
+func serialNumber() (string, error) {
+	size, _, err := procGetSystemFirmwareTable.Call(firmwareRSMB, 0, 0, 0)
+	if size == 0 {
+		return "", err
+	}
+	data := make([]byte, size)
+	read, _, err := procGetSystemFirmwareTable.Call(firmwareRSMB, 0, uintptr(unsafe.Pointer(&data[0])), size)
+	if read == 0 || read > size {
+		return "", err
+	}
+	return smbiosSerial(data[:read]), nil
+}
+
+// distinguishedName returns the computer's distinguished name, which Group Policy saves each time it applies
+func distinguishedName() (string, error) {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, `SOFTWARE\Microsoft\Windows\CurrentVersion\Group Policy\State\Machine`, registry.QUERY_VALUE)
+	if err != nil {
+		return "", err
+	}
+	defer key.Close()
+	dn, _, err := key.GetStringValue("Distinguished-Name")
+	return dn, err
+}
+
+// azureDeviceID asks dsregcmd for the computer's Azure AD device id
+func azureDeviceID() (string, error) {
+	out, err := exec.Command(filepath.Join(os.Getenv("WINDIR"), "system32", "dsregcmd.exe"), "/status").Output()
+	if err != nil {
+		return "", err
+	}
+	deviceID := parseDeviceID(string(out))
+	if deviceID == "" {
+		return "", fmt.Errorf("not joined to Azure AD")
+	}
+	return deviceID, nil
+}
+
+// groupMembership returns the names of the groups in the computer's token, which Group Policy saves each time it applies
+func groupMembership() ([]string, error) {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, `SOFTWARE\Microsoft\Windows\CurrentVersion\Group Policy\GroupMembership`, registry.QUERY_VALUE)
+	if err != nil {
+		return nil, err
+	}
+	defer key.Close()
+	count, _, err := key.GetIntegerValue("Count")
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []string
+	for i := uint64(0); i < count; i++ {
+		sidString, _, err := key.GetStringValue(fmt.Sprintf("Group%d", i))
+		if err != nil {
+			continue
+		}
+		sid, err := windows.StringToSid(sidString)
+		if err != nil {
+			continue
+		}
+		// Groups that cant be looked up, like ones from a domain that cant be reached, are left out
+		name, _, _, err := sid.LookupAccount("")
+		if err != nil {
+			continue
+		}
+		groups = append(groups, name)
+	}
+	return groups, nil
+}
@@ -0,0 +1,24 @@
+// Without a darwin specific build, go tools will try to include Windows libraries and fail
+
+//go:build !windows
+// +build !windows
+
+package identity
+
+import "fmt"
+
+func serialNumber() (string, error) {
+	return "", fmt.Errorf("serial numbers are only available on Windows")
+}
+
+func distinguishedName() (string, error) {
+	return "", fmt.Errorf("Active Directory is only available on Windows")
+}
+
+func azureDeviceID() (string, error) {
+	return "", fmt.Errorf("Azure AD is only available on Windows")
+}
+
+func groupMembership() ([]string, error) {
+	return nil, fmt.Errorf("Active Directory is only available on Windows")
+}
@@ -0,0 +1,37 @@
+//go:build windows
+// +build windows
+
+package installer
+
+import (
+	registry "golang.org/x/sys/windows/registry"
+)
+
+// activeSetupKey holds the commands Windows runs once for each user as they log on
+// A command runs again for a user when its version is higher than the last one they ran
+const activeSetupKey = `SOFTWARE\Microsoft\Active Setup\Installed Components\`
+
+// setActiveSetup registers a command to run as each user logs on
+func setActiveSetup(id, name, version, command string) error {
+	key, _, err := registry.CreateKey(registry.LOCAL_MACHINE, activeSetupKey+id, registry.SET_VALUE)
+	if err != nil {
+		return err
+	}
+	defer key.Close()
+
+	for valueName, value := range map[string]string{"": name, "StubPath": command, "Version": version} {
+		if err := key.SetStringValue(valueName, value); err != nil {
+			return err
+		}
+	}
+	return key.SetDWordValue("IsInstalled", 1)
+}
+
+// removeActiveSetup removes a command registered with setActiveSetup, if there is one
+func removeActiveSetup(id string) error {
+	err := registry.DeleteKey(registry.LOCAL_MACHINE, activeSetupKey+id)
+	if err == registry.ErrNotExist {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,16 @@
+// Without a darwin specific build, go tools will try to include Windows libraries and fail
+
+//go:build !windows
+// +build !windows
+
+package installer
+
+import "fmt"
+
+func setActiveSetup(id, name, version, command string) error {
+	return fmt.Errorf("installing at logon is not supported on this platform")
+}
+
+func removeActiveSetup(id string) error {
+	return nil
+}
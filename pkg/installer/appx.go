@@ -0,0 +1,67 @@
+package installer
+
+import (
+	"strings"
+
+	"github.com/1dustindavis/gorilla/pkg/catalog"
+)
+
+// isAppx returns true for the appx and msix installer types, which are installed the same way
+func isAppx(installerType string) bool {
+	return installerType == "appx" || installerType == "msix"
+}
+
+// appxPackageName returns the package name of an appx or msix item, from its installer or uninstaller
+func appxPackageName(item catalog.Item) string {
+	if item.Installer.PackageName != "" {
+		return item.Installer.PackageName
+	}
+	return item.Uninstaller.PackageName
+}
+
+// psQuote returns a string as a single quoted PowerShell literal
+func psQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// appxInstallCommand returns the command that installs an appx or msix package
+// By default the package is provisioned with DISM, so every user gets the app when they sign in.
+// An installer that runs as the user, or as each user logs on, adds the package for that user only.
+func appxInstallCommand(installerItem catalog.InstallerItem, absFile string, arguments []string) (string, []string) {
+	if installerItem.RunAs == "user" || installerItem.RunAs == runAsLogon {
+		psCommand := "Add-AppxPackage -Path " + psQuote(absFile)
+		if len(arguments) > 0 {
+			psCommand += " " + strings.Join(arguments, " ")
+		}
+		return commandPs1, []string{"-NoProfile", "-NoLogo", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-Command", psCommand}
+	}
+
+	installArgs := []string{"/Online", "/Add-ProvisionedAppxPackage", "/PackagePath:" + absFile}
+
+	// DISM requires a license, unless we tell it to skip it
+	skipLicense := true
+	for _, arg := range arguments {
+		if strings.HasPrefix(strings.ToLower(arg), "/licensepath:") {
+			skipLicense = false
+		}
+	}
+	if skipLicense {
+		installArgs = append(installArgs, "/SkipLicense")
+	}
+	return commandDism, append(installArgs, arguments...)
+}
+
+// appxUninstallCommand returns the command that removes an appx or msix package by name
+// The provisioned package is removed too, so the app isnt added back for new users
+func appxUninstallCommand(uninstallerItem catalog.InstallerItem, packageName string) (string, []string) {
+	name := psQuote(packageName)
+	var psCommand string
+	if uninstallerItem.RunAs == "user" || uninstallerItem.RunAs == runAsLogon {
+		psCommand = "Get-AppxPackage -Name " + name + " | Remove-AppxPackage"
+	} else {
+		psCommand = "$ErrorActionPreference = 'Stop'; " +
+			"Get-AppxProvisionedPackage -Online | Where-Object DisplayName -eq " + name + " | Remove-AppxProvisionedPackage -Online -AllUsers; " +
+			"Get-AppxPackage -AllUsers -Name " + name + " | Remove-AppxPackage -AllUsers"
+	}
+	return commandPs1, []string{"-NoProfile", "-NoLogo", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-Command", psCommand}
+}
@@ -3,42 +3,209 @@ package installer
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
-	"path"
 	"path/filepath"
 	"strings"
 	"sync"
+	"text/template"
+	"time"
 
 	"github.com/1dustindavis/gorilla/pkg/catalog"
+	"github.com/1dustindavis/gorilla/pkg/config"
 	"github.com/1dustindavis/gorilla/pkg/download"
 	"github.com/1dustindavis/gorilla/pkg/gorillalog"
+	"github.com/1dustindavis/gorilla/pkg/progress"
 	"github.com/1dustindavis/gorilla/pkg/report"
+	"github.com/1dustindavis/gorilla/pkg/signature"
+	"github.com/1dustindavis/gorilla/pkg/state"
 	"github.com/1dustindavis/gorilla/pkg/status"
 )
 
+const (
+	// How long an installer can run when neither the item nor the configuration set installer_timeout
+	defaultInstallerTimeout = time.Hour
+
+	// How long we keep reading the output of a command that was killed
+	outputGrace = 5 * time.Second
+
+	// How many times a bootstrap run tries an item when max_install_failures isnt set
+	bootstrapMaxFailures = 3
+)
+
 var (
 	// Base command for each installer type
 	commandNupkg = filepath.Join(os.Getenv("ProgramData"), "chocolatey/bin/choco.exe")
 	commandMsi   = filepath.Join(os.Getenv("WINDIR"), "system32/", "msiexec.exe")
 	commandPs1   = filepath.Join(os.Getenv("WINDIR"), "system32/", "WindowsPowershell", "v1.0", "powershell.exe")
+	commandDism  = filepath.Join(os.Getenv("WINDIR"), "system32/", "Dism.exe")
+	commandCmd   = filepath.Join(os.Getenv("WINDIR"), "system32/", "cmd.exe")
 
 	// These abstractions allows us to override when testing
 	execCommand       = exec.Command
 	statusCheckStatus = status.CheckStatus
+	statusForget      = status.Forget
 	runCommand        = runCMD
+	runningProcesses  = processNames
+	killProcess       = killProcesses
+	timeAfter         = time.After
+	downloadGet       = download.Get
 
 	// Stores url where we will download an item
 	installerURL   string
 	uninstallerURL string
+
+	// A package level copy of our config for the `installer` package to reference
+	installerCfg config.Configuration
+
+	// How many items were installed, updated, or removed successfully this run
+	changes int
+
+	// Cancelling this context kills any command that is running, and skips the items that havent started
+	runContext = context.Background()
 )
 
+// SetConfig accepts a configuration struct that all functions in the `installer` package will use
+func SetConfig(cfg config.Configuration) {
+	installerCfg = cfg
+	forceClosing = time.Time{}
+}
+
+// SetContext sets the context that stops installs when the run is interrupted
+func SetContext(ctx context.Context) {
+	runContext = ctx
+}
+
+// downloadTimeout returns the item's download timeout
+// Zero lets the `download` package use the configured default
+func downloadTimeout(item catalog.Item) time.Duration {
+	return time.Duration(item.DownloadTimeout) * time.Second
+}
+
+// installerTimeout returns the item's installer timeout, or the configured one if unset
+// Without either an installer gets an hour, so one that hangs cant hold up the run forever
+func installerTimeout(item catalog.Item) time.Duration {
+	if item.InstallerTimeout > 0 {
+		return time.Duration(item.InstallerTimeout) * time.Second
+	}
+	if installerCfg.InstallerTimeout > 0 {
+		return time.Duration(installerCfg.InstallerTimeout) * time.Second
+	}
+	return defaultInstallerTimeout
+}
+
+// runOptions holds the optional settings used when running a command
+type runOptions struct {
+	// Timeout kills the command once it expires, zero means no limit
+	Timeout time.Duration
+	// Dir is the working directory, empty means our own working directory
+	Dir string
+	// RunAs is either "system" (the default) or "user"
+	RunAs string
+	// SuccessCodes are exit codes other than zero that mean the command succeeded
+	SuccessCodes []int
+	// RebootCodes are exit codes that mean the command succeeded, but needs a restart to finish
+	RebootCodes []int
+	// OnExit is called with the exit code of a command that ran, whether or not it succeeded
+	OnExit func(code int)
+	// LogFile is replaced with everything the command writes to stdout and stderr
+	LogFile string
+	// OnFailure is called with the last lines of output from a command that failed
+	OnFailure func(output []string)
+}
+
+// Exit codes msiexec uses when it succeeds, which are used unless an msi sets its own
+var (
+	// ERROR_INSTALL_SUCCESS
+	msiSuccessCodes = []int{1707}
+	// ERROR_SUCCESS_REBOOT_REQUIRED and ERROR_SUCCESS_REBOOT_INITIATED
+	msiRebootCodes = []int{3010, 1641}
+)
+
+// commandOptions returns the options for running an item's installer or uninstaller
+// The working directory defaults to the directory the installer was downloaded to
+func commandOptions(item catalog.Item, installerItem catalog.InstallerItem, absFile string) runOptions {
+	options := runOptions{
+		Timeout:      installerTimeout(item),
+		Dir:          installerItem.WorkingDirectory,
+		RunAs:        installerItem.RunAs,
+		SuccessCodes: installerItem.SuccessCodes,
+		RebootCodes:  installerItem.RebootExitCodes,
+	}
+	if installerItem.Type == "msi" {
+		if options.SuccessCodes == nil {
+			options.SuccessCodes = msiSuccessCodes
+		}
+		if options.RebootCodes == nil {
+			options.RebootCodes = msiRebootCodes
+		}
+	}
+	options.OnExit = exitCodeRecorder(item, options.RebootCodes)
+	if options.Dir == "" && absFile != "" {
+		options.Dir = filepath.Dir(absFile)
+	}
+	return options
+}
+
+// exitCodeRecorder returns a function that keeps an item's exit code for the report,
+// and notes that a restart is required when the code says so
+func exitCodeRecorder(item catalog.Item, rebootCodes []int) func(int) {
+	return func(code int) {
+		if code == 0 {
+			return
+		}
+		report.ExitCodes[item.DisplayName] = code
+		if successCode(code, rebootCodes) {
+			gorillalog.Info(item.DisplayName, "exited with", code, "which needs a restart")
+			addRestart(item.DisplayName, RestartRequired)
+		}
+	}
+}
+
 // runCommand executes a command and it's argurments in the CMD environment
-func runCMD(command string, arguments []string) (string, error) {
+// Running as "user" launches the command with the token of the user logged in to the console.
+// That process can be inspected and controlled by the user, so it should only be used for
+// installers that must write to the user's profile and never with secrets in the arguments.
+func runCMD(command string, arguments []string, options runOptions) (string, error) {
 	cmd := execCommand(command, arguments...)
+	cmd.Dir = options.Dir
+
+	// Switch to the logged in user if requested
+	switch strings.ToLower(options.RunAs) {
+	case "", "system":
+	case "user":
+		closeToken, err := runAsUser(cmd)
+		if err != nil {
+			gorillalog.Warn("command:", command, arguments)
+			gorillalog.Warn("Unable to run as user:", err)
+			return "", err
+		}
+		defer closeToken()
+	default:
+		err := fmt.Errorf("unsupported run_as value: %s", options.RunAs)
+		gorillalog.Warn(err)
+		return "", err
+	}
+
+	// Everything the command writes is saved to the log file, if there is one
+	var logFile io.Writer = ioutil.Discard
+	if options.LogFile != "" {
+		file, err := os.Create(options.LogFile)
+		if err != nil {
+			gorillalog.Warn("Unable to create output log:", options.LogFile, err)
+		} else {
+			defer file.Close()
+			logFile = file
+		}
+	}
+
 	var cmdOutput string
+	var stderr bytes.Buffer
+	cmd.Stderr = io.MultiWriter(&stderr, logFile)
 	cmdReader, err := cmd.StdoutPipe()
 	if err != nil {
 		gorillalog.Warn("command:", command, arguments)
@@ -49,34 +216,157 @@ func runCMD(command string, arguments []string) (string, error) {
 	wg.Add(1)
 
 	scanner := bufio.NewScanner(cmdReader)
+	stdout := tail{max: outputLines}
 	gorillalog.Debug("command:", command, arguments)
 	go func() {
 		gorillalog.Debug("Command Output:")
 		gorillalog.Debug("--------------------")
 		for scanner.Scan() {
 			gorillalog.Debug(scanner.Text())
+			fmt.Fprintln(logFile, scanner.Text())
 			cmdOutput = scanner.Text()
+			if strings.TrimSpace(cmdOutput) != "" {
+				stdout.add(cmdOutput)
+			}
 		}
 		gorillalog.Debug("--------------------")
 		wg.Done()
 	}()
 
+	tree := newProcessTree(cmd)
 	err = cmd.Start()
 	if err != nil {
 		gorillalog.Warn("command:", command, arguments)
 		gorillalog.Warn("Error running command:", err)
+	} else {
+		trackTree(tree)
+	}
+
+	// Killing the command kills everything it started too. If something else still has its output open,
+	// we stop waiting for the output after a moment so the run can carry on.
+	kill := func() {
+		tree.kill()
+		if cmdReader != nil {
+			time.AfterFunc(outputGrace, func() { cmdReader.Close() })
+		}
+	}
+
+	// Kill the command if it runs longer than the timeout
+	var timer *time.Timer
+	if options.Timeout > 0 && err == nil {
+		timer = time.AfterFunc(options.Timeout, func() {
+			gorillalog.Warn("Killing", command, "since it is still running after", options.Timeout)
+			kill()
+		})
+	}
+
+	// Kill the command if the run is interrupted
+	started := err == nil
+	finished := make(chan struct{})
+	interrupted := make(chan bool, 1)
+	if started {
+		go func() {
+			select {
+			case <-runContext.Done():
+				kill()
+				interrupted <- true
+			case <-finished:
+				interrupted <- false
+			}
+		}()
 	}
 
 	wg.Wait()
 	err = cmd.Wait()
+	close(finished)
+	tree.release()
+
+	// If the timer already fired, the command was killed
+	if timer != nil && !timer.Stop() {
+		err = fmt.Errorf("command timed out after %v", options.Timeout)
+	}
+	if started && <-interrupted {
+		err = fmt.Errorf("command was interrupted")
+	}
+
+	if options.OnExit != nil && cmd.ProcessState != nil {
+		options.OnExit(cmd.ProcessState.ExitCode())
+	}
+
+	// Some installers exit with a code other than zero when they succeed, such as 3010 when a restart is needed
+	if exitErr, ok := err.(*exec.ExitError); ok && successCode(exitErr.ExitCode(), options.SuccessCodes) {
+		gorillalog.Info("Command exited with success code", exitErr.ExitCode(), command)
+		err = nil
+	} else if ok && successCode(exitErr.ExitCode(), options.RebootCodes) {
+		gorillalog.Info("Command exited with restart code", exitErr.ExitCode(), command)
+		err = nil
+	}
 	if err != nil {
 		gorillalog.Warn("command:", command, arguments)
 		gorillalog.Warn("Command error:", err)
 	}
 
+	// Anything written to stderr usually explains a failure, such as an exception from a script
+	if stderrOut := strings.TrimSpace(stderr.String()); stderrOut != "" {
+		for _, line := range strings.Split(stderrOut, "\n") {
+			if err != nil {
+				gorillalog.Warn("stderr:", strings.TrimRight(line, "\r"))
+			} else {
+				gorillalog.Debug("stderr:", strings.TrimRight(line, "\r"))
+			}
+		}
+	}
+
+	// Silent installers rarely say why they failed anywhere else, so the end of their output goes in the log and the report
+	if err != nil {
+		for _, line := range stdout.lines {
+			gorillalog.Warn("output:", line)
+		}
+		if options.OnFailure != nil {
+			output := append(append([]string(nil), stdout.lines...), lastLines(stderr.String(), outputLines)...)
+			if len(output) > outputLines {
+				output = output[len(output)-outputLines:]
+			}
+			options.OnFailure(output)
+		}
+	}
+
 	return cmdOutput, err
 }
 
+// successCode returns true if the exit code is one of the provided success codes
+func successCode(exitCode int, successCodes []int) bool {
+	for _, code := range successCodes {
+		if code == exitCode {
+			return true
+		}
+	}
+	return false
+}
+
+// msiProductCode returns the product code used to uninstall an msi,
+// from the uninstaller or, since it is usually the same product, the installer
+func msiProductCode(item catalog.Item) string {
+	if item.Uninstaller.ProductCode != "" {
+		return item.Uninstaller.ProductCode
+	}
+	return item.Installer.ProductCode
+}
+
+// msiLogArguments returns the msiexec arguments to write a verbose log for an item
+// Logs are kept in the item's log directory, and replaced each time
+func msiLogArguments(item catalog.Item, action string) []string {
+	// Users cant write to our app data, so there is no log when the msi runs as they log on
+	if action == "install" && item.Installer.RunAs == runAsLogon || action == "uninstall" && item.Uninstaller.RunAs == runAsLogon {
+		return nil
+	}
+	logDir := itemLogDir(item)
+	if logDir == "" {
+		return nil
+	}
+	return []string{"/L*V", filepath.Join(logDir, "msi-"+action+".log")}
+}
+
 // Get a Nupkg's id using `choco list`
 func getNupkgID(nupkgDir, versionArg string) string {
 
@@ -85,25 +375,128 @@ func getNupkgID(nupkgDir, versionArg string) string {
 	arguments := []string{"list", versionArg, "--id-only", "-r", "-s", nupkgDir}
 
 	// Run the command and trim the output
-	cmdOut, _ := runCommand(command, arguments)
+	cmdOut, _ := runCommand(command, arguments, runOptions{})
 	nupkgID := strings.TrimSpace(cmdOut)
 
 	// The final output should just be the nupkg id
 	return nupkgID
 }
 
+// argumentData is the set of values available to installer argument templates
+type argumentData struct {
+	CachePath   string
+	DisplayName string
+	Hostname    string
+	Installer   string
+	Version     string
+}
+
+// argumentFuncs are the functions available to installer argument templates
+var argumentFuncs = template.FuncMap{
+	"env": os.Getenv,
+}
+
+// expandArguments renders any template placeholders in the provided arguments
+// The following values are available, along with `{{env "NAME"}}` for environment variables:
+//
+//	{{.CachePath}}   the local cache directory
+//	{{.DisplayName}} the item's display name
+//	{{.Hostname}}    the name of this computer
+//	{{.Installer}}   the path to the downloaded installer
+//	{{.Version}}     the item's version
+func expandArguments(item catalog.Item, arguments []string, absFile, cachePath string) ([]string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		gorillalog.Warn("Unable to determine hostname:", err)
+	}
+	data := argumentData{
+		CachePath:   cachePath,
+		DisplayName: item.DisplayName,
+		Hostname:    hostname,
+		Installer:   absFile,
+		Version:     item.Version,
+	}
+
+	var expanded []string
+	for _, argument := range arguments {
+		// Most arguments are plain strings, so only parse ones that contain a placeholder
+		if !strings.Contains(argument, "{{") {
+			expanded = append(expanded, argument)
+			continue
+		}
+
+		tmpl, err := template.New("argument").Option("missingkey=error").Funcs(argumentFuncs).Parse(argument)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse argument %q: %v", argument, err)
+		}
+		var buf bytes.Buffer
+		err = tmpl.Execute(&buf, data)
+		if err != nil {
+			return nil, fmt.Errorf("unable to expand argument %q: %v", argument, err)
+		}
+		expanded = append(expanded, buf.String())
+	}
+
+	return expanded, nil
+}
+
+// installerPath returns where an installer is run from, which is usually the cache
+// MSIs on a file share can be run in place instead of being copied to the cache first
+func installerPath(installer catalog.InstallerItem, itemURL, cachePath string) string {
+	if installerCfg.RunMSIInPlace && installer.Type == "msi" {
+		if sharePath, ok := download.LocalPath(itemURL); ok {
+			return sharePath
+		}
+	}
+	return download.CacheFile(cachePath, installer.Location)
+}
+
+// downloadInstaller downloads an item's installer if it is needed
+// If the installer isnt cached yet but a previous version it has a delta for is, only the delta is downloaded
+func downloadInstaller(item catalog.Item, absFile, itemURL, cachePath string) bool {
+	if _, err := os.Stat(absFile); os.IsNotExist(err) {
+		for _, delta := range item.Installer.Deltas {
+			baseFile := download.CacheFile(cachePath, delta.From)
+			if _, err := os.Stat(baseFile); err != nil {
+				continue
+			}
+			patchURL := download.ResolveURL(installerCfg.URLPackages, delta.Location)
+			if downloadPatch(absFile, baseFile, delta.FromHash, patchURL, delta.Hash, item.Installer.HashType, item.Installer.Hash, downloadTimeout(item)) {
+				return true
+			}
+		}
+	}
+	return downloadIfNeeded(absFile, itemURL, item.Installer.HashType, item.Installer.Hash, downloadTimeout(item))
+}
+
 func installItem(item catalog.Item, itemURL, cachePath string) string {
 
-	// Determine the paths needed for download and install
-	relPath, fileName := path.Split(item.Installer.Location)
-	absPath := filepath.Join(cachePath, relPath)
-	absFile := filepath.Join(absPath, fileName)
+	// Determine the path needed for download and install
+	absFile := installerPath(item.Installer, itemURL, cachePath)
 
 	// Download the item if it is needed
-	valid := download.IfNeeded(absFile, itemURL, item.Installer.Hash)
+	valid := downloadInstaller(item, absFile, itemURL, cachePath)
 	if !valid {
 		msg := fmt.Sprint("Unable to download valid file: ", itemURL)
 		gorillalog.Warn(msg)
+		report.FailedItems = append(report.FailedItems, item)
+		return msg
+	}
+
+	// Make sure the file was signed by us, and not just listed in the catalog
+	if err := verifySignature(item.Installer, absFile); err != nil {
+		msg := fmt.Sprint("Unable to verify signature of ", itemURL, ": ", err)
+		gorillalog.Warn(msg)
+		report.FailedItems = append(report.FailedItems, item)
+		return msg
+	}
+
+	// Fill in any placeholders in the installer arguments
+	arguments, err := expandArguments(item, item.Installer.Arguments, absFile, cachePath)
+	if err != nil {
+		msg := fmt.Sprint("Unable to prepare installer arguments for ", item.DisplayName, ": ", err)
+		gorillalog.Warn(msg)
+		report.FailedItems = append(report.FailedItems, item)
 		return msg
 	}
 
@@ -135,36 +528,72 @@ func installItem(item catalog.Item, itemURL, cachePath string) string {
 			installArgs = []string{"install", absFile, "-f", "-y", "-r"}
 		}
 
+		// Pass any arguments along to the installer inside the package, usually to keep it silent
+		if len(arguments) > 0 {
+			installArgs = append(installArgs, "--install-arguments="+strings.Join(arguments, " "))
+		}
+
 	} else if item.Installer.Type == "msi" {
 		gorillalog.Info("Installing msi for", item.DisplayName)
 		installCmd = commandMsi
 		installArgs = []string{"/i", absFile, "/qn", "/norestart"}
-		installArgs = append(installArgs, item.Installer.Arguments...)
+		installArgs = append(installArgs, msiLogArguments(item, "install")...)
+		installArgs = append(installArgs, arguments...)
 
 	} else if item.Installer.Type == "exe" {
 		gorillalog.Info("Installing exe for", item.DisplayName)
 		installCmd = absFile
-		installArgs = item.Installer.Arguments
+		installArgs = arguments
 
 	} else if item.Installer.Type == "ps1" {
 		gorillalog.Info("Installing ps1 for", item.DisplayName)
 		installCmd = commandPs1
 		installArgs = []string{"-NoProfile", "-NoLogo", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-File", absFile}
+		installArgs = append(installArgs, arguments...)
+
+	} else if isAppx(item.Installer.Type) {
+		gorillalog.Info("Installing", item.Installer.Type, "for", item.DisplayName)
+		installCmd, installArgs = appxInstallCommand(item.Installer, absFile, arguments)
+
+	} else if item.Installer.Type == "zip" {
+		if item.Installer.Destination == "" {
+			msg := fmt.Sprint("Zip installer has no destination: ", item.DisplayName)
+			gorillalog.Warn(msg)
+			report.FailedItems = append(report.FailedItems, item)
+			return msg
+		}
+		gorillalog.Info("Extracting zip for", item.DisplayName)
 
 	} else {
 		msg := fmt.Sprint("Unsupported installer type", item.Installer.Type)
 		gorillalog.Warn(msg)
+		report.FailedItems = append(report.FailedItems, item)
 		return msg
 	}
 
-	// Run the command
-	installerOut, errOut := runCommand(installCmd, installArgs)
+	// Run the command, zips are extracted directly and per-user installers wait until users log on
+	var installerOut string
+	var errOut error
+	if item.Installer.Type == "zip" {
+		installerOut, errOut = installZip(item, absFile)
+		if errOut != nil {
+			gorillalog.Warn("Unable to extract zip:", absFile, errOut)
+		}
+	} else if item.Installer.RunAs == runAsLogon {
+		installerOut, errOut = runAtLogon(item, "install", installCmd, installArgs)
+	} else {
+		installerOut, errOut = runCommand(installCmd, installArgs, captureOutput(item, "install", commandOptions(item, item.Installer, absFile)))
+	}
 
 	// Write success/failure event to log
 	if errOut != nil {
 		gorillalog.Warn(item.DisplayName, item.Version, "Installation FAILED")
+		report.FailedItems = append(report.FailedItems, item)
+	} else if item.Installer.RunAs == runAsLogon {
+		gorillalog.Success(item.DisplayName, item.Version, "will be installed as each user logs on")
 	} else {
-		gorillalog.Info(item.DisplayName, item.Version, "Installation SUCCESSFUL")
+		gorillalog.Success(item.DisplayName, item.Version, "Installation SUCCESSFUL")
+		notifyInstalled(item)
 	}
 
 	// Add the item to InstalledItems in GorillaReport
@@ -175,16 +604,107 @@ func installItem(item catalog.Item, itemURL, cachePath string) string {
 
 func uninstallItem(item catalog.Item, itemURL, cachePath string) string {
 
-	// Determine the paths needed for download and uinstall
-	relPath, fileName := path.Split(item.Uninstaller.Location)
-	absPath := filepath.Join(cachePath, relPath)
-	absFile := filepath.Join(absPath, fileName)
+	// The catalog sets up the uninstaller for every other method
+	switch item.UninstallMethod {
+	case "", "uninstaller", "installer", "msi":
+	case "script":
+		return uninstallScript(item, cachePath)
+	default:
+		msg := fmt.Sprint("Unsupported uninstall method ", item.UninstallMethod, " for ", item.DisplayName)
+		gorillalog.Warn(msg)
+		report.FailedItems = append(report.FailedItems, item)
+		return msg
+	}
+
+	// A command that is already on the computer, like the vendor's own uninstaller, is run in place
+	if item.Uninstaller.Type == "command" {
+		if item.Uninstaller.Command == "" {
+			msg := fmt.Sprint("Unable to uninstall ", item.DisplayName, ": no command")
+			gorillalog.Warn(msg)
+			report.FailedItems = append(report.FailedItems, item)
+			return msg
+		}
+		// The command can use the same placeholders as the arguments, like `{{env "ProgramFiles"}}`
+		expanded, err := expandArguments(item, append([]string{item.Uninstaller.Command}, item.Uninstaller.Arguments...), "", cachePath)
+		if err != nil {
+			msg := fmt.Sprint("Unable to prepare uninstall command for ", item.DisplayName, ": ", err)
+			gorillalog.Warn(msg)
+			report.FailedItems = append(report.FailedItems, item)
+			return msg
+		}
+		gorillalog.Info("Running uninstall command for", item.DisplayName)
+		return runUninstaller(item, expanded[0], expanded[1:], commandOptions(item, item.Uninstaller, ""))
+	}
+
+	// Zips are removed using their receipt, so there is nothing to download
+	if item.Uninstaller.Type == "zip" {
+		gorillalog.Info("Uninstalling zip for", item.DisplayName)
+		uninstallerOut, errOut := uninstallZip(item)
+		if errOut != nil {
+			gorillalog.Warn(item.DisplayName, item.Version, "Uninstallation FAILED", errOut)
+			report.FailedItems = append(report.FailedItems, item)
+		} else {
+			gorillalog.Success(item.DisplayName, item.Version, "Uninstallation SUCCESSFUL")
+		}
+		report.UninstalledItems = append(report.UninstalledItems, item)
+		return uninstallerOut
+	}
+
+	// Msi products can be removed by their product code, so there is nothing to download
+	if code := msiProductCode(item); item.Uninstaller.Type == "msi" && code != "" {
+		gorillalog.Info("Uninstalling msi product code for", item.DisplayName)
+		arguments, err := expandArguments(item, item.Uninstaller.Arguments, "", cachePath)
+		if err != nil {
+			msg := fmt.Sprint("Unable to prepare uninstaller arguments for ", item.DisplayName, ": ", err)
+			gorillalog.Warn(msg)
+			report.FailedItems = append(report.FailedItems, item)
+			return msg
+		}
+		uninstallArgs := append([]string{"/x", code, "/qn", "/norestart"}, msiLogArguments(item, "uninstall")...)
+		uninstallArgs = append(uninstallArgs, arguments...)
+		return runUninstaller(item, commandMsi, uninstallArgs, commandOptions(item, item.Uninstaller, ""))
+	}
+
+	// Appx and msix packages are removed by their package name, so there is nothing to download
+	if isAppx(item.Uninstaller.Type) {
+		name := appxPackageName(item)
+		if name == "" {
+			msg := fmt.Sprint("Unable to uninstall ", item.DisplayName, ": no package_name")
+			gorillalog.Warn(msg)
+			report.FailedItems = append(report.FailedItems, item)
+			return msg
+		}
+		gorillalog.Info("Uninstalling", item.Uninstaller.Type, "for", item.DisplayName)
+		uninstallCmd, uninstallArgs := appxUninstallCommand(item.Uninstaller, name)
+		return runUninstaller(item, uninstallCmd, uninstallArgs, commandOptions(item, item.Uninstaller, ""))
+	}
+
+	// Determine the path needed for download and uninstall
+	absFile := installerPath(item.Uninstaller, itemURL, cachePath)
 
 	// Download the item if it is needed
-	valid := download.IfNeeded(absFile, itemURL, item.Uninstaller.Hash)
+	valid := download.IfNeeded(absFile, itemURL, item.Uninstaller.HashType, item.Uninstaller.Hash, downloadTimeout(item))
 	if !valid {
 		msg := fmt.Sprint("Unable to download valid file: ", itemURL)
 		gorillalog.Warn(msg)
+		report.FailedItems = append(report.FailedItems, item)
+		return msg
+	}
+
+	// Make sure the file was signed by us, and not just listed in the catalog
+	if err := verifySignature(item.Uninstaller, absFile); err != nil {
+		msg := fmt.Sprint("Unable to verify signature of ", itemURL, ": ", err)
+		gorillalog.Warn(msg)
+		report.FailedItems = append(report.FailedItems, item)
+		return msg
+	}
+
+	// Fill in any placeholders in the uninstaller arguments
+	arguments, err := expandArguments(item, item.Uninstaller.Arguments, absFile, cachePath)
+	if err != nil {
+		msg := fmt.Sprint("Unable to prepare uninstaller arguments for ", item.DisplayName, ": ", err)
+		gorillalog.Warn(msg)
+		report.FailedItems = append(report.FailedItems, item)
 		return msg
 	}
 
@@ -217,35 +737,65 @@ func uninstallItem(item catalog.Item, itemURL, cachePath string) string {
 			uninstallArgs = []string{"uninstall", absFile, "-f", "-y", "-r"}
 		}
 
+		// Pass any arguments along to the uninstaller inside the package, usually to keep it silent
+		if len(arguments) > 0 {
+			uninstallArgs = append(uninstallArgs, "--uninstall-arguments="+strings.Join(arguments, " "))
+		}
+
 	} else if item.Uninstaller.Type == "msi" {
 		gorillalog.Info("Uninstalling msi for", item.DisplayName)
 		uninstallCmd = commandMsi
 		uninstallArgs = []string{"/x", absFile, "/qn", "/norestart"}
+		uninstallArgs = append(uninstallArgs, msiLogArguments(item, "uninstall")...)
+		uninstallArgs = append(uninstallArgs, arguments...)
 
 	} else if item.Uninstaller.Type == "exe" {
 		gorillalog.Info("Uninstalling exe for", item.DisplayName)
 		uninstallCmd = absFile
-		uninstallArgs = item.Uninstaller.Arguments
+		uninstallArgs = arguments
 
 	} else if item.Uninstaller.Type == "ps1" {
 		gorillalog.Info("Uninstalling ps1 for", item.DisplayName)
 		uninstallCmd = commandPs1
 		uninstallArgs = []string{"-NoProfile", "-NoLogo", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-File", absFile}
+		uninstallArgs = append(uninstallArgs, arguments...)
 
 	} else {
 		msg := fmt.Sprint("Unsupported uninstaller type", item.Uninstaller.Type)
 		gorillalog.Warn(msg)
+		report.FailedItems = append(report.FailedItems, item)
 		return msg
 	}
 
-	// Run the command
-	uninstallerOut, errOut := runCommand(uninstallCmd, uninstallArgs)
+	return runUninstaller(item, uninstallCmd, uninstallArgs, commandOptions(item, item.Uninstaller, absFile))
+}
+
+// runUninstaller runs an uninstall command and records the result
+func runUninstaller(item catalog.Item, uninstallCmd string, uninstallArgs []string, options runOptions) string {
+	// Run the command, or have it run as each user logs on
+	var uninstallerOut string
+	var errOut error
+	if options.RunAs == runAsLogon {
+		uninstallerOut, errOut = runAtLogon(item, "uninstall", uninstallCmd, uninstallArgs)
+	} else {
+		uninstallerOut, errOut = runCommand(uninstallCmd, uninstallArgs, captureOutput(item, "uninstall", options))
+	}
 
 	// Write success/failure event to log
-	if errOut != nil {
+	switch {
+	case errOut != nil:
 		gorillalog.Warn(item.DisplayName, item.Version, "Uninstallation FAILED")
-	} else {
-		gorillalog.Info(item.DisplayName, item.Version, "Uninstallation SUCCESSFUL")
+		report.FailedItems = append(report.FailedItems, item)
+	case options.RunAs == runAsLogon:
+		gorillalog.Success(item.DisplayName, item.Version, "will be uninstalled as each user logs on")
+	default:
+		gorillalog.Success(item.DisplayName, item.Version, "Uninstallation SUCCESSFUL")
+		// Users that log on later shouldnt get an item that has been removed
+		if item.Installer.RunAs == runAsLogon {
+			if err := unregisterLogon(logonID(item, "install")); err != nil {
+				gorillalog.Warn("Unable to remove the logon install of", item.DisplayName+":", err)
+			}
+		}
 	}
 
 	// Add the item to InstalledItems in GorillaReport
@@ -254,75 +804,265 @@ func uninstallItem(item catalog.Item, itemURL, cachePath string) string {
 	return uninstallerOut
 }
 
-func preinstallScript(catalogItem catalog.Item, cachePath string) (actionNeeded bool, checkErr error) {
+// uninstallScript runs an item's `uninstall_script` and records the result
+func uninstallScript(item catalog.Item, cachePath string) string {
+	gorillalog.Info("Running uninstall script for", item.DisplayName)
+	var msg string
+	if err := runScript(item, item.UninstallScript, item.UninstallScriptType, "UninstallScript", cachePath); err != nil {
+		msg = fmt.Sprint("Uninstall script error: ", err)
+		gorillalog.Warn(item.DisplayName, item.Version, "Uninstallation FAILED", err)
+		report.FailedItems = append(report.FailedItems, item)
+	} else {
+		gorillalog.Success(item.DisplayName, item.Version, "Uninstallation SUCCESSFUL")
+	}
+	report.UninstalledItems = append(report.UninstalledItems, item)
+	return msg
+}
 
-	// Write InstallCheckScript to disk as a Powershell file
-	tmpScript := filepath.Join(cachePath, "tmpPostScript.ps1")
-	ioutil.WriteFile(tmpScript, []byte(catalogItem.PreScript), 0755)
+// scriptCommand returns the command that runs a script of the provided type
+// Scripts are PowerShell unless they are declared as batch
+func scriptCommand(scriptType, scriptPath string) (string, []string, error) {
+	switch strings.ToLower(scriptType) {
+	case "", "ps1", "powershell":
+		return commandPs1, []string{"-NoProfile", "-NoLogo", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-File", scriptPath}, nil
+	case "bat", "batch", "cmd":
+		return commandCmd, []string{"/C", scriptPath}, nil
+	}
+	return "", nil, fmt.Errorf("unsupported script type: %s", scriptType)
+}
 
-	// Build the command to execute the script
-	psCmd := filepath.Join(os.Getenv("WINDIR"), "system32/", "WindowsPowershell", "v1.0", "powershell.exe")
-	psArgs := []string{"-NoProfile", "-NoLogo", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-File", tmpScript}
+// scriptExtension returns the file extension Windows needs to run a script of the provided type
+func scriptExtension(scriptType string) string {
+	switch strings.ToLower(scriptType) {
+	case "bat", "batch", "cmd":
+		return ".bat"
+	}
+	return ".ps1"
+}
 
-	// Execute the script
-	cmd := execCommand(psCmd, psArgs...)
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	err := cmd.Run()
-	cmdSuccess := cmd.ProcessState.Success()
-	outStr, errStr := stdout.String(), stderr.String()
+// runScript writes an inline script to a temporary file, runs it, and removes it again
+func runScript(item catalog.Item, script, scriptType, name, cachePath string) error {
+	tmpScript := filepath.Join(cachePath, "tmp"+name+scriptExtension(scriptType))
+	scriptCmd, scriptArgs, err := scriptCommand(scriptType, tmpScript)
+	if err != nil {
+		return err
+	}
 
-	// Delete the temporary script
-	os.Remove(tmpScript)
+	// Write the script to disk
+	err = ioutil.WriteFile(tmpScript, []byte(script), 0755)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpScript)
 
-	// Log results
-	gorillalog.Debug("Command Error:", err)
-	gorillalog.Debug("stdout:", outStr)
-	gorillalog.Debug("stderr:", errStr)
+	// Scripts are limited by the same timeout as the installer
+	_, err = runCommand(scriptCmd, scriptArgs, runOptions{Timeout: installerTimeout(item)})
+	return err
+}
 
-	return cmdSuccess, err
+// preinstallScript runs an item's `preinstall_script`
+func preinstallScript(item catalog.Item, cachePath string) error {
+	return runScript(item, item.PreScript, item.PreScriptType, "PreinstallScript", cachePath)
 }
 
-func postinstallScript(catalogItem catalog.Item, cachePath string) (actionNeeded bool, checkErr error) {
+// postinstallScript runs an item's `postinstall_script`
+func postinstallScript(item catalog.Item, cachePath string) error {
+	return runScript(item, item.PostScript, item.PostScriptType, "PostinstallScript", cachePath)
+}
 
-	// Write InstallCheckScript to disk as a Powershell file
-	tmpScript := filepath.Join(cachePath, "tmpPostScript.ps1")
-	ioutil.WriteFile(tmpScript, []byte(catalogItem.PostScript), 0755)
+var (
+	// By putting the functions in a variable, we can override later in tests
+	installItemFunc   = installItem
+	uninstallItemFunc = uninstallItem
+)
 
-	// Build the command to execute the script
-	psCmd := filepath.Join(os.Getenv("WINDIR"), "system32/", "WindowsPowershell", "v1.0", "powershell.exe")
-	psArgs := []string{"-NoProfile", "-NoLogo", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-File", tmpScript}
+// blockingApp returns the first of an item's blocking apps that is running, if any
+// Apps can be listed with or without the .exe extension
+func blockingApp(item catalog.Item) (string, error) {
+	if len(item.BlockingApps) == 0 {
+		return "", nil
+	}
+	names, err := runningProcesses()
+	if err != nil {
+		return "", err
+	}
 
-	// Execute the script
-	cmd := execCommand(psCmd, psArgs...)
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	err := cmd.Run()
-	cmdSuccess := cmd.ProcessState.Success()
-	outStr, errStr := stdout.String(), stderr.String()
+	running := make(map[string]bool)
+	for _, name := range names {
+		running[strings.TrimSuffix(strings.ToLower(name), ".exe")] = true
+	}
+	for _, app := range item.BlockingApps {
+		if running[strings.TrimSuffix(strings.ToLower(app), ".exe")] {
+			return app, nil
+		}
+	}
+	return "", nil
+}
 
-	// Delete the temporary script
-	os.Remove(tmpScript)
+// forceClosing is when the blocking apps of items past their deadline are closed, once the user has been warned
+// Every item past its deadline shares the same warning, so the run only waits once
+var forceClosing time.Time
 
-	// Log results
-	gorillalog.Debug("Command Error:", err)
-	gorillalog.Debug("stdout:", outStr)
-	gorillalog.Debug("stderr:", errStr)
+// pastDeadline returns true if an item has a `force_install_after_date` that has passed
+func pastDeadline(item catalog.Item) bool {
+	if item.ForceInstallAfterDate == "" {
+		return false
+	}
+	deadline, err := catalog.ParseDeadline(item.ForceInstallAfterDate)
+	return err == nil && !timeNow().Before(deadline)
+}
 
-	return cmdSuccess, err
+// warnForced warns the user once about every item past its deadline whose blocking apps are open,
+// so their apps can all be closed after a single warning period instead of one item after another
+func warnForced(items []catalog.Item, installerType string) {
+	if !forceClosing.IsZero() {
+		return
+	}
+	var overdue []catalog.Item
+	var names, apps []string
+	for _, item := range items {
+		if !pastDeadline(item) {
+			continue
+		}
+		if app, err := blockingApp(item); err == nil && app != "" {
+			overdue = append(overdue, item)
+			names = append(names, item.DisplayName)
+			apps = append(apps, app)
+		}
+	}
+	if len(overdue) == 0 {
+		return
+	}
+
+	warning := defaultForceWarning
+	if installerCfg.ForceInstallWarning > 0 {
+		warning = time.Duration(installerCfg.ForceInstallWarning) * time.Minute
+	}
+	forceClosing = timeNow().Add(warning)
+	gorillalog.Warn("The deadline to", installerType, strings.Join(names, ", "), "has passed, closing", strings.Join(apps, ", "), "in", warning)
+	notifyForced(overdue, forceClosing)
 }
 
-var (
-	// By putting the functions in a variable, we can override later in tests
-	installItemFunc   = installItem
-	uninstallItemFunc = uninstallItem
-)
+// forceInstall returns true once an item's blocking apps have been closed because its `force_install_after_date` has passed
+// Until then the item is deferred, and the user is reminded of the deadline. Afterwards they are warned,
+// and the apps are closed once the warning period is over.
+func forceInstall(item catalog.Item, installerType, app string) bool {
+	if item.ForceInstallAfterDate == "" {
+		gorillalog.Info("Deferring", installerType, "of", item.DisplayName, "while", app, "is running")
+		return false
+	}
+	deadline, err := catalog.ParseDeadline(item.ForceInstallAfterDate)
+	if err != nil {
+		gorillalog.Warn("Deferring", installerType, "of", item.DisplayName, "while", app, "is running, since its deadline is invalid:", err)
+		return false
+	}
+	if timeNow().Before(deadline) {
+		gorillalog.Info("Deferring", installerType, "of", item.DisplayName, "while", app, "is running, until", deadline.Format(time.RFC3339))
+		NotifyDeadline(item, deadline)
+		return false
+	}
+
+	// Items downloaded together were already warned about, so only wait out what is left of that warning
+	warnForced([]catalog.Item{item}, installerType)
+	if wait := forceClosing.Sub(timeNow()); wait > 0 {
+		select {
+		case <-timeAfter(wait):
+		case <-runContext.Done():
+			gorillalog.Warn("Skipping", installerType, "of", item.DisplayName, "because the run was interrupted")
+			return false
+		}
+	}
+
+	if err := killProcess(item.BlockingApps); err != nil {
+		gorillalog.Warn("Unable to close the blocking apps for", item.DisplayName+":", err)
+	}
+	// The apps may have been reopened, or refused to close
+	if app, err := blockingApp(item); err != nil || app != "" {
+		gorillalog.Warn("Deferring", installerType, "of", item.DisplayName, "because", app, "could not be closed")
+		return false
+	}
+	gorillalog.Info("Closed the blocking apps for", item.DisplayName)
+	return true
+}
+
+// Changes returns how many items were installed, updated, or removed successfully so far this run
+func Changes() int {
+	return changes
+}
+
+// recordAttempt saves the result of an install or uninstall to the local state,
+// and warns when the same installer keeps failing
+func recordAttempt(item catalog.Item, action, hash string, success bool) {
+	history := state.Record(item.DisplayName, action, item.Version, hash, success)
+	if success {
+		changes++
+		recordRestart(item)
+	}
+	if history.Failures > 0 {
+		report.FailureCounts[item.DisplayName] = history.Failures
+	}
+	if history.Failures > 1 {
+		gorillalog.Warn(item.DisplayName, item.Version, "has failed to", action, history.Failures, "times in a row")
+	}
+}
+
+// retryBlocked returns the reason an installer that keeps failing should not be attempted this run,
+// or an empty string if it should be. A new version or hash is always attempted.
+func retryBlocked(item catalog.Item, action, hash string) string {
+	history := state.Get(item.DisplayName)
+	// Someone running gorilla by hand wants it to try again
+	if history.Failures == 0 || !history.Matches(action, item.Version, hash) || installerCfg.Force || installerCfg.Mode == config.ModeManual {
+		return ""
+	}
+	// Provisioning has to finish, so bootstrap runs give up on an item that keeps failing even without a limit
+	max := installerCfg.MaxInstallFailures
+	if max == 0 && installerCfg.Mode == config.ModeBootstrap {
+		max = bootstrapMaxFailures
+	}
+	if max > 0 && history.Failures >= max {
+		return fmt.Sprint("it has failed ", history.Failures, " times, and will not be attempted again until the catalog changes")
+	}
+	// Bootstrap runs are waiting for everything to be installed, so they dont wait out the backoff
+	if wait := history.Remaining(installerCfg.RetryBackoffMinutes); installerCfg.RetryBackoffMinutes > 0 && wait > 0 && installerCfg.Mode != config.ModeBootstrap {
+		return fmt.Sprint("it has failed ", history.Failures, " times, and will be attempted again in ", wait.Round(time.Second))
+	}
+	return ""
+}
+
+// verifySignature checks a downloaded file against its detached signature when a signature key is configured
+// Files without a signature are only allowed when signatures are not required
+func verifySignature(installer catalog.InstallerItem, absFile string) error {
+	if installerCfg.SignatureKey == "" {
+		return nil
+	}
+	if installer.Signature == "" {
+		if installerCfg.RequireSignatures {
+			return fmt.Errorf("no signature in the catalog")
+		}
+		return nil
+	}
+
+	publicKey, err := ioutil.ReadFile(installerCfg.SignatureKey)
+	if err != nil {
+		return err
+	}
+	sig, err := downloadGet(download.ResolveURL(installerCfg.URLPackages, installer.Signature))
+	if err != nil {
+		return err
+	}
+	return signature.VerifyFile(publicKey, absFile, sig)
+}
 
 // Install determines if action needs to be taken on a item and then
 // calls the appropriate function to install or uninstall
 func Install(item catalog.Item, installerType, urlPackages, cachePath string, checkOnly bool) string {
+	// Nothing new is started once the run is interrupted, so it can stop as soon as possible
+	if runContext.Err() != nil {
+		gorillalog.Warn("Skipping", installerType, "of", item.DisplayName, "because the run was interrupted")
+		report.PendingItems = append(report.PendingItems, item)
+		return "Interrupted"
+	}
+
 	// Check the status and determine if any action is needed for this item
 	actionNeeded, err := statusCheckStatus(item, installerType, cachePath)
 	if err != nil {
@@ -336,51 +1076,94 @@ func Install(item catalog.Item, installerType, urlPackages, cachePath string, ch
 		return "Item not needed"
 	}
 
+	// Give an installer that keeps failing a break, instead of running it every time
+	if !checkOnly {
+		hash := item.Installer.Hash
+		if installerType == "uninstall" {
+			hash = item.Uninstaller.Hash
+		}
+		if reason := retryBlocked(item, installerType, hash); reason != "" {
+			report.FailureCounts[item.DisplayName] = state.Get(item.DisplayName).Failures
+			gorillalog.Warn("Skipping", installerType, "of", item.DisplayName, "because", reason)
+			report.PendingItems = append(report.PendingItems, item)
+			return "Too many failures"
+		}
+	}
+
+	// Leave the item for a later run while an app it would disrupt is open, unless its deadline has passed
+	if !checkOnly {
+		if app, err := blockingApp(item); err != nil {
+			gorillalog.Warn("Unable to check for blocking apps:", err)
+		} else if app != "" && !forceInstall(item, installerType, app) {
+			report.PendingItems = append(report.PendingItems, item)
+			return "Blocking app running"
+		}
+	}
+
 	// Install or uninstall the item
 	if installerType == "install" || installerType == "update" {
 		// Check if checkonly mode is enabled
 		if checkOnly {
 			report.InstalledItems = append(report.InstalledItems, item)
-			gorillalog.Info("[CHECK ONLY] Skipping actions for", item.DisplayName)
+			gorillalog.Info("[CHECK ONLY] Would", installerType, item.DisplayName)
 			// Check only mode doesn't perform any action, return
 			return "Check only enabled"
 		} else {
-			// Compile the item's URL
-			itemURL := urlPackages + item.Installer.Location
+			// Compile the item's URL, relative locations are resolved against `urlPackages`
+			itemURL := download.ResolveURL(urlPackages, item.Installer.Location)
 			// Run PreInstall_Script if needed
 			if item.PreScript != "" {
 				gorillalog.Info("Running Pre-Install script for", item.DisplayName)
-				preScriptSuccess, err := preinstallScript(item, cachePath)
-				if !preScriptSuccess {
-					gorillalog.Error("Pre-Install script error:", err)
+				// A failed pre-install script means the item isnt ready to be installed
+				if err := preinstallScript(item, cachePath); err != nil {
+					gorillalog.Warn("Pre-Install script error:", err)
+					report.FailedItems = append(report.FailedItems, item)
+					recordAttempt(item, installerType, item.Installer.Hash, false)
 					return "PreInstall-Script error"
 				}
 			}
 
 			// Run the installer
+			start, failures := time.Now(), len(report.FailedItems)
+			done := progress.Run(installerType, item.DisplayName)
 			installItemFunc(item, itemURL, cachePath)
+			done()
+			statusForget(item)
+			report.ItemDurations[item.DisplayName] = time.Since(start).Seconds()
+			recordAttempt(item, installerType, item.Installer.Hash, len(report.FailedItems) == failures)
 
 			// Run PostInstall_Script if needed
 			if item.PostScript != "" {
 				gorillalog.Info("Running Post-Install script for", item.DisplayName)
-				postScriptSuccess, err := postinstallScript(item, cachePath)
-				if !postScriptSuccess {
-					gorillalog.Error("Post-Install script error:", err)
+				if err := postinstallScript(item, cachePath); err != nil {
+					gorillalog.Warn("Post-Install script error:", err)
+					// The installer may have worked, but the item isnt set up until its script does
+					// A failed installer was already reported and recorded
+					if len(report.FailedItems) == failures {
+						report.FailedItems = append(report.FailedItems, item)
+						recordAttempt(item, installerType, item.Installer.Hash, false)
+					}
 					return "PostInstall-Script error"
 				}
 			}
 		}
 	} else if installerType == "uninstall" {
 		if checkOnly {
-			report.InstalledItems = append(report.InstalledItems, item)
-			gorillalog.Info("[CHECK ONLY] Skipping actions for", item.DisplayName)
+			report.UninstalledItems = append(report.UninstalledItems, item)
+			gorillalog.Info("[CHECK ONLY] Would uninstall", item.DisplayName)
 			// Check only mode doesn't perform any action, return
 			return "Check only enabled"
 		} else {
-			// Compile the item's URL
-			itemURL := urlPackages + item.Uninstaller.Location
+			// Compile the item's URL, relative locations are resolved against `urlPackages`
+			itemURL := download.ResolveURL(urlPackages, item.Uninstaller.Location)
 			// Run the installer
+			start, failures := time.Now(), len(report.FailedItems)
+			done := progress.Run(installerType, item.DisplayName)
 			uninstallItemFunc(item, itemURL, cachePath)
+			done()
+			statusForget(item)
+			report.ItemDurations[item.DisplayName] = time.Since(start).Seconds()
+			recordAttempt(item, installerType, item.Uninstaller.Hash, len(report.FailedItems) == failures)
 		}
 	} else {
 		gorillalog.Warn("Unsupported item type", item.DisplayName, installerType)
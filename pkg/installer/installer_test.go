@@ -1,30 +1,46 @@
 package installer
 
 import (
+	"archive/zip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/1dustindavis/gorilla/pkg/catalog"
 	"github.com/1dustindavis/gorilla/pkg/config"
 	"github.com/1dustindavis/gorilla/pkg/download"
 	"github.com/1dustindavis/gorilla/pkg/gorillalog"
 	"github.com/1dustindavis/gorilla/pkg/report"
+	"github.com/1dustindavis/gorilla/pkg/state"
 )
 
 // A lot of ideas taken from https://npf.io/2015/06/testing-exec-command/
 
 var (
 	// store original data to restore after each test
-	origExec            = execCommand
-	origCheckStatus     = statusCheckStatus
-	origReportInstalled = report.InstalledItems
-	origInstallItemFunc = installItemFunc
-	origRunCommand      = runCommand
+	origExec              = execCommand
+	origCheckStatus       = statusCheckStatus
+	origReportInstalled   = report.InstalledItems
+	origReportUninstalled = report.UninstalledItems
+	origInstallItemFunc   = installItemFunc
+	origUninstallItemFunc = uninstallItemFunc
+	origRunCommand        = runCommand
+	origRunningProcesses  = runningProcesses
+	origKillProcess       = killProcess
+	origTimeAfter         = timeAfter
 
 	// These tore the URL that `Install` generates during testing
 	installItemURL   string
@@ -115,7 +131,7 @@ func fakeExecCommand(command string, args ...string) *exec.Cmd {
 }
 
 // fakeRunCommand just returns a string and error interface
-func fakeRunCommand(command string, arguments []string) (string, error) {
+func fakeRunCommand(command string, arguments []string, options runOptions) (string, error) {
 	cmdOutput := "This is a fake test command return"
 	var err error
 	if msiItem.DisplayName == statusActionNoError {
@@ -132,6 +148,35 @@ func TestHelperProcess(t *testing.T) {
 	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
 		return
 	}
+	// Simulate a command that never finishes on its own
+	if os.Args[3] == "_gorilla_dev_sleep_" {
+		time.Sleep(time.Minute)
+	}
+	// Simulate an installer that hands off to another process, which keeps our output open
+	if os.Args[3] == "_gorilla_dev_spawn_" {
+		child := fakeExecCommand("_gorilla_dev_sleep_")
+		child.Stdout = os.Stdout
+		child.Start()
+		time.Sleep(time.Minute)
+	}
+	// Print the working directory instead of the command
+	if os.Args[3] == "_gorilla_dev_pwd_" {
+		dir, _ := os.Getwd()
+		fmt.Print(dir)
+		os.Exit(0)
+	}
+	// Exit with an error code
+	if os.Args[3] == "_gorilla_dev_exit_" {
+		os.Exit(42)
+	}
+	// Fail after writing more output than we keep
+	if os.Args[3] == "_gorilla_dev_fail_" {
+		for i := 1; i <= 25; i++ {
+			fmt.Println("line", i)
+		}
+		fmt.Fprintln(os.Stderr, "Setup failed")
+		os.Exit(1)
+	}
 	// print the command we received
 	fmt.Print(os.Args[3:])
 	os.Exit(0)
@@ -174,7 +219,7 @@ func TestRunCommand(t *testing.T) {
 	testCmd := append([]string{testCommand}, testArgs...)
 	expectedCmd := fmt.Sprint(testCmd)
 
-	actualCmd, _ := runCommand(testCommand, testArgs)
+	actualCmd, _ := runCommand(testCommand, testArgs, runOptions{})
 
 	// Compare the result with our expectations
 	structsMatch := reflect.DeepEqual(expectedCmd, actualCmd)
@@ -184,6 +229,512 @@ func TestRunCommand(t *testing.T) {
 	}
 }
 
+// TestRunCommandTimeout verifies that a command is killed once the timeout expires
+func TestRunCommandTimeout(t *testing.T) {
+	// Override execCommand with our fake version
+	execCommand = fakeExecCommand
+	defer func() { execCommand = origExec }()
+
+	start := time.Now()
+	_, err := runCommand("_gorilla_dev_sleep_", nil, runOptions{Timeout: 500 * time.Millisecond})
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("runCommand did not return a timeout error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 30*time.Second {
+		t.Errorf("runCommand was not killed after the timeout, ran for %v", elapsed)
+	}
+}
+
+// TestRunCommandTimeoutTree verifies that the processes a command started are killed along with it
+func TestRunCommandTimeoutTree(t *testing.T) {
+	execCommand = fakeExecCommand
+	defer func() { execCommand = origExec }()
+
+	start := time.Now()
+	_, err := runCommand("_gorilla_dev_spawn_", nil, runOptions{Timeout: 500 * time.Millisecond})
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("runCommand did not return a timeout error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 30*time.Second {
+		t.Errorf("runCommand waited on the process the command started, ran for %v", elapsed)
+	}
+}
+
+// TestRunCommandOutput verifies that output is saved to the log file, and the end of it is passed along when a command fails
+func TestRunCommandOutput(t *testing.T) {
+	execCommand = fakeExecCommand
+	defer func() { execCommand = origExec }()
+
+	dir, err := ioutil.TempDir("", "gorilla_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "install.log")
+
+	var output []string
+	_, err = runCommand("_gorilla_dev_fail_", nil, runOptions{LogFile: logFile, OnFailure: func(lines []string) { output = lines }})
+	if err == nil {
+		t.Fatal("expected the command to fail")
+	}
+
+	// Only the last lines are kept, with stderr at the end
+	expected := []string{"Setup failed"}
+	for i := 25; len(expected) < outputLines; i-- {
+		expected = append([]string{fmt.Sprint("line ", i)}, expected...)
+	}
+	if have, want := output, expected; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %v, want %v", have, want)
+	}
+
+	// The log file has all of it
+	logged, err := ioutil.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"line 1\n", "line 25\n", "Setup failed\n"} {
+		if !strings.Contains(string(logged), line) {
+			t.Errorf("log file is missing %q: %s", line, logged)
+		}
+	}
+
+	// Nothing is passed along when the command succeeds
+	output = nil
+	if _, err := runCommand("echo", nil, runOptions{OnFailure: func(lines []string) { output = lines }}); err != nil {
+		t.Fatal(err)
+	}
+	if output != nil {
+		t.Errorf("expected no output for a command that succeeded, got %v", output)
+	}
+}
+
+// TestCaptureOutput verifies that items log to their own directory, and a failure is reported with the end of the msi log
+func TestCaptureOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gorilla_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	origCfg, origOutput := installerCfg, report.FailureOutput
+	defer func() { installerCfg, report.FailureOutput = origCfg, origOutput }()
+	installerCfg = config.Configuration{AppDataPath: dir}
+	report.FailureOutput = make(map[string][]string)
+
+	item := catalog.Item{DisplayName: "Chef Client/1.2"}
+	logDir := filepath.Join(dir, "logs", "Chef_Client_1.2")
+	options := captureOutput(item, "install", runOptions{})
+	if have, want := options.LogFile, filepath.Join(logDir, "install.log"); have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+
+	// msiexec logs in UTF-16 with a byte order mark
+	msiLog := []byte{0xff, 0xfe}
+	for _, r := range "MSI (s) (A0:B4): Product: Chef Client -- Installation failed.\r\n=== Logging stopped ===\r\n" {
+		msiLog = append(msiLog, byte(r), byte(r>>8))
+	}
+	if err := ioutil.WriteFile(filepath.Join(logDir, "msi-install.log"), msiLog, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	options.OnFailure([]string{"Setup failed"})
+	expected := map[string][]string{
+		"Chef Client/1.2": {"Setup failed", "MSI (s) (A0:B4): Product: Chef Client -- Installation failed.", "=== Logging stopped ==="},
+	}
+	if have, want := report.FailureOutput, expected; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %v, want %v", have, want)
+	}
+}
+
+// TestRunCommandInterrupted verifies that a command is killed once the run is interrupted, and nothing new is started
+func TestRunCommandInterrupted(t *testing.T) {
+	// Override execCommand with our fake version
+	execCommand = fakeExecCommand
+	ctx, cancel := context.WithCancel(context.Background())
+	SetContext(ctx)
+	origPending := report.PendingItems
+	defer func() {
+		execCommand = origExec
+		SetContext(context.Background())
+		report.PendingItems = origPending
+	}()
+
+	start := time.Now()
+	time.AfterFunc(500*time.Millisecond, cancel)
+	_, err := runCommand("_gorilla_dev_sleep_", nil, runOptions{})
+	if err == nil || !strings.Contains(err.Error(), "interrupted") {
+		t.Errorf("runCommand did not return an interrupted error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 30*time.Second {
+		t.Errorf("runCommand was not killed after the interruption, ran for %v", elapsed)
+	}
+
+	if have, want := Install(catalog.Item{DisplayName: "Chocolatey"}, "install", "", "", false), "Interrupted"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+}
+
+// TestRunCommandOptions verifies the working directory and run as options are honored
+func TestRunCommandOptions(t *testing.T) {
+	// Override execCommand with our fake version
+	execCommand = fakeExecCommand
+	defer func() { execCommand = origExec }()
+
+	dir, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	actualDir, err := runCommand("_gorilla_dev_pwd_", nil, runOptions{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := actualDir, dir; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+
+	// Unknown values are rejected without running anything
+	_, err = runCommand("echo", nil, runOptions{RunAs: "administrator"})
+	if err == nil {
+		t.Error("runCommand did not return an error for an unsupported run_as value")
+	}
+
+	// The working directory defaults to the directory of the installer
+	// Functions cant be compared, so OnExit is checked along with the exit codes
+	options := func(item catalog.Item) runOptions {
+		options := commandOptions(item, item.Installer, filepath.Join("testdata", "packages", "test.exe"))
+		options.OnExit = nil
+		return options
+	}
+	item := catalog.Item{Installer: catalog.InstallerItem{RunAs: "user"}}
+	expected := runOptions{Timeout: defaultInstallerTimeout, Dir: filepath.Join("testdata", "packages"), RunAs: "user"}
+	if have, want := options(item), expected; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %#v, want %#v", have, want)
+	}
+	item.Installer.WorkingDirectory = `C:\Installers\Extracted`
+	expected.Dir = `C:\Installers\Extracted`
+	if have, want := options(item), expected; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %#v, want %#v", have, want)
+	}
+}
+
+// TestRunCommandSuccessCodes verifies that only the configured exit codes are treated as success
+func TestRunCommandSuccessCodes(t *testing.T) {
+	// Override execCommand with our fake version
+	execCommand = fakeExecCommand
+	defer func() { execCommand = origExec }()
+
+	_, err := runCommand("_gorilla_dev_exit_", nil, runOptions{})
+	if err == nil {
+		t.Error("runCommand did not return an error for a non-zero exit code")
+	}
+	_, err = runCommand("_gorilla_dev_exit_", nil, runOptions{SuccessCodes: []int{3010, 42}})
+	if err != nil {
+		t.Errorf("runCommand returned an error for a success code: %v", err)
+	}
+
+	// Restart codes are a success too, and the exit code is passed along either way
+	var exitCodes []int
+	onExit := func(code int) { exitCodes = append(exitCodes, code) }
+	if _, err = runCommand("_gorilla_dev_exit_", nil, runOptions{RebootCodes: []int{42}, OnExit: onExit}); err != nil {
+		t.Errorf("runCommand returned an error for a restart code: %v", err)
+	}
+	runCommand("_gorilla_dev_", nil, runOptions{OnExit: onExit})
+	if have, want := exitCodes, []int{42, 0}; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %v, want %v", have, want)
+	}
+
+	// Success codes come from the installer or uninstaller being run
+	item := catalog.Item{Installer: catalog.InstallerItem{SuccessCodes: []int{17}}}
+	if have, want := commandOptions(item, item.Installer, "test.exe").SuccessCodes, []int{17}; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %v, want %v", have, want)
+	}
+
+	// Msi items get msiexec's codes, unless they set their own
+	item = catalog.Item{DisplayName: "Chef Client", Installer: catalog.InstallerItem{Type: "msi"}}
+	options := commandOptions(item, item.Installer, "test.msi")
+	if !reflect.DeepEqual(options.SuccessCodes, []int{1707}) || !reflect.DeepEqual(options.RebootCodes, []int{3010, 1641}) {
+		t.Errorf("Unexpected msi exit codes: %v %v", options.SuccessCodes, options.RebootCodes)
+	}
+	item.Installer.RebootExitCodes = []int{194}
+	options = commandOptions(item, item.Installer, "test.msi")
+	if have, want := options.RebootCodes, []int{194}; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %v, want %v", have, want)
+	}
+
+	// A restart code requires a restart, and every exit code other than zero is reported
+	report.ExitCodes = make(map[string]int)
+	defer func() {
+		restartAction, restartItems = "", nil
+		report.ExitCodes = make(map[string]int)
+	}()
+	options.OnExit(1)
+	if action, _ := RestartNeeded(); action != "" {
+		t.Errorf("A failure asked for a restart: %s", action)
+	}
+	options.OnExit(194)
+	if action, items := RestartNeeded(); action != RestartRequired || !reflect.DeepEqual(items, []string{"Chef Client"}) {
+		t.Errorf("have %s %v, want %s for Chef Client", action, items, RestartRequired)
+	}
+	if have, want := report.ExitCodes, map[string]int{"Chef Client": 194}; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %v, want %v", have, want)
+	}
+}
+
+// TestNotify verifies the notification command is only run when configured, with its placeholders filled in
+func TestNotify(t *testing.T) {
+	var actualCommands [][]string
+	var actualOptions runOptions
+	runCommand = func(command string, arguments []string, options runOptions) (string, error) {
+		actualCommands = append(actualCommands, append([]string{command}, arguments...))
+		actualOptions = options
+		return "", nil
+	}
+	defer func() {
+		runCommand = origRunCommand
+		SetConfig(config.Configuration{})
+	}()
+	item := catalog.Item{DisplayName: "Chef Client", Version: "1.2.3"}
+
+	// Nothing should run without a command
+	SetConfig(config.Configuration{})
+	notifyInstalled(item)
+	if len(actualCommands) != 0 {
+		t.Errorf("A notification was sent without a command configured: %#v", actualCommands)
+	}
+
+	// With a command, the default messages are used
+	SetConfig(config.Configuration{NotifyCommand: []string{"notifier.exe", "-event", "{{.Event}}", "{{.Message}}"}})
+	notifyInstalled(item)
+	NotifyReboot()
+	expected := [][]string{
+		{"notifier.exe", "-event", "install", "Chef Client 1.2.3 has been installed"},
+		{"notifier.exe", "-event", "reboot", "Please restart your computer to finish installing software"},
+	}
+	if !reflect.DeepEqual(expected, actualCommands) {
+		t.Errorf("\n-----\nhave\n%#v\nwant\n%#v\n-----", actualCommands, expected)
+	}
+	if have, want := actualOptions.RunAs, "user"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+
+	// A custom message
+	actualCommands = nil
+	SetConfig(config.Configuration{
+		NotifyCommand: []string{"notifier.exe", "{{.Message}}"},
+		NotifyMessage: "{{.DisplayName}} is ready to use",
+	})
+	notifyInstalled(item)
+	expected = [][]string{{"notifier.exe", "Chef Client is ready to use"}}
+	if !reflect.DeepEqual(expected, actualCommands) {
+		t.Errorf("\n-----\nhave\n%#v\nwant\n%#v\n-----", actualCommands, expected)
+	}
+}
+
+// TestNotifyToast verifies toasts are shown when enabled, and that nothing is shown during quiet hours
+func TestNotifyToast(t *testing.T) {
+	var actualCommands [][]string
+	runCommand = func(command string, arguments []string, options runOptions) (string, error) {
+		actualCommands = append(actualCommands, append([]string{command}, arguments...))
+		return "", nil
+	}
+	timeNow = func() time.Time { return time.Date(2021, 6, 1, 23, 30, 0, 0, time.UTC) }
+	defer func() {
+		runCommand = origRunCommand
+		timeNow = time.Now
+		SetConfig(config.Configuration{})
+	}()
+	item := catalog.Item{DisplayName: "Chef Client", Version: "1.2.3"}
+
+	// A toast is shown with PowerShell, with the message encoded
+	SetConfig(config.Configuration{NotifyToast: true})
+	NotifyDeadline(item, time.Date(2021, 6, 4, 17, 0, 0, 0, time.UTC))
+	if len(actualCommands) != 1 {
+		t.Fatalf("Expected a single toast, got %#v", actualCommands)
+	}
+	command := actualCommands[0]
+	if have, want := command[0], commandPs1; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+	if have, want := command[len(command)-2], "-EncodedCommand"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+	_, expected := toastCommand("Software installing soon", "Chef Client 1.2.3 will be installed after Friday, June 4 at 5:00 PM, please save your work before then")
+	if have, want := command[len(command)-1], expected[len(expected)-1]; have != want {
+		t.Errorf("The toast did not have the expected message")
+	}
+
+	// A notification command takes the place of toasts
+	actualCommands = nil
+	SetConfig(config.Configuration{NotifyToast: true, NotifyCommand: []string{"notifier.exe", "{{.Message}}"}})
+	NotifyReboot()
+	if want := [][]string{{"notifier.exe", "Please restart your computer to finish installing software"}}; !reflect.DeepEqual(want, actualCommands) {
+		t.Errorf("\n-----\nhave\n%#v\nwant\n%#v\n-----", actualCommands, want)
+	}
+
+	// Quiet hours skip notifications
+	actualCommands = nil
+	SetConfig(config.Configuration{NotifyToast: true, NotifyQuietHours: "22:00-07:00"})
+	NotifyReboot()
+	if len(actualCommands) != 0 {
+		t.Errorf("A notification was sent during quiet hours: %#v", actualCommands)
+	}
+
+	// Unless someone started the run themselves
+	SetConfig(config.Configuration{NotifyToast: true, NotifyQuietHours: "22:00-07:00", Mode: config.ModeManual})
+	NotifyReboot()
+	if len(actualCommands) != 1 {
+		t.Errorf("Expected a notification during quiet hours in a manual run, got %#v", actualCommands)
+	}
+}
+
+// TestQuietHours verifies quiet hours, including those that run past midnight
+func TestQuietHours(t *testing.T) {
+	at := func(hour, minute int) time.Time { return time.Date(2021, 6, 1, hour, minute, 0, 0, time.UTC) }
+	tests := []struct {
+		hours    string
+		now      time.Time
+		expected bool
+	}{
+		{"", at(23, 0), false},
+		{"22:00-07:00", at(23, 0), true},
+		{"22:00-07:00", at(6, 59), true},
+		{"22:00-07:00", at(7, 0), false},
+		{"22:00-07:00", at(12, 0), false},
+		{"12:00-13:30", at(13, 15), true},
+		{"12:00-13:30", at(11, 59), false},
+		{"12:00 - 13:30", at(12, 0), true},
+		{"noon", at(12, 0), false},
+	}
+	for _, test := range tests {
+		if have := inQuietHours(test.hours, test.now); have != test.expected {
+			t.Errorf("%q at %s: have %v, want %v", test.hours, test.now.Format("15:04"), have, test.expected)
+		}
+	}
+}
+
+// TestRestartNeeded verifies that the most urgent restart is kept, along with every item that asked for one
+func TestRestartNeeded(t *testing.T) {
+	defer func() { restartAction, restartItems = "", nil }()
+
+	recordRestart(catalog.Item{DisplayName: "Firefox"})
+	recordRestart(catalog.Item{DisplayName: "Chrome", RestartAction: RestartNone})
+	if action, items := RestartNeeded(); action != "" || items != nil {
+		t.Errorf("Expected no restart, got %s for %v", action, items)
+	}
+
+	recordRestart(catalog.Item{DisplayName: "Driver", RestartAction: RestartRequired})
+	recordRestart(catalog.Item{DisplayName: "Office", RestartAction: RestartRecommended})
+	action, items := RestartNeeded()
+	if action != RestartRequired {
+		t.Errorf("have %s, want %s", action, RestartRequired)
+	}
+	if want := []string{"Driver", "Office"}; !reflect.DeepEqual(want, items) {
+		t.Errorf("have %v, want %v", items, want)
+	}
+}
+
+// TestInstallerTimeout verifies that an item's timeout overrides the configured default
+func TestInstallerTimeout(t *testing.T) {
+	SetConfig(config.Configuration{InstallerTimeout: 600})
+	defer SetConfig(config.Configuration{})
+
+	if have, want := installerTimeout(catalog.Item{}), 600*time.Second; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	if have, want := installerTimeout(catalog.Item{InstallerTimeout: 2700}), 2700*time.Second; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	if have, want := downloadTimeout(catalog.Item{DownloadTimeout: 60}), 60*time.Second; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+
+	// Installers always have a timeout
+	SetConfig(config.Configuration{})
+	if have, want := installerTimeout(catalog.Item{}), time.Hour; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+}
+
+// writeTestZip creates a zip containing the provided file names
+func writeTestZip(t *testing.T, path string, names []string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := zip.NewWriter(f)
+	for _, name := range names {
+		entry, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprint(entry, name)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestZip verifies that a zip is extracted to the destination and removed using its receipt
+func TestZip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gorilla_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	SetConfig(config.Configuration{AppDataPath: filepath.Join(dir, "appdata")})
+	defer SetConfig(config.Configuration{})
+
+	destination := filepath.Join(dir, "tool")
+	item := catalog.Item{
+		DisplayName: "Tool",
+		Installer:   catalog.InstallerItem{Type: "zip", Destination: destination},
+		Uninstaller: catalog.InstallerItem{Type: "zip"},
+	}
+
+	// Extract a zip with nested directories
+	zipPath := filepath.Join(dir, "tool.zip")
+	writeTestZip(t, zipPath, []string{"tool.exe", "bin/", "bin/helper.dll", "conf/settings.ini"})
+	_, err = installZip(item, zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"tool.exe", "bin/helper.dll", "conf/settings.ini"} {
+		contents, err := ioutil.ReadFile(filepath.Join(destination, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have, want := string(contents), name; have != want {
+			t.Errorf("have %s, want %s", have, want)
+		}
+	}
+
+	// Uninstalling removes the files, the empty directories, and the receipt
+	_, err = uninstallZip(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(destination); !os.IsNotExist(err) {
+		t.Errorf("Destination still exists after uninstalling: %s", destination)
+	}
+	if _, err := os.Stat(receiptPath(item)); !os.IsNotExist(err) {
+		t.Errorf("Receipt still exists after uninstalling: %s", receiptPath(item))
+	}
+
+	// Entries that escape the destination are rejected before anything is extracted
+	for _, name := range []string{"../evil.txt", "bin/../../evil.txt", "/evil.txt"} {
+		writeTestZip(t, zipPath, []string{"good.txt", name})
+		_, err = installZip(item, zipPath)
+		if err == nil {
+			t.Errorf("installZip did not return an error for %s", name)
+		}
+		if _, err := os.Stat(destination); !os.IsNotExist(err) {
+			t.Errorf("installZip extracted files from an archive containing %s", name)
+		}
+	}
+}
+
 // TestInstallItem validate the command that is passed to
 // exec.Command for each installer type
 func TestInstallItem(t *testing.T) {
@@ -215,7 +766,7 @@ func TestInstallItem(t *testing.T) {
 	nupkgFile := filepath.Join(pkgCache, nupkgPath)
 	nupkgDir := filepath.Dir(nupkgFile)
 	nupkgID := fmt.Sprintf("[%s list --version=1.2.3 --id-only -r -s %s]", nupkgCmd, nupkgDir)
-	expectedNupkg := fmt.Sprintf("[%s install %s -s %s --version=1.2.3 -f -y -r]", nupkgCmd, nupkgID, nupkgDir)
+	expectedNupkg := fmt.Sprintf("[%s install %s -s %s --version=1.2.3 -f -y -r --install-arguments=/L=1033 /S]", nupkgCmd, nupkgID, nupkgDir)
 	if have, want := actualNupkg, expectedNupkg; have != want {
 		t.Errorf("\n-----\nhave\n%s\nwant\n%s\n-----", have, want)
 	}
@@ -268,13 +819,181 @@ func TestInstallItem(t *testing.T) {
 	// Check the result
 	ps1Cmd := filepath.Join(os.Getenv("WINDIR"), "system32/WindowsPowershell/v1.0/powershell.exe")
 	ps1File := filepath.Join(pkgCache, ps1Path)
-	expectedPs1 := "[" + ps1Cmd + " -NoProfile -NoLogo -NonInteractive -ExecutionPolicy Bypass -File " + ps1File + "]"
+	expectedPs1 := "[" + ps1Cmd + " -NoProfile -NoLogo -NonInteractive -ExecutionPolicy Bypass -File " + ps1File + " /L=1033 /S]"
 	if have, want := actualPs1, expectedPs1; have != want {
 		t.Errorf("\n-----\nhave\n%s\nwant\n%s\n-----", have, want)
 	}
 
 }
 
+// TestRunAtLogon verifies that per-user installers are registered to run as users log on, instead of being run
+func TestRunAtLogon(t *testing.T) {
+	execCommand = fakeExecCommand
+	registered := make(map[string][]string)
+	registerLogon = func(id, name, version, command string) error {
+		registered[id] = []string{name, version, command}
+		return nil
+	}
+	unregisterLogon = func(id string) error {
+		delete(registered, id)
+		return nil
+	}
+	report.FailedItems = nil
+	defer func() {
+		execCommand = origExec
+		registerLogon = setActiveSetup
+		unregisterLogon = removeActiveSetup
+		report.FailedItems = nil
+	}()
+
+	item := msiItem
+	item.DisplayName = "Zoom Client"
+	item.Version = "5.11.1 (6602)"
+	item.Installer.RunAs = "logon"
+	item.Installer.Arguments = []string{"MSIINSTALLPERUSER=1", `INSTALLDIR=C:\Program Files\Zoom\`}
+	item.Uninstaller.RunAs = "logon"
+
+	// The msi is registered, without a log since users cant write one
+	msiFile := filepath.Join("testdata/packages", "chef-client/chef-client-14.3.37-1-x64.msi")
+	expected := commandMsi + " /i " + msiFile + ` /qn /norestart MSIINSTALLPERUSER=1 "INSTALLDIR=C:\Program Files\Zoom\\"`
+	if strings.Contains(commandMsi, " ") {
+		expected = `"` + commandMsi + `"` + expected[len(commandMsi):]
+	}
+	if have := installItem(item, "https://example.com/packages/chef-client/chef-client-14.3.37-1-x64.msi", "testdata/"); have != expected {
+		t.Errorf("\n-----\nhave\n%s\nwant\n%s\n-----", have, expected)
+	}
+	want := map[string][]string{"Gorilla.Zoom_Client.install": {"Zoom Client", "5,11,1,6602", expected}}
+	if !reflect.DeepEqual(registered, want) {
+		t.Errorf("\n-----\nhave\n%#v\nwant\n%#v\n-----", registered, want)
+	}
+
+	// Uninstalling replaces the install
+	uninstallItem(item, "https://example.com/packages/chef-client/chef-client-14.3.37-1-x64uninst.msi", "testdata/")
+	if _, ok := registered["Gorilla.Zoom_Client.install"]; ok || len(registered) != 1 {
+		t.Errorf("Expected only the uninstall to be registered, have %#v", registered)
+	}
+	if len(report.FailedItems) != 0 {
+		t.Errorf("Expected no failures, have %d", len(report.FailedItems))
+	}
+
+	// Arguments are only quoted when they need to be
+	for _, test := range []struct {
+		arguments []string
+		expected  string
+	}{
+		{[]string{"setup.exe", "/S"}, `setup.exe /S`},
+		{[]string{"setup.exe", ""}, `setup.exe ""`},
+		{[]string{"setup.exe", `say "hi"`}, `setup.exe "say \"hi\""`},
+		{[]string{"setup.exe", `\\server\share\`}, `setup.exe \\server\share\`},
+	} {
+		if have := commandLine(test.arguments[0], test.arguments[1:]); have != test.expected {
+			t.Errorf("%q: have %s, want %s", test.arguments, have, test.expected)
+		}
+	}
+	if have, want := logonVersion("beta"), "1"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+}
+
+// TestMsiLogArguments verifies that msi logs are written to the item's log directory with a safe name
+func TestMsiLogArguments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gorilla_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	origCfg := installerCfg
+	defer func() { installerCfg = origCfg }()
+
+	// Without an app data path, there is nowhere to write a log
+	installerCfg = config.Configuration{}
+	if args := msiLogArguments(catalog.Item{DisplayName: "Chef Client"}, "install"); args != nil {
+		t.Errorf("expected no log arguments, got %v", args)
+	}
+
+	installerCfg = config.Configuration{AppDataPath: dir}
+	expected := []string{"/L*V", filepath.Join(dir, "logs", "Chef_Client_1.2", "msi-install.log")}
+	if have, want := msiLogArguments(catalog.Item{DisplayName: "Chef Client/1.2"}, "install"), expected; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "logs", "Chef_Client_1.2")); err != nil {
+		t.Errorf("msi log directory was not created: %v", err)
+	}
+}
+
+// TestAppxCommands verifies that appx and msix packages are provisioned for every user unless they run as the user
+func TestAppxCommands(t *testing.T) {
+	absFile := filepath.Join("testdata", "packages", "Example App.msix")
+
+	// Provisioned with DISM, skipping the license unless one is provided
+	cmd, args := appxInstallCommand(catalog.InstallerItem{Type: "msix"}, absFile, nil)
+	expected := []string{"/Online", "/Add-ProvisionedAppxPackage", "/PackagePath:" + absFile, "/SkipLicense"}
+	if cmd != commandDism || !reflect.DeepEqual(args, expected) {
+		t.Errorf("have %s %v, want %s %v", cmd, args, commandDism, expected)
+	}
+	license := []string{"/LicensePath:C:\\license.xml"}
+	_, args = appxInstallCommand(catalog.InstallerItem{Type: "msix"}, absFile, license)
+	expected = []string{"/Online", "/Add-ProvisionedAppxPackage", "/PackagePath:" + absFile, "/LicensePath:C:\\license.xml"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("have %v, want %v", args, expected)
+	}
+
+	// Added for the current user
+	cmd, args = appxInstallCommand(catalog.InstallerItem{Type: "appx", RunAs: "user"}, absFile, nil)
+	if have, want := args[len(args)-1], "Add-AppxPackage -Path '"+absFile+"'"; cmd != commandPs1 || have != want {
+		t.Errorf("have %s %s, want %s %s", cmd, have, commandPs1, want)
+	}
+
+	// Package names are quoted, and removed for every user along with the provisioned package
+	_, args = appxUninstallCommand(catalog.InstallerItem{Type: "msix"}, "Vendor.O'Brien")
+	if have := args[len(args)-1]; !strings.Contains(have, "Remove-AppxProvisionedPackage") || !strings.Contains(have, "-Name 'Vendor.O''Brien'") {
+		t.Errorf("unexpected uninstall command: %s", have)
+	}
+	_, args = appxUninstallCommand(catalog.InstallerItem{Type: "msix", RunAs: "user"}, "Vendor.App")
+	if have, want := args[len(args)-1], "Get-AppxPackage -Name 'Vendor.App' | Remove-AppxPackage"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+}
+
+// TestExpandArguments verifies that argument placeholders are filled in and invalid ones are rejected
+func TestExpandArguments(t *testing.T) {
+	os.Setenv("GORILLA_TEST_LICSERVER", "lic.example.com")
+	defer os.Unsetenv("GORILLA_TEST_LICSERVER")
+	hostname, _ := os.Hostname()
+
+	item := catalog.Item{DisplayName: "Chef Client", Version: "1.2.3"}
+	arguments := []string{
+		`/S`,
+		`LICSERVER={{env "GORILLA_TEST_LICSERVER"}}`,
+		`/LOG={{.CachePath}}\{{.DisplayName}}-{{.Version}}.log`,
+		`HOST={{.Hostname}}`,
+		`{{.Installer}}`,
+	}
+
+	expected := []string{
+		`/S`,
+		`LICSERVER=lic.example.com`,
+		`/LOG=testdata/\Chef Client-1.2.3.log`,
+		`HOST=` + hostname,
+		`testdata/packages/test.exe`,
+	}
+	actual, err := expandArguments(item, arguments, "testdata/packages/test.exe", "testdata/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("\n-----\nhave\n%#v\nwant\n%#v\n-----", actual, expected)
+	}
+
+	// Unknown values and malformed templates should return an error
+	for _, invalid := range []string{`{{.Password}}`, `{{.CachePath`, `{{exec "cmd"}}`} {
+		if _, err := expandArguments(item, []string{invalid}, "", ""); err == nil {
+			t.Errorf("expandArguments did not return an error for %s", invalid)
+		}
+	}
+}
+
 // TestInstallStatusError verifies that Install returns if status check fails
 func TestInstallStatusError(t *testing.T) {
 	// Override checkStatus with our fake version
@@ -345,7 +1064,7 @@ func TestUninstallItem(t *testing.T) {
 	nupkgFile := filepath.Join(pkgCache, nupkgPath)
 	nupkgDir := filepath.Dir(nupkgFile)
 	nupkgID := fmt.Sprintf("[%s list --version=1.2.3 --id-only -r -s %s]", nupkgCmd, nupkgDir)
-	expectedNupkg := fmt.Sprintf("[%s uninstall %s -s %s --version=1.2.3 -f -y -r]", nupkgCmd, nupkgID, nupkgDir)
+	expectedNupkg := fmt.Sprintf("[%s uninstall %s -s %s --version=1.2.3 -f -y -r --uninstall-arguments=/U=1033 /S]", nupkgCmd, nupkgID, nupkgDir)
 	if have, want := actualNupkg, expectedNupkg; have != want {
 		t.Errorf("\n-----\nhave\n%s\nwant\n%s\n-----", have, want)
 	}
@@ -359,11 +1078,23 @@ func TestUninstallItem(t *testing.T) {
 	// Check the result
 	msiCmd := filepath.Join(os.Getenv("WINDIR"), "system32/msiexec.exe")
 	msiPath := filepath.Clean("testdata/packages/chef-client/chef-client-14.3.37-1-x64uninst.msi")
-	expectedMsi := "[" + msiCmd + " /x " + msiPath + " /qn /norestart]"
+	expectedMsi := "[" + msiCmd + " /x " + msiPath + " /qn /norestart /U=1033 /S]"
 	if have, want := actualMsi, expectedMsi; have != want {
 		t.Errorf("\n-----\nhave\n%s\nwant\n%s\n-----", have, want)
 	}
 
+	// An msi with a product code is uninstalled without downloading anything
+	productCodeItem := catalog.Item{
+		DisplayName: statusNoActionNoError,
+		Installer:   catalog.InstallerItem{Type: "msi", ProductCode: "{6F1D1E2C-6F5A-4E55-9C3C-2F1B4F1E8A11}"},
+		Uninstaller: catalog.InstallerItem{Type: "msi"},
+	}
+	actualProductCode := uninstallItem(productCodeItem, "https://example.com/does-not-exist", cachePath)
+	expectedProductCode := "[" + msiCmd + " /x {6F1D1E2C-6F5A-4E55-9C3C-2F1B4F1E8A11} /qn /norestart]"
+	if have, want := actualProductCode, expectedProductCode; have != want {
+		t.Errorf("\n-----\nhave\n%s\nwant\n%s\n-----", have, want)
+	}
+
 	//
 	// Exe
 	//
@@ -386,11 +1117,31 @@ func TestUninstallItem(t *testing.T) {
 	// Check the result
 	ps1Cmd := filepath.Join(os.Getenv("WINDIR"), "system32/WindowsPowershell/v1.0/powershell.exe")
 	ps1Path := filepath.Clean("testdata/packages/chef-client/chef-client-14.3.37-1-x64uninst.ps1")
-	expectedPs1 := "[" + ps1Cmd + " -NoProfile -NoLogo -NonInteractive -ExecutionPolicy Bypass -File " + ps1Path + "]"
+	expectedPs1 := "[" + ps1Cmd + " -NoProfile -NoLogo -NonInteractive -ExecutionPolicy Bypass -File " + ps1Path + " /U=1033 /S]"
 	if have, want := actualPs1, expectedPs1; have != want {
 		t.Errorf("\n-----\nhave\n%s\nwant\n%s\n-----", have, want)
 	}
 
+	// A command already on the computer is run in place, without downloading anything
+	commandItem := catalog.Item{
+		DisplayName: statusNoActionNoError,
+		Uninstaller: catalog.InstallerItem{
+			Type:      "command",
+			Command:   `C:\Program Files\Vendor\uninstall.exe`,
+			Arguments: []string{"/S", "/name={{.DisplayName}}"},
+		},
+	}
+	actualCommand := uninstallItem(commandItem, "https://example.com/does-not-exist", cachePath)
+	expectedCommand := `[C:\Program Files\Vendor\uninstall.exe /S /name=` + statusNoActionNoError + `]`
+	if have, want := actualCommand, expectedCommand; have != want {
+		t.Errorf("\n-----\nhave\n%s\nwant\n%s\n-----", have, want)
+	}
+
+	// An unknown uninstall method fails instead of guessing
+	unknownItem := catalog.Item{DisplayName: statusNoActionNoError, UninstallMethod: "magic"}
+	if have := uninstallItem(unknownItem, "https://example.com/does-not-exist", cachePath); !strings.Contains(have, "Unsupported uninstall method") {
+		t.Errorf("Expected an unsupported uninstall method, have %s", have)
+	}
 }
 
 // TestUninstallStatusError verifies that Uninstall returns if status check fails
@@ -499,6 +1250,344 @@ func TestInstallReport(t *testing.T) {
 
 }
 
+// TestCheckOnlyReport verifies that check only mode reports what would change without running anything
+func TestCheckOnlyReport(t *testing.T) {
+	// Override checkStatus and the install functions with our fake versions
+	statusCheckStatus = fakeCheckStatus
+	installItemFunc = fakeInstallItem
+	uninstallItemFunc = fakeUninstallItem
+	report.InstalledItems = []interface{}{}
+	report.UninstalledItems = []interface{}{}
+	installItemURL, uninstallItemURL = "", ""
+	defer func() {
+		statusCheckStatus = origCheckStatus
+		installItemFunc = origInstallItemFunc
+		uninstallItemFunc = origUninstallItemFunc
+		report.InstalledItems = origReportInstalled
+		report.UninstalledItems = origReportUninstalled
+	}()
+
+	// Every action is needed for this item
+	checkItem := msiItem
+	checkItem.DisplayName = statusActionNoError
+	for _, installerType := range []string{"install", "update", "uninstall"} {
+		if have, want := Install(checkItem, installerType, "https://example.com/", "testdata/", true), "Check only enabled"; have != want {
+			t.Errorf("%s: have %s, want %s", installerType, have, want)
+		}
+	}
+
+	// Nothing should have been run
+	if installItemURL != "" || uninstallItemURL != "" {
+		t.Errorf("Check only mode ran an installer: %s %s", installItemURL, uninstallItemURL)
+	}
+
+	// Installs and updates are reported as installed, uninstalls as uninstalled
+	if have, want := report.InstalledItems, []interface{}{checkItem, checkItem}; !reflect.DeepEqual(have, want) {
+		t.Errorf("\nExpected: %#v\nReceived: %#v", want, have)
+	}
+	if have, want := report.UninstalledItems, []interface{}{checkItem}; !reflect.DeepEqual(have, want) {
+		t.Errorf("\nExpected: %#v\nReceived: %#v", want, have)
+	}
+}
+
+// TestInstallScripts verifies that pre and post install scripts run around the installer,
+// and that a failed pre-install script stops the install
+func TestInstallScripts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gorilla_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Record each script that is run, failing any that ask to
+	var ran []string
+	statusCheckStatus = fakeCheckStatus
+	installItemFunc = fakeInstallItem
+	runCommand = func(command string, arguments []string, options runOptions) (string, error) {
+		scriptPath := arguments[len(arguments)-1]
+		contents, err := ioutil.ReadFile(scriptPath)
+		if err != nil {
+			return "", err
+		}
+		ran = append(ran, filepath.Base(command)+" "+filepath.Ext(scriptPath)+" "+string(contents))
+		if strings.Contains(string(contents), "fail") {
+			return "", fmt.Errorf("script failed")
+		}
+		return "", nil
+	}
+	report.FailedItems = []interface{}{}
+	defer func() {
+		statusCheckStatus = origCheckStatus
+		installItemFunc = origInstallItemFunc
+		runCommand = origRunCommand
+		report.FailedItems = nil
+	}()
+
+	item := msiItem
+	item.DisplayName = statusActionNoError
+	item.PreScript = "net stop example"
+	item.PreScriptType = "bat"
+	item.PostScript = "Write-Host done"
+
+	installItemURL = ""
+	Install(item, "install", "https://example.com/", dir, false)
+	expected := []string{"cmd.exe .bat net stop example", "powershell.exe .ps1 Write-Host done"}
+	if !reflect.DeepEqual(ran, expected) {
+		t.Errorf("\nExpected: %v\nReceived: %v", expected, ran)
+	}
+	if installItemURL == "" {
+		t.Errorf("The installer did not run")
+	}
+	if have, want := state.Get(item.DisplayName).LastResult, "success"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+
+	// The scripts are removed once they have run
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("Temporary scripts were not removed: %v", files)
+	}
+
+	// A failed pre-install script skips the installer and the post-install script
+	ran = nil
+	installItemURL = ""
+	item.PreScript = "fail"
+	if have, want := Install(item, "install", "https://example.com/", dir, false), "PreInstall-Script error"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+	if installItemURL != "" || len(ran) != 1 {
+		t.Errorf("Install continued after a failed pre-install script: %v", ran)
+	}
+	if len(report.FailedItems) != 1 {
+		t.Errorf("A failed pre-install script was not reported: %v", report.FailedItems)
+	}
+	if have, want := state.Get(item.DisplayName).LastResult, "failure"; have != want {
+		t.Errorf("A failed pre-install script was not recorded: have %s, want %s", have, want)
+	}
+
+	// Unknown script types are rejected without running anything
+	ran = nil
+	item.PreScriptType = "vbs"
+	if have, want := Install(item, "install", "https://example.com/", dir, false), "PreInstall-Script error"; have != want || len(ran) != 0 {
+		t.Errorf("have %s, want %s, ran %v", have, want, ran)
+	}
+
+	// A failed post-install script is reported and recorded, even though the installer ran
+	ran = nil
+	installItemURL = ""
+	report.FailedItems = []interface{}{}
+	item.PreScript = ""
+	item.PostScript = "fail"
+	if have, want := Install(item, "install", "https://example.com/", dir, false), "PostInstall-Script error"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+	if installItemURL == "" {
+		t.Errorf("The installer did not run")
+	}
+	if len(report.FailedItems) != 1 {
+		t.Errorf("A failed post-install script was not reported: %v", report.FailedItems)
+	}
+	if have, want := state.Get(item.DisplayName).LastResult, "failure"; have != want {
+		t.Errorf("A failed post-install script was not recorded: have %s, want %s", have, want)
+	}
+}
+
+// TestBlockingApps verifies that an item is deferred while one of its blocking apps is running
+func TestBlockingApps(t *testing.T) {
+	statusCheckStatus = fakeCheckStatus
+	installItemFunc = fakeInstallItem
+	uninstallItemFunc = fakeUninstallItem
+	runningProcesses = func() ([]string, error) {
+		return []string{"System", "explorer.exe", "Firefox.exe"}, nil
+	}
+	report.PendingItems = []interface{}{}
+	defer func() {
+		statusCheckStatus = origCheckStatus
+		installItemFunc = origInstallItemFunc
+		uninstallItemFunc = origUninstallItemFunc
+		runningProcesses = origRunningProcesses
+		report.PendingItems = nil
+	}()
+
+	item := msiItem
+	item.DisplayName = statusActionNoError
+
+	// Names are matched without case, with or without the extension
+	for _, blocking := range [][]string{{"firefox"}, {"notepad.exe", "firefox.exe"}} {
+		item.BlockingApps = blocking
+		for _, installerType := range []string{"install", "uninstall"} {
+			installItemURL, uninstallItemURL = "", ""
+			if have, want := Install(item, installerType, "https://example.com/", "testdata/", false), "Blocking app running"; have != want {
+				t.Errorf("%v %s: have %s, want %s", blocking, installerType, have, want)
+			}
+			if installItemURL != "" || uninstallItemURL != "" {
+				t.Errorf("%v %s: ran while a blocking app was running", blocking, installerType)
+			}
+		}
+	}
+	if have, want := len(report.PendingItems), 4; have != want {
+		t.Errorf("have %d pending items, want %d", have, want)
+	}
+
+	// Apps that arent running dont block anything
+	item.BlockingApps = []string{"notepad.exe"}
+	installItemURL = ""
+	Install(item, "install", "https://example.com/", "testdata/", false)
+	if installItemURL == "" {
+		t.Errorf("The installer did not run")
+	}
+}
+
+// TestForceInstall verifies that blocking apps are closed once an item's deadline has passed, after warning the user
+func TestForceInstall(t *testing.T) {
+	statusCheckStatus = fakeCheckStatus
+	installItemFunc = fakeInstallItem
+	running := []string{"explorer.exe", "Firefox.exe"}
+	runningProcesses = func() ([]string, error) {
+		return running, nil
+	}
+	var killed []string
+	killProcess = func(names []string) error {
+		killed = names
+		running = []string{"explorer.exe"}
+		return nil
+	}
+	var waited time.Duration
+	timeAfter = func(d time.Duration) <-chan time.Time {
+		waited = d
+		c := make(chan time.Time, 1)
+		c <- time.Time{}
+		return c
+	}
+	var notifications []string
+	runCommand = func(command string, arguments []string, options runOptions) (string, error) {
+		notifications = append(notifications, arguments[0])
+		return "", nil
+	}
+	timeNow = func() time.Time { return time.Date(2021, 6, 1, 12, 0, 0, 0, time.Local) }
+	SetConfig(config.Configuration{NotifyCommand: []string{"notifier.exe", "{{.Message}}"}})
+	report.PendingItems = []interface{}{}
+	defer func() {
+		statusCheckStatus = origCheckStatus
+		installItemFunc = origInstallItemFunc
+		runningProcesses = origRunningProcesses
+		killProcess = origKillProcess
+		timeAfter = origTimeAfter
+		runCommand = origRunCommand
+		timeNow = time.Now
+		SetConfig(config.Configuration{})
+		report.PendingItems = nil
+	}()
+
+	item := msiItem
+	item.DisplayName = statusActionNoError
+	item.Version = "2.0"
+	item.BlockingApps = []string{"firefox"}
+
+	// Before the deadline, the item is deferred and the user is reminded
+	item.ForceInstallAfterDate = "2021-06-04T17:00"
+	installItemURL = ""
+	if have, want := Install(item, "install", "https://example.com/", "testdata/", false), "Blocking app running"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+	expected := []string{statusActionNoError + " 2.0 will be installed after Friday, June 4 at 5:00 PM, please save your work before then"}
+	if !reflect.DeepEqual(expected, notifications) || installItemURL != "" || killed != nil {
+		t.Errorf("Before the deadline, have notifications %#v, killed %v, installed %v", notifications, killed, installItemURL != "")
+	}
+
+	// An unreadable deadline never forces an install
+	notifications = nil
+	item.ForceInstallAfterDate = "soon"
+	if have, want := Install(item, "install", "https://example.com/", "testdata/", false), "Blocking app running"; have != want || killed != nil {
+		t.Errorf("have %s, want %s, killed %v", have, want, killed)
+	}
+
+	// After the deadline, the user is warned before the apps are closed and the item is installed
+	item.ForceInstallAfterDate = "2021-06-01"
+	Install(item, "install", "https://example.com/", "testdata/", false)
+	expected = []string{statusActionNoError + " 2.0 is past its deadline, please save your work since open apps will be closed at 12:05 PM"}
+	if !reflect.DeepEqual(expected, notifications) {
+		t.Errorf("\n-----\nhave\n%#v\nwant\n%#v\n-----", notifications, expected)
+	}
+	if waited != 5*time.Minute || !reflect.DeepEqual(killed, item.BlockingApps) {
+		t.Errorf("Waited %v and killed %v", waited, killed)
+	}
+	if installItemURL == "" {
+		t.Errorf("The installer did not run after the deadline")
+	}
+	if have, want := len(report.PendingItems), 2; have != want {
+		t.Errorf("have %d pending items, want %d", have, want)
+	}
+}
+
+// TestForceInstallTogether verifies every item past its deadline shares one warning, so the run only waits once
+func TestForceInstallTogether(t *testing.T) {
+	statusCheckStatus = fakeCheckStatus
+	installItemFunc = fakeInstallItem
+	running := []string{"firefox.exe", "chrome.exe", "excel.exe"}
+	runningProcesses = func() ([]string, error) {
+		return running, nil
+	}
+	killProcess = func(names []string) error {
+		var left []string
+		for _, name := range running {
+			if !strings.EqualFold(strings.TrimSuffix(name, ".exe"), names[0]) {
+				left = append(left, name)
+			}
+		}
+		running = left
+		return nil
+	}
+	// Time moves on while we wait
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.Local)
+	var waits []time.Duration
+	timeAfter = func(d time.Duration) <-chan time.Time {
+		waits = append(waits, d)
+		now = now.Add(d)
+		c := make(chan time.Time, 1)
+		c <- time.Time{}
+		return c
+	}
+	var notifications []string
+	runCommand = func(command string, arguments []string, options runOptions) (string, error) {
+		notifications = append(notifications, arguments[0])
+		return "", nil
+	}
+	timeNow = func() time.Time { return now }
+	SetConfig(config.Configuration{NotifyCommand: []string{"notifier.exe", "{{.Message}}"}})
+	defer func() {
+		statusCheckStatus = origCheckStatus
+		installItemFunc = origInstallItemFunc
+		runningProcesses = origRunningProcesses
+		killProcess = origKillProcess
+		timeAfter = origTimeAfter
+		runCommand = origRunCommand
+		timeNow = time.Now
+		SetConfig(config.Configuration{})
+		report.PendingItems = nil
+	}()
+
+	var items []catalog.Item
+	for _, app := range []string{"firefox", "chrome", "excel"} {
+		items = append(items, catalog.Item{DisplayName: statusActionNoError, Version: "2.0", BlockingApps: []string{app}, ForceInstallAfterDate: "2021-06-01"})
+	}
+	Download(items, "install", "https://example.com/", "testdata/")
+	for _, item := range items {
+		Install(item, "install", "https://example.com/", "testdata/", false)
+	}
+
+	expected := []string{strings.Join([]string{statusActionNoError, statusActionNoError, statusActionNoError}, ", ") +
+		" are past their deadlines, please save your work since open apps will be closed at 12:05 PM"}
+	if !reflect.DeepEqual(expected, notifications) {
+		t.Errorf("\n-----\nhave\n%#v\nwant\n%#v\n-----", notifications, expected)
+	}
+	if have, want := waits, []time.Duration{5 * time.Minute}; !reflect.DeepEqual(have, want) {
+		t.Errorf("have waits %v, want %v", have, want)
+	}
+	if len(running) != 0 {
+		t.Errorf("Expected every blocking app to be closed, still running: %v", running)
+	}
+}
+
 func fakeInstallItem(item catalog.Item, itemURL, cachePath string) string {
 	installItemURL = itemURL
 	return ""
@@ -587,7 +1676,7 @@ func Example_runCommand() {
 	testArgs := []string{"arg1", "arg2"}
 
 	// Run the function
-	runCommand(testCmd, testArgs)
+	runCommand(testCmd, testArgs, runOptions{})
 
 	// Output:
 	// command: Command Test! [arg1 arg2]
@@ -720,3 +1809,231 @@ func Example_uninstallItemFailure() {
 	// _gorilla_dev_action_error_ 1.2.3 Uninstallation FAILED
 
 }
+
+// TestRetryBlocked validates that an installer that keeps failing is skipped until it changes
+func TestRetryBlocked(t *testing.T) {
+	origCfg := installerCfg
+	defer func() { installerCfg = origCfg }()
+
+	item := msiItem
+	item.DisplayName = "Retry Test"
+	state.Record(item.DisplayName, "install", item.Version, item.Installer.Hash, false)
+	state.Record(item.DisplayName, "install", item.Version, item.Installer.Hash, false)
+
+	// Too many failures
+	installerCfg = config.Configuration{MaxInstallFailures: 2}
+	if reason := retryBlocked(item, "install", item.Installer.Hash); !strings.Contains(reason, "until the catalog changes") {
+		t.Errorf("Expected the item to be skipped until it changes: %s", reason)
+	}
+
+	// Still backing off
+	installerCfg = config.Configuration{RetryBackoffMinutes: 60}
+	if reason := retryBlocked(item, "install", item.Installer.Hash); !strings.Contains(reason, "attempted again in") {
+		t.Errorf("Expected the item to be skipped while backing off: %s", reason)
+	}
+
+	// A new installer, a different action, or force are always attempted
+	if reason := retryBlocked(item, "install", "newhash"); reason != "" {
+		t.Errorf("Expected a new installer to be attempted: %s", reason)
+	}
+	if reason := retryBlocked(item, "uninstall", item.Uninstaller.Hash); reason != "" {
+		t.Errorf("Expected an uninstall to be attempted: %s", reason)
+	}
+	installerCfg.Force = true
+	if reason := retryBlocked(item, "install", item.Installer.Hash); reason != "" {
+		t.Errorf("Expected force to attempt the item: %s", reason)
+	}
+
+	// Manual runs always try again, and bootstrap runs dont wait out the backoff
+	installerCfg = config.Configuration{MaxInstallFailures: 2, Mode: config.ModeManual}
+	if reason := retryBlocked(item, "install", item.Installer.Hash); reason != "" {
+		t.Errorf("Expected a manual run to attempt the item: %s", reason)
+	}
+	installerCfg = config.Configuration{RetryBackoffMinutes: 60, Mode: config.ModeBootstrap}
+	if reason := retryBlocked(item, "install", item.Installer.Hash); reason != "" {
+		t.Errorf("Expected a bootstrap run to attempt the item: %s", reason)
+	}
+	installerCfg.MaxInstallFailures = 2
+	if reason := retryBlocked(item, "install", item.Installer.Hash); !strings.Contains(reason, "until the catalog changes") {
+		t.Errorf("Expected a bootstrap run to give up on the item: %s", reason)
+	}
+
+	// Without a limit, bootstrap runs still give up eventually
+	installerCfg = config.Configuration{Mode: config.ModeBootstrap}
+	if reason := retryBlocked(item, "install", item.Installer.Hash); reason != "" {
+		t.Errorf("Expected a bootstrap run to attempt the item: %s", reason)
+	}
+	state.Record(item.DisplayName, "install", item.Version, item.Installer.Hash, false)
+	if reason := retryBlocked(item, "install", item.Installer.Hash); !strings.Contains(reason, "until the catalog changes") {
+		t.Errorf("Expected a bootstrap run to give up on the item: %s", reason)
+	}
+
+	// Without a limit or backoff, every run tries again
+	installerCfg = config.Configuration{}
+	if reason := retryBlocked(item, "install", item.Installer.Hash); reason != "" {
+		t.Errorf("Expected the item to be attempted: %s", reason)
+	}
+}
+
+// TestVerifySignature validates that installers are checked against their signature when a key is configured
+func TestVerifySignature(t *testing.T) {
+	origCfg, origGet := installerCfg, downloadGet
+	defer func() { installerCfg, downloadGet = origCfg, origGet }()
+
+	dir, err := ioutil.TempDir("", "gorilla_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Sign an installer with a new key
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "packages.pem")
+	err = ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey}), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	absFile := filepath.Join(dir, "setup.msi")
+	err = ioutil.WriteFile(absFile, []byte("gorilla"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte("gorilla"))
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sigURL string
+	downloadGet = func(url string) ([]byte, error) {
+		sigURL = url
+		return sig, nil
+	}
+	signed := catalog.InstallerItem{Location: "packages/setup.msi", Signature: "packages/setup.msi.sig"}
+
+	// Without a key, nothing is checked
+	installerCfg = config.Configuration{URLPackages: "https://example.com/"}
+	if err := verifySignature(catalog.InstallerItem{}, absFile); err != nil {
+		t.Errorf("Expected an unsigned installer to be allowed: %v", err)
+	}
+
+	// A valid signature
+	installerCfg.SignatureKey = keyPath
+	if err := verifySignature(signed, absFile); err != nil {
+		t.Errorf("Expected a valid signature: %v", err)
+	}
+	if have, want := sigURL, "https://example.com/packages/setup.msi.sig"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+
+	// A file that changed after it was signed
+	err = ioutil.WriteFile(absFile, []byte("not gorilla"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifySignature(signed, absFile); err == nil {
+		t.Error("Expected a modified installer to fail verification")
+	}
+
+	// Unsigned installers are only refused when signatures are required
+	if err := verifySignature(catalog.InstallerItem{}, absFile); err != nil {
+		t.Errorf("Expected an unsigned installer to be allowed: %v", err)
+	}
+	installerCfg.RequireSignatures = true
+	if err := verifySignature(catalog.InstallerItem{}, absFile); err == nil {
+		t.Error("Expected an unsigned installer to be refused")
+	}
+}
+
+// TestInstallerPath validates that only MSIs on a file share are run in place, and only when configured
+func TestInstallerPath(t *testing.T) {
+	origCfg := installerCfg
+	defer func() { installerCfg = origCfg }()
+
+	cachePath := filepath.Join("testdata", "cache")
+	msi := catalog.InstallerItem{Type: "msi", Location: "packages/setup.msi"}
+	shareURL := "smb://fileserver/software/gorilla/packages/setup.msi"
+	cached := filepath.Join(cachePath, "packages", "setup.msi")
+
+	installerCfg = config.Configuration{}
+	if have, want := installerPath(msi, shareURL, cachePath), cached; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+
+	installerCfg.RunMSIInPlace = true
+	if have, want := installerPath(msi, shareURL, cachePath), filepath.FromSlash("//fileserver/software/gorilla/packages/setup.msi"); have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+	if have, want := installerPath(msi, "https://example.com/packages/setup.msi", cachePath), cached; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+	exe := catalog.InstallerItem{Type: "exe", Location: "packages/setup.exe"}
+	if have, want := installerPath(exe, "smb://fileserver/software/gorilla/packages/setup.exe", cachePath), filepath.Join(cachePath, "packages", "setup.exe"); have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+}
+
+// TestDownloadInstaller verifies a delta is tried before the full installer, only when the previous version is cached
+func TestDownloadInstaller(t *testing.T) {
+	origCfg, origDownload, origPatch := installerCfg, downloadIfNeeded, downloadPatch
+	defer func() { installerCfg, downloadIfNeeded, downloadPatch = origCfg, origDownload, origPatch }()
+	installerCfg = config.Configuration{URLPackages: "https://example.com/"}
+
+	cachePath, err := ioutil.TempDir("", "gorilla_delta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cachePath)
+
+	var calls []string
+	patched := true
+	downloadPatch = func(absFile, baseFile, baseHash, patchURL, patchHash, hashType, hash string, timeout time.Duration) bool {
+		calls = append(calls, patchURL)
+		return patched
+	}
+	downloadIfNeeded = func(absFile string, url string, hashType string, hash string, timeout time.Duration) bool {
+		calls = append(calls, url)
+		return true
+	}
+
+	item := catalog.Item{Installer: catalog.InstallerItem{
+		Type:     "msi",
+		Location: "packages/setup-2.0.msi",
+		Deltas: []catalog.Delta{
+			{From: "packages/setup-0.9.msi", Location: "packages/setup-0.9-2.0.bsdiff"},
+			{From: "packages/setup-1.0.msi", Location: "packages/setup-1.0-2.0.bsdiff"},
+		},
+	}}
+	absFile := download.CacheFile(cachePath, item.Installer.Location)
+	itemURL := "https://example.com/packages/setup-2.0.msi"
+
+	// Without a previous version, the full installer is downloaded
+	downloadInstaller(item, absFile, itemURL, cachePath)
+	if have, want := fmt.Sprint(calls), fmt.Sprint([]string{itemURL}); have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+
+	// Only the delta for the cached version is used
+	calls = nil
+	os.MkdirAll(filepath.Join(cachePath, "packages"), 0755)
+	ioutil.WriteFile(filepath.Join(cachePath, "packages", "setup-1.0.msi"), []byte("1.0"), 0644)
+	downloadInstaller(item, absFile, itemURL, cachePath)
+	if have, want := fmt.Sprint(calls), fmt.Sprint([]string{"https://example.com/packages/setup-1.0-2.0.bsdiff"}); have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+
+	// A failed delta falls back to the full installer
+	calls = nil
+	patched = false
+	downloadInstaller(item, absFile, itemURL, cachePath)
+	if have, want := fmt.Sprint(calls), fmt.Sprint([]string{"https://example.com/packages/setup-1.0-2.0.bsdiff", itemURL}); have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+}
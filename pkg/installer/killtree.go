@@ -0,0 +1,51 @@
+//go:build windows
+// +build windows
+
+package installer
+
+import (
+	"os/exec"
+
+	"github.com/1dustindavis/gorilla/pkg/gorillalog"
+	"golang.org/x/sys/windows"
+)
+
+// prepareTree doesnt need to do anything on Windows, since the job object is set up once the command starts
+func prepareTree(cmd *exec.Cmd) {}
+
+// trackTree adds a started command to a job object, which anything it starts is added to as well
+// A process started in the moment before the command is added isnt tracked, but installers rarely start that quickly
+func trackTree(t *processTree) {
+	job, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		gorillalog.Debug("Unable to create a job object, only the command itself will be killed:", err)
+		return
+	}
+	process, err := windows.OpenProcess(windows.PROCESS_SET_QUOTA|windows.PROCESS_TERMINATE, false, uint32(t.cmd.Process.Pid))
+	if err == nil {
+		err = windows.AssignProcessToJobObject(job, process)
+		windows.CloseHandle(process)
+	}
+	if err != nil {
+		gorillalog.Debug("Unable to track the processes the command starts, only the command itself will be killed:", err)
+		windows.CloseHandle(job)
+		return
+	}
+	t.job = uintptr(job)
+}
+
+// killTree ends every process in the job
+func killTree(t *processTree) error {
+	if t.job == 0 {
+		return t.cmd.Process.Kill()
+	}
+	return windows.TerminateJobObject(windows.Handle(t.job), 1)
+}
+
+// releaseTree closes the job, which leaves its processes running
+func releaseTree(t *processTree) {
+	if t.job != 0 {
+		windows.CloseHandle(windows.Handle(t.job))
+		t.job = 0
+	}
+}
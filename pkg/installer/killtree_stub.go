@@ -0,0 +1,28 @@
+// Without a darwin specific build, go tools will try to include Windows libraries and fail
+
+//go:build !windows
+// +build !windows
+
+package installer
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// prepareTree starts the command in its own process group, which anything it starts joins too
+func prepareTree(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+func trackTree(t *processTree) {}
+
+// killTree ends every process in the group
+func killTree(t *processTree) error {
+	return syscall.Kill(-t.cmd.Process.Pid, syscall.SIGKILL)
+}
+
+func releaseTree(t *processTree) {}
@@ -0,0 +1,94 @@
+package installer
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/1dustindavis/gorilla/pkg/catalog"
+	"github.com/1dustindavis/gorilla/pkg/gorillalog"
+)
+
+// Installers and uninstallers with `run_as: logon` are registered with Active Setup instead of being run,
+// so they run once as each user logs on. Per-user installers, like an msi that only installs into the
+// user's profile, can then reach every user of the computer and not only the one logged in right now.
+const runAsLogon = "logon"
+
+// These abstractions allow us to override when testing
+var (
+	registerLogon   = setActiveSetup
+	unregisterLogon = removeActiveSetup
+)
+
+// logonID returns the Active Setup id for an item's install or uninstall
+func logonID(item catalog.Item, action string) string {
+	return "Gorilla." + safeName(item.DisplayName) + "." + action
+}
+
+// safeName replaces any characters that arent safe in a file or key name
+func safeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == '.' || r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+var versionNumbers = regexp.MustCompile(`[0-9]+`)
+
+// logonVersion turns an item's version into the comma separated numbers Active Setup compares,
+// so a new version of an item runs again for users that already ran the last one
+func logonVersion(version string) string {
+	numbers := versionNumbers.FindAllString(version, 4)
+	if len(numbers) == 0 {
+		return "1"
+	}
+	return strings.Join(numbers, ",")
+}
+
+// commandLine joins a command and its arguments, quoting any that contain spaces or quotes
+func commandLine(command string, arguments []string) string {
+	var parts []string
+	for _, part := range append([]string{command}, arguments...) {
+		if part != "" && !strings.ContainsAny(part, " \t\"") {
+			parts = append(parts, part)
+			continue
+		}
+		// Backslashes are only special before a quote
+		var quoted strings.Builder
+		quoted.WriteByte('"')
+		slashes := 0
+		for _, r := range part {
+			switch r {
+			case '\\':
+				slashes++
+			case '"':
+				quoted.WriteString(strings.Repeat(`\`, slashes+1))
+				slashes = 0
+			default:
+				slashes = 0
+			}
+			quoted.WriteRune(r)
+		}
+		quoted.WriteString(strings.Repeat(`\`, slashes))
+		quoted.WriteByte('"')
+		parts = append(parts, quoted.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+// runAtLogon registers a command to install or uninstall an item as each user logs on,
+// replacing the opposite action if it was registered before
+func runAtLogon(item catalog.Item, action, command string, arguments []string) (string, error) {
+	opposite := "uninstall"
+	if action == "uninstall" {
+		opposite = "install"
+	}
+	if err := unregisterLogon(logonID(item, opposite)); err != nil {
+		gorillalog.Warn("Unable to remove the logon", opposite, "of", item.DisplayName+":", err)
+	}
+
+	line := commandLine(command, arguments)
+	gorillalog.Info("Registering", item.DisplayName, "to", action, "as each user logs on:", line)
+	return line, registerLogon(logonID(item, action), item.DisplayName, logonVersion(item.Version), line)
+}
@@ -0,0 +1,221 @@
+package installer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf16"
+
+	"github.com/1dustindavis/gorilla/pkg/catalog"
+	"github.com/1dustindavis/gorilla/pkg/config"
+	"github.com/1dustindavis/gorilla/pkg/gorillalog"
+)
+
+const (
+	// Default messages, used if the configuration doesn't provide one
+	defaultNotifyMessage       = "{{.DisplayName}} {{.Version}} has been installed"
+	defaultNotifyRebootMessage = "Please restart your computer to finish installing software"
+	defaultDeadlineMessage     = "{{.DisplayName}} {{.Version}} will be installed after {{.Deadline}}, please save your work before then"
+	defaultForceMessage        = "{{.DisplayName}} {{.Version}} is past its deadline, please save your work since open apps will be closed at {{.Deadline}}"
+	defaultForceAllMessage     = "{{.DisplayName}} are past their deadlines, please save your work since open apps will be closed at {{.Deadline}}"
+
+	// How long the user has to save their work before blocking apps are closed, if the configuration doesn't say
+	defaultForceWarning = 5 * time.Minute
+
+	// Notifications should be quick, so dont let one hold up the run
+	notifyTimeout = 30 * time.Second
+
+	// Toasts are shown as PowerShell, since Windows only shows toasts from registered apps
+	toastAppID  = `{1AC14E77-02E7-4E5D-B744-2EB1AE5198B7}\WindowsPowerShell\v1.0\powershell.exe`
+	toastScript = `[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] | Out-Null
+[Windows.Data.Xml.Dom.XmlDocument, Windows.Data.Xml.Dom.XmlDocument, ContentType = WindowsRuntime] | Out-Null
+$xml = New-Object Windows.Data.Xml.Dom.XmlDocument
+$xml.LoadXml('%s')
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('%s').Show([Windows.UI.Notifications.ToastNotification]::new($xml))`
+)
+
+// toastTitles is the title of the toast for each event
+var toastTitles = map[string]string{
+	"install":  "Software installed",
+	"reboot":   "Restart required",
+	"deadline": "Software installing soon",
+	"force":    "Software installing now",
+}
+
+// timeNow is used to check quiet hours, so tests can pick the time
+var timeNow = time.Now
+
+// notifyData is the set of values available to notification templates
+type notifyData struct {
+	Event       string
+	DisplayName string
+	Version     string
+	Deadline    string
+	Message     string
+}
+
+// renderNotify fills in a notification template
+func renderNotify(text string, data notifyData) (string, error) {
+	tmpl, err := template.New("notify").Option("missingkey=error").Funcs(argumentFuncs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, data)
+	return buf.String(), err
+}
+
+// notify runs the configured notification command, or shows a toast if they are enabled
+// The command runs as the logged in user, so anything it displays is visible to them
+func notify(data notifyData, message string) {
+	if len(installerCfg.NotifyCommand) == 0 && !installerCfg.NotifyToast {
+		return
+	}
+
+	var err error
+	data.Message, err = renderNotify(message, data)
+	if err != nil {
+		gorillalog.Warn("Unable to prepare notification message:", err)
+		return
+	}
+
+	// Nothing is shown during quiet hours, and the next run reminds them about anything still pending
+	// Quiet hours are only for scheduled runs, since someone is waiting on any other run
+	if installerCfg.Mode != config.ModeManual && installerCfg.Mode != config.ModeBootstrap && inQuietHours(installerCfg.NotifyQuietHours, timeNow()) {
+		gorillalog.Debug("Skipping notification during quiet hours:", data.Message)
+		return
+	}
+
+	if len(installerCfg.NotifyCommand) == 0 {
+		gorillalog.Debug("Showing toast:", data.Message)
+		command, arguments := toastCommand(toastTitles[data.Event], data.Message)
+		if _, err := runCommand(command, arguments, runOptions{Timeout: notifyTimeout, RunAs: "user"}); err != nil {
+			gorillalog.Warn("Unable to show toast:", err)
+		}
+		return
+	}
+
+	// Every part of the command may include placeholders
+	var command []string
+	for _, part := range installerCfg.NotifyCommand {
+		rendered, err := renderNotify(part, data)
+		if err != nil {
+			gorillalog.Warn("Unable to prepare notification command:", err)
+			return
+		}
+		command = append(command, rendered)
+	}
+
+	gorillalog.Debug("Sending notification:", data.Message)
+	_, err = runCommand(command[0], command[1:], runOptions{Timeout: notifyTimeout, RunAs: "user"})
+	if err != nil {
+		gorillalog.Warn("Unable to send notification:", err)
+	}
+}
+
+// notifyInstalled lets the user know an item has finished installing
+func notifyInstalled(item catalog.Item) {
+	message := installerCfg.NotifyMessage
+	if message == "" {
+		message = defaultNotifyMessage
+	}
+	notify(notifyData{Event: "install", DisplayName: item.DisplayName, Version: item.Version}, message)
+}
+
+// NotifyReboot lets the user know a restart is needed
+func NotifyReboot() {
+	message := installerCfg.NotifyRebootMessage
+	if message == "" {
+		message = defaultNotifyRebootMessage
+	}
+	notify(notifyData{Event: "reboot"}, message)
+}
+
+// NotifyDeadline lets the user know an item will be installed once its deadline passes
+func NotifyDeadline(item catalog.Item, deadline time.Time) {
+	message := installerCfg.NotifyDeadlineMessage
+	if message == "" {
+		message = defaultDeadlineMessage
+	}
+	notify(notifyData{
+		Event:       "deadline",
+		DisplayName: item.DisplayName,
+		Version:     item.Version,
+		Deadline:    deadline.Format("Monday, January 2 at 3:04 PM"),
+	}, message)
+}
+
+// notifyForced warns the user that the blocking apps of items past their deadline will be closed
+// Every item shares one warning, with their names joined together and no version when there is more than one
+func notifyForced(items []catalog.Item, closing time.Time) {
+	data := notifyData{
+		Event:       "force",
+		DisplayName: items[0].DisplayName,
+		Version:     items[0].Version,
+		Deadline:    closing.Format("3:04 PM"),
+	}
+	message := defaultForceMessage
+	if len(items) > 1 {
+		var names []string
+		for _, item := range items {
+			names = append(names, item.DisplayName)
+		}
+		data.DisplayName, data.Version = strings.Join(names, ", "), ""
+		message = defaultForceAllMessage
+	}
+	if installerCfg.NotifyForceMessage != "" {
+		message = installerCfg.NotifyForceMessage
+	}
+	notify(data, message)
+}
+
+// inQuietHours returns true if the time is within quiet hours, like "22:00-07:00"
+// Quiet hours that end before they start run past midnight
+func inQuietHours(hours string, now time.Time) bool {
+	if hours == "" {
+		return false
+	}
+	parts := strings.Split(hours, "-")
+	if len(parts) != 2 {
+		gorillalog.Warn("Unable to read notify_quiet_hours, expected a range like 22:00-07:00:", hours)
+		return false
+	}
+	start, startErr := time.Parse("15:04", strings.TrimSpace(parts[0]))
+	end, endErr := time.Parse("15:04", strings.TrimSpace(parts[1]))
+	if startErr != nil || endErr != nil {
+		gorillalog.Warn("Unable to read notify_quiet_hours, expected a range like 22:00-07:00:", hours)
+		return false
+	}
+
+	minute := now.Hour()*60 + now.Minute()
+	startMinute := start.Hour()*60 + start.Minute()
+	endMinute := end.Hour()*60 + end.Minute()
+	if startMinute <= endMinute {
+		return minute >= startMinute && minute < endMinute
+	}
+	return minute >= startMinute || minute < endMinute
+}
+
+// toastCommand returns the PowerShell command that shows a toast with a title and message
+// The script is encoded, so nothing in the message can be mistaken for part of the command
+func toastCommand(title, message string) (string, []string) {
+	var text bytes.Buffer
+	text.WriteString(`<toast><visual><binding template="ToastGeneric"><text>`)
+	xml.EscapeText(&text, []byte(title))
+	text.WriteString(`</text><text>`)
+	xml.EscapeText(&text, []byte(message))
+	text.WriteString(`</text></binding></visual></toast>`)
+	script := fmt.Sprintf(toastScript, strings.ReplaceAll(text.String(), "'", "''"), toastAppID)
+
+	// PowerShell expects an encoded command to be base64 of UTF-16LE
+	var encoded bytes.Buffer
+	for _, char := range utf16.Encode([]rune(script)) {
+		binary.Write(&encoded, binary.LittleEndian, char)
+	}
+	return commandPs1, []string{"-NoProfile", "-NoLogo", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-EncodedCommand", base64.StdEncoding.EncodeToString(encoded.Bytes())}
+}
@@ -0,0 +1,104 @@
+package installer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/1dustindavis/gorilla/pkg/catalog"
+	"github.com/1dustindavis/gorilla/pkg/gorillalog"
+	"github.com/1dustindavis/gorilla/pkg/report"
+)
+
+// How many lines of output are kept for the log and the report when a command fails
+const outputLines = 20
+
+// tail keeps the last few lines written to it
+type tail struct {
+	lines []string
+	max   int
+}
+
+func (t *tail) add(line string) {
+	t.lines = append(t.lines, line)
+	if len(t.lines) > t.max {
+		t.lines = t.lines[len(t.lines)-t.max:]
+	}
+}
+
+// lastLines returns up to the last n lines of some text, without blank lines
+func lastLines(text string, n int) []string {
+	t := tail{max: n}
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimRight(line, "\r"); strings.TrimSpace(line) != "" {
+			t.add(line)
+		}
+	}
+	return t.lines
+}
+
+// itemLogDir returns the directory an item's logs are kept in, creating it if needed
+// Each item gets its own directory under `logs` in our app data, so everything about a failed install is in one place
+func itemLogDir(item catalog.Item) string {
+	if installerCfg.AppDataPath == "" {
+		return ""
+	}
+	logDir := filepath.Join(installerCfg.AppDataPath, "logs", safeName(item.DisplayName))
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		gorillalog.Warn("Unable to create log directory:", logDir, err)
+		return ""
+	}
+	return logDir
+}
+
+// captureOutput sets up the options to save everything an installer or uninstaller writes to the item's log directory,
+// and to attach the end of it to the report if the command fails
+func captureOutput(item catalog.Item, action string, options runOptions) runOptions {
+	logDir := itemLogDir(item)
+	if logDir != "" {
+		options.LogFile = filepath.Join(logDir, action+".log")
+	}
+	started := timeNow()
+	options.OnFailure = func(output []string) {
+		// msiexec writes its own log, which usually says more than it does
+		if logDir != "" {
+			msiLog := filepath.Join(logDir, "msi-"+action+".log")
+			if info, err := os.Stat(msiLog); err == nil && !info.ModTime().Before(started.Truncate(time.Second)) {
+				lines, err := readLogTail(msiLog, outputLines)
+				if err != nil {
+					gorillalog.Warn("Unable to read msi log:", msiLog, err)
+				}
+				for _, line := range lines {
+					gorillalog.Warn("msi log:", line)
+				}
+				output = append(output, lines...)
+			}
+		}
+		if len(output) > 0 {
+			report.FailureOutput[item.DisplayName] = output
+		}
+	}
+	return options
+}
+
+// readLogTail returns the last n lines of a log file
+// msiexec writes its logs in UTF-16 when the msi asks it to, so that is decoded first
+func readLogTail(path string, n int) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, []byte{0xff, 0xfe}) {
+		data = data[2:]
+		units := make([]uint16, len(data)/2)
+		for i := range units {
+			units[i] = uint16(data[2*i]) | uint16(data[2*i+1])<<8
+		}
+		return lastLines(string(utf16.Decode(units)), n), nil
+	}
+	return lastLines(string(bytes.TrimPrefix(data, []byte{0xef, 0xbb, 0xbf})), n), nil
+}
@@ -0,0 +1,80 @@
+package installer
+
+import (
+	"sync"
+
+	"github.com/1dustindavis/gorilla/pkg/catalog"
+	"github.com/1dustindavis/gorilla/pkg/download"
+	"github.com/1dustindavis/gorilla/pkg/gorillalog"
+)
+
+// defaultParallelDownloads is how many installers are downloaded at once if max_parallel_downloads isnt set
+const defaultParallelDownloads = 4
+
+// These abstractions allows us to override when testing
+var (
+	downloadIfNeeded = download.IfNeeded
+	downloadPatch    = download.Patch
+)
+
+// Download fetches the installers for every item that needs to be installed or updated,
+// several at a time, so each install can start as soon as the previous one finishes.
+// Install still verifies each file before it is used, so a failed download is simply retried then.
+// Anyone using the apps of items past their deadline is warned first, so they can save their work while we download.
+func Download(items []catalog.Item, installerType, urlPackages, cachePath string) {
+	// Only download what will actually be installed
+	var ready, needed []catalog.Item
+	queued := make(map[string]bool)
+	for _, item := range items {
+		if retryBlocked(item, installerType, item.Installer.Hash) != "" {
+			continue
+		}
+		actionNeeded, err := statusCheckStatus(item, installerType, cachePath)
+		if err != nil || !actionNeeded {
+			continue
+		}
+		ready = append(ready, item)
+		if item.Installer.Location == "" {
+			continue
+		}
+		// Two items may share an installer, which should only be downloaded once
+		// An installer that is run in place is never downloaded at all
+		absFile := download.CacheFile(cachePath, item.Installer.Location)
+		itemURL := download.ResolveURL(urlPackages, item.Installer.Location)
+		if queued[absFile] || installerPath(item.Installer, itemURL, cachePath) != absFile {
+			continue
+		}
+		queued[absFile] = true
+		needed = append(needed, item)
+	}
+	warnForced(ready, installerType)
+	if len(needed) == 0 {
+		return
+	}
+
+	workers := installerCfg.MaxParallelDownloads
+	if workers <= 0 {
+		workers = defaultParallelDownloads
+	}
+	gorillalog.Info("Downloading", len(needed), "installers,", workers, "at a time")
+
+	// Each worker downloads items from the queue until it is empty
+	queue := make(chan catalog.Item)
+	var wg sync.WaitGroup
+	for i := 0; i < workers && i < len(needed); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range queue {
+				absFile := download.CacheFile(cachePath, item.Installer.Location)
+				itemURL := download.ResolveURL(urlPackages, item.Installer.Location)
+				downloadInstaller(item, absFile, itemURL, cachePath)
+			}
+		}()
+	}
+	for _, item := range needed {
+		queue <- item
+	}
+	close(queue)
+	wg.Wait()
+}
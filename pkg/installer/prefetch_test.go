@@ -0,0 +1,74 @@
+package installer
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/1dustindavis/gorilla/pkg/catalog"
+	"github.com/1dustindavis/gorilla/pkg/config"
+)
+
+// TestDownload validates that needed installers are downloaded once each, no more than the limit at a time
+func TestDownload(t *testing.T) {
+	origCfg, origDownload := installerCfg, downloadIfNeeded
+	defer func() {
+		installerCfg, downloadIfNeeded, statusCheckStatus = origCfg, origDownload, origCheckStatus
+	}()
+	installerCfg = config.Configuration{MaxParallelDownloads: 2}
+	statusCheckStatus = fakeCheckStatus
+
+	// Record each download, and the most that ran at once
+	var mutex sync.Mutex
+	var downloaded []string
+	var running, maxRunning int
+	downloadIfNeeded = func(absFile string, url string, hashType string, hash string, timeout time.Duration) bool {
+		mutex.Lock()
+		downloaded = append(downloaded, url)
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mutex.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mutex.Lock()
+		running--
+		mutex.Unlock()
+		return true
+	}
+
+	var items []catalog.Item
+	for i := 1; i <= 5; i++ {
+		items = append(items, catalog.Item{
+			DisplayName: statusActionNoError,
+			Installer:   catalog.InstallerItem{Type: "msi", Location: fmt.Sprintf("packages/item%d.msi", i)},
+		})
+	}
+	// Already installed, a shared installer, and an item with nothing to download
+	items = append(items,
+		catalog.Item{DisplayName: statusNoActionNoError, Installer: catalog.InstallerItem{Type: "msi", Location: "packages/installed.msi"}},
+		catalog.Item{DisplayName: statusActionNoError, Installer: catalog.InstallerItem{Type: "msi", Location: "packages/item1.msi"}},
+		catalog.Item{DisplayName: statusActionNoError, Uninstaller: catalog.InstallerItem{Type: "msi"}},
+	)
+
+	Download(items, "install", "https://example.com/", "/cache")
+
+	sort.Strings(downloaded)
+	expected := []string{
+		"https://example.com/packages/item1.msi",
+		"https://example.com/packages/item2.msi",
+		"https://example.com/packages/item3.msi",
+		"https://example.com/packages/item4.msi",
+		"https://example.com/packages/item5.msi",
+	}
+	if fmt.Sprint(downloaded) != fmt.Sprint(expected) {
+		t.Errorf("\nExpected: %v\nReceived: %v", expected, downloaded)
+	}
+	if maxRunning != 2 {
+		t.Errorf("Expected 2 downloads at once, got %d", maxRunning)
+	}
+}
@@ -0,0 +1,73 @@
+//go:build windows
+// +build windows
+
+package installer
+
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// processNames returns the executable name of every running process
+func processNames() ([]string, error) {
+	snapshot, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer windows.CloseHandle(snapshot)
+
+	var names []string
+	var entry windows.ProcessEntry32
+	entry.Size = uint32(unsafe.Sizeof(entry))
+	for err = windows.Process32First(snapshot, &entry); err == nil; err = windows.Process32Next(snapshot, &entry) {
+		names = append(names, windows.UTF16ToString(entry.ExeFile[:]))
+	}
+	if err != windows.ERROR_NO_MORE_FILES {
+		return nil, err
+	}
+	return names, nil
+}
+
+// killProcesses ends every running process with one of the names, which are compared without case or the .exe extension
+func killProcesses(names []string) error {
+	kill := make(map[string]bool)
+	for _, name := range names {
+		kill[strings.TrimSuffix(strings.ToLower(name), ".exe")] = true
+	}
+
+	snapshot, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(snapshot)
+
+	var killErr error
+	var entry windows.ProcessEntry32
+	entry.Size = uint32(unsafe.Sizeof(entry))
+	for err = windows.Process32First(snapshot, &entry); err == nil; err = windows.Process32Next(snapshot, &entry) {
+		name := windows.UTF16ToString(entry.ExeFile[:])
+		if !kill[strings.TrimSuffix(strings.ToLower(name), ".exe")] {
+			continue
+		}
+		if err := terminateProcess(entry.ProcessID); err != nil && killErr == nil {
+			killErr = fmt.Errorf("unable to close %s: %v", name, err)
+		}
+	}
+	if err != windows.ERROR_NO_MORE_FILES {
+		return err
+	}
+	return killErr
+}
+
+// terminateProcess ends a single process by its id
+func terminateProcess(pid uint32) error {
+	process, err := windows.OpenProcess(windows.PROCESS_TERMINATE, false, pid)
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(process)
+	return windows.TerminateProcess(process, 1)
+}
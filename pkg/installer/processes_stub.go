@@ -0,0 +1,16 @@
+// Without a darwin specific build, go tools will try to include Windows libraries and fail
+
+//go:build !windows
+// +build !windows
+
+package installer
+
+import "fmt"
+
+func processNames() ([]string, error) {
+	return nil, fmt.Errorf("listing running processes is not supported on this platform")
+}
+
+func killProcesses(names []string) error {
+	return fmt.Errorf("closing processes is not supported on this platform")
+}
@@ -0,0 +1,64 @@
+package installer
+
+import (
+	"github.com/1dustindavis/gorilla/pkg/catalog"
+	"github.com/1dustindavis/gorilla/pkg/gorillalog"
+)
+
+// Restart actions an item can declare, from the least to the most urgent
+const (
+	RestartNone        = "none"
+	RestartRecommended = "recommend_restart"
+	RestartRequired    = "require_restart"
+)
+
+// restartRank orders the restart actions, where an item without one never needs a restart
+var restartRank = map[string]int{
+	"":                 0,
+	RestartNone:        0,
+	RestartRecommended: 1,
+	RestartRequired:    2,
+}
+
+var (
+	// restartAction is the most urgent restart the items installed or removed this run asked for
+	restartAction string
+
+	// restartItems are the items that asked for a restart this run
+	restartItems []string
+)
+
+// recordRestart notes that an item was installed or removed, in case it needs a restart
+func recordRestart(item catalog.Item) {
+	if restartRank[item.RestartAction] == 0 {
+		return
+	}
+	gorillalog.Info(item.DisplayName, "asked for a restart:", item.RestartAction)
+	addRestart(item.DisplayName, item.RestartAction)
+}
+
+// addRestart keeps the more urgent restart action, and the item that asked for it
+func addRestart(name, action string) {
+	for _, item := range restartItems {
+		if item == name {
+			restartAction = StrongerRestart(restartAction, action)
+			return
+		}
+	}
+	restartItems = append(restartItems, name)
+	restartAction = StrongerRestart(restartAction, action)
+}
+
+// RestartNeeded returns the most urgent restart the items installed or removed this run asked for,
+// and which items asked for one
+func RestartNeeded() (string, []string) {
+	return restartAction, restartItems
+}
+
+// StrongerRestart returns the more urgent of two restart actions
+func StrongerRestart(a, b string) string {
+	if restartRank[b] > restartRank[a] {
+		return b
+	}
+	return a
+}
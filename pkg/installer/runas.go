@@ -0,0 +1,36 @@
+//go:build windows
+// +build windows
+
+package installer
+
+import (
+	"fmt"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// runAsUser configures a command to run as the user logged in to the console
+// It returns a function that releases the user's token once the command is finished
+func runAsUser(cmd *exec.Cmd) (func(), error) {
+	// 0xFFFFFFFF means nobody is attached to the console
+	sessionID := windows.WTSGetActiveConsoleSessionId()
+	if sessionID == 0xFFFFFFFF {
+		return nil, fmt.Errorf("no user is logged in")
+	}
+
+	// This only works when we are running as SYSTEM
+	var token windows.Token
+	err := windows.WTSQueryUserToken(sessionID, &token)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the logged in user's token: %v", err)
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Token = syscall.Token(token)
+
+	return func() { token.Close() }, nil
+}
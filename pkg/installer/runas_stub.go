@@ -0,0 +1,15 @@
+// Without a darwin specific build, go tools will try to include Windows libraries and fail
+
+//go:build !windows
+// +build !windows
+
+package installer
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+func runAsUser(cmd *exec.Cmd) (func(), error) {
+	return nil, fmt.Errorf("running as the logged in user is not supported on this platform")
+}
@@ -0,0 +1,41 @@
+package installer
+
+import (
+	"os/exec"
+	"sync"
+)
+
+// processTree is a command along with every process it starts, so an installer that hands off to msiexec
+// or a copy of itself cant keep running, or keep the run waiting on its output, once it is killed
+type processTree struct {
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	job      uintptr
+	released bool
+}
+
+// newProcessTree prepares a command to be tracked, before it is started
+func newProcessTree(cmd *exec.Cmd) *processTree {
+	prepareTree(cmd)
+	return &processTree{cmd: cmd}
+}
+
+// kill ends the command and everything it started, unless the tree has already been released
+func (t *processTree) kill() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.released {
+		return
+	}
+	if err := killTree(t); err != nil {
+		t.cmd.Process.Kill()
+	}
+}
+
+// release stops tracking the tree once the command is finished, leaving anything it started running
+func (t *processTree) release() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.released = true
+	releaseTree(t)
+}
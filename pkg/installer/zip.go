@@ -0,0 +1,168 @@
+package installer
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/1dustindavis/gorilla/pkg/catalog"
+	"github.com/1dustindavis/gorilla/pkg/gorillalog"
+)
+
+// zipReceipt records the files extracted from a zip, so they can be removed later
+type zipReceipt struct {
+	Destination string   `json:"destination"`
+	Files       []string `json:"files"`
+}
+
+// receiptPath returns the path of the receipt for an item
+func receiptPath(item catalog.Item) string {
+	return filepath.Join(installerCfg.AppDataPath, "receipts", item.DisplayName+".json")
+}
+
+// zipTarget returns the path a zip entry will be extracted to
+// Entries that would be written outside of the destination are rejected
+func zipTarget(destination string, name string) (string, error) {
+	// Zip entries always use forward slashes, but some tools write backslashes anyway
+	cleanName := filepath.Clean(filepath.FromSlash(strings.ReplaceAll(name, `\`, "/")))
+	if filepath.IsAbs(cleanName) || filepath.VolumeName(cleanName) != "" || strings.HasPrefix(cleanName, string(os.PathSeparator)) {
+		return "", fmt.Errorf("zip entry has an absolute path: %s", name)
+	}
+	for _, part := range strings.Split(filepath.ToSlash(cleanName), "/") {
+		if part == ".." {
+			return "", fmt.Errorf("zip entry is outside of the destination: %s", name)
+		}
+	}
+
+	target := filepath.Join(destination, cleanName)
+	if !strings.HasPrefix(target, filepath.Clean(destination)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("zip entry is outside of the destination: %s", name)
+	}
+	return target, nil
+}
+
+// installZip extracts a zip to the item's destination and saves a receipt of the files
+func installZip(item catalog.Item, absFile string) (string, error) {
+	destination := filepath.Clean(item.Installer.Destination)
+
+	archive, err := zip.OpenReader(absFile)
+	if err != nil {
+		return "", err
+	}
+	defer archive.Close()
+
+	// Check every entry before writing anything, so a bad archive is never partially extracted
+	targets := make([]string, len(archive.File))
+	for i, f := range archive.File {
+		if f.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("zip entry is a symlink: %s", f.Name)
+		}
+		targets[i], err = zipTarget(destination, f.Name)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// Extract each entry, keeping the structure of the archive
+	var receipt = zipReceipt{Destination: destination}
+	for i, f := range archive.File {
+		if f.FileInfo().IsDir() {
+			err = os.MkdirAll(targets[i], 0755)
+			if err != nil {
+				return "", err
+			}
+			continue
+		}
+
+		err = extractZipFile(f, targets[i])
+		if err != nil {
+			return "", err
+		}
+		gorillalog.Debug("Extracted", f.Name, "to", targets[i])
+		receipt.Files = append(receipt.Files, targets[i])
+	}
+
+	// Save the receipt for uninstalling later
+	receiptJSON, err := json.MarshalIndent(receipt, "", "    ")
+	if err != nil {
+		return "", err
+	}
+	err = os.MkdirAll(filepath.Dir(receiptPath(item)), 0755)
+	if err != nil {
+		return "", err
+	}
+	err = ioutil.WriteFile(receiptPath(item), receiptJSON, 0644)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("Extracted %d files to %s", len(receipt.Files), destination), nil
+}
+
+// extractZipFile writes a single file from a zip to the target path
+func extractZipFile(f *zip.File, target string) error {
+	err := os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return err
+	}
+
+	src, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// uninstallZip removes the files listed in an item's receipt, along with any directories left empty
+func uninstallZip(item catalog.Item) (string, error) {
+	receiptJSON, err := ioutil.ReadFile(receiptPath(item))
+	if err != nil {
+		return "", fmt.Errorf("unable to read receipt: %v", err)
+	}
+	var receipt zipReceipt
+	err = json.Unmarshal(receiptJSON, &receipt)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse receipt: %v", err)
+	}
+
+	// Remove each file and remember the directories they were in
+	dirs := make(map[string]bool)
+	for _, file := range receipt.Files {
+		err = os.Remove(file)
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		for dir := filepath.Dir(file); strings.HasPrefix(dir, receipt.Destination+string(os.PathSeparator)); dir = filepath.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+
+	// Remove the deepest directories first, and leave any that still contain other files
+	var sortedDirs []string
+	for dir := range dirs {
+		sortedDirs = append(sortedDirs, dir)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(sortedDirs)))
+	for _, dir := range append(sortedDirs, receipt.Destination) {
+		os.Remove(dir)
+	}
+
+	os.Remove(receiptPath(item))
+	return fmt.Sprintf("Removed %d files from %s", len(receipt.Files), receipt.Destination), nil
+}
@@ -0,0 +1,121 @@
+package ipc
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/1dustindavis/gorilla/pkg/progress"
+)
+
+// eventBuffer is how many events a slow watcher can fall behind before it starts missing them
+const eventBuffer = 100
+
+// Event is progress from the service, like a run starting or a line of its output
+type Event struct {
+	Time time.Time `json:"time"`
+	// Type is started or finished for a run, output for each line it logs,
+	// and progress for each update on a download or installer
+	Type     string           `json:"type"`
+	Message  string           `json:"message"`
+	Progress *progress.Update `json:"progress,omitempty"`
+}
+
+// Events sends each published event to everyone watching
+type Events struct {
+	mu       sync.Mutex
+	watchers map[chan Event]struct{}
+}
+
+// Publish sends an event to everyone watching
+// A watcher that has fallen behind misses the event, so a stuck client never holds up a run
+func (e *Events) Publish(eventType, message string) {
+	e.publish(Event{Time: time.Now(), Type: eventType, Message: message})
+}
+
+// publish sends any event to everyone watching
+func (e *Events) publish(event Event) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for watcher := range e.watchers {
+		select {
+		case watcher <- event:
+		default:
+		}
+	}
+}
+
+// watch returns a channel of the events published from now on, and a function that stops them
+func (e *Events) watch() (<-chan Event, func()) {
+	watcher := make(chan Event, eventBuffer)
+	e.mu.Lock()
+	if e.watchers == nil {
+		e.watchers = make(map[chan Event]struct{})
+	}
+	e.watchers[watcher] = struct{}{}
+	e.mu.Unlock()
+
+	return watcher, func() {
+		e.mu.Lock()
+		delete(e.watchers, watcher)
+		e.mu.Unlock()
+	}
+}
+
+// stream writes each event as a line of JSON, until done is closed or a write fails
+// flush is called after each event if it isnt nil, so it isnt left sitting in a buffer
+func (e *Events) stream(w io.Writer, flush func(), done <-chan struct{}) {
+	events, stop := e.watch()
+	defer stop()
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case <-done:
+			return
+		case event := <-events:
+			if err := encoder.Encode(event); err != nil {
+				return
+			}
+			if flush != nil {
+				flush()
+			}
+		}
+	}
+}
+
+// Writer returns a writer that publishes each line written to it as output
+// Pointing a run's stdout and stderr at it lets watchers follow the run's progress,
+// and lines from the progress package's JSON reporter are published as progress
+func (e *Events) Writer() io.Writer {
+	return &lineWriter{events: e}
+}
+
+// lineWriter holds onto a partial line until the rest of it is written
+type lineWriter struct {
+	events *Events
+	mu     sync.Mutex
+	buf    []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimRight(string(w.buf[:i]), "\r")
+		w.buf = w.buf[i+1:]
+		if update, ok := progress.Parse(line); ok {
+			w.events.publish(Event{Time: time.Now(), Type: "progress", Message: update.String(), Progress: &update})
+		} else if line != "" {
+			w.events.Publish("output", line)
+		}
+	}
+	return len(p), nil
+}
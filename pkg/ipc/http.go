@@ -0,0 +1,89 @@
+package ipc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// methods is the HTTP method each command is sent with, since only some of them change anything
+var methods = map[string]string{
+	"list":    http.MethodGet,
+	"status":  http.MethodGet,
+	"events":  http.MethodGet,
+	"run":     http.MethodPost,
+	"install": http.MethodPost,
+	"remove":  http.MethodPost,
+}
+
+// ListenHTTP listens on a localhost port, for tools that would rather use HTTP than the named pipe
+func ListenHTTP(port int) (net.Listener, error) {
+	return net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+}
+
+// ServeHTTP answers the same requests as the named pipe, with the command as the path
+// Installs and removals are posted with a body like `{"items": ["Firefox"]}`
+// Events are streamed as one JSON object per line, until the client disconnects
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Any page open in a browser can reach localhost, but it cant leave out the Origin header or change the Host
+	if r.Header.Get("Origin") != "" || !isLoopback(r.Host) {
+		http.Error(w, "requests must come from this computer", http.StatusForbidden)
+		return
+	}
+
+	command := strings.Trim(r.URL.Path, "/")
+	method, ok := methods[command]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != method {
+		http.Error(w, fmt.Sprint(command, " must be sent with ", method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	req := Request{Command: command}
+	if r.Method == http.MethodPost && r.ContentLength != 0 {
+		var body Request
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, fmt.Sprint("unable to read request: ", err), http.StatusBadRequest)
+			return
+		}
+		req.Items = body.Items
+	}
+
+	if command == "events" && s.Events != nil {
+		flusher, _ := w.(http.Flusher)
+		flush := func() {
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		flush()
+		s.Events.stream(w, flush, r.Context().Done())
+		return
+	}
+
+	resp := s.Handler(req)
+	w.Header().Set("Content-Type", "application/json")
+	if resp.Error != "" {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// isLoopback returns true if the host, with or without a port, is localhost or a loopback address
+func isLoopback(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,138 @@
+package ipc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"time"
+)
+
+// PipeName is the named pipe the service listens on
+const PipeName = `\\.\pipe\gorilla`
+
+// Request is a single command sent to the service
+type Request struct {
+	Command string   `json:"command"`
+	Items   []string `json:"items,omitempty"`
+}
+
+// Response is the service's answer to a request
+type Response struct {
+	Error string `json:"error,omitempty"`
+	Items []Item `json:"items,omitempty"`
+	// Busy is what the service is doing right now, like `Installing Firefox`
+	Busy   string  `json:"busy,omitempty"`
+	Status *Status `json:"status,omitempty"`
+}
+
+// Status is what the service knows about its runs
+type Status struct {
+	Running bool   `json:"running"`
+	Busy    string `json:"busy,omitempty"`
+	// LastRun is when the last run finished, which is zero until the first run does
+	LastRun      time.Time `json:"last_run"`
+	LastExitCode int       `json:"last_exit_code"`
+	NextRun      time.Time `json:"next_run"`
+	// Bootstrapping is true while the computer is still being set up, so a tool can show a full-screen status
+	Bootstrapping bool `json:"bootstrapping,omitempty"`
+}
+
+// Item is an optional install or a pending action, as shown to the person at the computer
+type Item struct {
+	Name             string `json:"name"`
+	DisplayName      string `json:"display_name"`
+	Version          string `json:"version,omitempty"`
+	InstalledVersion string `json:"installed_version,omitempty"`
+	Installed        bool   `json:"installed"`
+	UpdatePending    bool   `json:"update_pending"`
+	Optional         bool   `json:"optional"`
+	// Pending is what the next run will do to the item: install, update, or remove
+	Pending string `json:"pending,omitempty"`
+}
+
+// Handler answers a request
+type Handler func(Request) Response
+
+// Server answers requests with its handler, and streams its events to anyone who asks for them
+type Server struct {
+	Handler Handler
+	Events  *Events
+}
+
+// Serve answers one request on each connection, until the listener is closed
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn reads a request from the connection and writes the handler's response
+// An events request keeps the connection open, with one event written per line until it closes
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	var req Request
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		json.NewEncoder(conn).Encode(Response{Error: fmt.Sprint("unable to read request: ", err)})
+		return
+	}
+	if req.Command == "events" && s.Events != nil {
+		// Anything read after the request means the client is gone
+		done := make(chan struct{})
+		go func() {
+			io.Copy(ioutil.Discard, conn)
+			close(done)
+		}()
+		s.Events.stream(conn, nil, done)
+		return
+	}
+	json.NewEncoder(conn).Encode(s.Handler(req))
+}
+
+// Call sends a request over the connection and returns the response
+// An error from the service is returned as an error too, along with the rest of the response
+func Call(conn net.Conn, req Request) (Response, error) {
+	var resp Response
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return resp, err
+	}
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return resp, err
+	}
+	if resp.Error != "" {
+		return resp, fmt.Errorf("%s", resp.Error)
+	}
+	return resp, nil
+}
+
+// Send connects to the service, sends a request, and returns the response
+func Send(req Request) (Response, error) {
+	conn, err := Dial()
+	if err != nil {
+		return Response{}, err
+	}
+	defer conn.Close()
+	return Call(conn, req)
+}
+
+// Watch asks the service for its events, and calls fn with each one until fn returns false
+func Watch(conn net.Conn, fn func(Event) bool) error {
+	if err := json.NewEncoder(conn).Encode(Request{Command: "events"}); err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(conn)
+	for {
+		var event Event
+		if err := decoder.Decode(&event); err != nil {
+			return err
+		}
+		if !fn(event) {
+			return nil
+		}
+	}
+}
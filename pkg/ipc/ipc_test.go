@@ -0,0 +1,218 @@
+package ipc
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1dustindavis/gorilla/pkg/progress"
+)
+
+// TestServe verifies that requests reach the handler and responses reach the caller
+func TestServe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	server := &Server{Handler: func(req Request) Response {
+		switch req.Command {
+		case "list":
+			return Response{Items: []Item{{Name: "Firefox", DisplayName: "Mozilla Firefox", Optional: true}}, Busy: "Installing Chrome"}
+		case "install":
+			return Response{Error: "not an optional install: " + req.Items[0]}
+		}
+		return Response{Error: "unknown command"}
+	}}
+	go server.Serve(l)
+
+	call := func(req Request) (Response, error) {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		return Call(conn, req)
+	}
+
+	resp, err := call(Request{Command: "list"})
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	expected := Response{Items: []Item{{Name: "Firefox", DisplayName: "Mozilla Firefox", Optional: true}}, Busy: "Installing Chrome"}
+	if !reflect.DeepEqual(expected, resp) {
+		t.Errorf("\nExpected: %#v\nActual: %#v", expected, resp)
+	}
+
+	// An error from the service is returned as an error
+	_, err = call(Request{Command: "install", Items: []string{"Chrome"}})
+	if err == nil || err.Error() != "not an optional install: Chrome" {
+		t.Errorf("Expected the service's error, got %v", err)
+	}
+}
+
+// TestEvents verifies that watchers get each line a run writes, and stop cleanly
+func TestEvents(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	events := &Events{}
+	go (&Server{Events: events}).Serve(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	received := make(chan Event)
+	go Watch(conn, func(event Event) bool {
+		received <- event
+		return event.Type != "finished"
+	})
+
+	// Keep publishing until the watcher is registered, since Watch cant say when that happens
+	deadline := time.After(5 * time.Second)
+	for waiting := true; waiting; {
+		events.Publish("started", "Gorilla run started")
+		select {
+		case <-received:
+			waiting = false
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("Timed out waiting for the watcher")
+		}
+	}
+
+	// Output is published a line at a time, even when it is written in pieces
+	writer := events.Writer()
+	writer.Write([]byte("INFO: Installing "))
+	writer.Write([]byte("Firefox\r\n\nINFO: Done\n"))
+	// Progress from the JSON reporter is published as progress
+	writer.Write([]byte(progress.Prefix + `{"action":"install","name":"Firefox","elapsed_seconds":65}` + "\n"))
+	events.Publish("finished", "Gorilla run completed")
+
+	var messages []string
+	for event := range received {
+		if event.Type == "started" {
+			continue
+		}
+		messages = append(messages, event.Type+": "+event.Message)
+		if event.Type == "finished" {
+			break
+		}
+	}
+	expected := []string{"output: INFO: Installing Firefox", "output: INFO: Done", "progress: Installing Firefox: 1m5s", "finished: Gorilla run completed"}
+	if !reflect.DeepEqual(expected, messages) {
+		t.Errorf("\nExpected: %#v\nActual: %#v", expected, messages)
+	}
+}
+
+// TestServeHTTP verifies the HTTP commands, and that browsers are turned away
+func TestServeHTTP(t *testing.T) {
+	var got Request
+	server := httptest.NewServer(&Server{Events: &Events{}, Handler: func(req Request) Response {
+		got = req
+		if req.Command == "run" {
+			return Response{Error: "a run is already in progress", Busy: "Running"}
+		}
+		return Response{Status: &Status{Running: true}}
+	}})
+	defer server.Close()
+
+	// A status is returned as JSON
+	resp, err := http.Get(server.URL + "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status Response
+	json.NewDecoder(resp.Body).Decode(&status)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || status.Status == nil || !status.Status.Running {
+		t.Errorf("Unexpected status response: %d %#v", resp.StatusCode, status)
+	}
+
+	// The items to install are read from the body
+	resp, err = http.Post(server.URL+"/install", "application/json", strings.NewReader(`{"command": "remove", "items": ["Firefox"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if expected := (Request{Command: "install", Items: []string{"Firefox"}}); !reflect.DeepEqual(expected, got) {
+		t.Errorf("\nExpected: %#v\nActual: %#v", expected, got)
+	}
+
+	// An error from the handler is a server error
+	resp, err = http.Post(server.URL+"/run", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Expected a server error for a failed run, got %d", resp.StatusCode)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		origin string
+		code   int
+	}{
+		{http.MethodGet, "/run", "", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/unknown", "", http.StatusNotFound},
+		{http.MethodPost, "/run", "https://example.com", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, server.URL+test.path, nil)
+		if test.origin != "" {
+			req.Header.Set("Origin", test.origin)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.code {
+			t.Errorf("%s %s: expected %d, got %d", test.method, test.path, test.code, resp.StatusCode)
+		}
+	}
+
+	// Events are streamed a line at a time
+	resp, err = http.Get(server.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	events := server.Config.Handler.(*Server).Events
+	deadline := time.After(5 * time.Second)
+	for {
+		events.Publish("started", "Gorilla run started")
+		select {
+		case line := <-lines:
+			var event Event
+			if err := json.Unmarshal([]byte(line), &event); err != nil || event.Message != "Gorilla run started" {
+				t.Errorf("Unexpected event: %s", line)
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("Timed out waiting for an event")
+		}
+	}
+}
@@ -0,0 +1,195 @@
+//go:build windows
+// +build windows
+
+package ipc
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	pipeAccessDuplex          = 0x3
+	pipeRejectRemoteClients   = 0x8
+	pipeUnlimitedInstances    = 255
+	fileFlagFirstPipeInstance = 0x00080000
+	pipeBufferSize            = 64 * 1024
+
+	// pipeSDDL lets SYSTEM and administrators do anything, and users logged in at the computer read and write
+	pipeSDDL = "D:P(A;;GA;;;SY)(A;;GA;;;BA)(A;;GRGW;;;IU)"
+
+	// dialTimeout is how long to wait for a free pipe instance while the service answers someone else
+	dialTimeout = 5 * time.Second
+)
+
+// pipeAddr is the name of a pipe, for the net.Addr interface
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+// pipeListener creates a new instance of the pipe for each connection
+type pipeListener struct {
+	sa     *windows.SecurityAttributes
+	mu     sync.Mutex
+	next   windows.Handle
+	closed bool
+}
+
+// Listen creates the service's named pipe
+// The first instance is created right away, so a second service can't listen on the same pipe
+func Listen() (net.Listener, error) {
+	sd, err := windows.SecurityDescriptorFromString(pipeSDDL)
+	if err != nil {
+		return nil, err
+	}
+	l := &pipeListener{
+		sa: &windows.SecurityAttributes{
+			Length:             uint32(unsafe.Sizeof(windows.SecurityAttributes{})),
+			SecurityDescriptor: sd,
+		},
+	}
+	l.next, err = l.createPipe(fileFlagFirstPipeInstance)
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// createPipe creates another instance of the pipe, which only accepts clients on this computer
+func (l *pipeListener) createPipe(flags uint32) (windows.Handle, error) {
+	name, err := windows.UTF16PtrFromString(PipeName)
+	if err != nil {
+		return windows.InvalidHandle, err
+	}
+	return windows.CreateNamedPipe(name, pipeAccessDuplex|flags, pipeRejectRemoteClients,
+		pipeUnlimitedInstances, pipeBufferSize, pipeBufferSize, 0, l.sa)
+}
+
+// Accept waits for a client to connect to the pipe
+func (l *pipeListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil, net.ErrClosed
+	}
+	h := l.next
+	l.next = 0
+	l.mu.Unlock()
+
+	if h == 0 {
+		var err error
+		h, err = l.createPipe(0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// A client that connected before we started waiting is already connected
+	err := windows.ConnectNamedPipe(h, nil)
+	if err != nil && err != windows.ERROR_PIPE_CONNECTED {
+		windows.CloseHandle(h)
+		return nil, err
+	}
+
+	l.mu.Lock()
+	closed := l.closed
+	l.mu.Unlock()
+	if closed {
+		windows.CloseHandle(h)
+		return nil, net.ErrClosed
+	}
+	return &pipeConn{handle: h, server: true}, nil
+}
+
+// Close stops accepting connections
+func (l *pipeListener) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	l.mu.Unlock()
+
+	// Accept waits until a client connects, so connect to wake it up
+	if conn, err := Dial(); err == nil {
+		conn.Close()
+	}
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr { return pipeAddr(PipeName) }
+
+// Dial connects to the service's named pipe
+func Dial() (net.Conn, error) {
+	name, err := windows.UTF16PtrFromString(PipeName)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(dialTimeout)
+	for {
+		h, err := windows.CreateFile(name, windows.GENERIC_READ|windows.GENERIC_WRITE, 0, nil, windows.OPEN_EXISTING, 0, 0)
+		if err == nil {
+			return &pipeConn{handle: h}, nil
+		}
+		if err == windows.ERROR_PIPE_BUSY && time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+			continue
+		}
+		if err == windows.ERROR_FILE_NOT_FOUND {
+			return nil, fmt.Errorf("the gorilla service is not running")
+		}
+		return nil, err
+	}
+}
+
+// pipeConn is one end of a connected pipe
+type pipeConn struct {
+	handle windows.Handle
+	server bool
+	once   sync.Once
+}
+
+func (c *pipeConn) Read(b []byte) (int, error) {
+	var n uint32
+	err := windows.ReadFile(c.handle, b, &n, nil)
+	if err == windows.ERROR_BROKEN_PIPE || err == windows.ERROR_PIPE_NOT_CONNECTED {
+		return int(n), io.EOF
+	}
+	return int(n), err
+}
+
+func (c *pipeConn) Write(b []byte) (int, error) {
+	var n uint32
+	err := windows.WriteFile(c.handle, b, &n, nil)
+	return int(n), err
+}
+
+// Close waits for the client to read everything the server wrote, then closes the pipe
+func (c *pipeConn) Close() error {
+	var err error
+	c.once.Do(func() {
+		if c.server {
+			windows.FlushFileBuffers(c.handle)
+		}
+		err = windows.CloseHandle(c.handle)
+	})
+	return err
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return pipeAddr(PipeName) }
+func (c *pipeConn) RemoteAddr() net.Addr { return pipeAddr(PipeName) }
+
+// Pipes are opened for synchronous use, so they dont support deadlines
+func (c *pipeConn) SetDeadline(t time.Time) error      { return errNoDeadlines }
+func (c *pipeConn) SetReadDeadline(t time.Time) error  { return errNoDeadlines }
+func (c *pipeConn) SetWriteDeadline(t time.Time) error { return errNoDeadlines }
+
+var errNoDeadlines = fmt.Errorf("deadlines are not supported on the gorilla pipe")
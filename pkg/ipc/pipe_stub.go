@@ -0,0 +1,21 @@
+// Without a darwin specific build, go tools will try to include Windows libraries and fail
+
+//go:build !windows
+// +build !windows
+
+package ipc
+
+import (
+	"fmt"
+	"net"
+)
+
+// Listen is just a placeholder on darwin
+func Listen() (net.Listener, error) {
+	return nil, fmt.Errorf("named pipes are only supported on Windows")
+}
+
+// Dial is just a placeholder on darwin
+func Dial() (net.Conn, error) {
+	return nil, fmt.Errorf("named pipes are only supported on Windows")
+}
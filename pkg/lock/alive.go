@@ -0,0 +1,24 @@
+//go:build windows
+// +build windows
+
+package lock
+
+import "golang.org/x/sys/windows"
+
+// stillActive is the exit code of a process that hasnt exited
+const stillActive = 259
+
+// isProcessAlive returns true if a process is still running
+func isProcessAlive(pid int) bool {
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		// A process we arent allowed to open is still running
+		return err == windows.ERROR_ACCESS_DENIED
+	}
+	defer windows.CloseHandle(h)
+	var code uint32
+	if err := windows.GetExitCodeProcess(h, &code); err != nil {
+		return false
+	}
+	return code == stillActive
+}
@@ -0,0 +1,20 @@
+// Without a darwin specific build, go tools will try to include Windows libraries and fail
+
+//go:build !windows
+// +build !windows
+
+package lock
+
+import (
+	"os"
+	"syscall"
+)
+
+// isProcessAlive returns true if a process is still running
+func isProcessAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return p.Signal(syscall.Signal(0)) == nil
+}
@@ -0,0 +1,78 @@
+package lock
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// startupGrace is how long a lock without a process id is trusted, since the run that created it may not have written it yet
+const startupGrace = time.Minute
+
+// These abstractions allows us to override when testing
+var processAlive = isProcessAlive
+
+// BusyError means another run holds the lock
+type BusyError struct {
+	PID int
+}
+
+func (e *BusyError) Error() string {
+	return fmt.Sprintf("another run is in progress (process %d)", e.PID)
+}
+
+// Acquire takes the run lock, so a scheduled run and a manual run never install at the same time
+// The lock file holds our process id, so a lock left behind by a run that crashed can be taken over.
+// Force takes the lock even from a run that is still going.
+func Acquire(path string, force bool) error {
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = fmt.Fprint(f, os.Getpid())
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			return err
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+
+		// Someone else has the lock, which is only a problem if they are still running
+		if pid, held := holder(path); held && !force {
+			return &BusyError{PID: pid}
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return fmt.Errorf("unable to take the run lock: %s", path)
+}
+
+// holder returns the process id in a lock file, and whether that process still holds it
+func holder(path string) (int, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, false
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, time.Since(info.ModTime()) < startupGrace
+	}
+	return pid, processAlive(pid)
+}
+
+// Release removes the run lock, as long as we still hold it
+func Release(path string) {
+	data, err := ioutil.ReadFile(path)
+	if err == nil && strings.TrimSpace(string(data)) == strconv.Itoa(os.Getpid()) {
+		os.Remove(path)
+	}
+}
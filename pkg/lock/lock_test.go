@@ -0,0 +1,75 @@
+package lock
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestAcquire verifies the lock is refused while its holder is running, and taken over once it isnt
+func TestAcquire(t *testing.T) {
+	origAlive := processAlive
+	defer func() { processAlive = origAlive }()
+
+	dir, err := ioutil.TempDir("", "gorilla_lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "gorilla.lock")
+
+	if err := Acquire(path, false); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(path)
+	if have, want := string(data), strconv.Itoa(os.Getpid()); have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+	Release(path)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Expected the lock to be released")
+	}
+
+	// Another run is still going
+	ioutil.WriteFile(path, []byte("1234"), 0644)
+	processAlive = func(pid int) bool { return pid == 1234 }
+	err = Acquire(path, false)
+	if busy, ok := err.(*BusyError); !ok || busy.PID != 1234 {
+		t.Errorf("Expected the lock to be busy, got %v", err)
+	}
+
+	// We never release a lock we dont hold
+	Release(path)
+	if _, err := os.Stat(path); err != nil {
+		t.Error("Expected another run's lock to be kept")
+	}
+
+	// Forcing takes it anyway
+	if err := Acquire(path, true); err != nil {
+		t.Errorf("Expected force to take the lock, got %v", err)
+	}
+	Release(path)
+
+	// A run that crashed left its lock behind
+	ioutil.WriteFile(path, []byte("1234"), 0644)
+	processAlive = func(pid int) bool { return false }
+	if err := Acquire(path, false); err != nil {
+		t.Errorf("Expected a stale lock to be taken over, got %v", err)
+	}
+	Release(path)
+
+	// A lock without a process id is only trusted while its run could still be starting
+	ioutil.WriteFile(path, nil, 0644)
+	if _, ok := Acquire(path, false).(*BusyError); !ok {
+		t.Error("Expected a new lock without a process id to be busy")
+	}
+	old := time.Now().Add(-2 * startupGrace)
+	os.Chtimes(path, old, old)
+	if err := Acquire(path, false); err != nil {
+		t.Errorf("Expected an old lock without a process id to be taken over, got %v", err)
+	}
+	Release(path)
+}
@@ -0,0 +1,343 @@
+package manifest
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/1dustindavis/gorilla/pkg/gorillalog"
+	version "github.com/hashicorp/go-version"
+)
+
+//
+// Conditions are simple expressions that compare facts about this machine to a value, for example:
+//
+//   os_version >= "10.0.22000" AND arch == "x64"
+//   hostname BEGINSWITH "LAB-" OR free_disk_gb < 20
+//   registry("HKLM\SOFTWARE\Policies\Example", "Channel") == "beta"
+//
+// Comparisons can be combined with AND, OR, NOT, and parentheses.
+// Values that look like versions or numbers are compared as versions, anything else as
+// case insensitive text. CONTAINS and BEGINSWITH always compare text.
+//
+
+// These abstractions allows us to override when testing
+var (
+	conditionFacts = getFacts
+	registryValue  = readRegistryValue
+)
+
+// getFacts returns the facts about this machine that conditions can refer to
+// A fact that cant be determined is left out, and any condition using it will fail to evaluate
+func getFacts() map[string]string {
+	facts := map[string]string{
+		"arch": arch(),
+	}
+
+	if hostname, err := os.Hostname(); err == nil {
+		facts["hostname"] = hostname
+	} else {
+		gorillalog.Warn("Unable to determine hostname:", err)
+	}
+
+	if osVersion, err := osVersion(); err == nil {
+		facts["os_version"] = osVersion
+	} else {
+		gorillalog.Debug("Unable to determine os version:", err)
+	}
+
+	if freeDisk, err := freeDiskGB(); err == nil {
+		facts["free_disk_gb"] = freeDisk
+	} else {
+		gorillalog.Debug("Unable to determine free disk space:", err)
+	}
+
+	return facts
+}
+
+// arch returns the architecture using the names Windows admins expect
+func arch() string {
+	switch runtime.GOARCH {
+	case "amd64":
+		return "x64"
+	case "386":
+		return "x86"
+	default:
+		return runtime.GOARCH
+	}
+}
+
+// evaluateCondition returns the result of a condition using the facts provided
+func evaluateCondition(condition string, facts map[string]string) (bool, error) {
+	tokens, err := tokenize(condition)
+	if err != nil {
+		return false, err
+	}
+	if len(tokens) == 0 {
+		return false, fmt.Errorf("empty condition")
+	}
+
+	p := &conditionParser{tokens: tokens, facts: facts}
+	result, err := p.parseOr()
+	if err != nil {
+		return false, fmt.Errorf("%s: %v", condition, err)
+	}
+	if p.pos < len(p.tokens) {
+		return false, fmt.Errorf("%s: unexpected %q", condition, p.tokens[p.pos].text)
+	}
+	return result, nil
+}
+
+// tokenKind describes what a token in a condition represents
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenString
+	tokenSymbol
+)
+
+// token is a single piece of a condition
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// tokenize splits a condition into words, quoted strings, and symbols
+// Quoted strings have no escape characters, so registry paths can be written as-is
+func tokenize(condition string) (tokens []token, err error) {
+	for i := 0; i < len(condition); {
+		c := condition[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(condition[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("%s: unterminated string", condition)
+			}
+			tokens = append(tokens, token{tokenString, condition[i+1 : i+1+end]})
+			i += end + 2
+		case strings.HasPrefix(condition[i:], "==") || strings.HasPrefix(condition[i:], "!=") ||
+			strings.HasPrefix(condition[i:], "<=") || strings.HasPrefix(condition[i:], ">="):
+			tokens = append(tokens, token{tokenSymbol, condition[i : i+2]})
+			i += 2
+		case strings.IndexByte("<>(),", c) >= 0:
+			tokens = append(tokens, token{tokenSymbol, string(c)})
+			i++
+		case isWordChar(c):
+			start := i
+			for i < len(condition) && isWordChar(condition[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokenWord, condition[start:i]})
+		default:
+			return nil, fmt.Errorf("%s: unexpected character %q", condition, c)
+		}
+	}
+	return tokens, nil
+}
+
+// isWordChar returns true for characters that can be part of a fact name or an unquoted value
+func isWordChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.' || c == '-'
+}
+
+// conditionParser evaluates a condition as it is parsed
+type conditionParser struct {
+	tokens []token
+	pos    int
+	facts  map[string]string
+}
+
+// peek returns the next token without consuming it
+func (p *conditionParser) peek() (token, bool) {
+	if p.pos >= len(p.tokens) {
+		return token{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+// keyword consumes the next token if it is the provided keyword, ignoring case
+func (p *conditionParser) keyword(word string) bool {
+	next, ok := p.peek()
+	if ok && next.kind == tokenWord && strings.EqualFold(next.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// symbol consumes the next token if it is the provided symbol
+func (p *conditionParser) symbol(symbol string) bool {
+	next, ok := p.peek()
+	if ok && next.kind == tokenSymbol && next.text == symbol {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// parseOr handles `a OR b`, which has the lowest precedence
+func (p *conditionParser) parseOr() (bool, error) {
+	result, err := p.parseAnd()
+	if err != nil {
+		return false, err
+	}
+	for p.keyword("OR") {
+		next, err := p.parseAnd()
+		if err != nil {
+			return false, err
+		}
+		result = result || next
+	}
+	return result, nil
+}
+
+// parseAnd handles `a AND b`
+func (p *conditionParser) parseAnd() (bool, error) {
+	result, err := p.parseNot()
+	if err != nil {
+		return false, err
+	}
+	for p.keyword("AND") {
+		next, err := p.parseNot()
+		if err != nil {
+			return false, err
+		}
+		result = result && next
+	}
+	return result, nil
+}
+
+// parseNot handles `NOT a`
+func (p *conditionParser) parseNot() (bool, error) {
+	if p.keyword("NOT") {
+		result, err := p.parseNot()
+		return !result, err
+	}
+	return p.parsePrimary()
+}
+
+// parsePrimary handles a parenthesized condition or a single comparison
+func (p *conditionParser) parsePrimary() (bool, error) {
+	if p.symbol("(") {
+		result, err := p.parseOr()
+		if err != nil {
+			return false, err
+		}
+		if !p.symbol(")") {
+			return false, fmt.Errorf("missing closing parenthesis")
+		}
+		return result, nil
+	}
+
+	left, err := p.parseValue()
+	if err != nil {
+		return false, err
+	}
+	operator, ok := p.peek()
+	if !ok {
+		return false, fmt.Errorf("missing comparison after %q", left)
+	}
+	p.pos++
+	right, err := p.parseValue()
+	if err != nil {
+		return false, err
+	}
+	return compare(left, operator.text, right)
+}
+
+// parseValue returns the value of a fact, a literal, or a registry lookup
+func (p *conditionParser) parseValue() (string, error) {
+	next, ok := p.peek()
+	if !ok {
+		return "", fmt.Errorf("missing value")
+	}
+	p.pos++
+
+	switch {
+	case next.kind == tokenString:
+		return next.text, nil
+	case next.kind == tokenWord && next.text[0] >= '0' && next.text[0] <= '9':
+		// Unquoted numbers and versions are literals
+		return next.text, nil
+	case next.kind == tokenWord && strings.EqualFold(next.text, "registry"):
+		return p.parseRegistry()
+	case next.kind == tokenWord:
+		fact, ok := p.facts[strings.ToLower(next.text)]
+		if !ok {
+			return "", fmt.Errorf("unknown fact %q", next.text)
+		}
+		return fact, nil
+	default:
+		return "", fmt.Errorf("unexpected %q", next.text)
+	}
+}
+
+// parseRegistry handles `registry("HKLM\path\to\key", "ValueName")`
+// A key or value that doesnt exist is an empty string
+func (p *conditionParser) parseRegistry() (string, error) {
+	var args []string
+	if !p.symbol("(") {
+		return "", fmt.Errorf("registry requires a key and value name")
+	}
+	for len(args) < 2 {
+		next, ok := p.peek()
+		if !ok || next.kind != tokenString {
+			return "", fmt.Errorf("registry requires a quoted key and value name")
+		}
+		p.pos++
+		args = append(args, next.text)
+		if len(args) < 2 && !p.symbol(",") {
+			return "", fmt.Errorf("registry requires a key and value name")
+		}
+	}
+	if !p.symbol(")") {
+		return "", fmt.Errorf("registry requires a key and value name")
+	}
+	return registryValue(args[0], args[1])
+}
+
+// compare applies an operator to two values
+func compare(left, operator, right string) (bool, error) {
+	switch strings.ToUpper(operator) {
+	case "CONTAINS":
+		return strings.Contains(strings.ToLower(left), strings.ToLower(right)), nil
+	case "BEGINSWITH":
+		return strings.HasPrefix(strings.ToLower(left), strings.ToLower(right)), nil
+	}
+
+	// Compare as versions if we can, which also covers plain numbers
+	leftVersion, leftErr := version.NewVersion(left)
+	rightVersion, rightErr := version.NewVersion(right)
+	if leftErr == nil && rightErr == nil {
+		result := leftVersion.Compare(rightVersion)
+		switch operator {
+		case "==":
+			return result == 0, nil
+		case "!=":
+			return result != 0, nil
+		case "<":
+			return result < 0, nil
+		case "<=":
+			return result <= 0, nil
+		case ">":
+			return result > 0, nil
+		case ">=":
+			return result >= 0, nil
+		}
+		return false, fmt.Errorf("unknown operator %q", operator)
+	}
+
+	switch operator {
+	case "==":
+		return strings.EqualFold(left, right), nil
+	case "!=":
+		return !strings.EqualFold(left, right), nil
+	case "<", "<=", ">", ">=":
+		return false, fmt.Errorf("%q and %q cant be compared with %s", left, right, operator)
+	}
+	return false, fmt.Errorf("unknown operator %q", operator)
+}
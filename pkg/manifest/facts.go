@@ -0,0 +1,85 @@
+//go:build windows
+// +build windows
+
+package manifest
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/windows"
+	registry "golang.org/x/sys/windows/registry"
+)
+
+// registryRoots maps the ways a root key may be written to the key itself
+var registryRoots = map[string]registry.Key{
+	"HKLM":               registry.LOCAL_MACHINE,
+	"HKEY_LOCAL_MACHINE": registry.LOCAL_MACHINE,
+	"HKCU":               registry.CURRENT_USER,
+	"HKEY_CURRENT_USER":  registry.CURRENT_USER,
+	"HKCR":               registry.CLASSES_ROOT,
+	"HKEY_CLASSES_ROOT":  registry.CLASSES_ROOT,
+	"HKU":                registry.USERS,
+	"HKEY_USERS":         registry.USERS,
+}
+
+// osVersion returns the Windows version as major.minor.build
+func osVersion() (string, error) {
+	info := windows.RtlGetVersion()
+	return fmt.Sprintf("%d.%d.%d", info.MajorVersion, info.MinorVersion, info.BuildNumber), nil
+}
+
+// freeDiskGB returns the free space on the system drive in whole gigabytes
+func freeDiskGB() (string, error) {
+	drive, err := windows.UTF16PtrFromString(os.Getenv("SystemDrive") + `\`)
+	if err != nil {
+		return "", err
+	}
+	var freeBytes uint64
+	err = windows.GetDiskFreeSpaceEx(drive, &freeBytes, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatUint(freeBytes/(1<<30), 10), nil
+}
+
+// readRegistryValue returns a registry value as a string
+// A key or value that doesnt exist returns an empty string
+func readRegistryValue(keyPath, valueName string) (string, error) {
+	parts := strings.SplitN(keyPath, `\`, 2)
+	root, ok := registryRoots[strings.ToUpper(parts[0])]
+	if !ok || len(parts) < 2 {
+		return "", fmt.Errorf("unsupported registry key: %s", keyPath)
+	}
+
+	key, err := registry.OpenKey(root, parts[1], registry.QUERY_VALUE)
+	if err == registry.ErrNotExist {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	defer key.Close()
+
+	// Read the value based on the type it was stored as
+	_, valueType, err := key.GetValue(valueName, nil)
+	if err == registry.ErrNotExist {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	switch valueType {
+	case registry.DWORD, registry.QWORD:
+		value, _, err := key.GetIntegerValue(valueName)
+		return strconv.FormatUint(value, 10), err
+	case registry.SZ, registry.EXPAND_SZ:
+		value, _, err := key.GetStringValue(valueName)
+		return value, err
+	case registry.MULTI_SZ:
+		values, _, err := key.GetStringsValue(valueName)
+		return strings.Join(values, ","), err
+	default:
+		return "", fmt.Errorf("unsupported registry value type: %s\\%s", keyPath, valueName)
+	}
+}
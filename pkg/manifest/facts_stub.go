@@ -0,0 +1,20 @@
+// Without a darwin specific build, go tools will try to include Windows libraries and fail
+
+//go:build !windows
+// +build !windows
+
+package manifest
+
+import "fmt"
+
+func osVersion() (string, error) {
+	return "", fmt.Errorf("os_version is only available on Windows")
+}
+
+func freeDiskGB() (string, error) {
+	return "", fmt.Errorf("free_disk_gb is only available on Windows")
+}
+
+func readRegistryValue(keyPath, valueName string) (string, error) {
+	return "", fmt.Errorf("registry conditions are only available on Windows")
+}
@@ -1,29 +1,162 @@
 package manifest
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/1dustindavis/gorilla/pkg/config"
 	"github.com/1dustindavis/gorilla/pkg/download"
 	"github.com/1dustindavis/gorilla/pkg/gorillalog"
+	"github.com/1dustindavis/gorilla/pkg/identity"
 	"github.com/1dustindavis/gorilla/pkg/report"
+	"github.com/1dustindavis/gorilla/pkg/signature"
 	"gopkg.in/yaml.v3"
 )
 
 // Item represents a single object from the manifest
 type Item struct {
-	Name       string   `yaml:"name"`
-	Includes   []string `yaml:"included_manifests"`
-	Installs   []string `yaml:"managed_installs"`
-	Uninstalls []string `yaml:"managed_uninstalls"`
-	Updates    []string `yaml:"managed_updates"`
-	Catalogs   []string `yaml:"catalogs"`
+	Name       string            `yaml:"name"`
+	Includes   []string          `yaml:"included_manifests"`
+	Installs   ItemList          `yaml:"managed_installs"`
+	Uninstalls ItemList          `yaml:"managed_uninstalls"`
+	Updates    ItemList          `yaml:"managed_updates"`
+	Optional   ItemList          `yaml:"optional_installs,omitempty"`
+	Catalogs   []string          `yaml:"catalogs"`
+	Conditions []ConditionalItem `yaml:"conditional_items,omitempty"`
 }
 
-// This abstraction allows us to override when testing
-var downloadGet = download.Get
+// ConditionalItem is a group of items that are only added to a manifest when the condition is true
+type ConditionalItem struct {
+	Condition  string   `yaml:"condition"`
+	Includes   []string `yaml:"included_manifests,omitempty"`
+	Installs   ItemList `yaml:"managed_installs,omitempty"`
+	Uninstalls ItemList `yaml:"managed_uninstalls,omitempty"`
+	Updates    ItemList `yaml:"managed_updates,omitempty"`
+	Optional   ItemList `yaml:"optional_installs,omitempty"`
+}
+
+// These abstractions allows us to override when testing
+var (
+	// Manifests rarely change between runs, so only changed manifests are downloaded again
+	downloadGet = download.GetCached
+
+	// identityGet looks up the placeholders a manifest name can use
+	identityGet = identity.Get
+
+	// The groups this computer is in, for group_manifests
+	adGroups    = identity.ADGroups
+	graphGroups = identity.Graph.Groups
+
+	// defaultLocalManifest is always used if it exists, so a single machine can be given extra items
+	defaultLocalManifest = filepath.Join(os.Getenv("ProgramData"), "gorilla", "local_manifest.yaml")
+)
+
+// localManifestPaths returns the configured local manifests, and the default local manifest if it exists
+func localManifestPaths(cfg config.Configuration) []string {
+	paths := append([]string{}, cfg.LocalManifests...)
+	if _, err := os.Stat(defaultLocalManifest); err != nil {
+		return paths
+	}
+	for _, path := range paths {
+		if filepath.Clean(path) == filepath.Clean(defaultLocalManifest) {
+			return paths
+		}
+	}
+	return append(paths, defaultLocalManifest)
+}
+
+// getLocalManifests reads each local manifest, skipping any that cant be read
+func getLocalManifests(cfg config.Configuration) (localManifests []Item) {
+	for _, path := range localManifestPaths(cfg) {
+		gorillalog.Info("Manifest File:", path)
+		localManifestYaml, err := ioutil.ReadFile(path)
+		if err != nil {
+			gorillalog.Warn("Unable to read local manifest: ", path, err)
+			continue
+		}
+		localManifests = append(localManifests, parseManifest(path, localManifestYaml))
+	}
+	return localManifests
+}
+
+// Name returns the top level manifest for this computer, filling in placeholders like `serials/%serial%`
+// The default manifest is used when this computer has no value for one of them
+func Name(cfg config.Configuration) (string, error) {
+	if !identity.HasPlaceholders(cfg.Manifest) {
+		return cfg.Manifest, nil
+	}
+	name, err := identityGet().Expand(cfg.Manifest)
+	if err == nil {
+		gorillalog.Info("Using manifest", name, "for this computer")
+		return name, nil
+	}
+	if cfg.DefaultManifest == "" {
+		return "", err
+	}
+	gorillalog.Info("Using the default manifest", cfg.DefaultManifest+",", err)
+	return cfg.DefaultManifest, nil
+}
+
+// groupManifests returns the manifests `group_manifests` maps to the groups this computer is in,
+// so adding a computer to a group like "SW-AutoCAD" is all it takes to give it the software
+func groupManifests(cfg config.Configuration) []string {
+	if len(cfg.GroupManifests) == 0 {
+		return nil
+	}
+	groups, err := computerGroups(cfg)
+	if err != nil {
+		gorillalog.Warn("Unable to look up the groups this computer is in:", err)
+		return nil
+	}
+	member := make(map[string]bool)
+	for _, group := range groups {
+		member[strings.ToLower(group)] = true
+	}
+
+	// Group names arent case sensitive, and are sorted so the manifests are always included in the same order
+	var names []string
+	for group := range cfg.GroupManifests {
+		names = append(names, group)
+	}
+	sort.Strings(names)
+	var manifests []string
+	for _, group := range names {
+		if member[strings.ToLower(group)] {
+			gorillalog.Info("Including manifest", cfg.GroupManifests[group], "for group", group)
+			manifests = append(manifests, cfg.GroupManifests[group])
+		}
+	}
+	return manifests
+}
+
+// computerGroups returns the names of the groups this computer is in, from Active Directory or Azure AD
+func computerGroups(cfg config.Configuration) ([]string, error) {
+	switch strings.ToLower(cfg.GroupSource) {
+	case "", config.GroupSourceAD:
+		return adGroups()
+	case config.GroupSourceAzure:
+		deviceID := identityGet().AzureDeviceID
+		if deviceID == "" {
+			return nil, fmt.Errorf("this computer isnt joined to Azure AD")
+		}
+		client, err := download.Client()
+		if err != nil {
+			return nil, err
+		}
+		return graphGroups(identity.Graph{
+			TenantID:     cfg.GraphTenantID,
+			ClientID:     cfg.GraphClientID,
+			ClientSecret: cfg.GraphClientSecret,
+			Client:       client,
+		}, deviceID)
+	}
+	return nil, fmt.Errorf("expected ad or azure for group_source, not %q", cfg.GroupSource)
+}
 
 // Get returns two slices:
 // 1) All manifest objects
@@ -45,73 +178,79 @@ func Get(cfg config.Configuration) (manifests []Item, newCatalogs []string) {
 	defer func() {
 		if r := recover(); r != nil {
 			fmt.Println(r)
+			report.Fail()
 			report.End()
-			os.Exit(1)
+			report.Exit()
 		}
 	}()
 
+	// Manifests for the groups this computer is in come right after the top level manifest
+	for _, include := range groupManifests(cfg) {
+		if !contains(manifestsList, include) {
+			manifestsList = append(manifestsList, include)
+		}
+	}
+
+	// Local manifests can include manifests from the server too, so read them first
+	localManifests := getLocalManifests(cfg)
+	for _, localManifest := range localManifests {
+		for _, include := range localManifest.Includes {
+			if !contains(manifestsList, include) {
+				manifestsList = append(manifestsList, include)
+			}
+		}
+	}
+	manifestsRemaining = len(manifestsList)
+
 	for manifestsRemaining > 0 {
 		currentManifest := manifestsList[manifestsProcessed]
 
-		// Add the current manifest to our working list
-		workingList := []string{currentManifest}
-
 		// Download the manifest
 		manifestURL := cfg.URL + "manifests/" + currentManifest + ".yaml"
 		gorillalog.Info("Manifest Url:", manifestURL)
 		yamlFile, err := downloadGet(manifestURL)
+
+		// A manifest picked for this computer may not have been written yet, so the default is used instead
+		if errors.Is(err, download.ErrNotFound) && manifestsProcessed == 0 && cfg.DefaultManifest != "" && currentManifest != cfg.DefaultManifest {
+			gorillalog.Info("Manifest", currentManifest, "was not found, using the default manifest", cfg.DefaultManifest)
+			currentManifest = cfg.DefaultManifest
+			manifestsList[0] = currentManifest
+			report.Items["Manifest"] = currentManifest
+			manifestURL = cfg.URL + "manifests/" + currentManifest + ".yaml"
+			gorillalog.Info("Manifest Url:", manifestURL)
+			yamlFile, err = downloadGet(manifestURL)
+		}
 		if err != nil {
+			// A repo that cant be reached is a different problem than a missing or broken manifest
+			if errors.Is(err, download.ErrNetwork) || errors.Is(err, download.ErrServerError) {
+				report.FailWith(report.ExitNetworkError)
+			}
 			gorillalog.Error("Unable to retrieve manifest: ", err)
 		}
 
-		newManifest := parseManifest(manifestURL, yamlFile)
-
-		// Add any includes to our working list
-		workingList = append(workingList, newManifest.Includes...)
+		// Make sure the manifest was signed by us before trusting anything in it
+		err = signature.VerifyURL(cfg.MetadataKey, manifestURL, yamlFile, downloadGet)
+		if err != nil {
+			gorillalog.Error("Unable to verify manifest signature: ", manifestURL, err)
+		}
 
-		// Get workingList unique items, and add to the real list
-		for _, item := range workingList {
+		newManifest := parseManifest(manifestURL, yamlFile)
 
-			// Check if unique in manifestsList
-			var uniqueInList = true
-			for i := range manifestsList {
-				if manifestsList[i] == item {
-					uniqueInList = false
-				}
-			}
-			// Update manifestsList if it is unique
-			if uniqueInList {
-				manifestsList = append(manifestsList, item)
+		// Add any includes to the list, skipping manifests we have already seen
+		// Each manifest is only retrieved once, so an include cycle cant loop forever
+		for _, include := range newManifest.Includes {
+			if !contains(manifestsList, include) {
+				manifestsList = append(manifestsList, include)
+			} else {
+				gorillalog.Debug("Skipping manifest that was already included:", include, "from", currentManifest)
 			}
 		}
 
-		// Check if this is unique in manifests
-		var uniqueInManifests = true
-		for i := range manifests {
-			if manifests[i].Name == newManifest.Name {
-				uniqueInManifests = false
-			}
-		}
-		// Update manifests
-		if uniqueInManifests {
-			// manifests = append([]Item{newManifest}, manifests...)
-			manifests = append(manifests, newManifest)
-		}
+		// Every manifest in the list is unique, even if the name inside it is not
+		manifests = append(manifests, newManifest)
 
 		// If any catalogs are in the manifest, append them to the end of the list
-		for _, newCatalog := range newManifest.Catalogs {
-			// Before adding it, check if it is already on the list
-			var match bool
-			for _, oldCatalog := range cfg.Catalogs {
-				if oldCatalog == newCatalog {
-					match = true
-				}
-			}
-			// If "match" is still false, it is not already in the catalog slice
-			if !match {
-				newCatalogs = append(newCatalogs, newCatalog)
-			}
-		}
+		newCatalogs = addCatalogs(cfg.Catalogs, newCatalogs, newManifest.Catalogs)
 
 		// Increment counters
 		manifestsTotal = len(manifestsList)
@@ -119,23 +258,35 @@ func Get(cfg config.Configuration) (manifests []Item, newCatalogs []string) {
 		manifestsRemaining = manifestsTotal - manifestsProcessed
 	}
 
-	// Add the local manifest after processing all other manifests
-	if len(cfg.LocalManifests) > 0 {
-		for _, manifest := range cfg.LocalManifests {
-			var localManifest Item
-			gorillalog.Info("Manifest File:", manifest)
-			localManifestsYaml, err := ioutil.ReadFile(manifest)
-			if err != nil {
-				gorillalog.Warn("Unable to parse yaml manifest: ", manifest, err)
-			}
-			localManifest = parseManifest(manifest, localManifestsYaml)
-			manifests = append(manifests, localManifest)
-		}
+	// Add the local manifests after processing all other manifests
+	for _, localManifest := range localManifests {
+		manifests = append(manifests, localManifest)
+		newCatalogs = addCatalogs(cfg.Catalogs, newCatalogs, localManifest.Catalogs)
 	}
 
 	return manifests, newCatalogs
 }
 
+// addCatalogs appends any catalogs that are not already configured or on the list
+func addCatalogs(configured, newCatalogs, manifestCatalogs []string) []string {
+	for _, newCatalog := range manifestCatalogs {
+		if !contains(configured, newCatalog) && !contains(newCatalogs, newCatalog) {
+			newCatalogs = append(newCatalogs, newCatalog)
+		}
+	}
+	return newCatalogs
+}
+
+// contains returns true if the slice includes the string
+func contains(s []string, e string) bool {
+	for _, a := range s {
+		if a == e {
+			return true
+		}
+	}
+	return false
+}
+
 func parseManifest(manifestURL string, yamlFile []byte) Item {
 	// Parse the new manifest
 	var newManifest Item
@@ -143,5 +294,33 @@ func parseManifest(manifestURL string, yamlFile []byte) Item {
 	if err != nil {
 		gorillalog.Error("Unable to parse yaml manifest: ", manifestURL, err)
 	}
-	return newManifest
+	return applyConditions(newManifest)
+}
+
+// applyConditions adds the items from each conditional item whose condition is true
+// A condition that cant be evaluated is logged and treated as false
+func applyConditions(manifestItem Item) Item {
+	if len(manifestItem.Conditions) == 0 {
+		return manifestItem
+	}
+
+	facts := conditionFacts()
+	for _, conditional := range manifestItem.Conditions {
+		match, err := evaluateCondition(conditional.Condition, facts)
+		if err != nil {
+			gorillalog.Warn("Unable to evaluate condition in manifest:", manifestItem.Name, err)
+			continue
+		}
+		if !match {
+			gorillalog.Debug("Condition is false:", conditional.Condition)
+			continue
+		}
+		gorillalog.Debug("Condition is true:", conditional.Condition)
+		manifestItem.Includes = append(manifestItem.Includes, conditional.Includes...)
+		manifestItem.Installs = append(manifestItem.Installs, conditional.Installs...)
+		manifestItem.Uninstalls = append(manifestItem.Uninstalls, conditional.Uninstalls...)
+		manifestItem.Updates = append(manifestItem.Updates, conditional.Updates...)
+		manifestItem.Optional = append(manifestItem.Optional, conditional.Optional...)
+	}
+	return manifestItem
 }
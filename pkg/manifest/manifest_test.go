@@ -6,12 +6,24 @@ import (
 	"testing"
 
 	"github.com/1dustindavis/gorilla/pkg/config"
+	"github.com/1dustindavis/gorilla/pkg/download"
+	"github.com/1dustindavis/gorilla/pkg/identity"
 	yaml "gopkg.in/yaml.v3"
 )
 
 var (
 	// store the current downloadGet function in order to restore later
-	origDownloadGet = downloadGet
+	origDownloadGet    = downloadGet
+	origConditionFacts = conditionFacts
+	origRegistryValue  = registryValue
+
+	// Facts about a pretend machine for evaluating conditions
+	testFacts = map[string]string{
+		"arch":         "x64",
+		"hostname":     "LAB-PC01",
+		"os_version":   "10.0.19045",
+		"free_disk_gb": "42",
+	}
 
 	// Define a Configuration struct to pass to `Get`
 	cfg = config.Configuration{
@@ -38,6 +50,22 @@ var (
 		Updates:    []string{"TestUpdate1", "TestUpdate2"},
 		Catalogs:   []string{},
 	}
+	// These include each other, and themselves, to confirm we dont loop forever
+	// Neither has a name, so they can only be told apart by the manifest they were included as
+	cycleManifestA = Item{
+		Includes:   []string{"cycle_manifest_b", "cycle_manifest_a"},
+		Installs:   []string{"TestInstall1"},
+		Uninstalls: []string{},
+		Updates:    []string{},
+		Catalogs:   []string{"production1"},
+	}
+	cycleManifestB = Item{
+		Includes:   []string{"cycle_manifest_a", "cycle_manifest_b"},
+		Installs:   []string{"TestInstall2"},
+		Uninstalls: []string{},
+		Updates:    []string{},
+		Catalogs:   []string{"production1", "alpha"},
+	}
 	localManifest = Item{
 		Name:       "example_local_manifest",
 		Installs:   []string{"Opera"},
@@ -106,6 +134,238 @@ func TestGetCatalogs(t *testing.T) {
 	}
 }
 
+// TestGetIncludeCycle verifies that manifests including each other are only retrieved once
+func TestGetIncludeCycle(t *testing.T) {
+
+	// Override the download function, but restore it when we're done
+	downloadGet = fakeDownload
+	defer func() {
+		downloadGet = origDownloadGet
+	}()
+
+	cycleCfg := cfg
+	cycleCfg.Manifest = "cycle_manifest_a"
+	cycleCfg.LocalManifests = nil
+
+	actualManifests, newCatalogs := Get(cycleCfg)
+
+	expectedManifests := []Item{cycleManifestA, cycleManifestB}
+	if !reflect.DeepEqual(expectedManifests, actualManifests) {
+		t.Errorf("\nExpected: %#v\nActual: %#v", expectedManifests, actualManifests)
+	}
+
+	// Catalogs should only be added once, and not if they are already configured
+	expectedCatalogs := []string{"production1"}
+	if !reflect.DeepEqual(expectedCatalogs, newCatalogs) {
+		t.Errorf("\nExpected: %#v\nActual: %#v", expectedCatalogs, newCatalogs)
+	}
+}
+
+// TestGetDefaultManifest verifies the default manifest is used when the one picked for this computer doesnt exist
+func TestGetDefaultManifest(t *testing.T) {
+	downloadGet = func(manifestURL string) ([]byte, error) {
+		if manifestURL == "https://example.com/manifests/serials/5CG1234XYZ.yaml" {
+			return nil, fmt.Errorf("404: %w", download.ErrNotFound)
+		}
+		return fakeDownload(manifestURL)
+	}
+	defer func() {
+		downloadGet = origDownloadGet
+	}()
+
+	defaultCfg := cfg
+	defaultCfg.Manifest = "serials/5CG1234XYZ"
+	defaultCfg.DefaultManifest = "cycle_manifest_a"
+	defaultCfg.LocalManifests = nil
+
+	actualManifests, _ := Get(defaultCfg)
+	expectedManifests := []Item{cycleManifestA, cycleManifestB}
+	if !reflect.DeepEqual(expectedManifests, actualManifests) {
+		t.Errorf("\nExpected: %#v\nActual: %#v", expectedManifests, actualManifests)
+	}
+}
+
+// TestName verifies the placeholders in the manifest name are filled in, falling back to the default manifest
+func TestName(t *testing.T) {
+	identityGet = func() identity.Identity { return identity.Identity{Hostname: "lab-pc01", Serial: "5CG1234XYZ"} }
+	defer func() { identityGet = identity.Get }()
+
+	nameCfg := config.Configuration{Manifest: "serials/%serial%"}
+	if have, err := Name(nameCfg); err != nil || have != "serials/5CG1234XYZ" {
+		t.Errorf("have %s, %v, want serials/5CG1234XYZ", have, err)
+	}
+
+	// Without a default, a placeholder this computer has no value for is an error
+	nameCfg.Manifest = "ou/%ou%"
+	if have, err := Name(nameCfg); err == nil {
+		t.Errorf("expected an error, got %s", have)
+	}
+	nameCfg.DefaultManifest = "site_default"
+	if have, err := Name(nameCfg); err != nil || have != "site_default" {
+		t.Errorf("have %s, %v, want site_default", have, err)
+	}
+
+	// A name without placeholders is used as is
+	nameCfg.Manifest = "example_manifest"
+	if have, err := Name(nameCfg); err != nil || have != "example_manifest" {
+		t.Errorf("have %s, %v, want example_manifest", have, err)
+	}
+}
+
+// TestGetGroupManifests verifies the manifests for this computer's groups are included after the top level manifest
+func TestGetGroupManifests(t *testing.T) {
+	downloadGet = fakeDownload
+	adGroups = func() ([]string, error) { return []string{"Domain Computers", "sw-autocad"}, nil }
+	defer func() {
+		downloadGet = origDownloadGet
+		adGroups = identity.ADGroups
+	}()
+
+	groupCfg := cfg
+	groupCfg.Manifest = "cycle_manifest_a"
+	groupCfg.LocalManifests = nil
+	groupCfg.GroupManifests = map[string]string{"SW-AutoCAD": "included_manifest", "SW-Office": "example_manifest"}
+
+	actualManifests, _ := Get(groupCfg)
+	expectedManifests := []Item{cycleManifestA, includedManifest, cycleManifestB}
+	if !reflect.DeepEqual(expectedManifests, actualManifests) {
+		t.Errorf("\nExpected: %#v\nActual: %#v", expectedManifests, actualManifests)
+	}
+}
+
+// TestGroupManifests verifies groups are mapped to manifests in a stable order, from Active Directory or Azure AD
+func TestGroupManifests(t *testing.T) {
+	adGroups = func() ([]string, error) { return []string{"SW-AutoCAD", "SW-Office"}, nil }
+	identityGet = func() identity.Identity { return identity.Identity{AzureDeviceID: "device-id"} }
+	var graph identity.Graph
+	graphGroups = func(g identity.Graph, deviceID string) ([]string, error) {
+		graph = g
+		if deviceID != "device-id" {
+			return nil, fmt.Errorf("device %s was not found in Azure AD", deviceID)
+		}
+		return []string{"All Laptops"}, nil
+	}
+	defer func() {
+		adGroups = identity.ADGroups
+		identityGet = identity.Get
+		graphGroups = identity.Graph.Groups
+	}()
+
+	groupCfg := config.Configuration{GroupManifests: map[string]string{
+		"SW-Office":   "software/office",
+		"SW-AutoCAD":  "software/autocad",
+		"All Laptops": "laptops",
+	}}
+	if have, want := groupManifests(groupCfg), []string{"software/autocad", "software/office"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %v, want %v", have, want)
+	}
+
+	groupCfg.GroupSource = "azure"
+	groupCfg.GraphTenantID = "tenant"
+	if have, want := groupManifests(groupCfg), []string{"laptops"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	if graph.TenantID != "tenant" {
+		t.Errorf("expected the graph settings to be passed along, got %#v", graph)
+	}
+
+	// Groups that cant be looked up include nothing, rather than stopping the run
+	identityGet = func() identity.Identity { return identity.Identity{} }
+	if have := groupManifests(groupCfg); have != nil {
+		t.Errorf("expected no manifests without an Azure AD device id, got %v", have)
+	}
+	groupCfg.GroupSource = "ldap"
+	if have := groupManifests(groupCfg); have != nil {
+		t.Errorf("expected no manifests for an unknown group source, got %v", have)
+	}
+}
+
+// TestEvaluateCondition verifies that conditions are evaluated against the facts
+func TestEvaluateCondition(t *testing.T) {
+	registryValue = func(keyPath, valueName string) (string, error) {
+		if keyPath == `HKLM\SOFTWARE\Example` && valueName == "Channel" {
+			return "beta", nil
+		}
+		return "", nil
+	}
+	defer func() { registryValue = origRegistryValue }()
+
+	tests := []struct {
+		condition string
+		expected  bool
+	}{
+		{`arch == "x64"`, true},
+		{`arch == 'X64'`, true},
+		{`arch != "x64"`, false},
+		{`os_version >= "10.0.22000"`, false},
+		{`os_version >= 10.0.19041`, true},
+		{`free_disk_gb < 100`, true},
+		{`free_disk_gb > 9`, true},
+		{`hostname BEGINSWITH "lab-"`, true},
+		{`hostname contains "pc"`, true},
+		{`registry("HKLM\SOFTWARE\Example", "Channel") == "beta"`, true},
+		{`registry("HKLM\SOFTWARE\Missing", "Channel") == ""`, true},
+		{`arch == "x86" OR hostname BEGINSWITH "LAB-"`, true},
+		{`arch == "x64" AND NOT free_disk_gb > 20`, false},
+		{`(arch == "x86" OR arch == "x64") AND os_version < 11`, true},
+	}
+	for _, test := range tests {
+		actual, err := evaluateCondition(test.condition, testFacts)
+		if err != nil {
+			t.Errorf("%s: %v", test.condition, err)
+			continue
+		}
+		if actual != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.condition, test.expected, actual)
+		}
+	}
+
+	// Conditions that cant be evaluated should return an error
+	invalid := []string{
+		``,
+		`arch ==`,
+		`arch == "x64`,
+		`unknown_fact == "x64"`,
+		`hostname > "LAB"`,
+		`(arch == "x64"`,
+		`arch == "x64" "extra"`,
+		`registry("HKLM\SOFTWARE\Example") == "beta"`,
+	}
+	for _, condition := range invalid {
+		if _, err := evaluateCondition(condition, testFacts); err == nil {
+			t.Errorf("%s: expected an error", condition)
+		}
+	}
+}
+
+// TestApplyConditions verifies that only items with a true condition are added to the manifest
+func TestApplyConditions(t *testing.T) {
+	conditionFacts = func() map[string]string { return testFacts }
+	defer func() { conditionFacts = origConditionFacts }()
+
+	manifestItem := Item{
+		Name:     "conditional_manifest",
+		Installs: []string{"GoogleChrome"},
+		Conditions: []ConditionalItem{
+			{Condition: `arch == "x64"`, Installs: []string{"Chocolatey"}, Includes: []string{"x64_manifest"}},
+			{Condition: `arch == "arm64"`, Installs: []string{"ArmTools"}},
+			{Condition: `not a valid condition`, Uninstalls: []string{"AdobeFlash"}},
+			{Condition: `free_disk_gb < 50`, Uninstalls: []string{"LargeApp"}, Updates: []string{"CanonDrivers"}},
+		},
+	}
+
+	actual := applyConditions(manifestItem)
+	expected := manifestItem
+	expected.Includes = []string{"x64_manifest"}
+	expected.Installs = []string{"GoogleChrome", "Chocolatey"}
+	expected.Uninstalls = []string{"LargeApp"}
+	expected.Updates = []string{"CanonDrivers"}
+
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("\nExpected: %#v\nActual: %#v", expected, actual)
+	}
+}
+
 // fakeDownload returns a manifest encoded as yaml based on the url passed
 func fakeDownload(manifestURL string) ([]byte, error) {
 
@@ -118,6 +378,10 @@ func fakeDownload(manifestURL string) ([]byte, error) {
 	case "https://example.com/manifests/included_manifest.yaml":
 		fmt.Println("included!")
 		testManifest = includedManifest
+	case "https://example.com/manifests/cycle_manifest_a.yaml":
+		testManifest = cycleManifestA
+	case "https://example.com/manifests/cycle_manifest_b.yaml":
+		testManifest = cycleManifestB
 	default:
 		return nil, fmt.Errorf("Unexpected test url: %s", manifestURL)
 	}
@@ -130,3 +394,80 @@ func fakeDownload(manifestURL string) ([]byte, error) {
 
 	return yamlBytes, nil
 }
+
+// TestGetLocalManifests verifies that local manifests can include server manifests and catalogs,
+// and that the default local manifest is used when it exists
+func TestGetLocalManifests(t *testing.T) {
+	downloadGet = fakeDownload
+	origDefaultLocalManifest := defaultLocalManifest
+	defaultLocalManifest = "testdata/example_local_manifest.yaml"
+	defer func() {
+		downloadGet = origDownloadGet
+		defaultLocalManifest = origDefaultLocalManifest
+	}()
+
+	localCfg := config.Configuration{
+		URL:            "https://example.com/",
+		Manifest:       "included_manifest",
+		LocalManifests: []string{"testdata/include_local_manifest.yaml", "testdata/missing_manifest.yaml"},
+	}
+	includeLocalManifest := Item{
+		Name:     "include_local_manifest",
+		Includes: []string{"cycle_manifest_a"},
+		Installs: []string{"Firefox"},
+		Catalogs: []string{"testing"},
+	}
+
+	manifests, newCatalogs := Get(localCfg)
+	expectedManifests := []Item{includedManifest, cycleManifestA, cycleManifestB, includeLocalManifest, localManifest}
+	if !reflect.DeepEqual(manifests, expectedManifests) {
+		t.Errorf("\nExpected: %#v\nActual: %#v", expectedManifests, manifests)
+	}
+	if have, want := newCatalogs, []string{"production1", "alpha", "testing"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("\nExpected: %#v\nActual: %#v", want, have)
+	}
+
+	// The default local manifest isnt read twice when it is also configured
+	if have, want := localManifestPaths(cfg), []string{"testdata/example_local_manifest.yaml"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("\nExpected: %#v\nActual: %#v", want, have)
+	}
+}
+
+// TestItemList verifies that manifest entries can pin a version in either form
+func TestItemList(t *testing.T) {
+	yamlFile := []byte(`
+name: pinned
+managed_installs:
+  - GoogleChrome
+  - Firefox version=115.0.2
+  - name: Zoom
+    version: 5.15.2
+  - name: Slack
+`)
+	manifestItem := parseManifest("pinned", yamlFile)
+
+	expected := ItemList{"GoogleChrome", "Firefox version=115.0.2", "Zoom version=5.15.2", "Slack"}
+	if have, want := manifestItem.Installs, expected; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %#v, want %#v", have, want)
+	}
+
+	tests := map[string][2]string{
+		"GoogleChrome":             {"GoogleChrome", ""},
+		"Firefox version=115.0.2":  {"Firefox", "115.0.2"},
+		"Chef Client Version=17.1": {"Chef Client", "17.1"},
+		"Chef Client":              {"Chef Client", ""},
+		"version=1.0":              {"version=1.0", ""},
+	}
+	for entry, want := range tests {
+		name, version := SplitPin(entry)
+		if name != want[0] || version != want[1] {
+			t.Errorf("%s: have %s %s, want %s %s", entry, name, version, want[0], want[1])
+		}
+	}
+
+	// An entry without a name is an error
+	var invalid Item
+	if err := yaml.Unmarshal([]byte("managed_installs:\n  - version: 1.0\n"), &invalid); err == nil {
+		t.Errorf("Expected an error for an entry without a name")
+	}
+}
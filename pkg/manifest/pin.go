@@ -0,0 +1,71 @@
+package manifest
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ItemList is a list of items in a manifest
+// Each entry is either an item name, or a map with a `name` and the `version` to pin it to:
+//
+//	managed_installs:
+//	  - GoogleChrome
+//	  - Firefox version=115.0.2
+//	  - name: Zoom
+//	    version: 5.15.2
+//
+// Pinned entries are stored as "name version=x", the same as the short form.
+type ItemList []string
+
+// UnmarshalYAML accepts both forms of an entry
+func (l *ItemList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Tag == "!!null" {
+		return nil
+	}
+	if value.Kind != yaml.SequenceNode {
+		return fmt.Errorf("line %d: expected a list of items", value.Line)
+	}
+
+	items := ItemList{}
+	for _, entry := range value.Content {
+		switch entry.Kind {
+		case yaml.ScalarNode:
+			items = append(items, entry.Value)
+		case yaml.MappingNode:
+			var pinned struct {
+				Name    string `yaml:"name"`
+				Version string `yaml:"version"`
+			}
+			if err := entry.Decode(&pinned); err != nil {
+				return err
+			}
+			if pinned.Name == "" {
+				return fmt.Errorf("line %d: item has no name", entry.Line)
+			}
+			if pinned.Version == "" {
+				items = append(items, pinned.Name)
+			} else {
+				items = append(items, pinned.Name+" version="+pinned.Version)
+			}
+		default:
+			return fmt.Errorf("line %d: expected an item name or a name and version", entry.Line)
+		}
+	}
+	*l = items
+	return nil
+}
+
+// SplitPin returns the item name and pinned version of a manifest entry
+// The version is empty if the entry isnt pinned
+func SplitPin(entry string) (name, version string) {
+	fields := strings.Fields(entry)
+	if len(fields) > 1 {
+		last := fields[len(fields)-1]
+		if strings.HasPrefix(strings.ToLower(last), "version=") {
+			return strings.Join(fields[:len(fields)-1], " "), last[len("version="):]
+		}
+	}
+	return entry, ""
+}
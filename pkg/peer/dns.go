@@ -0,0 +1,158 @@
+package peer
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+// Only the small part of the DNS message format that peers use to find each other is implemented:
+// a single TXT question for a hash, answered with a TXT record holding the port the cache is served on
+// https://datatracker.ietf.org/doc/html/rfc6762
+
+const (
+	typeTXT         = 16
+	classIN         = 1
+	unicastResponse = 0x8000
+	flagResponse    = 0x8400
+
+	// serviceName is added to each question, so other mDNS responders ignore it
+	serviceName = "_gorilla._tcp.local"
+)
+
+var errInvalidMessage = errors.New("invalid mdns message")
+
+// hashName returns the name asked for to find a hash
+// A label is at most 63 bytes, so the hash is split in two
+func hashName(hash string) string {
+	hash = strings.ToLower(hash)
+	return hash[:len(hash)/2] + "." + hash[len(hash)/2:] + "." + serviceName
+}
+
+// nameHash returns the hash in a name, or an empty string if it isnt a question for a hash
+func nameHash(name string) string {
+	if !strings.HasSuffix(name, "."+serviceName) {
+		return ""
+	}
+	return strings.Replace(strings.TrimSuffix(name, "."+serviceName), ".", "", 1)
+}
+
+// appendUint16 appends a number in network byte order
+func appendUint16(msg []byte, n uint16) []byte {
+	return append(msg, byte(n>>8), byte(n))
+}
+
+// appendUint32 appends a number in network byte order
+func appendUint32(msg []byte, n uint32) []byte {
+	return append(msg, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+// appendName appends a name as a series of length prefixed labels
+func appendName(msg []byte, name string) []byte {
+	for _, label := range strings.Split(name, ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	return append(msg, 0)
+}
+
+// readName reads an uncompressed name at the offset, and returns it with the offset after it
+func readName(msg []byte, offset int) (string, int, error) {
+	var labels []string
+	for {
+		if offset >= len(msg) {
+			return "", 0, errInvalidMessage
+		}
+		length := int(msg[offset])
+		offset++
+		if length == 0 {
+			return strings.Join(labels, "."), offset, nil
+		}
+		// Compressed names are never sent by peers
+		if length > 63 || offset+length > len(msg) {
+			return "", 0, errInvalidMessage
+		}
+		labels = append(labels, string(msg[offset:offset+length]))
+		offset += length
+	}
+}
+
+// newQuery returns a question for the peers that have a hash, asking for a unicast response
+func newQuery(hash string) []byte {
+	msg := make([]byte, 12)
+	binary.BigEndian.PutUint16(msg[4:], 1)
+	msg = appendName(msg, hashName(hash))
+	msg = appendUint16(msg, typeTXT)
+	return appendUint16(msg, classIN|unicastResponse)
+}
+
+// parseQuery returns the hash a query is asking for, or an empty string if it isnt a question from a peer
+func parseQuery(msg []byte) (uint16, string) {
+	if len(msg) < 12 || binary.BigEndian.Uint16(msg[2:])&0x8000 != 0 || binary.BigEndian.Uint16(msg[4:]) != 1 {
+		return 0, ""
+	}
+	name, offset, err := readName(msg, 12)
+	if err != nil || offset+4 > len(msg) || binary.BigEndian.Uint16(msg[offset:]) != typeTXT {
+		return 0, ""
+	}
+	return binary.BigEndian.Uint16(msg), nameHash(name)
+}
+
+// newAnswer returns the answer to a query for a hash, with the port the cache is served on
+// The question is repeated, since the answer is sent straight back to the peer that asked
+func newAnswer(id uint16, hash string, port string) []byte {
+	msg := make([]byte, 12)
+	binary.BigEndian.PutUint16(msg, id)
+	binary.BigEndian.PutUint16(msg[2:], flagResponse)
+	binary.BigEndian.PutUint16(msg[4:], 1)
+	binary.BigEndian.PutUint16(msg[6:], 1)
+	msg = appendName(msg, hashName(hash))
+	msg = appendUint16(msg, typeTXT)
+	msg = appendUint16(msg, classIN)
+
+	txt := "port=" + port
+	msg = appendName(msg, hashName(hash))
+	msg = appendUint16(msg, typeTXT)
+	msg = appendUint16(msg, classIN)
+	msg = appendUint32(msg, 10)
+	msg = appendUint16(msg, uint16(len(txt)+1))
+	msg = append(msg, byte(len(txt)))
+	return append(msg, txt...)
+}
+
+// parseAnswer returns the hash and port from an answer, or empty strings if it isnt an answer from a peer
+func parseAnswer(msg []byte) (string, string) {
+	if len(msg) < 12 || binary.BigEndian.Uint16(msg[2:])&0x8000 == 0 {
+		return "", ""
+	}
+	questions, answers := int(binary.BigEndian.Uint16(msg[4:])), int(binary.BigEndian.Uint16(msg[6:]))
+	offset := 12
+	for i := 0; i < questions; i++ {
+		_, next, err := readName(msg, offset)
+		if err != nil || next+4 > len(msg) {
+			return "", ""
+		}
+		offset = next + 4
+	}
+	for i := 0; i < answers; i++ {
+		name, next, err := readName(msg, offset)
+		if err != nil || next+10 > len(msg) {
+			return "", ""
+		}
+		recordType := binary.BigEndian.Uint16(msg[next:])
+		length := int(binary.BigEndian.Uint16(msg[next+8:]))
+		data := next + 10
+		if data+length > len(msg) {
+			return "", ""
+		}
+		offset = data + length
+		if recordType != typeTXT || nameHash(name) == "" || length < 1 || int(msg[data]) >= length {
+			continue
+		}
+		txt := msg[data+1 : data+1+int(msg[data])]
+		if port := strings.TrimPrefix(string(txt), "port="); port != string(txt) {
+			return nameHash(name), port
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,254 @@
+// Package peer shares verified packages between clients on the same network,
+// so a branch site with many identical machines only downloads each package from the repo once.
+// Each client serves its cache by hash, and finds the peers that have a hash by asking over mDNS.
+// Anything fetched from a peer is verified against the catalog hash before it is used.
+package peer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/1dustindavis/gorilla/pkg/gorillalog"
+)
+
+// These abstractions allows us to override when testing
+var (
+	// mdnsAddr is where questions are sent
+	mdnsAddr = "224.0.0.251:5353"
+
+	// listenMDNS joins the mDNS group, to hear questions from other peers
+	listenMDNS = func() (*net.UDPConn, error) {
+		addr, err := net.ResolveUDPAddr("udp4", mdnsAddr)
+		if err != nil {
+			return nil, err
+		}
+		return net.ListenMulticastUDP("udp4", nil, addr)
+	}
+)
+
+// cachedFile is a file in the cache, along with what it looked like when it was hashed
+type cachedFile struct {
+	size    int64
+	modTime time.Time
+	hash    string
+}
+
+// Server serves the packages in a cache to other peers, and answers when they ask for one we have
+type Server struct {
+	cachePath string
+	port      string
+	http      *http.Server
+	mdns      *net.UDPConn
+
+	// Files are only hashed again when they change
+	// Questions and downloads are handled in parallel, so it is only read or changed while holding mutex
+	files map[string]cachedFile
+	mutex sync.Mutex
+}
+
+// Start serves the cache on a port, and answers questions for the packages in it until the server is closed
+func Start(cachePath string, port int) (*Server, error) {
+	listener, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		return nil, fmt.Errorf("unable to serve the peer cache: %v", err)
+	}
+	mdns, err := listenMDNS()
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("unable to listen for peers: %v", err)
+	}
+
+	s := &Server{
+		cachePath: cachePath,
+		port:      strconv.Itoa(listener.Addr().(*net.TCPAddr).Port),
+		mdns:      mdns,
+		files:     make(map[string]cachedFile),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sha256/", s.serveHash)
+	s.http = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go s.http.Serve(listener)
+	go s.answer()
+	gorillalog.Info("Serving the peer cache on port", s.port)
+	return s, nil
+}
+
+// Close stops serving the cache and answering questions
+func (s *Server) Close() error {
+	s.mdns.Close()
+	return s.http.Close()
+}
+
+// answer replies to each question for a hash that is in the cache
+// Answers are sent straight back to the peer that asked, so the rest of the network doesnt hear them
+func (s *Server) answer() {
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := s.mdns.ReadFromUDP(buf)
+		if err != nil {
+			// The connection is only closed when the server is
+			return
+		}
+		id, hash := parseQuery(buf[:n])
+		if hash == "" || s.lookup(hash) == "" {
+			continue
+		}
+		gorillalog.Debug("Answering peer", from.IP, "for", hash)
+		s.mdns.WriteToUDP(newAnswer(id, hash, s.port), from)
+	}
+}
+
+// serveHash serves the file in the cache with the requested hash
+// Ranges are supported, so a peer can resume an interrupted download
+func (s *Server) serveHash(w http.ResponseWriter, r *http.Request) {
+	hash := strings.TrimPrefix(r.URL.Path, "/sha256/")
+	filePath := s.lookup(hash)
+	if filePath == "" {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	gorillalog.Debug("Serving", filePath, "to peer", r.RemoteAddr)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+// lookup returns the path of a file in the cache with a sha256 hash, or an empty string if there isnt one
+// The cache is only scanned again when the hash isnt found, since a package may have been downloaded since
+func (s *Server) lookup(hash string) string {
+	hash = strings.ToLower(hash)
+	if len(hash) != sha256.Size*2 {
+		return ""
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if filePath := s.find(hash); filePath != "" {
+		return filePath
+	}
+	s.scan()
+	return s.find(hash)
+}
+
+// find returns the path of a file we already hashed, as long as it hasnt changed
+func (s *Server) find(hash string) string {
+	for filePath, file := range s.files {
+		if file.hash != hash {
+			continue
+		}
+		info, err := os.Stat(filePath)
+		if err == nil && info.Size() == file.size && info.ModTime().Equal(file.modTime) {
+			return filePath
+		}
+	}
+	return ""
+}
+
+// scan hashes every new or changed file in the cache, and forgets the ones that are gone
+// Partial downloads are skipped, since they arent complete yet
+func (s *Server) scan() {
+	seen := make(map[string]bool)
+	filepath.Walk(s.cachePath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || strings.HasSuffix(filePath, ".partial") {
+			return nil
+		}
+		seen[filePath] = true
+		if file, ok := s.files[filePath]; ok && file.size == info.Size() && file.modTime.Equal(info.ModTime()) {
+			return nil
+		}
+		hash, err := hashFile(filePath)
+		if err != nil {
+			gorillalog.Warn("Unable to hash cached file:", filePath, err)
+			return nil
+		}
+		s.files[filePath] = cachedFile{size: info.Size(), modTime: info.ModTime(), hash: hash}
+		return nil
+	})
+	for filePath := range s.files {
+		if !seen[filePath] {
+			delete(s.files, filePath)
+		}
+	}
+}
+
+// hashFile returns the sha256 hash of a file
+func hashFile(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Find asks the network for peers that have a sha256 hash, and returns a `peer://` url for each one that answered
+// We wait up to the timeout for the first answer, and only briefly after that for any others
+func Find(hash string, timeout time.Duration) []string {
+	hash = strings.ToLower(hash)
+	if len(hash) != sha256.Size*2 {
+		return nil
+	}
+	addr, err := net.ResolveUDPAddr("udp4", mdnsAddr)
+	if err != nil {
+		gorillalog.Warn("Unable to ask for peers:", err)
+		return nil
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		gorillalog.Warn("Unable to ask for peers:", err)
+		return nil
+	}
+	defer conn.Close()
+	if _, err := conn.WriteToUDP(newQuery(hash), addr); err != nil {
+		gorillalog.Debug("Unable to ask for peers:", err)
+		return nil
+	}
+
+	var peers []string
+	seen := make(map[string]bool)
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return peers
+		}
+		answerHash, port := parseAnswer(buf[:n])
+		if answerHash != hash {
+			continue
+		}
+		peerURL := "peer://" + net.JoinHostPort(from.IP.String(), port) + "/sha256/" + hash
+		if seen[peerURL] {
+			continue
+		}
+		seen[peerURL] = true
+		peers = append(peers, peerURL)
+		if len(peers) == 1 {
+			conn.SetReadDeadline(time.Now().Add(250 * time.Millisecond))
+		}
+	}
+}
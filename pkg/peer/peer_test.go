@@ -0,0 +1,118 @@
+package peer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestMessages verifies a question and its answer survive a round trip
+func TestMessages(t *testing.T) {
+	hash := "E3B0C44298FC1C149AFBF4C8996FB92427AE41E4649B934CA495991B7852B855"
+
+	id, asked := parseQuery(newQuery(hash))
+	if have, want := asked, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+
+	answered, port := parseAnswer(newAnswer(id, asked, "8089"))
+	if have, want := answered, asked; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+	if have, want := port, "8089"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+
+	// An answer is not a question, and a question is not an answer
+	if _, hash := parseQuery(newAnswer(id, asked, "8089")); hash != "" {
+		t.Errorf("Answer parsed as a question: %s", hash)
+	}
+	if hash, _ := parseAnswer(newQuery(asked)); hash != "" {
+		t.Errorf("Question parsed as an answer: %s", hash)
+	}
+
+	// Truncated messages are ignored
+	answer := newAnswer(id, asked, "8089")
+	for i := range answer {
+		parseQuery(answer[:i])
+		parseAnswer(answer[:i])
+	}
+}
+
+// TestServer verifies a peer finds and downloads a file from another peer's cache
+func TestServer(t *testing.T) {
+	origListen, origAddr := listenMDNS, mdnsAddr
+	defer func() {
+		listenMDNS, mdnsAddr = origListen, origAddr
+	}()
+
+	// Questions are sent straight to the server over loopback, instead of the multicast group
+	listenMDNS = func() (*net.UDPConn, error) {
+		return net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	}
+
+	cachePath, err := ioutil.TempDir("", "gorilla_peer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cachePath)
+	content := []byte("installer content")
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	os.MkdirAll(filepath.Join(cachePath, "packages"), 0755)
+	ioutil.WriteFile(filepath.Join(cachePath, "packages", "setup.msi"), content, 0644)
+
+	// A partial download is never served
+	partial := []byte("installer")
+	partialSum := sha256.Sum256(partial)
+	ioutil.WriteFile(filepath.Join(cachePath, "packages", "other.msi.partial"), partial, 0644)
+
+	server, err := Start(cachePath, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	mdnsAddr = server.mdns.LocalAddr().String()
+
+	peers := Find(hash, time.Second)
+	if len(peers) != 1 {
+		t.Fatalf("Expected one peer: %v", peers)
+	}
+	if have, want := peers[0], "peer://127.0.0.1:"+server.port+"/sha256/"+hash; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+	if peers := Find(hex.EncodeToString(partialSum[:]), 100*time.Millisecond); len(peers) != 0 {
+		t.Errorf("Expected no peers for a partial file: %v", peers)
+	}
+
+	resp, err := http.Get("http://127.0.0.1:" + server.port + "/sha256/" + hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := string(body), string(content); have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+
+	// A file that changed is hashed again, so its old hash is no longer served
+	ioutil.WriteFile(filepath.Join(cachePath, "packages", "setup.msi"), []byte("new installer content"), 0644)
+	os.Chtimes(filepath.Join(cachePath, "packages", "setup.msi"), time.Now(), time.Now().Add(time.Minute))
+	resp, err = http.Get("http://127.0.0.1:" + server.port + "/sha256/" + hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if have, want := resp.StatusCode, http.StatusNotFound; have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+}
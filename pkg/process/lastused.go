@@ -0,0 +1,18 @@
+//go:build windows
+// +build windows
+
+package process
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// lastUsed returns when a cached file was last accessed
+func lastUsed(info os.FileInfo) time.Time {
+	if data, ok := info.Sys().(*syscall.Win32FileAttributeData); ok {
+		return time.Unix(0, data.LastAccessTime.Nanoseconds())
+	}
+	return info.ModTime()
+}
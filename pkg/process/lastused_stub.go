@@ -0,0 +1,16 @@
+// Without a darwin specific build, go tools will try to include Windows libraries and fail
+
+//go:build !windows
+// +build !windows
+
+package process
+
+import (
+	"os"
+	"time"
+)
+
+// lastUsed returns when a cached file was last modified, since access times vary by platform
+func lastUsed(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
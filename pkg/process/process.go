@@ -3,19 +3,29 @@ package process
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/1dustindavis/gorilla/pkg/catalog"
+	"github.com/1dustindavis/gorilla/pkg/download"
 	"github.com/1dustindavis/gorilla/pkg/gorillalog"
 	"github.com/1dustindavis/gorilla/pkg/installer"
+	"github.com/1dustindavis/gorilla/pkg/ipc"
 	"github.com/1dustindavis/gorilla/pkg/manifest"
+	"github.com/1dustindavis/gorilla/pkg/state"
+	"github.com/1dustindavis/gorilla/pkg/status"
 )
 
 // firstItem returns the first occurrence of an item in a map of catalogs
+// An item pinned to a version, such as "Firefox version=115.0.2", only matches that version
 func firstItem(itemName string, catalogsMap map[int]map[string]catalog.Item) (catalog.Item, error) {
+	name, pinnedVersion := manifest.SplitPin(itemName)
+
 	// Get the keys in the map and sort them so we can loop over them in order
 	keys := make([]int, 0)
 	for k := range catalogsMap {
@@ -26,10 +36,20 @@ func firstItem(itemName string, catalogsMap map[int]map[string]catalog.Item) (ca
 	// loop through each catalog and return if we find a match
 	for _, k := range keys {
 		// If
-		if item, exists := catalogsMap[k][itemName]; exists {
+		if item, exists := catalogsMap[k][name]; exists {
+			// A pinned item only matches the version it is pinned to
+			if pinnedVersion != "" && item.Version != pinnedVersion {
+				continue
+			}
 			// If it does exist, we should confirm it is a valid item
 			validInstallItem := (item.Installer.Type != "" && item.Installer.Location != "")
-			validUninstallItem := (item.Uninstaller.Type != "" && item.Uninstaller.Location != "")
+			// An msi can also be uninstalled by its product code, and an appx or msix by its package name,
+			// without an uninstaller to download
+			validUninstallItem := (item.Uninstaller.Type != "" && item.Uninstaller.Location != "") ||
+				(item.Uninstaller.Type == "msi" && (item.Uninstaller.ProductCode != "" || item.Installer.ProductCode != "")) ||
+				((item.Uninstaller.Type == "appx" || item.Uninstaller.Type == "msix") && (item.Uninstaller.PackageName != "" || item.Installer.PackageName != "")) ||
+				(item.Uninstaller.Type == "command" && item.Uninstaller.Command != "") ||
+				(item.UninstallMethod == "script" && item.UninstallScript != "")
 
 			if validInstallItem || validUninstallItem {
 				return item, nil
@@ -38,6 +58,9 @@ func firstItem(itemName string, catalogsMap map[int]map[string]catalog.Item) (ca
 	}
 
 	// return an empty catalog item if we didnt already find and return a match
+	if pinnedVersion != "" {
+		return catalog.Item{}, fmt.Errorf("did not find a valid item in any catalog; Item name: %v; Version: %v", name, pinnedVersion)
+	}
 	return catalog.Item{}, fmt.Errorf("did not find a valid item in any catalog; Item name: %v", itemName)
 
 }
@@ -48,6 +71,10 @@ func Manifests(manifests []manifest.Item, catalogsMap map[int]map[string]catalog
 	for _, manifestItem := range manifests {
 		// Installs
 		for _, item := range manifestItem.Installs {
+			// Skip items that more than one manifest includes
+			if contains(installs, item) {
+				continue
+			}
 			// Check for the first valid item from our catalogs
 			// Continue to the next item in the loop if we get an error
 			_, err := firstItem(item, catalogsMap)
@@ -61,6 +88,10 @@ func Manifests(manifests []manifest.Item, catalogsMap map[int]map[string]catalog
 		}
 		// Uninstalls
 		for _, item := range manifestItem.Uninstalls {
+			// Skip items that more than one manifest includes
+			if contains(uninstalls, item) {
+				continue
+			}
 			// Check for the first valid item from our catalogs
 			// Continue to the next item in the loop if we get an error
 			_, err := firstItem(item, catalogsMap)
@@ -74,6 +105,10 @@ func Manifests(manifests []manifest.Item, catalogsMap map[int]map[string]catalog
 		}
 		// Updates
 		for _, item := range manifestItem.Updates {
+			// Skip items that more than one manifest includes
+			if contains(updates, item) {
+				continue
+			}
 			// Check for the first valid item from our catalogs
 			// Continue to the next item in the loop if we get an error
 			_, err := firstItem(item, catalogsMap)
@@ -86,16 +121,273 @@ func Manifests(manifests []manifest.Item, catalogsMap map[int]map[string]catalog
 			updates = append(updates, item)
 		}
 	}
+
+	// A manifest that pins a version takes precedence over one that doesnt
+	installs = preferPinned(installs)
+	updates = preferPinned(updates)
+
+	// Add any items that declare they are an update for something we manage
+	var managed []string
+	managed = append(managed, installs...)
+	managed = append(managed, updates...)
+	updates = append(updates, updatesFor(managed, updates, catalogsMap)...)
+
 	return
 }
 
-// This abstraction allows us to override when testing
-var installerInstall = installer.Install
+// Optional returns the optional installs from every manifest, which are only installed when someone asks for them
+func Optional(manifests []manifest.Item, catalogsMap map[int]map[string]catalog.Item) (optional []string) {
+	for _, manifestItem := range manifests {
+		for _, item := range manifestItem.Optional {
+			// Skip items that more than one manifest includes
+			if contains(optional, item) {
+				continue
+			}
+			if _, err := firstItem(item, catalogsMap); err != nil {
+				gorillalog.Warn(err)
+				continue
+			}
+			optional = append(optional, item)
+		}
+	}
+	return optional
+}
+
+// Requested returns the optional installs someone has asked for, so they are installed and updated like any other install
+// Items that are already in the installs list are skipped
+func Requested(optional, installs []string, catalogsMap map[int]map[string]catalog.Item) (requested []string) {
+	for _, item := range optional {
+		if contains(installs, item) {
+			continue
+		}
+		validItem, err := firstItem(item, catalogsMap)
+		if err != nil {
+			continue
+		}
+		if state.Get(validItem.DisplayName).Requested {
+			requested = append(requested, item)
+		}
+	}
+	return requested
+}
+
+// SetRequested records whether someone asked for each of the items that is an optional install
+// An optional install that was removed is no longer requested, so it isnt installed again on the next run
+func SetRequested(items, optional []string, catalogsMap map[int]map[string]catalog.Item, requested bool) {
+	for _, item := range items {
+		if !contains(optional, item) {
+			continue
+		}
+		validItem, err := firstItem(item, catalogsMap)
+		if err != nil {
+			continue
+		}
+		gorillalog.Debug("Optional install", item, "requested:", requested)
+		state.SetRequested(validItem.DisplayName, requested)
+	}
+}
+
+// preferPinned removes any item that is also in the list pinned to a version
+func preferPinned(items []string) (preferred []string) {
+	pinned := make(map[string]bool)
+	for _, item := range items {
+		if name, version := manifest.SplitPin(item); version != "" {
+			pinned[name] = true
+		}
+	}
+	for _, item := range items {
+		if name, version := manifest.SplitPin(item); version == "" && pinned[name] {
+			gorillalog.Debug("Skipping", item, "because a manifest pins its version")
+			continue
+		}
+		preferred = append(preferred, item)
+	}
+	return preferred
+}
+
+// updatesFor returns the names of catalog items that are an update for any of the managed items
+// Items that are already in the existing list are skipped
+func updatesFor(managed, existing []string, catalogsMap map[int]map[string]catalog.Item) (updates []string) {
+	// Get the keys in the map and sort them so we can loop over them in order
+	keys := make([]int, 0)
+	for k := range catalogsMap {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+
+	// Updates refer to items by name, even if the manifest pins a version
+	var managedNames []string
+	for _, item := range managed {
+		name, _ := manifest.SplitPin(item)
+		managedNames = append(managedNames, name)
+	}
+
+	for _, k := range keys {
+		// Sort the item names so the updates are always returned in the same order
+		names := make([]string, 0)
+		for name := range catalogsMap[k] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if contains(existing, name) || contains(updates, name) {
+				continue
+			}
+			for _, base := range catalogsMap[k][name].UpdateFor {
+				if contains(managedNames, base) {
+					updates = append(updates, name)
+					break
+				}
+			}
+		}
+	}
+	return updates
+}
+
+// contains returns true if the slice includes the string
+func contains(s []string, e string) bool {
+	for _, a := range s {
+		if a == e {
+			return true
+		}
+	}
+	return false
+}
+
+// baseInstalled returns true if at least one item an update is for is currently installed
+func baseInstalled(item catalog.Item, catalogsMap map[int]map[string]catalog.Item, cachePath string) bool {
+	for _, base := range item.UpdateFor {
+		validBase, err := firstItem(base, catalogsMap)
+		if err != nil {
+			gorillalog.Warn(err)
+			continue
+		}
+
+		// An uninstall is only needed when the item is installed
+		installed, err := statusCheckStatus(validBase, "uninstall", cachePath)
+		if err != nil {
+			gorillalog.Warn("Unable to check status:", base, err)
+			continue
+		}
+		if installed {
+			return true
+		}
+	}
+	return false
+}
+
+// These abstractions allows us to override when testing
+var (
+	installerInstall   = installer.Install
+	installerDownload  = installer.Download
+	statusCheckStatus  = status.CheckStatus
+	statusIncompatible = status.Incompatible
+)
+
+// FilterCategories returns only the items that belong to one of the provided categories
+// Categories are not case sensitive
+func FilterCategories(items []string, categories []string, catalogsMap map[int]map[string]catalog.Item) (filtered []string) {
+	for _, item := range items {
+		validItem, err := firstItem(item, catalogsMap)
+		if err != nil {
+			continue
+		}
+		for _, category := range categories {
+			if strings.EqualFold(validItem.Category, category) {
+				filtered = append(filtered, item)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// sortByPriority returns the items ordered by priority, highest first
+// Items with the same priority keep their original order, and dependencies are
+// still installed before the first item that needs them
+func sortByPriority(items []string, catalogsMap map[int]map[string]catalog.Item) []string {
+	priorities := make(map[string]int)
+	for _, item := range items {
+		validItem, err := firstItem(item, catalogsMap)
+		if err == nil {
+			priorities[item] = validItem.Priority
+		}
+	}
+
+	sorted := append([]string{}, items...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return priorities[sorted[i]] > priorities[sorted[j]]
+	})
+	gorillalog.Debug("Install order:", sorted)
+	return sorted
+}
+
+// resolveDependencies returns the items along with all of their dependencies, including
+// dependencies of dependencies, ordered so every item comes after everything it depends on.
+// Each item is only returned once, no matter how many items depend on it.
+// An item that is part of a dependency cycle, or depends on one, is logged and left out.
+func resolveDependencies(items []string, catalogsMap map[int]map[string]catalog.Item) (resolved []string) {
+	// Track which items are resolved, and which are on the current path so we can spot a cycle
+	done := make(map[string]bool)
+	failed := make(map[string]bool)
+	var path []string
+
+	var visit func(item string) bool
+	visit = func(item string) bool {
+		if done[item] {
+			return true
+		}
+		if failed[item] {
+			return false
+		}
+		for i, onPath := range path {
+			if onPath == item {
+				cycle := append(append([]string{}, path[i:]...), item)
+				gorillalog.Warn("Dependency cycle found:", strings.Join(cycle, " -> "))
+				return false
+			}
+		}
+
+		validItem, err := firstItem(item, catalogsMap)
+		if err != nil {
+			gorillalog.Warn(err)
+			failed[item] = true
+			return false
+		}
+
+		// Resolve each dependency before the item that needs it
+		path = append(path, item)
+		ok := true
+		for _, dependency := range validItem.Dependencies {
+			if !visit(dependency) {
+				ok = false
+				break
+			}
+		}
+		path = path[:len(path)-1]
+
+		if !ok {
+			gorillalog.Warn("Skipping", item, "because its dependencies could not be resolved")
+			failed[item] = true
+			return false
+		}
+		done[item] = true
+		resolved = append(resolved, item)
+		return true
+	}
+
+	for _, item := range items {
+		visit(item)
+	}
+	return resolved
+}
 
 // Installs prepares and then installs an array of items
 func Installs(installs []string, catalogsMap map[int]map[string]catalog.Item, urlPackages, cachePath string, CheckOnly bool) {
-	// Iterate through the installs array, install dependencies, and then the item itself
-	for _, item := range installs {
+	// Skip updates for items that are not installed, before we bring in their dependencies
+	var wanted []string
+	for _, item := range sortByPriority(installs, catalogsMap) {
 		// Get the first valid item from our catalogs
 		// Continue to the next item in the loop if we get an error
 		validItem, err := firstItem(item, catalogsMap)
@@ -103,24 +395,137 @@ func Installs(installs []string, catalogsMap map[int]map[string]catalog.Item, ur
 			gorillalog.Warn(err)
 			continue
 		}
-		// Check for dependencies and install if found
-		if len(validItem.Dependencies) > 0 {
-			for _, dependency := range validItem.Dependencies {
-				validDependency, err := firstItem(dependency, catalogsMap)
-				if err != nil {
-					gorillalog.Warn(err)
-					continue
-				}
-				installerInstall(validDependency, "install", urlPackages, cachePath, CheckOnly)
+		if reason := statusIncompatible(validItem); reason != "" {
+			// An item that doesnt fit this computer isnt a problem with the run
+			gorillalog.Info("Skipping", item, "because", reason)
+			continue
+		}
+		if len(validItem.UpdateFor) > 0 && !baseInstalled(validItem, catalogsMap, cachePath) {
+			gorillalog.Info("Skipping", item, "because it is an update for an item that is not installed:", validItem.UpdateFor)
+			continue
+		}
+		wanted = append(wanted, item)
+	}
+
+	// Iterate through the items and their dependencies, with each dependency before the items that need it
+	var validNames []string
+	var validItems []catalog.Item
+	for _, item := range resolveDependencies(wanted, catalogsMap) {
+		validItem, err := firstItem(item, catalogsMap)
+		if err != nil {
+			gorillalog.Warn(err)
+			continue
+		}
+		// A dependency can be incompatible too
+		if reason := statusIncompatible(validItem); reason != "" {
+			gorillalog.Info("Skipping", item, "because", reason)
+			continue
+		}
+		validNames = append(validNames, item)
+		validItems = append(validItems, validItem)
+	}
+
+	// Download everything up front, so the downloads can run in parallel
+	if !CheckOnly {
+		installerDownload(validItems, "install", urlPackages, cachePath)
+	}
+
+	// Install the items
+	for i, validItem := range validItems {
+		result := installerInstall(validItem, "install", urlPackages, cachePath, CheckOnly)
+
+		// Remember which items a dependency was installed for, so it can be removed along with them
+		if CheckOnly || contains(wanted, validNames[i]) {
+			continue
+		}
+		if result == "" || len(state.Get(validItem.DisplayName).DependencyOf) > 0 {
+			for _, parent := range dependents(validNames[i], validNames, catalogsMap) {
+				state.AddDependencyOf(validItem.DisplayName, parent)
+			}
+		}
+	}
+}
+
+// dependents returns the items in the list that depend directly on the provided item
+func dependents(itemName string, items []string, catalogsMap map[int]map[string]catalog.Item) (parents []string) {
+	for _, item := range items {
+		validItem, err := firstItem(item, catalogsMap)
+		if err == nil && contains(validItem.Dependencies, itemName) {
+			name, _ := manifest.SplitPin(item)
+			parents = append(parents, name)
+		}
+	}
+	return parents
+}
+
+// UnusedDependencies returns the items that were only installed as dependencies of items that have since been uninstalled.
+// An item only counts as gone once its uninstall succeeded, so this should run after `Uninstalls`.
+// Anything the manifests still install or update, or that is already being uninstalled, is kept.
+func UnusedDependencies(uninstalls, installs, updates []string, catalogsMap map[int]map[string]catalog.Item) (unused []string) {
+	// Manifests can pin a version, but dependencies are tracked by name
+	var keep []string
+	for _, item := range append(append(append([]string{}, installs...), updates...), uninstalls...) {
+		name, _ := manifest.SplitPin(item)
+		keep = append(keep, name)
+	}
+
+	// Get every item name in the catalogs, sorted so the results are consistent
+	var names []string
+	for _, catalogItems := range catalogsMap {
+		for name := range catalogItems {
+			if !contains(names, name) {
+				names = append(names, name)
 			}
 		}
-		// Install the item
-		installerInstall(validItem, "install", urlPackages, cachePath, CheckOnly)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if contains(keep, name) {
+			continue
+		}
+		validItem, err := firstItem(name, catalogsMap)
+		if err != nil {
+			continue
+		}
+		history := state.Get(validItem.DisplayName)
+		if len(history.DependencyOf) == 0 {
+			continue
+		}
+
+		// Every item it was installed for must be gone, an uninstall that failed or was skipped leaves it needed
+		needed := false
+		for _, parent := range history.DependencyOf {
+			parentItem, err := firstItem(parent, catalogsMap)
+			if err != nil || !state.Get(parentItem.DisplayName).Removed() {
+				needed = true
+				break
+			}
+		}
+		if needed {
+			continue
+		}
+
+		gorillalog.Info("Uninstalling", name, "because the items it was installed for are uninstalled:", history.DependencyOf)
+		unused = append(unused, name)
+	}
+	return unused
+}
+
+// UninstallUnused uninstalls the dependencies that are no longer needed once `uninstalls` are gone
+// Removing a dependency can leave its own dependencies unused, so it keeps going until nothing else is found.
+// Dependencies are never forced out, so one that is still needed is left alone.
+func UninstallUnused(uninstalls, installs, updates []string, catalogsMap map[int]map[string]catalog.Item, urlPackages, cachePath string, CheckOnly bool) {
+	tried := append([]string{}, uninstalls...)
+	for unused := UnusedDependencies(tried, installs, updates, catalogsMap); len(unused) > 0; unused = UnusedDependencies(tried, installs, updates, catalogsMap) {
+		Uninstalls(unused, catalogsMap, urlPackages, cachePath, CheckOnly, false)
+		tried = append(tried, unused...)
 	}
 }
 
 // Uninstalls prepares and then installs an array of items
-func Uninstalls(uninstalls []string, catalogsMap map[int]map[string]catalog.Item, urlPackages, cachePath string, CheckOnly bool) {
+// Items that another installed item depends on are skipped, unless Force is true
+func Uninstalls(uninstalls []string, catalogsMap map[int]map[string]catalog.Item, urlPackages, cachePath string, CheckOnly, Force bool) {
 	// Iterate through the uninstalls array and uninstall the item
 	for _, item := range uninstalls {
 		// Get the first valid item from our catalogs
@@ -130,15 +535,61 @@ func Uninstalls(uninstalls []string, catalogsMap map[int]map[string]catalog.Item
 			gorillalog.Warn(err)
 			continue
 		}
+		// Dont break an installed item by removing something it depends on
+		if dependents := neededBy(item, uninstalls, catalogsMap, cachePath); len(dependents) > 0 {
+			if !Force {
+				gorillalog.Warn("Not uninstalling", item, "because it is still needed by:", dependents)
+				continue
+			}
+			gorillalog.Warn("Forcing uninstall of", item, "which is still needed by:", dependents)
+		}
 		// Uninstall the item
 		installerInstall(validItem, "uninstall", urlPackages, cachePath, CheckOnly)
 	}
 }
 
+// neededBy returns the installed items that depend on the provided item
+// Items that are also being uninstalled are ignored
+func neededBy(itemName string, uninstalls []string, catalogsMap map[int]map[string]catalog.Item, cachePath string) (dependents []string) {
+	// Get every item name in the catalogs, sorted so the results are consistent
+	var names []string
+	for _, catalogItems := range catalogsMap {
+		for name := range catalogItems {
+			if !contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	itemName, _ = manifest.SplitPin(itemName)
+	for _, name := range names {
+		if name == itemName || contains(uninstalls, name) {
+			continue
+		}
+		validItem, err := firstItem(name, catalogsMap)
+		if err != nil || !contains(validItem.Dependencies, itemName) {
+			continue
+		}
+
+		// An uninstall is only needed when the item is installed
+		installed, err := statusCheckStatus(validItem, "uninstall", cachePath)
+		if err != nil {
+			gorillalog.Warn("Unable to check status:", name, err)
+			continue
+		}
+		if installed {
+			dependents = append(dependents, name)
+		}
+	}
+	return dependents
+}
+
 // Updates prepares and then installs an array of items
 func Updates(updates []string, catalogsMap map[int]map[string]catalog.Item, urlPackages, cachePath string, CheckOnly bool) {
 	// Iterate through the updates array and update the item **if it is already installed**
-	for _, item := range updates {
+	var validItems []catalog.Item
+	for _, item := range sortByPriority(updates, catalogsMap) {
 		// Get the first valid item from our catalogs
 		// Continue to the next item in the loop if we get an error
 		validItem, err := firstItem(item, catalogsMap)
@@ -146,11 +597,170 @@ func Updates(updates []string, catalogsMap map[int]map[string]catalog.Item, urlP
 			gorillalog.Warn(err)
 			continue
 		}
-		// Update the item
+		if reason := statusIncompatible(validItem); reason != "" {
+			gorillalog.Info("Skipping", item, "because", reason)
+			continue
+		}
+		// Skip updates for items that are not installed
+		if len(validItem.UpdateFor) > 0 && !baseInstalled(validItem, catalogsMap, cachePath) {
+			gorillalog.Info("Skipping", item, "because it is an update for an item that is not installed:", validItem.UpdateFor)
+			continue
+		}
+		validItems = append(validItems, validItem)
+	}
+
+	// Download everything up front, so the downloads can run in parallel
+	if !CheckOnly {
+		installerDownload(validItems, "update", urlPackages, cachePath)
+	}
+
+	// Update the items
+	for _, validItem := range validItems {
 		installerInstall(validItem, "update", urlPackages, cachePath, CheckOnly)
 	}
 }
 
+// This abstraction allows us to override when testing
+var statusQuery = status.Query
+
+// managedList is a list of items and how the manifests manage them
+type managedList struct {
+	managedAs string
+	items     []string
+}
+
+// Status prints the current state of every managed item without making any changes
+// Optional installs nobody has asked for are listed last, since they are never processed
+func Status(installs, uninstalls, updates, optional []string, catalogsMap map[int]map[string]catalog.Item, cachePath string) {
+	var available []string
+	for _, item := range optional {
+		if !contains(installs, item) {
+			available = append(available, item)
+		}
+	}
+
+	// Print each list in the same order we would process them
+	printStatus([]managedList{
+		{"install", installs},
+		{"uninstall", uninstalls},
+		{"update", updates},
+		{"optional", available},
+	}, catalogsMap, cachePath)
+}
+
+// ItemStatus prints the current state of the requested items, along with how the manifests manage each one
+// Items that arent in any manifest can still be checked, and are shown as not managed
+func ItemStatus(items, installs, uninstalls, updates, optional []string, catalogsMap map[int]map[string]catalog.Item, cachePath string) {
+	var managedLists []managedList
+	for _, item := range items {
+		managedAs := "-"
+		switch {
+		case contains(installs, item):
+			managedAs = "install"
+		case contains(uninstalls, item):
+			managedAs = "uninstall"
+		case contains(updates, item):
+			managedAs = "update"
+		case contains(optional, item):
+			managedAs = "optional"
+		}
+		managedLists = append(managedLists, managedList{managedAs, []string{item}})
+	}
+	printStatus(managedLists, catalogsMap, cachePath)
+}
+
+// List returns the optional installs, and any managed item the next run will change, for the self-service app
+// Items whose status cant be checked are left out
+func List(installs, uninstalls, updates, optional []string, catalogsMap map[int]map[string]catalog.Item, cachePath string) (items []ipc.Item) {
+	var names []string
+	for _, item := range append(append(append(append([]string{}, optional...), installs...), updates...), uninstalls...) {
+		if !contains(names, item) {
+			names = append(names, item)
+		}
+	}
+
+	for _, item := range names {
+		validItem, err := firstItem(item, catalogsMap)
+		if err != nil {
+			gorillalog.Warn(err)
+			continue
+		}
+		itemStatus, err := statusQuery(validItem, cachePath)
+		if err != nil {
+			gorillalog.Warn("Unable to check status:", item, err)
+			continue
+		}
+
+		// An optional install is only pending once someone asked for it, which adds it to the installs
+		var pending string
+		switch {
+		case contains(uninstalls, item) && !contains(installs, item):
+			if itemStatus.Installed {
+				pending = "remove"
+			}
+		case contains(installs, item) && !itemStatus.Installed:
+			pending = "install"
+		case (contains(installs, item) || contains(updates, item)) && itemStatus.UpdatePending:
+			pending = "update"
+		}
+
+		// Managed items are only interesting to the user when the next run will change them
+		isOptional := contains(optional, item)
+		if !isOptional && pending == "" {
+			continue
+		}
+		items = append(items, ipc.Item{
+			Name:             item,
+			DisplayName:      validItem.DisplayName,
+			Version:          validItem.Version,
+			InstalledVersion: itemStatus.InstalledVersion,
+			Installed:        itemStatus.Installed,
+			UpdatePending:    itemStatus.UpdatePending,
+			Optional:         isOptional,
+			Pending:          pending,
+		})
+	}
+	return items
+}
+
+// printStatus prints a table with the state of each item in the lists
+func printStatus(managedLists []managedList, catalogsMap map[int]map[string]catalog.Item, cachePath string) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ITEM\tMANAGED AS\tINSTALLED\tINSTALLED VERSION\tCATALOG VERSION\tUPDATE PENDING")
+
+	for _, managedList := range managedLists {
+		for _, item := range managedList.items {
+			validItem, err := firstItem(item, catalogsMap)
+			if err != nil {
+				gorillalog.Warn(err)
+				continue
+			}
+
+			itemStatus, err := statusQuery(validItem, cachePath)
+			if err != nil {
+				gorillalog.Warn("Unable to check status:", item, err)
+				continue
+			}
+
+			// Use a placeholder when the installed version is unknown
+			installedVersion := itemStatus.InstalledVersion
+			if installedVersion == "" {
+				installedVersion = "-"
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%v\t%s\t%s\t%v\n",
+				item,
+				managedList.managedAs,
+				itemStatus.Installed,
+				installedVersion,
+				validItem.Version,
+				itemStatus.UpdatePending,
+			)
+		}
+	}
+	w.Flush()
+}
+
 // dirEmpty returns true if the directory is empty
 func dirEmpty(path string) bool {
 	f, err := os.Open(path)
@@ -229,3 +839,110 @@ func CleanUp(cachePath string) {
 		return
 	}
 }
+
+// CleanOrphans removes cached files that are not referenced by any item in the catalogs
+func CleanOrphans(cachePath string, catalogsMap map[int]map[string]catalog.Item) {
+
+	// Build a set of every file the catalogs could have downloaded
+	// Windows paths are not case sensitive, so compare them in lower case
+	referenced := make(map[string]bool)
+	for _, catalogItems := range catalogsMap {
+		for _, item := range catalogItems {
+			for _, location := range []string{item.Installer.Location, item.Uninstaller.Location} {
+				if location == "" {
+					continue
+				}
+				cacheFile := strings.ToLower(filepath.Clean(download.CacheFile(cachePath, location)))
+				referenced[cacheFile] = true
+				// Keep interrupted downloads too, so they can be resumed
+				referenced[cacheFile+".partial"] = true
+			}
+			// Keep each previous version a delta starts from, so the next update can be patched
+			for _, delta := range item.Installer.Deltas {
+				referenced[strings.ToLower(filepath.Clean(download.CacheFile(cachePath, delta.From)))] = true
+			}
+		}
+	}
+
+	// Remove any file that is not in the set
+	err := filepath.Walk(cachePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			gorillalog.Warn("Failed to access path:", path, err)
+			return err
+		}
+		if !info.IsDir() && !referenced[strings.ToLower(filepath.Clean(path))] {
+			gorillalog.Info("Cleaning orphaned cached file:", path)
+			osRemove(path)
+		}
+		return nil
+	})
+	if err != nil {
+		gorillalog.Warn("error walking path:", cachePath, err)
+	}
+}
+
+// LimitCache removes the least recently used files until the cache is no larger than maxBytes
+// A cached installer's access time is updated each time it is used
+func LimitCache(cachePath string, maxBytes int64) {
+	type cachedFile struct {
+		path     string
+		size     int64
+		lastUsed time.Time
+	}
+
+	// Find every file in the cache and the total size
+	var files []cachedFile
+	var total int64
+	err := filepath.Walk(cachePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			gorillalog.Warn("Failed to access path:", path, err)
+			return err
+		}
+		if !info.IsDir() {
+			files = append(files, cachedFile{path: path, size: info.Size(), lastUsed: lastUsed(info)})
+			total += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		gorillalog.Warn("error walking path:", cachePath, err)
+		return
+	}
+	if total <= maxBytes {
+		return
+	}
+
+	// Remove the least recently used files first
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].lastUsed.Before(files[j].lastUsed)
+	})
+	for _, file := range files {
+		if total <= maxBytes {
+			break
+		}
+		gorillalog.Info("Cache is over its size limit, removing:", file.path)
+		if err := osRemove(file.path); err != nil {
+			gorillalog.Warn("Unable to remove cached file:", file.path, err)
+			continue
+		}
+		total -= file.size
+	}
+}
+
+// ClearCache removes everything in the cache, but leaves the cache directory itself
+func ClearCache(cachePath string) error {
+	entries, err := ioutil.ReadDir(cachePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		path := filepath.Join(cachePath, entry.Name())
+		gorillalog.Info("Clearing cached item:", path)
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
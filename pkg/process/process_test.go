@@ -1,6 +1,7 @@
 package process
 
 import (
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -8,13 +9,21 @@ import (
 	"time"
 
 	"github.com/1dustindavis/gorilla/pkg/catalog"
+	"github.com/1dustindavis/gorilla/pkg/ipc"
 	"github.com/1dustindavis/gorilla/pkg/manifest"
+	"github.com/1dustindavis/gorilla/pkg/report"
+	"github.com/1dustindavis/gorilla/pkg/state"
+	"github.com/1dustindavis/gorilla/pkg/status"
 )
 
 var (
 	// store original data to restore after each test
-	origInstall  = installerInstall
-	origOsRemove = osRemove
+	origInstall      = installerInstall
+	origDownload     = installerDownload
+	origOsRemove     = osRemove
+	origStatusQuery  = statusQuery
+	origCheckStatus  = statusCheckStatus
+	origIncompatible = statusIncompatible
 
 	// Setup a test catalog
 	testCatalogs = map[int]map[string]catalog.Item{1: {
@@ -108,6 +117,7 @@ var (
 
 	// Define a variable that our fake functions can store results in
 	actualInstalledItems   []string
+	actualDownloadedItems  []string
 	actualUninstalledItems []string
 	actualUpdatedItems     []string
 	actualRemovedFiles     []string
@@ -159,14 +169,197 @@ func TestManifests(t *testing.T) {
 	}
 }
 
+// TestFirstItem verifies that an item in an earlier catalog takes precedence over later catalogs
+func TestFirstItem(t *testing.T) {
+	catalogs := map[int]map[string]catalog.Item{
+		2: {
+			"Firefox": catalog.Item{
+				DisplayName: "Firefox",
+				Installer:   catalog.InstallerItem{Type: "msi", Location: "firefox-115.msi"},
+				Version:     "115.0",
+			},
+			"Chrome": catalog.Item{
+				DisplayName: "Chrome",
+				Installer:   catalog.InstallerItem{Type: "msi", Location: "chrome.msi"},
+			},
+		},
+		1: {
+			"Firefox": catalog.Item{
+				DisplayName: "Firefox",
+				Installer:   catalog.InstallerItem{Type: "msi", Location: "firefox-116.msi"},
+				Version:     "116.0",
+			},
+			// An item without an installer or uninstaller is passed over
+			"Chrome": catalog.Item{
+				DisplayName: "Chrome",
+			},
+		},
+	}
+
+	tests := map[string]string{
+		"Firefox":               "firefox-116.msi",
+		"Chrome":                "chrome.msi",
+		"Firefox version=115.0": "firefox-115.msi",
+	}
+	for name, expected := range tests {
+		item, err := firstItem(name, catalogs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have, want := item.Installer.Location, expected; have != want {
+			t.Errorf("%s: have %s, want %s", name, have, want)
+		}
+	}
+
+	if _, err := firstItem("Missing", catalogs); err == nil {
+		t.Errorf("Expected an error for an item that is not in any catalog")
+	}
+	if _, err := firstItem("Firefox version=114.0", catalogs); err == nil {
+		t.Errorf("Expected an error for a version that is not in any catalog")
+	}
+}
+
+// TestManifestsPinned verifies that a pinned item replaces the same item without a version
+func TestManifestsPinned(t *testing.T) {
+	catalogs := map[int]map[string]catalog.Item{
+		1: {"Firefox": catalog.Item{Installer: catalog.InstallerItem{Type: "msi", Location: "firefox-116.msi"}, Version: "116.0"}},
+		2: {"Firefox": catalog.Item{Installer: catalog.InstallerItem{Type: "msi", Location: "firefox-115.msi"}, Version: "115.0"}},
+	}
+	testManifests := []manifest.Item{
+		{Name: "site", Installs: []string{"Firefox"}},
+		{Name: "machine", Installs: []string{"Firefox version=115.0"}},
+	}
+
+	installs, _, _ := Manifests(testManifests, catalogs)
+	if have, want := installs, []string{"Firefox version=115.0"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %#v, want %#v", have, want)
+	}
+}
+
+// TestFilterCategories verifies that only items in the provided categories are kept
+func TestFilterCategories(t *testing.T) {
+	categoryCatalogs := map[int]map[string]catalog.Item{1: {
+		"Firefox": catalog.Item{
+			Installer: catalog.InstallerItem{Type: "msi", Location: "Firefox.msi"},
+			Category:  "Browsers",
+		},
+		"Chrome": catalog.Item{
+			Installer: catalog.InstallerItem{Type: "msi", Location: "Chrome.msi"},
+			Category:  "Browsers",
+		},
+		"CanonDrivers": catalog.Item{
+			Installer: catalog.InstallerItem{Type: "msi", Location: "Canon.msi"},
+			Category:  "Printers",
+		},
+		"Zoom": catalog.Item{
+			Installer: catalog.InstallerItem{Type: "msi", Location: "Zoom.msi"},
+		},
+	}}
+	items := []string{"Firefox", "CanonDrivers", "Zoom", "Chrome"}
+
+	expected := []string{"Firefox", "Chrome"}
+	actual := FilterCategories(items, []string{"browsers"}, categoryCatalogs)
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("\nExpected: %#v\nActual: %#v", expected, actual)
+	}
+
+	expected = []string{"Firefox", "CanonDrivers", "Chrome"}
+	actual = FilterCategories(items, []string{"Browsers", "Printers"}, categoryCatalogs)
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("\nExpected: %#v\nActual: %#v", expected, actual)
+	}
+}
+
+// TestSortByPriority verifies that higher priority items are first, and equal priorities keep their order
+func TestSortByPriority(t *testing.T) {
+	priorityCatalogs := map[int]map[string]catalog.Item{1: {
+		"Runtime": catalog.Item{
+			Installer: catalog.InstallerItem{Type: "msi", Location: "Runtime.msi"},
+			Priority:  10,
+		},
+		"Agent": catalog.Item{
+			Installer: catalog.InstallerItem{Type: "msi", Location: "Agent.msi"},
+			Priority:  5,
+		},
+		"Browser": catalog.Item{
+			Installer: catalog.InstallerItem{Type: "msi", Location: "Browser.msi"},
+		},
+		"Editor": catalog.Item{
+			Installer: catalog.InstallerItem{Type: "msi", Location: "Editor.msi"},
+		},
+		"Cleanup": catalog.Item{
+			Installer: catalog.InstallerItem{Type: "msi", Location: "Cleanup.msi"},
+			Priority:  -1,
+		},
+	}}
+	items := []string{"Cleanup", "Browser", "Agent", "Editor", "Runtime"}
+
+	expected := []string{"Runtime", "Agent", "Browser", "Editor", "Cleanup"}
+	actual := sortByPriority(items, priorityCatalogs)
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("\nExpected: %#v\nActual: %#v", expected, actual)
+	}
+
+	// The original list should not be modified
+	if items[0] != "Cleanup" {
+		t.Errorf("sortByPriority modified the original list: %#v", items)
+	}
+}
+
+// TestResolveDependencies verifies that nested dependencies are installed first, only once,
+// and that items in a dependency cycle are skipped
+func TestResolveDependencies(t *testing.T) {
+	dependencyCatalogs := map[int]map[string]catalog.Item{1: {
+		"Runtime": catalog.Item{
+			Installer: catalog.InstallerItem{Type: "msi", Location: "Runtime.msi"},
+		},
+		"Framework": catalog.Item{
+			Installer:    catalog.InstallerItem{Type: "msi", Location: "Framework.msi"},
+			Dependencies: []string{"Runtime"},
+		},
+		"Editor": catalog.Item{
+			Installer:    catalog.InstallerItem{Type: "msi", Location: "Editor.msi"},
+			Dependencies: []string{"Framework"},
+		},
+		"Plugin": catalog.Item{
+			Installer:    catalog.InstallerItem{Type: "msi", Location: "Plugin.msi"},
+			Dependencies: []string{"Editor", "Runtime"},
+		},
+		"ChickenA": catalog.Item{
+			Installer:    catalog.InstallerItem{Type: "msi", Location: "ChickenA.msi"},
+			Dependencies: []string{"EggB"},
+		},
+		"EggB": catalog.Item{
+			Installer:    catalog.InstallerItem{Type: "msi", Location: "EggB.msi"},
+			Dependencies: []string{"ChickenA"},
+		},
+		"NeedsCycle": catalog.Item{
+			Installer:    catalog.InstallerItem{Type: "msi", Location: "NeedsCycle.msi"},
+			Dependencies: []string{"ChickenA"},
+		},
+	}}
+	items := []string{"Plugin", "ChickenA", "Editor", "NeedsCycle", "Runtime", "Plugin"}
+
+	expected := []string{"Runtime", "Framework", "Editor", "Plugin"}
+	actual := resolveDependencies(items, dependencyCatalogs)
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("\nExpected: %#v\nActual: %#v", expected, actual)
+	}
+}
+
 // TestInstalls tests if install items and their dependencies are processed correctly
 func TestInstalls(t *testing.T) {
 
-	// Override the install function to use our fake function
+	// Override the install and download functions to use our fake functions
 	installerInstall = fakeInstall
-	defer func() { installerInstall = origInstall }()
+	installerDownload = fakeDownload
+	defer func() {
+		installerInstall = origInstall
+		installerDownload = origDownload
+	}()
 
 	// Run `Installs` with test data
+	actualDownloadedItems = nil
 	Installs(testInstalls, testCatalogs, "URLPackages", "CachePath", checkOnlyMode)
 
 	// Define what we expect to be in the list of installed items
@@ -180,6 +373,41 @@ func TestInstalls(t *testing.T) {
 	if !matchItems {
 		t.Errorf("\nExpected: %#v\nActual: %#v", expectedItems, actualInstalledItems)
 	}
+
+	// Everything is downloaded before it is installed
+	if !reflect.DeepEqual(expectedItems, actualDownloadedItems) {
+		t.Errorf("\nExpected: %#v\nActual: %#v", expectedItems, actualDownloadedItems)
+	}
+}
+
+// TestInstallsIncompatible tests that items this computer doesnt support are skipped, without an error
+func TestInstallsIncompatible(t *testing.T) {
+	installerInstall = fakeInstall
+	installerDownload = fakeDownload
+	statusIncompatible = fakeIncompatible
+	defer func() {
+		installerInstall = origInstall
+		installerDownload = origDownload
+		statusIncompatible = origIncompatible
+	}()
+
+	actualInstalledItems = nil
+	actualDownloadedItems = nil
+	report.Errors = nil
+	Installs([]string{"GoogleChrome", "TestInstall1"}, testCatalogs, "URLPackages", "CachePath", checkOnlyMode)
+
+	// An item that doesnt fit this computer is skipped without an error
+	if len(report.Errors) != 0 {
+		t.Errorf("Expected no errors for a skipped item, got %v", report.Errors)
+	}
+
+	expectedItems := []string{"TestInstall1"}
+	if !reflect.DeepEqual(expectedItems, actualInstalledItems) {
+		t.Errorf("\nExpected: %#v\nActual: %#v", expectedItems, actualInstalledItems)
+	}
+	if !reflect.DeepEqual(expectedItems, actualDownloadedItems) {
+		t.Errorf("\nExpected: %#v\nActual: %#v", expectedItems, actualDownloadedItems)
+	}
 }
 
 // TestUninstalls tests if uninstall items are processed correctly
@@ -190,7 +418,7 @@ func TestUninstalls(t *testing.T) {
 	defer func() { installerInstall = origInstall }()
 
 	// Run `Uninstalls` with test data
-	Uninstalls(testUninstalls, testCatalogs, "URLPackages", "CachePath", checkOnlyMode)
+	Uninstalls(testUninstalls, testCatalogs, "URLPackages", "CachePath", checkOnlyMode, false)
 
 	// Define what we expect to be in the list of uninstalled items
 	expectedItems := testUninstalls
@@ -204,12 +432,206 @@ func TestUninstalls(t *testing.T) {
 	}
 }
 
+// TestUninstallDependencies verifies that an item is not uninstalled while an installed item depends on it
+func TestUninstallDependencies(t *testing.T) {
+	// Override the install and status functions to use our fake functions
+	installerInstall = fakeUninstall
+	statusCheckStatus = fakeCheckStatus
+	defer func() {
+		installerInstall = origInstall
+		statusCheckStatus = origCheckStatus
+	}()
+
+	dependencyCatalogs := map[int]map[string]catalog.Item{1: {
+		"Runtime": catalog.Item{
+			DisplayName: "Runtime",
+			Uninstaller: catalog.InstallerItem{Type: "msi", Location: "Runtime.msi"},
+		},
+		"Library": catalog.Item{
+			DisplayName: "Library",
+			Uninstaller: catalog.InstallerItem{Type: "msi", Location: "Library.msi"},
+		},
+		"Base": catalog.Item{
+			DisplayName:  "Base",
+			Uninstaller:  catalog.InstallerItem{Type: "msi", Location: "Base.msi"},
+			Dependencies: []string{"Runtime"},
+		},
+		"Other": catalog.Item{
+			DisplayName:  "Other",
+			Uninstaller:  catalog.InstallerItem{Type: "msi", Location: "Other.msi"},
+			Dependencies: []string{"Library"},
+		},
+	}}
+
+	// "Base" is installed and needs "Runtime", but "Other" is not installed
+	actualUninstalledItems = nil
+	Uninstalls([]string{"Runtime", "Library"}, dependencyCatalogs, "URLPackages", "CachePath", checkOnlyMode, false)
+	expectedItems := []string{"Library"}
+	if !reflect.DeepEqual(expectedItems, actualUninstalledItems) {
+		t.Errorf("\nExpected: %#v\nActual: %#v", expectedItems, actualUninstalledItems)
+	}
+
+	// Forcing the uninstall removes both
+	actualUninstalledItems = nil
+	Uninstalls([]string{"Runtime", "Library"}, dependencyCatalogs, "URLPackages", "CachePath", checkOnlyMode, true)
+	expectedItems = []string{"Runtime", "Library"}
+	if !reflect.DeepEqual(expectedItems, actualUninstalledItems) {
+		t.Errorf("\nExpected: %#v\nActual: %#v", expectedItems, actualUninstalledItems)
+	}
+
+	// Uninstalling the dependent item at the same time removes both
+	actualUninstalledItems = nil
+	Uninstalls([]string{"Runtime", "Base"}, dependencyCatalogs, "URLPackages", "CachePath", checkOnlyMode, false)
+	expectedItems = []string{"Runtime", "Base"}
+	if !reflect.DeepEqual(expectedItems, actualUninstalledItems) {
+		t.Errorf("\nExpected: %#v\nActual: %#v", expectedItems, actualUninstalledItems)
+	}
+}
+
+// TestUnusedDependencies verifies that dependencies installed for an item are only removed once every item
+// they were installed for has been uninstalled, including dependencies of those dependencies
+func TestUnusedDependencies(t *testing.T) {
+	// Results are recorded like the installer does, and the item named by failing fails to uninstall
+	failing := ""
+	installerInstall = func(item catalog.Item, installerType string, urlPackages string, cachePath string, checkOnly bool) string {
+		if installerType == "uninstall" {
+			actualUninstalledItems = append(actualUninstalledItems, item.DisplayName)
+		}
+		state.Record(item.DisplayName, installerType, item.Version, "", installerType != "uninstall" || item.DisplayName != failing)
+		return ""
+	}
+	statusCheckStatus = func(item catalog.Item, installType string, cachePath string) (bool, error) {
+		history := state.Get(item.DisplayName)
+		return history.LastResult != "" && !history.Removed(), nil
+	}
+	installerDownload = fakeDownload
+	defer func() {
+		installerInstall = origInstall
+		installerDownload = origDownload
+		statusCheckStatus = origCheckStatus
+	}()
+	appData, err := ioutil.TempDir("", "gorilla_dependencies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(appData)
+	if err := state.Load(appData); err != nil {
+		t.Fatal(err)
+	}
+
+	dependencyCatalogs := map[int]map[string]catalog.Item{1: {
+		"Core": catalog.Item{
+			DisplayName: "Core",
+			Installer:   catalog.InstallerItem{Type: "msi", Location: "Core.msi"},
+		},
+		"Runtime": catalog.Item{
+			DisplayName:  "Runtime",
+			Installer:    catalog.InstallerItem{Type: "msi", Location: "Runtime.msi"},
+			Dependencies: []string{"Core"},
+		},
+		"App": catalog.Item{
+			DisplayName:  "App",
+			Installer:    catalog.InstallerItem{Type: "msi", Location: "App.msi"},
+			Dependencies: []string{"Runtime"},
+		},
+		"Tool": catalog.Item{
+			DisplayName:  "Tool",
+			Installer:    catalog.InstallerItem{Type: "msi", Location: "Tool.msi"},
+			Dependencies: []string{"Core"},
+		},
+	}}
+
+	// Installing "App" pulls in "Runtime", which pulls in "Core"
+	Installs([]string{"App", "Tool"}, dependencyCatalogs, "URLPackages", "CachePath", checkOnlyMode)
+
+	// Nothing is unused until an uninstall has actually happened
+	if actual := UnusedDependencies([]string{"App"}, []string{"Tool"}, nil, dependencyCatalogs); actual != nil {
+		t.Errorf("Expected nothing before App is uninstalled, got %#v", actual)
+	}
+
+	// "Core" is still needed by "Tool", since its uninstall failed
+	failing = "Tool"
+	actualUninstalledItems = nil
+	Uninstalls([]string{"App", "Tool"}, dependencyCatalogs, "URLPackages", "CachePath", checkOnlyMode, false)
+	UninstallUnused([]string{"App", "Tool"}, nil, nil, dependencyCatalogs, "URLPackages", "CachePath", checkOnlyMode)
+	expected := []string{"App", "Tool", "Runtime"}
+	if !reflect.DeepEqual(expected, actualUninstalledItems) {
+		t.Errorf("\nExpected: %#v\nActual: %#v", expected, actualUninstalledItems)
+	}
+
+	// Once "Tool" is gone too, so is "Core"
+	failing = ""
+	actualUninstalledItems = nil
+	Uninstalls([]string{"Tool"}, dependencyCatalogs, "URLPackages", "CachePath", checkOnlyMode, false)
+	UninstallUnused([]string{"Tool"}, nil, nil, dependencyCatalogs, "URLPackages", "CachePath", checkOnlyMode)
+	expected = []string{"Tool", "Core"}
+	if !reflect.DeepEqual(expected, actualUninstalledItems) {
+		t.Errorf("\nExpected: %#v\nActual: %#v", expected, actualUninstalledItems)
+	}
+
+	// Removing "Runtime" leaves "Core" unused as well, so both go in one run
+	if err := state.Load(appData); err != nil {
+		t.Fatal(err)
+	}
+	Installs([]string{"App"}, dependencyCatalogs, "URLPackages", "CachePath", checkOnlyMode)
+	actualUninstalledItems = nil
+	Uninstalls([]string{"App"}, dependencyCatalogs, "URLPackages", "CachePath", checkOnlyMode, false)
+
+	// Anything a manifest still installs is kept
+	if actual := UnusedDependencies([]string{"App"}, []string{"Runtime", "Core"}, nil, dependencyCatalogs); actual != nil {
+		t.Errorf("Expected nothing the manifest installs, got %#v", actual)
+	}
+
+	UninstallUnused([]string{"App"}, nil, nil, dependencyCatalogs, "URLPackages", "CachePath", checkOnlyMode)
+	expected = []string{"App", "Runtime", "Core"}
+	if !reflect.DeepEqual(expected, actualUninstalledItems) {
+		t.Errorf("\nExpected: %#v\nActual: %#v", expected, actualUninstalledItems)
+	}
+}
+
+// TestOptional verifies optional installs are only managed once they are requested
+func TestOptional(t *testing.T) {
+	manifests := []manifest.Item{
+		{Name: "site", Optional: manifest.ItemList{"GoogleChrome", "TestInstall1", "Missing"}},
+		{Name: "group", Optional: manifest.ItemList{"TestInstall1", "TestInstall2"}},
+	}
+	optional := Optional(manifests, testCatalogs)
+	expected := []string{"GoogleChrome", "TestInstall1", "TestInstall2"}
+	if !reflect.DeepEqual(expected, optional) {
+		t.Errorf("\nExpected: %#v\nActual: %#v", expected, optional)
+	}
+
+	// Nothing is requested yet
+	if requested := Requested(optional, nil, testCatalogs); len(requested) != 0 {
+		t.Errorf("Expected no requested items, got %#v", requested)
+	}
+
+	// Only optional installs are tracked, and items already installed by a manifest are skipped
+	SetRequested([]string{"GoogleChrome", "TestInstall2", "Chef Client"}, optional, testCatalogs, true)
+	defer SetRequested(optional, optional, testCatalogs, false)
+	expected = []string{"TestInstall2"}
+	if requested := Requested(optional, []string{"GoogleChrome"}, testCatalogs); !reflect.DeepEqual(expected, requested) {
+		t.Errorf("\nExpected: %#v\nActual: %#v", expected, requested)
+	}
+
+	// Removing an optional install stops managing it
+	SetRequested([]string{"TestInstall2"}, optional, testCatalogs, false)
+	expected = []string{"GoogleChrome"}
+	if requested := Requested(optional, nil, testCatalogs); !reflect.DeepEqual(expected, requested) {
+		t.Errorf("\nExpected: %#v\nActual: %#v", expected, requested)
+	}
+}
+
 // TestUpdates tests if update items are processed correctly
 func TestUpdates(t *testing.T) {
 
-	// Override the install function to use our fake function
+	// Override the install and download functions to use our fake functions
 	installerInstall = fakeUpdate
-	defer func() { installerInstall = origInstall }()
+	installerDownload = fakeDownload
+	defer func() {
+		installerInstall = origInstall
+		installerDownload = origDownload
+	}()
 
 	// Run `Updates` with test data
 	Updates(testUpdates, testCatalogs, "URLPackages", "CachePath", checkOnlyMode)
@@ -226,6 +648,112 @@ func TestUpdates(t *testing.T) {
 	}
 }
 
+// TestUpdateFor verifies that updates are added and only processed when their base item is installed
+func TestUpdateFor(t *testing.T) {
+	// Override the install and status functions to use our fake functions
+	installerInstall = fakeUpdate
+	installerDownload = fakeDownload
+	statusCheckStatus = fakeCheckStatus
+	defer func() {
+		installerInstall = origInstall
+		installerDownload = origDownload
+		statusCheckStatus = origCheckStatus
+	}()
+
+	updateCatalogs := map[int]map[string]catalog.Item{1: {
+		"Base": catalog.Item{
+			DisplayName: "Base",
+			Installer:   catalog.InstallerItem{Type: "msi", Location: "Base.msi"},
+		},
+		"Other": catalog.Item{
+			DisplayName: "Other",
+			Installer:   catalog.InstallerItem{Type: "msi", Location: "Other.msi"},
+		},
+		"BasePatch": catalog.Item{
+			DisplayName: "BasePatch",
+			Installer:   catalog.InstallerItem{Type: "msi", Location: "BasePatch.msi"},
+			UpdateFor:   []string{"Base"},
+		},
+		"OtherPatch": catalog.Item{
+			DisplayName: "OtherPatch",
+			Installer:   catalog.InstallerItem{Type: "msi", Location: "OtherPatch.msi"},
+			UpdateFor:   []string{"Other"},
+		},
+	}}
+
+	// Both patches should be added since we manage both base items
+	testManifests := []manifest.Item{{Installs: []string{"Base"}, Updates: []string{"Other"}}}
+	_, _, actualUpdates := Manifests(testManifests, updateCatalogs)
+	expectedUpdates := []string{"Other", "BasePatch", "OtherPatch"}
+	if !reflect.DeepEqual(expectedUpdates, actualUpdates) {
+		t.Errorf("\nExpected: %#v\nActual: %#v", expectedUpdates, actualUpdates)
+	}
+
+	// Only the patch for the installed base item should be processed
+	actualUpdatedItems = nil
+	Updates([]string{"BasePatch", "OtherPatch"}, updateCatalogs, "URLPackages", "CachePath", checkOnlyMode)
+	expectedItems := []string{"BasePatch"}
+	if !reflect.DeepEqual(expectedItems, actualUpdatedItems) {
+		t.Errorf("\nExpected: %#v\nActual: %#v", expectedItems, actualUpdatedItems)
+	}
+}
+
+// ExampleStatus verifies the status of each managed item is printed
+func ExampleStatus() {
+	// Override the status query to use our fake function
+	statusQuery = fakeStatusQuery
+	defer func() { statusQuery = origStatusQuery }()
+
+	Status([]string{"GoogleChrome"}, []string{"AdobeFlash"}, nil, []string{"GoogleChrome", "TestInstall2"}, testCatalogs, "CachePath")
+
+	// Output:
+	// ITEM          MANAGED AS  INSTALLED  INSTALLED VERSION  CATALOG VERSION  UPDATE PENDING
+	// GoogleChrome  install     true       1.0                                 true
+	// AdobeFlash    uninstall   true       1.0                                 false
+	// TestInstall2  optional    true       1.0                                 false
+}
+
+// ExampleItemStatus verifies requested items are printed with how they are managed, even when they arent
+func ExampleItemStatus() {
+	// Override the status query to use our fake function
+	statusQuery = fakeStatusQuery
+	defer func() { statusQuery = origStatusQuery }()
+
+	ItemStatus([]string{"AdobeFlash", "TestInstall1", "TestInstall2"}, []string{"GoogleChrome"}, []string{"AdobeFlash"}, nil, []string{"TestInstall2"}, testCatalogs, "CachePath")
+
+	// Output:
+	// ITEM          MANAGED AS  INSTALLED  INSTALLED VERSION  CATALOG VERSION  UPDATE PENDING
+	// AdobeFlash    uninstall   true       1.0                                 false
+	// TestInstall1  -           true       1.0                                 false
+	// TestInstall2  optional    true       1.0                                 false
+}
+
+// TestList verifies optional installs and pending updates are listed for the self-service app
+func TestList(t *testing.T) {
+	// Override the status query to use our fake function
+	statusQuery = fakeStatusQuery
+	defer func() { statusQuery = origStatusQuery }()
+
+	items := List([]string{"GoogleChrome", "TestInstall1"}, nil, []string{"TestUpdate1"}, []string{"TestInstall2", "GoogleChrome"}, testCatalogs, "CachePath")
+	expected := []ipc.Item{
+		{Name: "TestInstall2", DisplayName: "TestInstall2", InstalledVersion: "1.0", Installed: true, Optional: true},
+		{Name: "GoogleChrome", DisplayName: "GoogleChrome", InstalledVersion: "1.0", Installed: true, UpdatePending: true, Optional: true, Pending: "update"},
+	}
+	if !reflect.DeepEqual(expected, items) {
+		t.Errorf("\nExpected: %#v\nActual: %#v", expected, items)
+	}
+
+	// A managed item is listed once the next run will update or remove it
+	items = List([]string{"GoogleChrome"}, []string{"TestUninstall1"}, nil, nil, testCatalogs, "CachePath")
+	expected = []ipc.Item{
+		{Name: "GoogleChrome", DisplayName: "GoogleChrome", InstalledVersion: "1.0", Installed: true, UpdatePending: true, Pending: "update"},
+		{Name: "TestUninstall1", DisplayName: "TestUninstall1", InstalledVersion: "1.0", Installed: true, Pending: "remove"},
+	}
+	if !reflect.DeepEqual(expected, items) {
+		t.Errorf("\nExpected: %#v\nActual: %#v", expected, items)
+	}
+}
+
 // TestCleanUp verifies that only the correct files and directories are removed
 func TestCleanUp(t *testing.T) {
 
@@ -280,6 +808,126 @@ func TestCleanUp(t *testing.T) {
 	}
 }
 
+// TestCleanOrphans verifies that only cached files not referenced by a catalog are removed
+func TestCleanOrphans(t *testing.T) {
+
+	// Override the os.Remove function
+	actualRemovedFiles = nil
+	osRemove = fakeOsRemove
+	defer func() {
+		osRemove = origOsRemove
+	}()
+
+	orphanCatalogs := map[int]map[string]catalog.Item{1: {
+		"New": catalog.Item{
+			Installer: catalog.InstallerItem{Type: "msi", Location: "new.msi"},
+		},
+		"Full": catalog.Item{
+			Installer:   catalog.InstallerItem{Type: "msi", Location: "https://cdn.example.com/full/file.msi"},
+			Uninstaller: catalog.InstallerItem{Type: "msi", Location: "full/uninstall.msi"},
+		},
+	}}
+
+	// Run `CleanOrphans`
+	CleanOrphans("testdata/cache", orphanCatalogs)
+
+	// Only the file that no item references should be removed
+	expectedFiles := []string{filepath.Clean("testdata/cache/old.msi")}
+	if !reflect.DeepEqual(expectedFiles, actualRemovedFiles) {
+		t.Errorf("\nExpected: %#v\nActual: %#v", expectedFiles, actualRemovedFiles)
+	}
+}
+
+// TestLimitCache verifies that the least recently used files are removed until the cache is small enough
+func TestLimitCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gorilla_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Each file is 100 bytes, and used a day after the one before it
+	usedTime := time.Now().Add(-240 * time.Hour)
+	for _, name := range []string{"oldest.msi", "full/older.msi", "newer.msi", "newest.msi.partial"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		usedTime = usedTime.Add(24 * time.Hour)
+		if err := os.Chtimes(path, usedTime, usedTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A cache under the limit is left alone
+	LimitCache(dir, 400)
+	if _, err := os.Stat(filepath.Join(dir, "oldest.msi")); err != nil {
+		t.Errorf("A file was removed from a cache under the limit: %v", err)
+	}
+
+	LimitCache(dir, 250)
+	for name, kept := range map[string]bool{"oldest.msi": false, "full/older.msi": false, "newer.msi": true, "newest.msi.partial": true} {
+		_, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name)))
+		if have, want := err == nil, kept; have != want {
+			t.Errorf("%s: have kept %v, want kept %v", name, have, want)
+		}
+	}
+}
+
+// TestClearCache verifies that everything in the cache is removed, but not the cache itself
+func TestClearCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gorilla_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "full"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "full", "file.msi"), []byte("gorilla"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "new.msi"), []byte("gorilla"), 0644)
+
+	err = ClearCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected an empty cache, found %d items", len(entries))
+	}
+
+	// A cache that doesnt exist yet is already clear
+	if err := ClearCache(filepath.Join(dir, "missing")); err != nil {
+		t.Errorf("Unexpected error clearing a missing cache: %v", err)
+	}
+}
+
+// Mocks the actual `status.CheckStatus` function and reports only "Base" as installed
+func fakeCheckStatus(item catalog.Item, installType string, cachePath string) (bool, error) {
+	return item.DisplayName == "Base", nil
+}
+
+// Mocks the actual `status.Incompatible` function and reports only "GoogleChrome" as incompatible
+func fakeIncompatible(item catalog.Item) string {
+	if item.DisplayName == "GoogleChrome" {
+		return "it supports x64, and this computer is arm64"
+	}
+	return ""
+}
+
+// Mocks the actual `status.Query` function and reports every item as installed
+func fakeStatusQuery(item catalog.Item, cachePath string) (status.ItemStatus, error) {
+	itemStatus := status.ItemStatus{
+		Installed:        true,
+		InstalledVersion: "1.0",
+		CatalogVersion:   item.Version,
+		UpdatePending:    item.DisplayName == "GoogleChrome",
+	}
+	return itemStatus, nil
+}
+
 // Mocks the actual `installer.Install` function and saves what it receives to `actualInstalledItems`
 func fakeInstall(item catalog.Item, installerType string, urlPackages string, cachePath string, checkOnly bool) string {
 	// Append any item we are passed to a slice for later comparison
@@ -287,6 +935,13 @@ func fakeInstall(item catalog.Item, installerType string, urlPackages string, ca
 	return ""
 }
 
+// Mocks the actual `installer.Download` function and saves what it receives to `actualDownloadedItems`
+func fakeDownload(items []catalog.Item, installerType string, urlPackages string, cachePath string) {
+	for _, item := range items {
+		actualDownloadedItems = append(actualDownloadedItems, item.DisplayName)
+	}
+}
+
 // Mocks the actual `installer.Install` function and saves what it receives to `actualUninstalledItems`
 func fakeUninstall(item catalog.Item, installerType string, urlPackages string, cachePath string, checkOnly bool) string {
 	// Append any item we are passed to a slice for later comparison
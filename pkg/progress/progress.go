@@ -0,0 +1,187 @@
+// Package progress reports how downloads and installers are coming along, so a large download or a slow
+// installer doesnt look like a hang. Updates go to every reporter that has been set, like the console or the log.
+package progress
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Update is how far along a download or installer is
+type Update struct {
+	// Action is download, or the installer's install, update, or uninstall
+	Action string `json:"action"`
+	Name   string `json:"name"`
+	// Bytes and Total are only set for downloads, and Total is zero when the server didnt say how large it is
+	Bytes int64 `json:"bytes,omitempty"`
+	Total int64 `json:"total,omitempty"`
+	// Rate is in bytes per second
+	Rate      float64 `json:"rate,omitempty"`
+	Elapsed   float64 `json:"elapsed_seconds"`
+	Remaining float64 `json:"remaining_seconds,omitempty"`
+	Finished  bool    `json:"finished,omitempty"`
+}
+
+// Percent returns how much of a download is done, or -1 if the size isnt known
+func (u Update) Percent() int {
+	if u.Total <= 0 {
+		return -1
+	}
+	return int(u.Bytes * 100 / u.Total)
+}
+
+// String describes the update, like "Downloading Chrome: 45% of 92.1 MB at 4.2 MB/s, 12s left"
+func (u Update) String() string {
+	if u.Action != "download" {
+		verb := map[string]string{"install": "Installing", "update": "Updating", "uninstall": "Uninstalling"}[u.Action]
+		if verb == "" {
+			verb = "Running"
+		}
+		if u.Finished {
+			return fmt.Sprintf("%s %s: done after %v", verb, u.Name, seconds(u.Elapsed))
+		}
+		return fmt.Sprintf("%s %s: %v", verb, u.Name, seconds(u.Elapsed))
+	}
+
+	msg := fmt.Sprintf("Downloading %s: %s", u.Name, Size(u.Bytes))
+	if percent := u.Percent(); percent >= 0 {
+		msg = fmt.Sprintf("Downloading %s: %d%% of %s", u.Name, percent, Size(u.Total))
+	}
+	if u.Finished {
+		return fmt.Sprintf("%s, done after %v", msg, seconds(u.Elapsed))
+	}
+	if u.Rate > 0 {
+		msg += fmt.Sprintf(" at %s/s", Size(int64(u.Rate)))
+	}
+	if u.Remaining > 0 {
+		msg += fmt.Sprintf(", %v left", seconds(u.Remaining))
+	}
+	return msg
+}
+
+// seconds turns a number of seconds into a rounded duration
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second)).Round(time.Second)
+}
+
+// Size formats a number of bytes, like "1.2 GB"
+func Size(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit && exp < 3; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGT"[exp])
+}
+
+// Reporter is told about every update
+type Reporter interface {
+	Progress(Update)
+}
+
+var (
+	// Updates are sent to each reporter one at a time, so reporters dont need to worry about locking
+	mu        sync.Mutex
+	reporters []Reporter
+
+	// Updates are sent at most this often while something is in progress
+	interval = time.Second
+
+	// This abstraction allows us to override the time when testing
+	timeNow = time.Now
+)
+
+// SetReporters replaces the reporters that are told about updates
+func SetReporters(r ...Reporter) {
+	mu.Lock()
+	defer mu.Unlock()
+	reporters = r
+}
+
+// send tells every reporter about an update
+func send(u Update) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, r := range reporters {
+		r.Progress(u)
+	}
+}
+
+// Transfer counts the bytes of a download as they are written to it
+type Transfer struct {
+	name  string
+	total int64
+	start int64
+	bytes int64
+	began time.Time
+	last  time.Time
+}
+
+// Download starts tracking a download of total bytes, zero if it isnt known
+// Start is how much was already downloaded by an earlier attempt, which doesnt count toward the rate
+func Download(name string, total, start int64) *Transfer {
+	now := timeNow()
+	return &Transfer{name: name, total: total, start: start, bytes: start, began: now, last: now}
+}
+
+// Write counts the bytes, and sends an update if it has been long enough since the last one
+func (t *Transfer) Write(p []byte) (int, error) {
+	t.bytes += int64(len(p))
+	if now := timeNow(); now.Sub(t.last) >= interval {
+		t.last = now
+		send(t.update(now))
+	}
+	return len(p), nil
+}
+
+// Done sends the final update
+func (t *Transfer) Done() {
+	u := t.update(timeNow())
+	u.Finished = true
+	send(u)
+}
+
+// update returns the progress so far
+func (t *Transfer) update(now time.Time) Update {
+	elapsed := now.Sub(t.began).Seconds()
+	u := Update{Action: "download", Name: t.name, Bytes: t.bytes, Total: t.total, Elapsed: elapsed}
+	if elapsed > 0 {
+		u.Rate = float64(t.bytes-t.start) / elapsed
+	}
+	if u.Rate > 0 && t.total > t.bytes {
+		u.Remaining = float64(t.total-t.bytes) / u.Rate
+	}
+	return u
+}
+
+// Run sends an update every interval while an installer runs, since there is no way to know how far along it is
+// The returned function stops the updates, and sends the final one
+func Run(action, name string) func() {
+	began := timeNow()
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				send(Update{Action: action, Name: name, Elapsed: timeNow().Sub(began).Seconds()})
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-stopped
+		send(Update{Action: action, Name: name, Elapsed: timeNow().Sub(began).Seconds(), Finished: true})
+	}
+}
@@ -0,0 +1,123 @@
+package progress
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recorder keeps every update it is sent
+type recorder struct {
+	updates []Update
+}
+
+func (r *recorder) Progress(u Update) {
+	r.updates = append(r.updates, u)
+}
+
+// TestDownload verifies downloads report their rate and time left, at most once an interval
+func TestDownload(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	r := &recorder{}
+	SetReporters(r)
+	defer func() {
+		timeNow = time.Now
+		SetReporters()
+	}()
+
+	// Half of the file was downloaded by an earlier attempt, which doesnt count toward the rate
+	transfer := Download("setup.msi", 4000, 2000)
+	transfer.Write(make([]byte, 500))
+	now = now.Add(time.Second)
+	transfer.Write(make([]byte, 500))
+	now = now.Add(500 * time.Millisecond)
+	transfer.Write(make([]byte, 500))
+	transfer.Done()
+
+	expected := []Update{
+		{Action: "download", Name: "setup.msi", Bytes: 3000, Total: 4000, Rate: 1000, Elapsed: 1, Remaining: 1},
+		{Action: "download", Name: "setup.msi", Bytes: 3500, Total: 4000, Rate: 1000, Elapsed: 1.5, Remaining: 0.5, Finished: true},
+	}
+	if !reflect.DeepEqual(expected, r.updates) {
+		t.Errorf("\n-----\nhave\n%#v\nwant\n%#v\n-----", r.updates, expected)
+	}
+	if have, want := r.updates[0].String(), "Downloading setup.msi: 75% of 3.9 KB at 1000 B/s, 1s left"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+}
+
+// TestRun verifies installers are reported until they finish
+func TestRun(t *testing.T) {
+	interval = time.Millisecond
+	r := &recorder{}
+	SetReporters(r)
+	defer func() {
+		interval = time.Second
+		SetReporters()
+	}()
+
+	done := Run("install", "Firefox")
+	time.Sleep(20 * time.Millisecond)
+	done()
+
+	if len(r.updates) < 2 {
+		t.Fatalf("Expected updates while running, have %#v", r.updates)
+	}
+	last := r.updates[len(r.updates)-1]
+	if !last.Finished || last.Action != "install" || last.Name != "Firefox" {
+		t.Errorf("Unexpected final update: %#v", last)
+	}
+	for _, u := range r.updates[:len(r.updates)-1] {
+		if u.Finished {
+			t.Errorf("Only the last update should be finished: %#v", r.updates)
+		}
+	}
+}
+
+// TestReporters verifies the console, log, and JSON reporters
+func TestReporters(t *testing.T) {
+	download := Update{Action: "download", Name: "setup.msi", Bytes: 1 << 30, Total: 4 << 30, Rate: 10 << 20, Elapsed: 2, Remaining: 307}
+	install := Update{Action: "install", Name: "Firefox", Elapsed: 3}
+
+	// The console redraws a single line, clearing what is left of a longer one
+	var buf bytes.Buffer
+	console := Console(&buf)
+	console.Progress(download)
+	console.Progress(install)
+	console.Progress(Update{Action: "install", Name: "Firefox", Elapsed: 4, Finished: true})
+	first, second := "Downloading setup.msi: 25% of 4.0 GB at 10.0 MB/s, 5m7s left", `\ Installing Firefox: 3s`
+	expected := "\r" + first + "\r" + second + strings.Repeat(" ", len(first)-len(second)) + "\rInstalling Firefox: done after 4s\n"
+	if have := buf.String(); have != expected {
+		t.Errorf("\n-----\nhave\n%q\nwant\n%q\n-----", have, expected)
+	}
+
+	// JSON lines can be read back
+	buf.Reset()
+	JSON(&buf).Progress(download)
+	if !strings.HasPrefix(buf.String(), Prefix) {
+		t.Errorf("Missing prefix: %s", buf.String())
+	}
+	if have, ok := Parse(strings.TrimSuffix(buf.String(), "\n")); !ok || !reflect.DeepEqual(have, download) {
+		t.Errorf("have %#v, want %#v", have, download)
+	}
+	if _, ok := Parse("INFO: Installing Firefox"); ok {
+		t.Errorf("Parsed a line that isnt progress")
+	}
+}
+
+// TestSize verifies sizes are shown in the largest unit that fits
+func TestSize(t *testing.T) {
+	for bytes, expected := range map[int64]string{
+		512:     "512 B",
+		1536:    "1.5 KB",
+		5 << 20: "5.0 MB",
+		3 << 40: "3.0 TB",
+	} {
+		if have := Size(bytes); have != expected {
+			t.Errorf("%d: have %s, want %s", bytes, have, expected)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package progress
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/1dustindavis/gorilla/pkg/gorillalog"
+)
+
+// Prefix starts each line written by the JSON reporter, so they can be told apart from the rest of the output
+const Prefix = "PROGRESS "
+
+// console keeps a single line up to date on a terminal
+type console struct {
+	w    io.Writer
+	last int
+}
+
+// Console returns a reporter that redraws the current update on a single line, and moves on once it is finished
+// Installers dont say how far along they are, so they get a spinner instead
+func Console(w io.Writer) Reporter {
+	return &console{w: w}
+}
+
+func (c *console) Progress(u Update) {
+	line := u.String()
+	if !u.Finished && u.Action != "download" {
+		line = fmt.Sprintf("%c %s", `|/-\`[int(u.Elapsed)%4], line)
+	}
+
+	// Clear whatever is left of a longer line
+	padding := ""
+	if c.last > len(line) {
+		padding = strings.Repeat(" ", c.last-len(line))
+	}
+	c.last = len(line)
+	if u.Finished {
+		c.last = 0
+		fmt.Fprint(c.w, "\r", line, padding, "\n")
+		return
+	}
+	fmt.Fprint(c.w, "\r", line, padding)
+}
+
+// logger writes an update to the log every so often
+type logger struct {
+	every  time.Duration
+	logged map[string]time.Time
+}
+
+// Log returns a reporter that logs each download or installer once it has been going for a while, and then every so often
+// Anything that finishes quickly is never logged, since the log already says when it starts and finishes
+func Log(every time.Duration) Reporter {
+	return &logger{every: every, logged: make(map[string]time.Time)}
+}
+
+func (l *logger) Progress(u Update) {
+	key := u.Action + " " + u.Name
+	if u.Finished {
+		delete(l.logged, key)
+		return
+	}
+	last, ok := l.logged[key]
+	if !ok {
+		// Count from when it started, so the first update is logged once it has been going for a while
+		last = timeNow().Add(-time.Duration(u.Elapsed * float64(time.Second)))
+		l.logged[key] = last
+	}
+	if timeNow().Sub(last) >= l.every {
+		l.logged[key] = timeNow()
+		gorillalog.Info(u.String())
+	}
+}
+
+// jsonReporter writes each update as a line of JSON
+type jsonReporter struct {
+	w io.Writer
+}
+
+// JSON returns a reporter that writes each update as a line of JSON after Prefix, for the service to pass along
+func JSON(w io.Writer) Reporter {
+	return jsonReporter{w: w}
+}
+
+func (j jsonReporter) Progress(u Update) {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return
+	}
+	fmt.Fprint(j.w, Prefix, string(data), "\n")
+}
+
+// Parse reads a line written by the JSON reporter, and returns false if the line isnt one
+func Parse(line string) (Update, bool) {
+	var u Update
+	if !strings.HasPrefix(line, Prefix) {
+		return u, false
+	}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, Prefix)), &u); err != nil {
+		return u, false
+	}
+	return u, true
+}
@@ -0,0 +1,22 @@
+//go:build windows
+// +build windows
+
+package report
+
+import (
+	registry "golang.org/x/sys/windows/registry"
+)
+
+// machineID returns the id Windows generated when it was installed
+func machineID() string {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, `SOFTWARE\Microsoft\Cryptography`, registry.QUERY_VALUE|registry.WOW64_64KEY)
+	if err != nil {
+		return ""
+	}
+	defer key.Close()
+	id, _, err := key.GetStringValue("MachineGuid")
+	if err != nil {
+		return ""
+	}
+	return id
+}
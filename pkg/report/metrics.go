@@ -0,0 +1,77 @@
+package report
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Metrics is a small summary of the most recent run for monitoring agents to read
+type Metrics struct {
+	LastRun          string `json:"last_run"`
+	LastResult       string `json:"last_result"`
+	LastSuccess      string `json:"last_success,omitempty"`
+	PendingReboot    bool   `json:"pending_reboot"`
+	InstalledCount   int    `json:"installed_count"`
+	UninstalledCount int    `json:"uninstalled_count"`
+	FailedCount      int    `json:"failed_count"`
+}
+
+// This abstraction allows us to override when testing
+var rebootPending = pendingReboot
+
+// RebootPending returns true if Windows is waiting for a restart
+func RebootPending() bool {
+	return rebootPending()
+}
+
+// WriteMetrics saves a summary of the current run to the provided path
+// The time of the last successful run is carried over from the existing file
+func WriteMetrics(path string) error {
+	// Get the current time
+	currentTime := time.Now().UTC()
+
+	// If fakeTime is not zero, we should use it instead
+	if !fakeTime.IsZero() {
+		currentTime = fakeTime
+	}
+
+	// Start with the previous metrics, so we know when the last success was
+	var metrics Metrics
+	if previous, err := ioutil.ReadFile(path); err == nil {
+		json.Unmarshal(previous, &metrics)
+	}
+
+	metrics.LastRun = currentTime.Format(time.RFC3339)
+	metrics.PendingReboot = rebootPending()
+	metrics.InstalledCount = len(InstalledItems)
+	metrics.UninstalledCount = len(UninstalledItems)
+	metrics.FailedCount = len(FailedItems)
+
+	if runFailed || Interrupted() || len(FailedItems) > 0 {
+		metrics.LastResult = "failure"
+	} else {
+		metrics.LastResult = "success"
+		metrics.LastSuccess = metrics.LastRun
+	}
+
+	metricsJSON, err := json.MarshalIndent(metrics, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so a reader never sees a partial file
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	tempPath := path + ".tmp"
+	err = ioutil.WriteFile(tempPath, metricsJSON, 0644)
+	if err != nil {
+		return err
+	}
+	os.Remove(path)
+	return os.Rename(tempPath, path)
+}
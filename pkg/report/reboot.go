@@ -0,0 +1,33 @@
+//go:build windows
+// +build windows
+
+package report
+
+import (
+	registry "golang.org/x/sys/windows/registry"
+)
+
+// pendingReboot returns true if Windows has recorded that a restart is required
+func pendingReboot() bool {
+	// The existence of either of these keys means a reboot is pending
+	rebootKeys := []string{
+		`SOFTWARE\Microsoft\Windows\CurrentVersion\Component Based Servicing\RebootPending`,
+		`SOFTWARE\Microsoft\Windows\CurrentVersion\WindowsUpdate\Auto Update\RebootRequired`,
+	}
+	for _, rebootKey := range rebootKeys {
+		key, err := registry.OpenKey(registry.LOCAL_MACHINE, rebootKey, registry.QUERY_VALUE)
+		if err == nil {
+			key.Close()
+			return true
+		}
+	}
+
+	// Files waiting to be replaced at the next boot also require a reboot
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Control\Session Manager`, registry.QUERY_VALUE)
+	if err != nil {
+		return false
+	}
+	defer key.Close()
+	renames, _, err := key.GetStringsValue("PendingFileRenameOperations")
+	return err == nil && len(renames) > 0
+}
@@ -20,6 +20,36 @@ var (
 	// UninstalledItems contains a list of items we attempted to uninstall
 	UninstalledItems []interface{}
 
+	// FailedItems contains a list of items that failed to install or uninstall
+	FailedItems []interface{}
+
+	// PendingItems contains a list of items that were deferred until a later run
+	PendingItems []interface{}
+
+	// Errors contains every warning and error logged during the run
+	Errors []interface{}
+
+	// FailureCounts contains the number of times in a row each failing item has failed
+	FailureCounts = make(map[string]int)
+
+	// ItemDurations contains the number of seconds each item took to install or uninstall
+	ItemDurations = make(map[string]float64)
+
+	// ExitCodes contains the exit code of each installer or uninstaller that exited with something other than zero
+	ExitCodes = make(map[string]int)
+
+	// FailureOutput contains the last lines of output from each installer or uninstaller that failed
+	FailureOutput = make(map[string][]string)
+
+	// MetricsFile is the path to save a run summary to, if one is configured
+	MetricsFile string
+
+	// runFailed is true if the run was unable to complete
+	runFailed bool
+
+	// startTime is when the run started, so we know how long it took
+	startTime time.Time
+
 	// fakeTime is used to override currentTime when running tests
 	fakeTime time.Time
 )
@@ -35,7 +65,8 @@ func Start() {
 		currentTime = fakeTime
 	}
 
-	// Add the end time to our map
+	// Add the start time to our map
+	startTime = currentTime
 	Items["StartTime"] = fmt.Sprint(currentTime.Format("2006-01-02 15:04:05 -0700"))
 
 	// Store the current user
@@ -51,14 +82,21 @@ func Start() {
 		fmt.Println("Unable to determine current time", hostErr)
 	}
 	Items["HostName"] = fmt.Sprint(hostName)
+
+	// Store an id that stays the same even if the computer is renamed
+	Items["MachineID"] = machineID()
+}
+
+// Fail records that the run was unable to complete because of its configuration, catalogs, or manifests
+func Fail() {
+	FailWith(ExitConfigError)
 }
 
 // End will compile everything and save to disk
 func End() {
 
 	// Compile everything
-	Items["InstalledItems"] = InstalledItems
-	Items["UninstalledItems"] = UninstalledItems
+	compile()
 
 	// Get the current time
 	currentTime := time.Now().UTC()
@@ -70,6 +108,9 @@ func End() {
 
 	// Add the end time to our map
 	Items["EndTime"] = fmt.Sprint(currentTime.Format("2006-01-02 15:04:05 -0700"))
+	if !startTime.IsZero() {
+		Items["Duration"] = currentTime.Sub(startTime).Seconds()
+	}
 
 	// Convert it all to json
 	reportJSON, marshalErr := json.Marshal(Items)
@@ -84,14 +125,28 @@ func End() {
 		fmt.Println("Unable to write GorillaReport.json to disk:", writeErr)
 	}
 
+	// Write the run summary if it was requested
+	if MetricsFile != "" {
+		metricsErr := WriteMetrics(MetricsFile)
+		if metricsErr != nil {
+			fmt.Println("Unable to write metrics file to disk:", metricsErr)
+		}
+	}
+
+	// Send the report to the server if one is configured
+	if URL != "" {
+		submitErr := Submit(URL, reportJSON)
+		if submitErr != nil {
+			fmt.Println("Unable to send GorillaReport to", URL, submitErr)
+		}
+	}
 }
 
 // Print writes the report to stdout instead of writing to disk
 // Used in check only mode
 func Print() {
 	// Compile everything
-	Items["InstalledItems"] = InstalledItems
-	Items["UninstalledItems"] = UninstalledItems
+	compile()
 
 	reportJSON, marshalErr := json.MarshalIndent(Items, "", "    ")
 	fmt.Println(string(reportJSON))
@@ -99,3 +154,17 @@ func Print() {
 		fmt.Println("Unable to create GorillaReport json", marshalErr)
 	}
 }
+
+// compile adds the items collected during the run to the report
+func compile() {
+	Items["InstalledItems"] = InstalledItems
+	Items["UninstalledItems"] = UninstalledItems
+	Items["FailedItems"] = FailedItems
+	Items["PendingItems"] = PendingItems
+	Items["Errors"] = Errors
+	Items["FailureCounts"] = FailureCounts
+	Items["ItemDurations"] = ItemDurations
+	Items["ExitCodes"] = ExitCodes
+	Items["FailureOutput"] = FailureOutput
+	Items["Interrupted"] = Interrupted()
+}
@@ -1,9 +1,12 @@
 package report
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/user"
+	"path/filepath"
 	"reflect"
 	"testing"
 	"time"
@@ -38,6 +41,8 @@ func TestStart(t *testing.T) {
 
 	expectedItems["HostName"] = fmt.Sprint(expectedHostname)
 
+	expectedItems["MachineID"] = machineID()
+
 	// Run the `Start` function
 	Start()
 
@@ -67,6 +72,15 @@ func TestEnd(t *testing.T) {
 	expectedItems["EndTime"] = fmt.Sprint(expectedTime)
 	expectedItems["InstalledItems"] = InstalledItems
 	expectedItems["UninstalledItems"] = UninstalledItems
+	expectedItems["FailedItems"] = FailedItems
+	expectedItems["PendingItems"] = PendingItems
+	expectedItems["Errors"] = Errors
+	expectedItems["FailureCounts"] = FailureCounts
+	expectedItems["ItemDurations"] = ItemDurations
+	expectedItems["ExitCodes"] = ExitCodes
+	expectedItems["FailureOutput"] = FailureOutput
+	expectedItems["Interrupted"] = false
+	expectedItems["Duration"] = fakeTime.Sub(startTime).Seconds()
 
 	// Run the `End` function
 	End()
@@ -78,3 +92,178 @@ func TestEnd(t *testing.T) {
 		t.Errorf("\n\nExpected:\n\n%#v\n\nReceived:\n\n %#v", expectedItems, Items)
 	}
 }
+
+// TestSubmit validates that the report is sent as json when a url is configured
+func TestSubmit(t *testing.T) {
+	origURL, origPost, origErrors := URL, PostFunc, Errors
+	defer func() { URL, PostFunc, Errors = origURL, origPost, origErrors }()
+
+	var sentURL, sentType string
+	var sent map[string]interface{}
+	URL = "https://example.com/report"
+	PostFunc = func(url string, contentType string, body []byte) error {
+		sentURL, sentType = url, contentType
+		return json.Unmarshal(body, &sent)
+	}
+	Errors = []interface{}{"Unable to check status"}
+
+	End()
+
+	if have, want := sentURL, URL; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+	if have, want := sentType, "application/json"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+	if have, want := sent["HostName"], Items["HostName"]; have != want {
+		t.Errorf("have %v, want %v", have, want)
+	}
+	if have, want := sent["Errors"], []interface{}{"Unable to check status"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %v, want %v", have, want)
+	}
+}
+
+// TestWriteMetrics validates that the metrics file summarizes the run
+// and keeps the time of the last success after a failure
+func TestWriteMetrics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gorilla_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	metricsPath := filepath.Join(dir, "metrics.json")
+
+	// Restore the original data when we are done
+	origInstalled, origUninstalled, origFailed, origTime := InstalledItems, UninstalledItems, FailedItems, fakeTime
+	defer func() {
+		InstalledItems, UninstalledItems, FailedItems, fakeTime, runFailed = origInstalled, origUninstalled, origFailed, origTime, false
+	}()
+
+	// A successful run
+	successTime := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	fakeTime = successTime
+	InstalledItems = []interface{}{"test Installs 1", "test Installs 2"}
+	UninstalledItems = nil
+	FailedItems = nil
+	err = WriteMetrics(metricsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Metrics{
+		LastRun:        "2021-01-02T03:04:05Z",
+		LastResult:     "success",
+		LastSuccess:    "2021-01-02T03:04:05Z",
+		InstalledCount: 2,
+	}
+	if have, want := readMetrics(t, metricsPath), expected; !reflect.DeepEqual(have, want) {
+		t.Errorf("\n\nExpected:\n\n%#v\n\nReceived:\n\n %#v", want, have)
+	}
+
+	// A failed run a day later
+	fakeTime = successTime.Add(24 * time.Hour)
+	FailedItems = []interface{}{"test Installs 2"}
+	err = WriteMetrics(metricsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = Metrics{
+		LastRun:        "2021-01-03T03:04:05Z",
+		LastResult:     "failure",
+		LastSuccess:    "2021-01-02T03:04:05Z",
+		InstalledCount: 2,
+		FailedCount:    1,
+	}
+	if have, want := readMetrics(t, metricsPath), expected; !reflect.DeepEqual(have, want) {
+		t.Errorf("\n\nExpected:\n\n%#v\n\nReceived:\n\n %#v", want, have)
+	}
+}
+
+// readMetrics parses a metrics file for comparison
+func readMetrics(t *testing.T, path string) Metrics {
+	var metrics Metrics
+	metricsJSON, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = json.Unmarshal(metricsJSON, &metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return metrics
+}
+
+// TestExit validates the exit code and summary for each kind of run
+func TestExit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gorilla_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	summaryPath := filepath.Join(dir, "summary.json")
+
+	// Restore the original data when we are done
+	origInstalled, origFailed, origErrors, origExit, origReboot := InstalledItems, FailedItems, Errors, osExit, rebootPending
+	defer func() {
+		InstalledItems, FailedItems, Errors, osExit, rebootPending = origInstalled, origFailed, origErrors, origExit, origReboot
+		SummaryPath, failCode, runFailed, interrupted = "", 0, false, 0
+	}()
+	var exitCode int
+	osExit = func(code int) { exitCode = code }
+	rebootPending = func() bool { return false }
+	SummaryPath = summaryPath
+
+	type item struct{ DisplayName string }
+	InstalledItems = []interface{}{item{"Firefox"}, item{"Chrome"}}
+	FailedItems = nil
+	Errors = nil
+
+	for _, test := range []struct {
+		failed   []interface{}
+		failWith []int
+		want     int
+		result   string
+	}{
+		{nil, nil, ExitSuccess, "success"},
+		{[]interface{}{item{"Chrome"}}, nil, ExitPartialFailure, "partial_failure"},
+		{[]interface{}{item{"Chrome"}}, []int{ExitNetworkError, ExitConfigError}, ExitNetworkError, "network_error"},
+		{nil, []int{ExitBusy}, ExitBusy, "busy"},
+		{nil, []int{ExitNetworkError, ExitInterrupted}, ExitInterrupted, "interrupted"},
+	} {
+		FailedItems, failCode, interrupted = test.failed, 0, 0
+		for _, code := range test.failWith {
+			// An interruption is recorded on its own, and wins over any failure before it
+			if code == ExitInterrupted {
+				Interrupt()
+				continue
+			}
+			FailWith(code)
+		}
+		var cleanedUp bool
+		AtExit(func() { cleanedUp = true })
+		Exit()
+		if have, want := exitCode, test.want; have != want {
+			t.Errorf("have %d, want %d", have, want)
+		}
+		if !cleanedUp {
+			t.Error("Expected cleanup to run before exiting")
+		}
+
+		var summary Summary
+		summaryJSON, err := ioutil.ReadFile(summaryPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(summaryJSON, &summary); err != nil {
+			t.Fatal(err)
+		}
+		if have, want := summary.Result, test.result; have != want {
+			t.Errorf("have %s, want %s", have, want)
+		}
+		if have, want := summary.Installed, []string{"Firefox", "Chrome"}; !reflect.DeepEqual(have, want) {
+			t.Errorf("have %v, want %v", have, want)
+		}
+		if have, want := len(summary.Failed), len(test.failed); have != want {
+			t.Errorf("have %d, want %d", have, want)
+		}
+	}
+}
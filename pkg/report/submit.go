@@ -0,0 +1,18 @@
+package report
+
+var (
+	// URL is where the report is sent at the end of a run, if one is configured
+	URL string
+
+	// PostFunc sends the report to the server, and is usually `download.Post`
+	// The download package depends on this one through config, so it is provided by main
+	PostFunc func(url string, contentType string, body []byte) error
+)
+
+// Submit sends a report to the provided url as json
+func Submit(url string, reportJSON []byte) error {
+	if PostFunc == nil {
+		return nil
+	}
+	return PostFunc(url, "application/json", reportJSON)
+}
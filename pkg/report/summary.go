@@ -0,0 +1,163 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+)
+
+// Exit codes, so wrappers like SCCM, Intune, or RMM scripts can tell how a run went
+const (
+	ExitSuccess        = 0
+	ExitPartialFailure = 1
+	ExitConfigError    = 2
+	ExitNetworkError   = 3
+	ExitBusy           = 4
+	ExitInterrupted    = 5
+)
+
+// exitResults describes each exit code in the summary
+var exitResults = map[int]string{
+	ExitSuccess:        "success",
+	ExitPartialFailure: "partial_failure",
+	ExitConfigError:    "config_error",
+	ExitNetworkError:   "network_error",
+	ExitBusy:           "busy",
+	ExitInterrupted:    "interrupted",
+}
+
+var (
+	// SummaryPath is where to write a JSON summary when the run exits, or "-" for stdout
+	SummaryPath string
+
+	// RestartAction is the restart the installed and removed items are waiting on, like require_restart
+	RestartAction string
+
+	// failCode is why the run was unable to complete, if it was
+	failCode int
+
+	// interrupted is set from a signal handler, so it is only read and written atomically
+	interrupted int32
+
+	// atExit is cleanup that has to happen however the run exits
+	atExit []func()
+
+	// This abstraction allows us to override when testing
+	osExit = os.Exit
+)
+
+// Summary is a machine readable result of a run, for whatever started gorilla to act on
+type Summary struct {
+	Result        string   `json:"result"`
+	ExitCode      int      `json:"exit_code"`
+	Installed     []string `json:"installed"`
+	Uninstalled   []string `json:"uninstalled"`
+	Failed        []string `json:"failed"`
+	Pending       []string `json:"pending"`
+	Errors        []string `json:"errors"`
+	RebootPending bool     `json:"reboot_pending"`
+	RestartAction string   `json:"restart_action,omitempty"`
+}
+
+// FailWith records that the run was unable to complete, and the exit code for why
+// Only the first reason is kept, since later failures are usually caused by it
+func FailWith(code int) {
+	runFailed = true
+	if failCode == 0 {
+		failCode = code
+	}
+}
+
+// Interrupt records that the run was stopped before it finished, such as by Ctrl+C
+// It wins over any other failure, since those are usually caused by the interruption
+func Interrupt() {
+	atomic.StoreInt32(&interrupted, 1)
+}
+
+// Interrupted returns true if the run was stopped before it finished
+func Interrupted() bool {
+	return atomic.LoadInt32(&interrupted) == 1
+}
+
+// ExitCode returns the code the run should exit with
+func ExitCode() int {
+	if Interrupted() {
+		return ExitInterrupted
+	}
+	if failCode != 0 {
+		return failCode
+	}
+	if len(FailedItems) > 0 {
+		return ExitPartialFailure
+	}
+	return ExitSuccess
+}
+
+// AtExit adds cleanup to run when Exit is called, since deferred functions never run after os.Exit
+func AtExit(f func()) {
+	atExit = append(atExit, f)
+}
+
+// Exit writes the summary if one was requested, and then exits with the code for the run
+func Exit() {
+	for _, f := range atExit {
+		f()
+	}
+	atExit = nil
+	if SummaryPath != "" {
+		if err := WriteSummary(SummaryPath); err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to write summary:", err)
+		}
+	}
+	osExit(ExitCode())
+}
+
+// WriteSummary writes a summary of the run to a path, or to stdout if the path is "-"
+func WriteSummary(path string) error {
+	code := ExitCode()
+	summary := Summary{
+		Result:        exitResults[code],
+		ExitCode:      code,
+		Installed:     itemNames(InstalledItems),
+		Uninstalled:   itemNames(UninstalledItems),
+		Failed:        itemNames(FailedItems),
+		Pending:       itemNames(PendingItems),
+		Errors:        itemNames(Errors),
+		RebootPending: rebootPending(),
+		RestartAction: RestartAction,
+	}
+	summaryJSON, err := json.MarshalIndent(summary, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	if path == "-" {
+		fmt.Println(string(summaryJSON))
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, summaryJSON, 0644)
+}
+
+// itemNames returns the display name of each item, or the item itself if it doesnt have one
+// Items are recorded as interfaces, since the catalog package depends on this one
+func itemNames(items []interface{}) []string {
+	names := []string{}
+	for _, item := range items {
+		value := reflect.ValueOf(item)
+		if value.Kind() == reflect.Struct {
+			if name := value.FieldByName("DisplayName"); name.IsValid() && name.Kind() == reflect.String {
+				names = append(names, name.String())
+				continue
+			}
+		}
+		names = append(names, fmt.Sprint(item))
+	}
+	return names
+}
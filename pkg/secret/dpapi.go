@@ -0,0 +1,48 @@
+//go:build windows
+// +build windows
+
+package secret
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// dpapiFlags use the machine's key instead of a user's, since gorilla usually runs as SYSTEM
+// Any user on the computer can decrypt the value, but it is useless if the config file is copied elsewhere
+const dpapiFlags = windows.CRYPTPROTECT_LOCAL_MACHINE | windows.CRYPTPROTECT_UI_FORBIDDEN
+
+// dpapiProtect encrypts data with DPAPI
+func dpapiProtect(data []byte) ([]byte, error) {
+	var out windows.DataBlob
+	if err := windows.CryptProtectData(newBlob(data), nil, nil, 0, nil, dpapiFlags, &out); err != nil {
+		return nil, err
+	}
+	return blobBytes(&out), nil
+}
+
+// dpapiUnprotect decrypts data that was encrypted with DPAPI
+func dpapiUnprotect(data []byte) ([]byte, error) {
+	var out windows.DataBlob
+	if err := windows.CryptUnprotectData(newBlob(data), nil, nil, 0, nil, dpapiFlags, &out); err != nil {
+		return nil, err
+	}
+	return blobBytes(&out), nil
+}
+
+// newBlob points a blob at our data
+func newBlob(data []byte) *windows.DataBlob {
+	if len(data) == 0 {
+		return &windows.DataBlob{}
+	}
+	return &windows.DataBlob{Size: uint32(len(data)), Data: &data[0]}
+}
+
+// blobBytes copies the data windows allocated for a blob, and then frees it
+func blobBytes(blob *windows.DataBlob) []byte {
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(blob.Data)))
+	data := make([]byte, blob.Size)
+	copy(data, (*[1 << 30]byte)(unsafe.Pointer(blob.Data))[:blob.Size:blob.Size])
+	return data
+}
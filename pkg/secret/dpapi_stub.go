@@ -0,0 +1,16 @@
+// Without a darwin specific build, go tools will try to include Windows libraries and fail
+
+//go:build !windows
+// +build !windows
+
+package secret
+
+import "fmt"
+
+func dpapiProtect(data []byte) ([]byte, error) {
+	return nil, fmt.Errorf("DPAPI is not supported on this platform")
+}
+
+func dpapiUnprotect(data []byte) ([]byte, error) {
+	return nil, fmt.Errorf("DPAPI is not supported on this platform")
+}
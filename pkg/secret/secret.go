@@ -0,0 +1,41 @@
+package secret
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// Prefix marks a value in the config file that was encrypted with Protect
+const Prefix = "dpapi:"
+
+// These abstractions allows us to override when testing
+var (
+	protect   = dpapiProtect
+	unprotect = dpapiUnprotect
+)
+
+// Protect encrypts a value so only this computer can decrypt it, and returns the text to put in a config file
+func Protect(value string) (string, error) {
+	data, err := protect([]byte(value))
+	if err != nil {
+		return "", err
+	}
+	return Prefix + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// Reveal decrypts a value that was encrypted with Protect, and returns any other value as it is
+func Reveal(value string) (string, error) {
+	if !strings.HasPrefix(value, Prefix) {
+		return value, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, Prefix))
+	if err != nil {
+		return "", fmt.Errorf("invalid encrypted value: %v", err)
+	}
+	plain, err := unprotect(data)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
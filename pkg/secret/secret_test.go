@@ -0,0 +1,54 @@
+package secret
+
+import (
+	"strings"
+	"testing"
+)
+
+// fakeProtect reverses the data, which is enough to tell whether it was protected
+func fakeProtect(data []byte) ([]byte, error) {
+	reversed := make([]byte, len(data))
+	for i, b := range data {
+		reversed[len(data)-1-i] = b
+	}
+	return reversed, nil
+}
+
+// TestProtect verifies a protected value is revealed as the original, and other values are left alone
+func TestProtect(t *testing.T) {
+	origProtect, origUnprotect := protect, unprotect
+	defer func() { protect, unprotect = origProtect, origUnprotect }()
+	protect, unprotect = fakeProtect, fakeProtect
+
+	protected, err := Protect("pizza")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := protected, "dpapi:YXp6aXA="; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+	if strings.Contains(protected, "pizza") {
+		t.Error("Protected value contains the original")
+	}
+
+	revealed, err := Reveal(protected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := revealed, "pizza"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+
+	// Values that were never protected are still allowed
+	revealed, err = Reveal("plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := revealed, "plain"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+
+	if _, err := Reveal("dpapi:not base64!"); err == nil {
+		t.Error("Expected an error for an invalid encrypted value")
+	}
+}
@@ -0,0 +1,260 @@
+package signature
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// Extension is added to the name of a signed file to get the name of its signature
+const Extension = ".sig"
+
+// ErrInvalid means the signature was not made by the private key that matches the public key
+var ErrInvalid = errors.New("signature is not valid")
+
+// Verify checks a detached signature of a message against a public key
+// The key may be a minisign public key, or a PEM encoded x509 certificate or public key.
+// Minisign signatures are the `.minisig` files created by `minisign -S`.
+// X509 signatures are an RSA or ECDSA signature of the message's SHA-256 hash,
+// such as one created by `openssl dgst -sha256 -sign`, either raw or base64 encoded.
+func Verify(publicKey []byte, message io.Reader, sig []byte) error {
+	if block, _ := pem.Decode(publicKey); block != nil {
+		return verifyX509(block, message, sig)
+	}
+	return verifyMinisign(publicKey, message, sig)
+}
+
+// VerifyFile checks a detached signature of a file against a public key
+func VerifyFile(publicKey []byte, path string, sig []byte) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return Verify(publicKey, f, sig)
+}
+
+// VerifyURL checks data downloaded from a url against the signature next to it, which is downloaded with get
+// Nothing is checked without a key, so catalogs and manifests are only verified when a metadata key is configured
+func VerifyURL(keyPath string, rawURL string, data []byte, get func(string) ([]byte, error)) error {
+	if keyPath == "" {
+		return nil
+	}
+	publicKey, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return err
+	}
+	sig, err := get(rawURL + Extension)
+	if err != nil {
+		return err
+	}
+	return Verify(publicKey, bytes.NewReader(data), sig)
+}
+
+// minisignLines returns the lines of a minisign key or signature, without the untrusted comment
+func minisignLines(data []byte) (lines []string) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "untrusted comment:") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// parseMinisignKey returns the key id and the ed25519 key from a minisign public key
+func parseMinisignKey(publicKey []byte) (keyID []byte, key ed25519.PublicKey, err error) {
+	lines := minisignLines(publicKey)
+	if len(lines) == 0 {
+		return nil, nil, fmt.Errorf("public key is empty")
+	}
+	raw, err := base64.StdEncoding.DecodeString(lines[0])
+	if err != nil || len(raw) != 2+8+ed25519.PublicKeySize || string(raw[:2]) != "Ed" {
+		return nil, nil, fmt.Errorf("public key is not a minisign or PEM public key")
+	}
+	return raw[2:10], ed25519.PublicKey(raw[10:]), nil
+}
+
+// verifyMinisign checks a minisign signature, including the signature of its trusted comment
+func verifyMinisign(publicKey []byte, message io.Reader, sig []byte) error {
+	keyID, key, err := parseMinisignKey(publicKey)
+	if err != nil {
+		return err
+	}
+
+	lines := minisignLines(sig)
+	if len(lines) < 3 || !strings.HasPrefix(lines[1], "trusted comment: ") {
+		return fmt.Errorf("signature is not a minisign signature")
+	}
+	raw, err := base64.StdEncoding.DecodeString(lines[0])
+	if err != nil || len(raw) != 2+8+ed25519.SignatureSize {
+		return fmt.Errorf("signature is not a minisign signature")
+	}
+	algorithm, sigKeyID, signature := string(raw[:2]), raw[2:10], raw[10:]
+	if !bytes.Equal(keyID, sigKeyID) {
+		return fmt.Errorf("signature was made with a different key: %w", ErrInvalid)
+	}
+
+	// Current versions sign a hash of the file, older versions sign the file itself
+	var signed []byte
+	switch algorithm {
+	case "ED":
+		h, err := blake2b.New512(nil)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(h, message); err != nil {
+			return err
+		}
+		signed = h.Sum(nil)
+	case "Ed":
+		signed, err = ioutil.ReadAll(message)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported minisign algorithm: %s", algorithm)
+	}
+	if !ed25519.Verify(key, signed, signature) {
+		return ErrInvalid
+	}
+
+	// The trusted comment is signed along with the signature, so it cant be changed either
+	comment := strings.TrimPrefix(lines[1], "trusted comment: ")
+	globalSignature, err := base64.StdEncoding.DecodeString(lines[2])
+	if err != nil || !ed25519.Verify(key, append(signature, comment...), globalSignature) {
+		return fmt.Errorf("trusted comment has been modified: %w", ErrInvalid)
+	}
+	return nil
+}
+
+// verifyX509 checks an RSA or ECDSA signature of the message's SHA-256 hash
+func verifyX509(block *pem.Block, message io.Reader, sig []byte) error {
+	var publicKey crypto.PublicKey
+	var err error
+	switch block.Type {
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		cert, err = x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			publicKey = cert.PublicKey
+		}
+	case "PUBLIC KEY":
+		publicKey, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		publicKey, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return fmt.Errorf("unsupported PEM type: %s", block.Type)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Signatures are often copied around as base64
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig))); err == nil {
+		sig = decoded
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, message); err != nil {
+		return err
+	}
+	digest := h.Sum(nil)
+
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest, sig) == nil || rsa.VerifyPSS(key, crypto.SHA256, digest, sig, nil) == nil {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		if ecdsa.VerifyASN1(key, digest, sig) {
+			return nil
+		}
+	default:
+		return fmt.Errorf("unsupported public key type: %T", publicKey)
+	}
+	return ErrInvalid
+}
+
+// Sign creates a detached signature of a message with a PEM encoded RSA or ECDSA private key
+// The signature is base64 encoded, and can be checked with Verify and the matching public key.
+func Sign(privateKey []byte, message io.Reader) ([]byte, error) {
+	block, _ := pem.Decode(privateKey)
+	if block == nil {
+		return nil, errors.New("private key is not PEM encoded")
+	}
+	var key crypto.Signer
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := parsed.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type: %T", parsed)
+		}
+		key = signer
+	case "RSA PRIVATE KEY":
+		parsed, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key = parsed
+	case "EC PRIVATE KEY":
+		parsed, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key = parsed
+	default:
+		return nil, fmt.Errorf("unsupported PEM type: %s", block.Type)
+	}
+	switch key.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey:
+	default:
+		return nil, fmt.Errorf("unsupported private key type: %T", key)
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, message); err != nil {
+		return nil, err
+	}
+	sig, err := key.Sign(rand.Reader, h.Sum(nil), crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(base64.StdEncoding.EncodeToString(sig) + "\n"), nil
+}
+
+// SignFile writes a detached signature of a file next to it, named with the `.sig` extension
+func SignFile(privateKey []byte, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sig, err := Sign(privateKey, f)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path+Extension, sig, 0644)
+}
@@ -0,0 +1,222 @@
+package signature
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// minisignKey returns a minisign public key, and a function to sign a message with it the way `minisign -S` does
+func minisignKey(t *testing.T, keyID string) ([]byte, func(message []byte, prehash bool) []byte) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encodedKey := base64.StdEncoding.EncodeToString(append([]byte("Ed"+keyID), publicKey...))
+	sign := func(message []byte, prehash bool) []byte {
+		algorithm := "Ed"
+		if prehash {
+			algorithm = "ED"
+			sum := blake2b.Sum512(message)
+			message = sum[:]
+		}
+		signature := ed25519.Sign(privateKey, message)
+		comment := "timestamp:1700000000\tfile:tool.msi"
+		global := ed25519.Sign(privateKey, append(append([]byte{}, signature...), comment...))
+		return []byte("untrusted comment: signature from minisign secret key\n" +
+			base64.StdEncoding.EncodeToString(append([]byte(algorithm+keyID), signature...)) + "\n" +
+			"trusted comment: " + comment + "\n" +
+			base64.StdEncoding.EncodeToString(global) + "\n")
+	}
+	return []byte("untrusted comment: minisign public key 4F2A\n" + encodedKey + "\n"), sign
+}
+
+// TestVerifyMinisign validates minisign signatures, in both the current and legacy formats
+func TestVerifyMinisign(t *testing.T) {
+	message := []byte("gorilla")
+	publicKey, sign := minisignKey(t, "12345678")
+
+	for _, prehash := range []bool{true, false} {
+		sig := sign(message, prehash)
+		if err := Verify(publicKey, bytes.NewReader(message), sig); err != nil {
+			t.Errorf("prehash %v: valid signature was rejected: %v", prehash, err)
+		}
+		if err := Verify(publicKey, bytes.NewReader([]byte("gorilla!")), sig); !errors.Is(err, ErrInvalid) {
+			t.Errorf("prehash %v: signature of a different message was accepted: %v", prehash, err)
+		}
+	}
+
+	// A changed trusted comment is rejected
+	sig := bytes.Replace(sign(message, true), []byte("file:tool.msi"), []byte("file:evil.msi"), 1)
+	if err := Verify(publicKey, bytes.NewReader(message), sig); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Expected a modified trusted comment to be rejected: %v", err)
+	}
+
+	// A signature from another key is rejected
+	_, otherSign := minisignKey(t, "87654321")
+	if err := Verify(publicKey, bytes.NewReader(message), otherSign(message, true)); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Expected a signature from another key to be rejected: %v", err)
+	}
+}
+
+// TestVerifyX509 validates RSA and ECDSA signatures of a SHA-256 hash, raw or base64 encoded
+func TestVerifyX509(t *testing.T) {
+	message := []byte("gorilla")
+	digest := sha256.Sum256(message)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaSig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecSig, err := ecdsa.SignASN1(rand.Reader, ecKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, test := range map[string]struct {
+		publicKey crypto.PublicKey
+		sig       []byte
+	}{
+		"rsa":          {&rsaKey.PublicKey, rsaSig},
+		"ecdsa":        {&ecKey.PublicKey, ecSig},
+		"ecdsa base64": {&ecKey.PublicKey, []byte(base64.StdEncoding.EncodeToString(ecSig) + "\n")},
+	} {
+		der, err := x509.MarshalPKIXPublicKey(test.publicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		publicKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+		if err := Verify(publicKey, bytes.NewReader(message), test.sig); err != nil {
+			t.Errorf("%s: valid signature was rejected: %v", name, err)
+		}
+		if err := Verify(publicKey, strings.NewReader("gorilla!"), test.sig); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: signature of a different message was accepted: %v", name, err)
+		}
+	}
+}
+
+// TestSign validates that signatures made with RSA and ECDSA private keys can be verified
+func TestSign(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8DER, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, test := range map[string]struct {
+		privateKey *pem.Block
+		publicKey  crypto.PublicKey
+	}{
+		"rsa":   {&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}, &rsaKey.PublicKey},
+		"ecdsa": {&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER}, &ecKey.PublicKey},
+		"pkcs8": {&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8DER}, &ecKey.PublicKey},
+	} {
+		sig, err := Sign(pem.EncodeToMemory(test.privateKey), strings.NewReader("gorilla"))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		der, err := x509.MarshalPKIXPublicKey(test.publicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		publicKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+		if err := Verify(publicKey, strings.NewReader("gorilla"), sig); err != nil {
+			t.Errorf("%s: signature was rejected: %v", name, err)
+		}
+	}
+
+	if _, err := Sign([]byte("not a key"), strings.NewReader("gorilla")); err == nil {
+		t.Error("Expected an invalid private key to be rejected")
+	}
+}
+
+// TestVerifyURL verifies downloaded data is only trusted with a valid signature next to it when a key is configured
+func TestVerifyURL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gorilla_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "metadata.pem")
+	err = ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey}), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifestURL := "https://example.com/manifests/example_manifest.yaml"
+	yamlFile := []byte("name: example_manifest\n")
+	sig, err := Sign(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: privateKey}), bytes.NewReader(yamlFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(url string) ([]byte, error) {
+		if url == manifestURL+Extension {
+			return sig, nil
+		}
+		return nil, fmt.Errorf("404: %s", url)
+	}
+
+	// Without a key, nothing is checked
+	if err := VerifyURL("", manifestURL, []byte("changed"), get); err != nil {
+		t.Errorf("Expected the manifest to be trusted without a key: %v", err)
+	}
+
+	if err := VerifyURL(keyPath, manifestURL, yamlFile, get); err != nil {
+		t.Errorf("Expected a valid signature: %v", err)
+	}
+	if err := VerifyURL(keyPath, manifestURL, []byte("changed"), get); err == nil {
+		t.Error("Expected a modified manifest to be rejected")
+	}
+	if err := VerifyURL(keyPath, "https://example.com/manifests/included_manifest.yaml", yamlFile, get); err == nil {
+		t.Error("Expected a manifest without a signature to be rejected")
+	}
+}
@@ -0,0 +1,192 @@
+package state
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Item is the history of attempts to install or uninstall a single item
+type Item struct {
+	Action      string    `json:"action"`
+	Version     string    `json:"version,omitempty"`
+	Hash        string    `json:"hash,omitempty"`
+	Attempts    int       `json:"attempts"`
+	Failures    int       `json:"failures"`
+	LastAttempt time.Time `json:"last_attempt"`
+	LastResult  string    `json:"last_result"`
+	LastSuccess time.Time `json:"last_success"`
+
+	// DependencyOf lists the items this one was installed to satisfy, if it was only installed as a dependency
+	DependencyOf []string `json:"dependency_of,omitempty"`
+
+	// Requested is true when someone asked for an optional install, which is then managed like any other install
+	Requested bool `json:"requested,omitempty"`
+}
+
+// maxBackoff is the longest we will wait between attempts, no matter how many have failed
+const maxBackoff = 24 * time.Hour
+
+var (
+	// items contains the history of every item we have attempted, by display name
+	items = make(map[string]Item)
+
+	// statePath is where the state is saved, if it was loaded from disk
+	statePath string
+
+	// fakeTime is used to override currentTime when running tests
+	fakeTime time.Time
+)
+
+// Path returns the path of the state file within the app data directory
+func Path(appDataPath string) string {
+	return filepath.Join(appDataPath, "state.json")
+}
+
+// Load reads the saved state from the app data directory, which is also where Save writes it
+// A missing state file is not an error, since it is only created after the first attempt
+func Load(appDataPath string) error {
+	statePath = Path(appDataPath)
+	items = make(map[string]Item)
+
+	stateJSON, err := ioutil.ReadFile(statePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	err = json.Unmarshal(stateJSON, &items)
+	if err != nil {
+		items = make(map[string]Item)
+	}
+	return err
+}
+
+// Get returns the history of an item, which is empty if it has never been attempted
+func Get(name string) Item {
+	return items[name]
+}
+
+// Matches returns true if the history is for the same action and installer
+func (item Item) Matches(action, version, hash string) bool {
+	return item.Action == action && item.Version == version && item.Hash == hash
+}
+
+// Removed returns true if the item's last attempt was a successful uninstall
+func (item Item) Removed() bool {
+	return item.Action == "uninstall" && item.LastResult == "success"
+}
+
+// Interval returns how long to wait after the last failure, doubling with each consecutive failure
+func (item Item) Interval(baseMinutes int) time.Duration {
+	interval := time.Duration(baseMinutes) * time.Minute
+	for i := 1; i < item.Failures && interval < maxBackoff; i++ {
+		interval *= 2
+	}
+	if interval > maxBackoff {
+		interval = maxBackoff
+	}
+	return interval
+}
+
+// Remaining returns how much longer we should wait before attempting the item again
+func (item Item) Remaining(baseMinutes int) time.Duration {
+	if item.Failures == 0 {
+		return 0
+	}
+
+	// Get the current time
+	currentTime := time.Now().UTC()
+
+	// If fakeTime is not zero, we should use it instead
+	if !fakeTime.IsZero() {
+		currentTime = fakeTime
+	}
+
+	return item.LastAttempt.Add(item.Interval(baseMinutes)).Sub(currentTime)
+}
+
+// Record adds an attempt to an item's history and returns the updated history
+// Failures only counts consecutive failures of the same version and hash,
+// so a new installer starts with a clean slate
+func Record(name, action, version, hash string, success bool) Item {
+	// Get the current time
+	currentTime := time.Now().UTC()
+
+	// If fakeTime is not zero, we should use it instead
+	if !fakeTime.IsZero() {
+		currentTime = fakeTime
+	}
+
+	item := items[name]
+	if !item.Matches(action, version, hash) {
+		item.Failures = 0
+	}
+	item.Action = action
+	item.Version = version
+	item.Hash = hash
+	item.Attempts++
+	item.LastAttempt = currentTime
+	if success {
+		item.Failures = 0
+		item.LastResult = "success"
+		item.LastSuccess = currentTime
+		// Once it is removed, nothing needs it anymore
+		if action == "uninstall" {
+			item.DependencyOf = nil
+		}
+	} else {
+		item.Failures++
+		item.LastResult = "failure"
+	}
+
+	items[name] = item
+	return item
+}
+
+// AddDependencyOf records that an item was installed to satisfy another item's dependencies
+func AddDependencyOf(name, parent string) {
+	item := items[name]
+	for _, existing := range item.DependencyOf {
+		if existing == parent {
+			return
+		}
+	}
+	item.DependencyOf = append(item.DependencyOf, parent)
+	items[name] = item
+}
+
+// SetRequested records whether someone has asked for an optional install
+func SetRequested(name string, requested bool) {
+	item := items[name]
+	item.Requested = requested
+	items[name] = item
+}
+
+// Save writes the state back to the file it was loaded from
+// Nothing is written if the state was never loaded, such as in check only mode
+func Save() error {
+	if statePath == "" {
+		return nil
+	}
+
+	stateJSON, err := json.MarshalIndent(items, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so a crash never leaves a partial file
+	err = os.MkdirAll(filepath.Dir(statePath), 0755)
+	if err != nil {
+		return err
+	}
+	tempPath := statePath + ".tmp"
+	err = ioutil.WriteFile(tempPath, stateJSON, 0644)
+	if err != nil {
+		return err
+	}
+	os.Remove(statePath)
+	return os.Rename(tempPath, statePath)
+}
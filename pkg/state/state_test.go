@@ -0,0 +1,144 @@
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestRecord validates that failures are counted until a success or a new installer
+func TestRecord(t *testing.T) {
+	origItems, origTime := items, fakeTime
+	defer func() { items, fakeTime = origItems, origTime }()
+	items = make(map[string]Item)
+	fakeTime = time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	Record("Tool", "install", "1.0", "abc", false)
+	Record("Tool", "install", "1.0", "abc", false)
+	expected := Item{
+		Action:      "install",
+		Version:     "1.0",
+		Hash:        "abc",
+		Attempts:    2,
+		Failures:    2,
+		LastAttempt: fakeTime,
+		LastResult:  "failure",
+	}
+	if have, want := Get("Tool"), expected; !reflect.DeepEqual(have, want) {
+		t.Errorf("\n\nExpected:\n\n%#v\n\nReceived:\n\n %#v", want, have)
+	}
+
+	// A new version starts counting failures again
+	if have, want := Record("Tool", "install", "1.1", "def", false).Failures, 1; have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+
+	// A success resets the failures
+	fakeTime = fakeTime.Add(time.Hour)
+	expected = Item{
+		Action:      "install",
+		Version:     "1.1",
+		Hash:        "def",
+		Attempts:    4,
+		LastAttempt: fakeTime,
+		LastResult:  "success",
+		LastSuccess: fakeTime,
+	}
+	if have, want := Record("Tool", "install", "1.1", "def", true), expected; !reflect.DeepEqual(have, want) {
+		t.Errorf("\n\nExpected:\n\n%#v\n\nReceived:\n\n %#v", want, have)
+	}
+
+	// Items we have never attempted have no history
+	if have, want := Get("Other"), (Item{}); !reflect.DeepEqual(have, want) {
+		t.Errorf("\n\nExpected:\n\n%#v\n\nReceived:\n\n %#v", want, have)
+	}
+}
+
+// TestDependencyOf validates that dependencies are tracked until they are uninstalled
+func TestDependencyOf(t *testing.T) {
+	origItems, origTime := items, fakeTime
+	defer func() { items, fakeTime = origItems, origTime }()
+	items = make(map[string]Item)
+	fakeTime = time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	Record("Runtime", "install", "1.0", "abc", true)
+	AddDependencyOf("Runtime", "App")
+	AddDependencyOf("Runtime", "Tool")
+	AddDependencyOf("Runtime", "App")
+	if have, want := Get("Runtime").DependencyOf, []string{"App", "Tool"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %#v, want %#v", have, want)
+	}
+
+	// A failed uninstall leaves it tracked
+	Record("Runtime", "uninstall", "1.0", "", false)
+	if Get("Runtime").Removed() || len(Get("Runtime").DependencyOf) != 2 {
+		t.Errorf("Expected a failed uninstall to keep the dependency: %#v", Get("Runtime"))
+	}
+
+	Record("Runtime", "uninstall", "1.0", "", true)
+	if !Get("Runtime").Removed() || Get("Runtime").DependencyOf != nil {
+		t.Errorf("Expected an uninstall to clear the dependency: %#v", Get("Runtime"))
+	}
+}
+
+// TestSaveLoad validates that the state is kept between runs
+func TestSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gorilla_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	origItems, origPath, origTime := items, statePath, fakeTime
+	defer func() { items, statePath, fakeTime = origItems, origPath, origTime }()
+	fakeTime = time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// A missing state file is an empty state
+	err = Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 0 {
+		t.Errorf("Expected an empty state: %v", items)
+	}
+
+	expected := Record("Tool", "install", "1.0", "abc", false)
+	err = Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := Get("Tool"), expected; !reflect.DeepEqual(have, want) {
+		t.Errorf("\n\nExpected:\n\n%#v\n\nReceived:\n\n %#v", want, have)
+	}
+}
+
+// TestRemaining validates that the wait doubles with each failure, up to a day
+func TestRemaining(t *testing.T) {
+	origTime := fakeTime
+	defer func() { fakeTime = origTime }()
+	lastAttempt := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	fakeTime = lastAttempt.Add(10 * time.Minute)
+
+	tests := []struct {
+		failures int
+		expected time.Duration
+	}{
+		{0, 0},
+		{1, 50 * time.Minute},
+		{3, 230 * time.Minute},
+		{20, 24*time.Hour - 10*time.Minute},
+	}
+	for _, test := range tests {
+		item := Item{Failures: test.failures, LastAttempt: lastAttempt}
+		if have, want := item.Remaining(60), test.expected; have != want {
+			t.Errorf("%d failures: have %v, want %v", test.failures, have, want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+//go:build windows
+// +build windows
+
+package status
+
+import (
+	"fmt"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Machine types returned by IsWow64Process2
+const (
+	imageFileMachineI386  = 0x014c
+	imageFileMachineAMD64 = 0x8664
+	imageFileMachineARM64 = 0xaa64
+)
+
+var procIsWow64Process2 = windows.NewLazySystemDLL("kernel32.dll").NewProc("IsWow64Process2")
+
+// windowsVersion returns the running Windows version, like `10.0.22631`
+// RtlGetVersion is used since GetVersionEx reports whatever version the manifest asks for
+func windowsVersion() (string, error) {
+	info := windows.RtlGetVersion()
+	if info == nil || info.MajorVersion == 0 {
+		return "", fmt.Errorf("RtlGetVersion returned no version")
+	}
+	return fmt.Sprintf("%d.%d.%d", info.MajorVersion, info.MinorVersion, info.BuildNumber), nil
+}
+
+// nativeArchitecture returns the architecture of Windows itself, even when gorilla runs emulated
+// An x64 build on an arm64 computer would otherwise report x64
+func nativeArchitecture() string {
+	if procIsWow64Process2.Find() == nil {
+		var processMachine, nativeMachine uint16
+		r, _, _ := procIsWow64Process2.Call(uintptr(windows.CurrentProcess()), uintptr(unsafe.Pointer(&processMachine)), uintptr(unsafe.Pointer(&nativeMachine)))
+		if r != 0 {
+			switch nativeMachine {
+			case imageFileMachineAMD64:
+				return "x64"
+			case imageFileMachineARM64:
+				return "arm64"
+			case imageFileMachineI386:
+				return "x86"
+			}
+		}
+	}
+
+	// Older versions of Windows dont have IsWow64Process2, so fall back to the environment
+	if arch := os.Getenv("PROCESSOR_ARCHITEW6432"); arch != "" {
+		return normalizeArchitecture(arch)
+	}
+	if arch := os.Getenv("PROCESSOR_ARCHITECTURE"); arch != "" {
+		return normalizeArchitecture(arch)
+	}
+	return goArchitecture()
+}
@@ -0,0 +1,18 @@
+// Without a darwin specific build, go tools will try to include Windows libraries and fail
+
+//go:build !windows
+// +build !windows
+
+package status
+
+import "fmt"
+
+// windowsVersion is just a placeholder on darwin
+func windowsVersion() (string, error) {
+	return "", fmt.Errorf("windows version only supported on Windows")
+}
+
+// nativeArchitecture returns the architecture gorilla was built for
+func nativeArchitecture() string {
+	return goArchitecture()
+}
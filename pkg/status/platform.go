@@ -0,0 +1,66 @@
+package status
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/1dustindavis/gorilla/pkg/catalog"
+)
+
+// Abstracted functions so we can override these in unit tests
+var (
+	osVersion      = windowsVersion
+	osArchitecture = nativeArchitecture
+)
+
+// normalizeArchitecture returns the name we use for an architecture, so `amd64`, `x86_64`, and `x64` all match
+func normalizeArchitecture(arch string) string {
+	switch strings.ToLower(strings.TrimSpace(arch)) {
+	case "x64", "amd64", "x86_64":
+		return "x64"
+	case "arm64", "aarch64":
+		return "arm64"
+	case "x86", "386", "i386", "i686":
+		return "x86"
+	}
+	return strings.ToLower(strings.TrimSpace(arch))
+}
+
+// goArchitecture returns the architecture gorilla was built for, for when the native one cant be found
+func goArchitecture() string {
+	return normalizeArchitecture(runtime.GOARCH)
+}
+
+// Incompatible returns why an item cant be installed on this computer, or an empty string if it can
+// An item can limit the Windows versions and the architectures it supports
+func Incompatible(catalogItem catalog.Item) string {
+	if len(catalogItem.SupportedArchitectures) > 0 {
+		arch := osArchitecture()
+		supported := false
+		for _, a := range catalogItem.SupportedArchitectures {
+			if normalizeArchitecture(a) == arch {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			return fmt.Sprintf("it supports %s, and this computer is %s", strings.Join(catalogItem.SupportedArchitectures, ", "), arch)
+		}
+	}
+
+	if catalogItem.MinimumOSVersion == "" && catalogItem.MaximumOSVersion == "" {
+		return ""
+	}
+	current, err := osVersion()
+	if err != nil {
+		return fmt.Sprintf("the Windows version could not be found: %v", err)
+	}
+	if catalogItem.MinimumOSVersion != "" && compareVersions(current, catalogItem.MinimumOSVersion) < 0 {
+		return fmt.Sprintf("it requires Windows %s or newer, and this computer has %s", catalogItem.MinimumOSVersion, current)
+	}
+	if catalogItem.MaximumOSVersion != "" && compareVersions(current, catalogItem.MaximumOSVersion) > 0 {
+		return fmt.Sprintf("it requires Windows %s or older, and this computer has %s", catalogItem.MaximumOSVersion, current)
+	}
+	return ""
+}
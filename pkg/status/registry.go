@@ -4,6 +4,11 @@
 package status
 
 import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
 	"github.com/1dustindavis/gorilla/pkg/gorillalog"
 	registry "golang.org/x/sys/windows/registry"
 )
@@ -100,3 +105,68 @@ func getUninstallKeys() (installedItems map[string]RegistryApplication, checkErr
 	}
 	return installedItems, checkErr
 }
+
+// registryRoots maps the names a registry path may start with to their root keys
+var registryRoots = map[string]registry.Key{
+	"HKLM":                registry.LOCAL_MACHINE,
+	"HKEY_LOCAL_MACHINE":  registry.LOCAL_MACHINE,
+	"HKCU":                registry.CURRENT_USER,
+	"HKEY_CURRENT_USER":   registry.CURRENT_USER,
+	"HKCR":                registry.CLASSES_ROOT,
+	"HKEY_CLASSES_ROOT":   registry.CLASSES_ROOT,
+	"HKU":                 registry.USERS,
+	"HKEY_USERS":          registry.USERS,
+	"HKCC":                registry.CURRENT_CONFIG,
+	"HKEY_CURRENT_CONFIG": registry.CURRENT_CONFIG,
+}
+
+// readRegistryValue returns a registry value's data as a string, and whether the value exists
+// An empty name only checks that the key exists. The 64 bit view is used, so 32 bit apps are under `Wow6432Node`.
+func readRegistryValue(path string, name string) (data string, exists bool, err error) {
+	parts := strings.SplitN(strings.Trim(path, `\`), `\`, 2)
+	root, ok := registryRoots[strings.ToUpper(parts[0])]
+	if !ok {
+		return "", false, fmt.Errorf("unknown registry root: %s", parts[0])
+	}
+	subKey := ""
+	if len(parts) > 1 {
+		subKey = parts[1]
+	}
+
+	key, err := registry.OpenKey(root, subKey, registry.QUERY_VALUE|registry.WOW64_64KEY)
+	if err == registry.ErrNotExist {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	defer key.Close()
+	if name == "" {
+		return "", true, nil
+	}
+
+	_, valueType, err := key.GetValue(name, nil)
+	if err == registry.ErrNotExist {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	switch valueType {
+	case registry.SZ, registry.EXPAND_SZ:
+		data, _, err = key.GetStringValue(name)
+	case registry.DWORD, registry.QWORD:
+		var n uint64
+		n, _, err = key.GetIntegerValue(name)
+		data = strconv.FormatUint(n, 10)
+	case registry.MULTI_SZ:
+		var values []string
+		values, _, err = key.GetStringsValue(name)
+		data = strings.Join(values, "\n")
+	default:
+		var raw []byte
+		raw, _, err = key.GetBinaryValue(name)
+		data = hex.EncodeToString(raw)
+	}
+	return data, err == nil, err
+}
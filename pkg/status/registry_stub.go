@@ -8,3 +8,7 @@ package status
 func getUninstallKeys() (map[string]RegistryApplication, error) {
 	return nil, nil
 }
+
+func readRegistryValue(path string, name string) (string, bool, error) {
+	return "", false, nil
+}
@@ -2,16 +2,19 @@ package status
 
 import (
 	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/1dustindavis/gorilla/pkg/catalog"
 	"github.com/1dustindavis/gorilla/pkg/download"
 	"github.com/1dustindavis/gorilla/pkg/gorillalog"
-	version "github.com/hashicorp/go-version"
 )
 
 // RegistryApplication contains attributes for an installed application
@@ -35,23 +38,34 @@ type WindowsMetadata struct {
 	versionBuild  int
 }
 
+// ProductName returns the product name from a file's metadata
+func (m WindowsMetadata) ProductName() string {
+	return m.productName
+}
+
+// CompanyName returns the company name from a file's metadata
+func (m WindowsMetadata) CompanyName() string {
+	return m.companyName
+}
+
+// Version returns the file version from a file's metadata
+func (m WindowsMetadata) Version() string {
+	return m.versionString
+}
+
 var (
 	// RegistryItems contains the status of all of the applications in the registry
 	RegistryItems map[string]RegistryApplication
 
 	// Abstracted functions so we can override these in unit tests
-	execCommand = exec.Command
+	execCommand   = exec.Command
+	registryValue = readRegistryValue
 )
 
 // checkRegistry iterates through the local registry and compiles all installed software
 func checkRegistry(catalogItem catalog.Item, installType string) (actionNeeded bool, checkErr error) {
 	// Iterate through the reg keys to compare with the catalog
 	checkReg := catalogItem.Check.Registry
-	catalogVersion, err := version.NewVersion(checkReg.Version)
-	if err != nil {
-		gorillalog.Warn("Unable to parse new version: ", checkReg.Version, err)
-	}
-
 	gorillalog.Debug("Check registry version:", checkReg.Version)
 	// If needed, populate applications status from the registry
 	if len(RegistryItems) == 0 {
@@ -66,13 +80,8 @@ func checkRegistry(catalogItem catalog.Item, installType string) (actionNeeded b
 			installed = true
 			gorillalog.Debug("Current installed version:", regItem.Version)
 
-			// Check if the catalog version matches the registry
-			currentVersion, err := version.NewVersion(regItem.Version)
-			if err != nil {
-				gorillalog.Warn("Unable to parse current version", err)
-			}
-			outdated := currentVersion.LessThan(catalogVersion)
-			if !outdated {
+			// Only an older version in the registry needs an update
+			if compareVersions(regItem.Version, checkReg.Version) >= 0 {
 				versionMatch = true
 			}
 			break
@@ -80,6 +89,11 @@ func checkRegistry(catalogItem catalog.Item, installType string) (actionNeeded b
 
 	}
 
+	return registryAction(installType, installed, versionMatch), checkErr
+}
+
+// registryAction decides if any action is needed based on what we found in the registry
+func registryAction(installType string, installed, versionMatch bool) (actionNeeded bool) {
 	if installType == "update" && !installed {
 		actionNeeded = false
 	} else if installType == "uninstall" {
@@ -89,15 +103,192 @@ func checkRegistry(catalogItem catalog.Item, installType string) (actionNeeded b
 	} else {
 		actionNeeded = true
 	}
+	return actionNeeded
+}
 
-	return actionNeeded, checkErr
+// registryValuesInstalled returns whether every registry key and value an item checks exists,
+// and whether they all match their expected data and version
+func registryValuesInstalled(catalogItem catalog.Item) (installed, versionMatch bool, checkErr error) {
+	versionMatch = true
+	for _, check := range catalogItem.Check.RegistryValues {
+		gorillalog.Debug("Check registry value:", check.Path, check.Name)
+		data, exists, err := registryValue(check.Path, check.Name)
+		if err != nil {
+			return false, false, err
+		}
+		if !exists {
+			return false, false, nil
+		}
+		if check.Data != "" && !strings.EqualFold(data, check.Data) {
+			gorillalog.Debug("Current registry data:", data)
+			versionMatch = false
+		}
+		if check.Version != "" && compareVersions(data, check.Version) < 0 {
+			gorillalog.Debug("Current installed version:", data)
+			versionMatch = false
+		}
+	}
+	return true, versionMatch, nil
 }
 
-func checkScript(catalogItem catalog.Item, cachePath string, installType string) (actionNeeded bool, checkErr error) {
+// checkRegistryValues compares registry keys and values to what the catalog expects
+func checkRegistryValues(catalogItem catalog.Item, installType string) (actionNeeded bool, checkErr error) {
+	installed, versionMatch, checkErr := registryValuesInstalled(catalogItem)
+	if checkErr != nil {
+		gorillalog.Warn("Unable to read the registry:", checkErr)
+		return false, checkErr
+	}
+	return registryAction(installType, installed, versionMatch), nil
+}
+
+// productCode returns the msi product code of an item, from its installer or uninstaller
+func productCode(catalogItem catalog.Item) string {
+	if catalogItem.Installer.ProductCode != "" {
+		return catalogItem.Installer.ProductCode
+	}
+	return catalogItem.Uninstaller.ProductCode
+}
+
+// productCodeItem returns the registry entry for an msi product code, if it is installed
+// Msi products are registered under a key named after their product code
+func productCodeItem(code string) (regItem RegistryApplication, installed bool) {
+	for _, regItem := range RegistryItems {
+		if strings.HasSuffix(strings.ToUpper(regItem.Key), `\`+strings.ToUpper(code)) {
+			return regItem, true
+		}
+	}
+	return regItem, false
+}
+
+// checkProductCode looks for an msi's product code in the registry and compares it to the item's version
+func checkProductCode(catalogItem catalog.Item, installType string) (actionNeeded bool, checkErr error) {
+	code := productCode(catalogItem)
+	gorillalog.Debug("Check product code:", code)
+
+	// If needed, populate applications status from the registry
+	if len(RegistryItems) == 0 {
+		RegistryItems, checkErr = getUninstallKeys()
+	}
+
+	regItem, installed := productCodeItem(code)
+	var versionMatch bool
+	if installed {
+		gorillalog.Debug("Current installed version:", regItem.Version)
+		// Without a catalog version, any installed version will do
+		versionMatch = catalogItem.Version == "" || compareVersions(regItem.Version, catalogItem.Version) >= 0
+	}
+
+	return registryAction(installType, installed, versionMatch), checkErr
+}
+
+// packageName returns the appx or msix package name of an item, from its installer or uninstaller
+func packageName(catalogItem catalog.Item) string {
+	if catalogItem.Installer.PackageName != "" {
+		return catalogItem.Installer.PackageName
+	}
+	return catalogItem.Uninstaller.PackageName
+}
+
+// appxVersion returns the newest version of an appx or msix package that is installed for
+// any user or provisioned for new users, or an empty string if it isnt installed
+func appxVersion(name string) (string, error) {
+	quoted := "'" + strings.ReplaceAll(name, "'", "''") + "'"
+	psCmd := filepath.Join(os.Getenv("WINDIR"), "system32/", "WindowsPowershell", "v1.0", "powershell.exe")
+	psArgs := []string{"-NoProfile", "-NoLogo", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-Command",
+		"@(Get-AppxProvisionedPackage -Online | Where-Object DisplayName -eq " + quoted + " | ForEach-Object { $_.Version }) + " +
+			"@(Get-AppxPackage -AllUsers -Name " + quoted + " | ForEach-Object { $_.Version }) | " +
+			"Sort-Object { [version]$_ } -Descending | Select-Object -First 1"}
+
+	cmd := execCommand(psCmd, psArgs...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		gorillalog.Debug("stderr:", stderr.String())
+		return "", err
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// checkAppx looks for an appx or msix package by name and compares it to the item's version
+func checkAppx(catalogItem catalog.Item, installType string) (actionNeeded bool, checkErr error) {
+	name := packageName(catalogItem)
+	gorillalog.Debug("Check package name:", name)
+
+	installedVersion, checkErr := appxVersion(name)
+	if checkErr != nil {
+		return false, checkErr
+	}
+
+	installed := installedVersion != ""
+	var versionMatch bool
+	if installed {
+		gorillalog.Debug("Current installed version:", installedVersion)
+		// Without a catalog version, any installed version will do
+		versionMatch = catalogItem.Version == "" || compareVersions(installedVersion, catalogItem.Version) >= 0
+	}
+
+	return registryAction(installType, installed, versionMatch), checkErr
+}
+
+// isInstalled uses an item's registry name, product code, package name, file paths, or registry values to
+// determine if any version of it is installed. Known is false if the item has none of these.
+func isInstalled(catalogItem catalog.Item) (installed, known bool) {
+	if catalogItem.Check.Registry.Name != "" || productCode(catalogItem) != "" {
+		known = true
+		if len(RegistryItems) == 0 {
+			var err error
+			RegistryItems, err = getUninstallKeys()
+			if err != nil {
+				gorillalog.Warn("Unable to read the registry:", err)
+			}
+		}
+	}
+
+	if name := catalogItem.Check.Registry.Name; name != "" {
+		for _, regItem := range RegistryItems {
+			if strings.Contains(regItem.Name, name) {
+				return true, known
+			}
+		}
+	}
+
+	if code := productCode(catalogItem); code != "" {
+		if _, installed := productCodeItem(code); installed {
+			return true, known
+		}
+	}
+
+	if name := packageName(catalogItem); name != "" {
+		known = true
+		if version, err := appxVersion(name); err == nil && version != "" {
+			return true, known
+		}
+	}
+
+	for _, checkFile := range catalogItem.Check.File {
+		known = true
+		if _, err := os.Stat(filepath.Clean(checkFile.Path)); err == nil {
+			return true, known
+		}
+	}
+
+	if len(catalogItem.Check.RegistryValues) > 0 {
+		known = true
+		if installed, _, err := registryValuesInstalled(catalogItem); err == nil && installed {
+			return true, known
+		}
+	}
+
+	return false, known
+}
 
-	// Write InstallCheckScript to disk as a Powershell file
-	tmpScript := filepath.Join(cachePath, "tmpCheckScript.ps1")
-	ioutil.WriteFile(tmpScript, []byte(catalogItem.Check.Script), 0755)
+// runScript writes a PowerShell script to disk and runs it, returning its exit code
+// An error means the script could not be run at all
+func runScript(script string, tmpScript string) (int, error) {
+	ioutil.WriteFile(tmpScript, []byte(script), 0755)
+	defer os.Remove(tmpScript)
 
 	// Build the command to execute the script
 	psCmd := filepath.Join(os.Getenv("WINDIR"), "system32/", "WindowsPowershell", "v1.0", "powershell.exe")
@@ -109,16 +300,27 @@ func checkScript(catalogItem catalog.Item, cachePath string, installType string)
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 	err := cmd.Run()
-	cmdSuccess := cmd.ProcessState.Success()
-	outStr, errStr := stdout.String(), stderr.String()
-
-	// Delete the temporary script
-	os.Remove(tmpScript)
 
 	// Log results
 	gorillalog.Debug("Command Error:", err)
-	gorillalog.Debug("stdout:", outStr)
-	gorillalog.Debug("stderr:", errStr)
+	gorillalog.Debug("stdout:", stdout.String())
+	gorillalog.Debug("stderr:", stderr.String())
+
+	if cmd.ProcessState == nil {
+		return -1, err
+	}
+	return cmd.ProcessState.ExitCode(), nil
+}
+
+func checkScript(catalogItem catalog.Item, cachePath string, installType string) (actionNeeded bool, checkErr error) {
+
+	// Write the check script to disk as a Powershell file and run it, unless it already ran
+	exitCode, err := cachedScript(catalogItem, catalogItem.Check.Script, "check_script", cachePath)
+	if err != nil {
+		// A script that never ran cant tell us anything, so dont act on it
+		return false, fmt.Errorf("unable to run check_script: %v", err)
+	}
+	cmdSuccess := exitCode == 0
 
 	actionNeeded = false
 	// Application not installed if exit 0
@@ -126,11 +328,115 @@ func checkScript(catalogItem catalog.Item, cachePath string, installType string)
 		actionNeeded = !cmdSuccess
 	} else if installType == "install" {
 		actionNeeded = cmdSuccess
+	} else if installType == "update" && cmdSuccess {
+		// The script cant tell a missing item from an outdated one, so an update
+		// is only needed if another check shows the item is already installed
+		installed, known := isInstalled(catalogItem)
+		if !known {
+			gorillalog.Info("Unable to determine if", catalogItem.DisplayName, "is installed, skipping update")
+		}
+		actionNeeded = installed
 	}
 
 	return actionNeeded, checkErr
 }
 
+var (
+	// Exit codes of check scripts are kept for the rest of the run,
+	// since an item is checked before it is downloaded and again before it is installed
+	scriptResults   = make(map[string]int)
+	scriptResultsMu sync.Mutex
+)
+
+// cachedScript runs one of an item's check scripts and returns its exit code, unless it already ran during this run
+// A script that could not be run at all is tried again next time
+func cachedScript(catalogItem catalog.Item, script string, kind string, cachePath string) (int, error) {
+	scriptResultsMu.Lock()
+	defer scriptResultsMu.Unlock()
+	key := catalogItem.DisplayName + "\x00" + script
+	if exitCode, ok := scriptResults[key]; ok {
+		gorillalog.Debug("Using the earlier result of", kind, "for", catalogItem.DisplayName)
+		return exitCode, nil
+	}
+
+	exitCode, err := runScript(script, filepath.Join(cachePath, "tmp_"+kind+".ps1"))
+	if err != nil {
+		return exitCode, err
+	}
+	scriptResults[key] = exitCode
+	return exitCode, nil
+}
+
+// itemScript runs an item's installcheck or uninstallcheck script
+// Exit code 0 means action is needed and 1 means it isnt, anything else is an error
+func itemScript(catalogItem catalog.Item, script string, kind string, cachePath string) (bool, error) {
+	exitCode, err := cachedScript(catalogItem, script, kind, cachePath)
+	if err != nil {
+		return false, fmt.Errorf("unable to run %s: %v", kind, err)
+	}
+	switch exitCode {
+	case 0:
+		return true, nil
+	case 1:
+		return false, nil
+	default:
+		return false, fmt.Errorf("%s exited with %d, expected 0 or 1", kind, exitCode)
+	}
+}
+
+// checkItemScripts uses an item's installcheck_script and uninstallcheck_script to decide if action is needed
+func checkItemScripts(catalogItem catalog.Item, cachePath string, installType string) (actionNeeded bool, checkErr error) {
+	if installType == "uninstall" {
+		return itemScript(catalogItem, catalogItem.UninstallCheckScript, "uninstallcheck_script", cachePath)
+	}
+	actionNeeded, checkErr = itemScript(catalogItem, catalogItem.InstallCheckScript, "installcheck_script", cachePath)
+	if checkErr != nil || !actionNeeded || installType != "update" {
+		return actionNeeded, checkErr
+	}
+
+	// The script cant tell a missing item from an outdated one, so an update
+	// is only needed if the uninstallcheck script or another check shows the item is already installed
+	if catalogItem.UninstallCheckScript != "" {
+		return itemScript(catalogItem, catalogItem.UninstallCheckScript, "uninstallcheck_script", cachePath)
+	}
+	installed, known := isInstalled(catalogItem)
+	if !known {
+		gorillalog.Info("Unable to determine if", catalogItem.DisplayName, "is installed, skipping update")
+	}
+	return installed, nil
+}
+
+// Forget drops the script results kept for an item, since installing or removing it changes them
+func Forget(catalogItem catalog.Item) {
+	scriptResultsMu.Lock()
+	defer scriptResultsMu.Unlock()
+	for key := range scriptResults {
+		if strings.HasPrefix(key, catalogItem.DisplayName+"\x00") {
+			delete(scriptResults, key)
+		}
+	}
+}
+
+// verifyFile compares a file check's hash to the actual hash of a file
+func verifyFile(path string, hashType string, expected string) bool {
+	h, err := download.NewHash(hashType, expected)
+	if err != nil {
+		gorillalog.Warn("Unable to verify file:", path, err)
+		return false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		gorillalog.Warn("Unable to open file:", path, err)
+		return false
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		gorillalog.Warn("Unable to hash file:", path, err)
+		return false
+	}
+	return strings.EqualFold(hex.EncodeToString(h.Sum(nil)), expected)
+}
+
 func checkPath(catalogItem catalog.Item, installType string) (actionNeeded bool, checkErr error) {
 	var actionStore []bool
 
@@ -172,7 +478,7 @@ func checkPath(catalogItem catalog.Item, installType string) (actionNeeded bool,
 		// if the hash does not match, we need to install
 		if checkFile.Hash != "" {
 			gorillalog.Debug("Check file hash:", checkFile.Hash)
-			hashMatch := download.Verify(path, checkFile.Hash)
+			hashMatch := verifyFile(path, checkFile.HashType, checkFile.Hash)
 			if !hashMatch {
 				actionStore = append(actionStore, true)
 				break
@@ -189,22 +495,8 @@ func checkPath(catalogItem catalog.Item, installType string) (actionNeeded bool,
 			}
 			gorillalog.Debug("Current installed version:", metadata.versionString)
 
-			// Convert both strings to a `Version` object
-			versionHave, err := version.NewVersion(metadata.versionString)
-			if err != nil {
-				gorillalog.Warn("Unable to compare version:", metadata.versionString)
-				actionStore = append(actionStore, true)
-				break
-			}
-			versionWant, err := version.NewVersion(checkFile.Version)
-			if err != nil {
-				gorillalog.Warn("Unable to compare version:", checkFile.Version)
-				actionStore = append(actionStore, true)
-				break
-			}
-
 			// Compare the versions
-			outdated := versionHave.LessThan(versionWant)
+			outdated := compareVersions(metadata.versionString, checkFile.Version) < 0
 			if outdated {
 				actionStore = append(actionStore, true)
 				break
@@ -225,7 +517,11 @@ func checkPath(catalogItem catalog.Item, installType string) (actionNeeded bool,
 // CheckStatus determines the method for checking status
 func CheckStatus(catalogItem catalog.Item, installType, cachePath string) (actionNeeded bool, checkErr error) {
 
-	if catalogItem.Check.Script != "" {
+	if (installType == "uninstall" && catalogItem.UninstallCheckScript != "") || (installType != "uninstall" && catalogItem.InstallCheckScript != "") {
+		gorillalog.Info("Checking status via", installType, "check script:", catalogItem.DisplayName)
+		return checkItemScripts(catalogItem, cachePath, installType)
+
+	} else if catalogItem.Check.Script != "" {
 		gorillalog.Info("Checking status via script:", catalogItem.DisplayName)
 		return checkScript(catalogItem, cachePath, installType)
 
@@ -233,12 +529,97 @@ func CheckStatus(catalogItem catalog.Item, installType, cachePath string) (actio
 		gorillalog.Info("Checking status via file:", catalogItem.DisplayName)
 		return checkPath(catalogItem, installType)
 
+	} else if len(catalogItem.Check.RegistryValues) > 0 {
+		gorillalog.Info("Checking status via registry values:", catalogItem.DisplayName)
+		return checkRegistryValues(catalogItem, installType)
+
 	} else if catalogItem.Check.Registry.Version != "" {
 		gorillalog.Info("Checking status via registry:", catalogItem.DisplayName)
 		return checkRegistry(catalogItem, installType)
+
+	} else if productCode(catalogItem) != "" {
+		gorillalog.Info("Checking status via product code:", catalogItem.DisplayName)
+		return checkProductCode(catalogItem, installType)
+
+	} else if packageName(catalogItem) != "" {
+		gorillalog.Info("Checking status via package name:", catalogItem.DisplayName)
+		return checkAppx(catalogItem, installType)
 	}
 
 	gorillalog.Warn("Not enough data to check the current status:", catalogItem.DisplayName)
 	return
 
 }
+
+// ItemStatus contains the current state of a catalog item on this machine
+type ItemStatus struct {
+	Installed        bool
+	InstalledVersion string
+	CatalogVersion   string
+	UpdatePending    bool
+}
+
+// installedVersion returns the currently installed version of an item, if it can be determined
+func installedVersion(catalogItem catalog.Item) string {
+	if catalogItem.Check.Registry.Name != "" {
+		for _, regItem := range RegistryItems {
+			if strings.Contains(regItem.Name, catalogItem.Check.Registry.Name) {
+				return regItem.Version
+			}
+		}
+	}
+
+	if code := productCode(catalogItem); code != "" {
+		if regItem, installed := productCodeItem(code); installed {
+			return regItem.Version
+		}
+	}
+
+	if name := packageName(catalogItem); name != "" {
+		if version, err := appxVersion(name); err == nil && version != "" {
+			return version
+		}
+	}
+
+	for _, checkFile := range catalogItem.Check.File {
+		if checkFile.Version == "" {
+			continue
+		}
+		path := filepath.Clean(checkFile.Path)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		return GetFileMetadata(path).versionString
+	}
+
+	for _, check := range catalogItem.Check.RegistryValues {
+		if check.Version == "" {
+			continue
+		}
+		if data, exists, err := registryValue(check.Path, check.Name); err == nil && exists {
+			return data
+		}
+	}
+
+	return ""
+}
+
+// Query determines the current state of an item without taking any action
+func Query(catalogItem catalog.Item, cachePath string) (itemStatus ItemStatus, checkErr error) {
+	itemStatus.CatalogVersion = catalogItem.Version
+
+	// An uninstall is only needed when the item is installed
+	itemStatus.Installed, checkErr = CheckStatus(catalogItem, "uninstall", cachePath)
+	if checkErr != nil {
+		return itemStatus, checkErr
+	}
+	if !itemStatus.Installed {
+		return itemStatus, checkErr
+	}
+
+	// An installed item that still needs an install is out of date
+	itemStatus.UpdatePending, checkErr = CheckStatus(catalogItem, "install", cachePath)
+	itemStatus.InstalledVersion = installedVersion(catalogItem)
+
+	return itemStatus, checkErr
+}
@@ -138,6 +138,8 @@ var (
 	// Define different options to bypass status checks during tests
 	statusActionNoError   = `_gorilla_dev_action_noerror_`
 	statusNoActionNoError = `_gorilla_dev_noaction_noerror_`
+	statusAppxInstalled   = `_gorilla_dev_appx_installed_`
+	statusScriptError     = `_gorilla_dev_script_error_`
 )
 
 // check if a slice contains a string
@@ -171,6 +173,12 @@ func TestHelperProcess(t *testing.T) {
 	if sliceContains(os.Args[3:], statusNoActionNoError) {
 		os.Exit(1)
 	}
+	if sliceContains(os.Args[3:], statusScriptError) {
+		os.Exit(2)
+	}
+	if sliceContains(os.Args[3:], statusAppxInstalled) {
+		fmt.Println("2.1.0.0")
+	}
 	os.Exit(0)
 }
 
@@ -247,12 +255,13 @@ func TestCheckRegistry(t *testing.T) {
 }
 
 // TestCheckScript validates that a script is properly written disk, ran, and then deleted
-// and the status is retrieved properly.
+// and the status is retrieved properly. Like other check scripts, it only runs once until the item is forgotten.
 func TestCheckScript(t *testing.T) {
 	// Override execCommand with our fake version
 	execCommand = fakeExecCommand
 	defer func() {
 		execCommand = origExec
+		scriptResults = make(map[string]int)
 	}()
 
 	// Set cachepath and run checkScript for scriptActionNoError
@@ -286,6 +295,130 @@ func TestCheckScript(t *testing.T) {
 		fmt.Printf("action: %v; error: %v\n", actionNeeded, err)
 		t.Errorf("Expected checkScript to action and no error")
 	}
+
+	// The earlier result is used, even though the script would now say otherwise
+	cachepath = fmt.Sprintf("testdata/%s/", statusActionNoError)
+	actionNeeded, err = checkScript(scriptNoActionNoError, cachepath, "install")
+	if actionNeeded || err != nil {
+		t.Errorf("have %v %v, want the earlier result of no action and no error", actionNeeded, err)
+	}
+
+	// Until the item is installed
+	Forget(scriptNoActionNoError)
+	actionNeeded, err = checkScript(scriptNoActionNoError, cachepath, "install")
+	if !actionNeeded || err != nil {
+		t.Errorf("have %v %v, want action and no error", actionNeeded, err)
+	}
+}
+
+// TestCheckScriptLaunchFailure verifies a check script that cant be started is an error, instead of a result
+func TestCheckScriptLaunchFailure(t *testing.T) {
+	execCommand = func(command string, args ...string) *exec.Cmd {
+		return exec.Command("testdata/missing-powershell")
+	}
+	defer func() {
+		execCommand = origExec
+		scriptResults = make(map[string]int)
+	}()
+
+	for _, installType := range []string{"install", "uninstall", "update"} {
+		actionNeeded, err := checkScript(scriptCheckItem, "testdata/", installType)
+		if actionNeeded || err == nil {
+			t.Errorf("%s: have %v %v, want no action and an error", installType, actionNeeded, err)
+		}
+	}
+
+	// The failure isnt kept, so the script is tried again
+	if len(scriptResults) != 0 {
+		t.Errorf("Expected no results to be kept, got %v", scriptResults)
+	}
+}
+
+// TestCheckItemScripts verifies installcheck and uninstallcheck scripts decide the status, and their results are kept until the item is forgotten
+func TestCheckItemScripts(t *testing.T) {
+	execCommand = fakeExecCommand
+	defer func() {
+		execCommand = origExec
+		scriptResults = make(map[string]int)
+	}()
+
+	item := catalog.Item{
+		DisplayName:          `itemScripts`,
+		InstallCheckScript:   `exit 0`,
+		UninstallCheckScript: `exit 1`,
+		Check:                catalog.InstallCheck{Script: `exit 1`},
+	}
+
+	// The install check script is used instead of the check script
+	actionNeeded, err := CheckStatus(item, "install", fmt.Sprintf("testdata/%s/", statusActionNoError))
+	if !actionNeeded || err != nil {
+		t.Errorf("have %v %v, want action and no error", actionNeeded, err)
+	}
+
+	// The earlier result is used, even though the script would now say otherwise
+	actionNeeded, err = CheckStatus(item, "install", fmt.Sprintf("testdata/%s/", statusNoActionNoError))
+	if !actionNeeded || err != nil {
+		t.Errorf("have %v %v, want action and no error", actionNeeded, err)
+	}
+
+	// Until the item is installed
+	Forget(item)
+	actionNeeded, err = CheckStatus(item, "install", fmt.Sprintf("testdata/%s/", statusNoActionNoError))
+	if actionNeeded || err != nil {
+		t.Errorf("have %v %v, want no action and no error", actionNeeded, err)
+	}
+
+	// An update needs the uninstall check script to show the item is installed
+	Forget(item)
+	actionNeeded, err = CheckStatus(item, "update", fmt.Sprintf("testdata/%s/", statusActionNoError))
+	if !actionNeeded || err != nil {
+		t.Errorf("have %v %v, want action and no error", actionNeeded, err)
+	}
+
+	// Any exit code besides 0 or 1 is an error
+	Forget(item)
+	if _, err := CheckStatus(item, "uninstall", fmt.Sprintf("testdata/%s/", statusScriptError)); err == nil {
+		t.Error("Expected an error for an unexpected exit code")
+	}
+}
+
+// TestCheckScriptUpdate verifies that a script check only updates an item that is already installed
+func TestCheckScriptUpdate(t *testing.T) {
+	// Override execCommand and the registry with our fake versions
+	execCommand = fakeExecCommand
+	RegistryItems = fakeRegistryItems
+	defer func() {
+		execCommand = origExec
+		RegistryItems = origRegistryItems
+		scriptResults = make(map[string]int)
+	}()
+
+	installed := scriptCheckItem
+	installed.Check.Registry.Name = `Registry Check Item`
+	missing := scriptCheckItem
+	missing.Check.Registry.Name = `Not Installed`
+	installedFile := scriptCheckItem
+	installedFile.Check.File = []catalog.FileCheck{{Path: `testdata/test_checkPath.msi`}}
+
+	tests := []struct {
+		name     string
+		item     catalog.Item
+		expected bool
+	}{
+		{"installed", installed, true},
+		{"installed file", installedFile, true},
+		{"missing", missing, false},
+		{"unknown", scriptCheckItem, false},
+	}
+	for _, test := range tests {
+		actionNeeded, err := CheckStatus(test.item, "update", "testdata/")
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if actionNeeded != test.expected {
+			t.Errorf("%s: actionNeeded: %v; expected %v", test.name, actionNeeded, test.expected)
+		}
+	}
 }
 
 // TestCheckPath validates that the status of a path is checked correctly
@@ -343,6 +476,224 @@ func TestCheckPath(t *testing.T) {
 
 }
 
+// TestVerifyFile validates Munki style md5 and sha1 checksums are accepted along with sha256
+func TestVerifyFile(t *testing.T) {
+	tests := []struct {
+		hashType string
+		hash     string
+		match    bool
+	}{
+		{"", "29baadaae342146c9c172fc2662d7cea", true},
+		{"", "96288A37F3E24915CAE1DD22F0196ED5D20D85C3", true},
+		{"", "cc8f5a895f1c500aa3b4ae35f3878595f4587054a32fa6d7e9f46363525c59f9", true},
+		{"md5", "29baadaae342146c9c172fc2662d7cea", true},
+		{"sha256", "29baadaae342146c9c172fc2662d7cea", false},
+		{"crc32", "29baadaa", false},
+	}
+	for _, test := range tests {
+		if have, want := verifyFile(`testdata/test_checkPath.msi`, test.hashType, test.hash), test.match; have != want {
+			t.Errorf("%s %s: have %v, want %v", test.hashType, test.hash, have, want)
+		}
+	}
+}
+
+// TestQuery validates that the installed state and versions are reported correctly
+func TestQuery(t *testing.T) {
+	RegistryItems = fakeRegistryItems
+	defer func() {
+		RegistryItems = origRegistryItems
+	}()
+
+	// Installed and current
+	itemStatus, err := Query(registryCheckItem, "testdata/")
+	if err != nil {
+		t.Error(err)
+	}
+	expected := ItemStatus{Installed: true, InstalledVersion: "1.2.0.3"}
+	if have, want := itemStatus, expected; have != want {
+		t.Errorf("have %#v, want %#v", have, want)
+	}
+
+	// Installed but outdated
+	registryCheckItemOutdated.Version = "33.12.0"
+	itemStatus, err = Query(registryCheckItemOutdated, "testdata/")
+	if err != nil {
+		t.Error(err)
+	}
+	expected = ItemStatus{Installed: true, InstalledVersion: "33.6.3", CatalogVersion: "33.12.0", UpdatePending: true}
+	if have, want := itemStatus, expected; have != want {
+		t.Errorf("have %#v, want %#v", have, want)
+	}
+
+	// Not installed
+	itemStatus, err = Query(registryCheckItemNotInstalled, "testdata/")
+	if err != nil {
+		t.Error(err)
+	}
+	expected = ItemStatus{}
+	if have, want := itemStatus, expected; have != want {
+		t.Errorf("have %#v, want %#v", have, want)
+	}
+}
+
+// TestCheckProductCode verifies that an msi is detected by the product code key in the registry
+func TestCheckProductCode(t *testing.T) {
+	RegistryItems = map[string]RegistryApplication{
+		`Product Code Item`: {
+			Key:     `Software\Microsoft\Windows\CurrentVersion\Uninstall\{6F1D1E2C-6F5A-4E55-9C3C-2F1B4F1E8A11}`,
+			Name:    `Product Code Item`,
+			Version: `2.1.0`,
+		},
+	}
+	defer func() {
+		RegistryItems = origRegistryItems
+	}()
+
+	item := catalog.Item{
+		Installer: catalog.InstallerItem{Type: "msi", ProductCode: "{6f1d1e2c-6f5a-4e55-9c3c-2f1b4f1e8a11}"},
+		Version:   "2.1.0",
+	}
+	missing := catalog.Item{
+		Installer: catalog.InstallerItem{Type: "msi", ProductCode: "{00000000-0000-0000-0000-000000000000}"},
+		Version:   "2.1.0",
+	}
+	outdated := item
+	outdated.Version = "2.2.0"
+
+	tests := []struct {
+		name        string
+		item        catalog.Item
+		installType string
+		expected    bool
+	}{
+		{"current install", item, "install", false},
+		{"outdated install", outdated, "install", true},
+		{"missing install", missing, "install", true},
+		{"current uninstall", item, "uninstall", true},
+		{"missing uninstall", missing, "uninstall", false},
+		{"outdated update", outdated, "update", true},
+		{"missing update", missing, "update", false},
+	}
+	for _, test := range tests {
+		actionNeeded, err := CheckStatus(test.item, test.installType, "testdata/")
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if actionNeeded != test.expected {
+			t.Errorf("%s: actionNeeded: %v; expected %v", test.name, actionNeeded, test.expected)
+		}
+	}
+}
+
+// TestCheckRegistryValues verifies that registry keys and values are compared to their expected data and version
+func TestCheckRegistryValues(t *testing.T) {
+	origRegistryValue := registryValue
+	registryValue = func(path string, name string) (string, bool, error) {
+		values := map[string]string{
+			`HKLM\SOFTWARE\Vendor\App\`:               "",
+			`HKLM\SOFTWARE\Vendor\App\DisplayVersion`: "1.2.3",
+			`HKLM\SOFTWARE\Vendor\App\Edition`:        "Enterprise",
+		}
+		data, exists := values[path+`\`+name]
+		return data, exists, nil
+	}
+	defer func() {
+		registryValue = origRegistryValue
+	}()
+
+	check := func(checks ...catalog.RegValueCheck) catalog.Item {
+		return catalog.Item{Check: catalog.InstallCheck{RegistryValues: checks}}
+	}
+	item := check(
+		catalog.RegValueCheck{Path: `HKLM\SOFTWARE\Vendor\App`},
+		catalog.RegValueCheck{Path: `HKLM\SOFTWARE\Vendor\App`, Name: "DisplayVersion", Version: "1.2.0"},
+		catalog.RegValueCheck{Path: `HKLM\SOFTWARE\Vendor\App`, Name: "Edition", Data: "enterprise"},
+	)
+	outdated := check(catalog.RegValueCheck{Path: `HKLM\SOFTWARE\Vendor\App`, Name: "DisplayVersion", Version: "1.10.0"})
+	wrongData := check(catalog.RegValueCheck{Path: `HKLM\SOFTWARE\Vendor\App`, Name: "Edition", Data: "Home"})
+	missing := check(
+		catalog.RegValueCheck{Path: `HKLM\SOFTWARE\Vendor\App`},
+		catalog.RegValueCheck{Path: `HKLM\SOFTWARE\Vendor\Missing`},
+	)
+
+	tests := []struct {
+		name        string
+		item        catalog.Item
+		installType string
+		expected    bool
+	}{
+		{"current install", item, "install", false},
+		{"outdated install", outdated, "install", true},
+		{"wrong data install", wrongData, "install", true},
+		{"missing install", missing, "install", true},
+		{"current uninstall", item, "uninstall", true},
+		{"missing uninstall", missing, "uninstall", false},
+		{"outdated update", outdated, "update", true},
+		{"missing update", missing, "update", false},
+	}
+	for _, test := range tests {
+		actionNeeded, err := CheckStatus(test.item, test.installType, "testdata/")
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if actionNeeded != test.expected {
+			t.Errorf("%s: actionNeeded: %v; expected %v", test.name, actionNeeded, test.expected)
+		}
+	}
+
+	if have, want := installedVersion(outdated), "1.2.3"; have != want {
+		t.Errorf("have %s, want %s", have, want)
+	}
+}
+
+// TestCheckAppx verifies that an appx or msix package is detected by its package name
+func TestCheckAppx(t *testing.T) {
+	// Override execCommand with our fake version
+	execCommand = fakeExecCommand
+	defer func() {
+		execCommand = origExec
+	}()
+
+	item := catalog.Item{
+		Installer: catalog.InstallerItem{Type: "msix", PackageName: statusAppxInstalled},
+		Version:   "2.1.0",
+	}
+	missing := catalog.Item{
+		Installer: catalog.InstallerItem{Type: "msix", PackageName: "Example.Missing"},
+		Version:   "2.1.0",
+	}
+	outdated := item
+	outdated.Version = "2.2.0"
+
+	tests := []struct {
+		name        string
+		item        catalog.Item
+		installType string
+		expected    bool
+	}{
+		{"current install", item, "install", false},
+		{"outdated install", outdated, "install", true},
+		{"missing install", missing, "install", true},
+		{"current uninstall", item, "uninstall", true},
+		{"missing uninstall", missing, "uninstall", false},
+		{"outdated update", outdated, "update", true},
+		{"missing update", missing, "update", false},
+	}
+	for _, test := range tests {
+		actionNeeded, err := CheckStatus(test.item, test.installType, "testdata/")
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if actionNeeded != test.expected {
+			t.Errorf("%s: actionNeeded: %v; expected %v", test.name, actionNeeded, test.expected)
+		}
+	}
+
+	if have, want := installedVersion(item), "2.1.0.0"; have != want {
+		t.Errorf("installedVersion: have %s, want %s", have, want)
+	}
+}
+
 // ExampleCheckStatus_script validates that a script check is ran
 func ExampleCheckStatus_script() {
 	// Override execCommand with our fake version
@@ -410,3 +761,61 @@ func ExampleCheckStatus_none() {
 	// Output:
 	// Not enough data to check the current status: noCheckItem
 }
+
+// TestCompareVersions verifies that versions are ordered correctly
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.2.3", "1.2.3", 0},
+		{"1.2.3", "1.2.10", -1},
+		{"10.0.19041.1", "10.0.19041.2", -1},
+		{"10.0.19041.1", "10.0.19041", 1},
+		{"2.0", "2.0.0.0", 0},
+		{"v1.4.0", "1.4.0", 0},
+		{"1, 2, 0, 3", "1.2.0.3", 0},
+		{"1.0.0-beta.2", "1.0.0", -1},
+		{"1.0.0-alpha", "1.0.0-beta", -1},
+		{"1.0.0+build.5", "1.0.0+build.9", 0},
+		{"2021a", "2021b", -1},
+		{"Latest", "latest", 0},
+	}
+	for _, test := range tests {
+		if have, want := compareVersions(test.a, test.b), test.expected; have != want {
+			t.Errorf("compareVersions(%q, %q): have %d, want %d", test.a, test.b, have, want)
+		}
+	}
+}
+
+// TestIncompatible verifies that items are limited to the Windows versions and architectures they support
+func TestIncompatible(t *testing.T) {
+	// Pretend to be an arm64 computer running Windows 11
+	osVersion = func() (string, error) { return "10.0.22631", nil }
+	osArchitecture = func() string { return "arm64" }
+	defer func() {
+		osVersion = windowsVersion
+		osArchitecture = nativeArchitecture
+	}()
+
+	tests := []struct {
+		name         string
+		item         catalog.Item
+		incompatible bool
+	}{
+		{"no limits", catalog.Item{}, false},
+		{"supported arch", catalog.Item{SupportedArchitectures: []string{"x64", "ARM64"}}, false},
+		{"arch alias", catalog.Item{SupportedArchitectures: []string{"aarch64"}}, false},
+		{"unsupported arch", catalog.Item{SupportedArchitectures: []string{"x64", "x86"}}, true},
+		{"minimum met", catalog.Item{MinimumOSVersion: "10.0.19041"}, false},
+		{"minimum not met", catalog.Item{MinimumOSVersion: "10.0.26100"}, true},
+		{"maximum met", catalog.Item{MaximumOSVersion: "10.0.22631.9999"}, false},
+		{"maximum exceeded", catalog.Item{MaximumOSVersion: "10.0.19045"}, true},
+	}
+	for _, test := range tests {
+		reason := Incompatible(test.item)
+		if have, want := reason != "", test.incompatible; have != want {
+			t.Errorf("%s: have incompatible %v (%q), want %v", test.name, have, reason, want)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package status
+
+import (
+	"strings"
+
+	version "github.com/hashicorp/go-version"
+)
+
+// normalizeVersion cleans up the ways versions are commonly written so they can be parsed,
+// such as a leading `v` or the comma separated file versions some installers report
+func normalizeVersion(v string) string {
+	v = strings.TrimSpace(v)
+	v = strings.TrimPrefix(strings.TrimPrefix(v, "v"), "V")
+	v = strings.ReplaceAll(v, ", ", ".")
+	return strings.ReplaceAll(v, ",", ".")
+}
+
+// compareVersions returns -1, 0, or 1 if a is older than, the same as, or newer than b
+// Semantic versions and Windows style versions like `10.0.19041.1` are compared segment by segment,
+// a prerelease sorts before its release, and build metadata is ignored.
+// If either version cant be parsed, they are compared as case insensitive strings instead.
+func compareVersions(a, b string) int {
+	versionA, errA := version.NewVersion(normalizeVersion(a))
+	versionB, errB := version.NewVersion(normalizeVersion(b))
+	if errA != nil || errB != nil {
+		return strings.Compare(strings.ToLower(strings.TrimSpace(a)), strings.ToLower(strings.TrimSpace(b)))
+	}
+	return versionA.Compare(versionB)
+}